@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==       == == =
+	= ==== REST API v1 ==== =
+	  = == ==       == == =
+*/
+
+// apiEventsPath адрес коллекции событий REST API
+const apiEventsPath = "/api/v1/events"
+
+// Ресурсы REST API:
+//
+//	GET    /api/v1/events?from=&to=  события пользователя; с from/to — вхождения за период, без них — все хранимые события.
+//	                                 q — поиск по названию, limit и cursor — постраничная выдача (см. ParseListOptions)
+//	POST   /api/v1/events            создание события, 201 и Location созданного события
+//	GET    /api/v1/events/{id}       событие
+//	PUT    /api/v1/events/{id}       замена события целиком
+//	PATCH  /api/v1/events/{id}       изменение присутствующих в теле полей, null очищает поле (с occurrence — выделение
+//	                                 вхождения серии: в ответе выделенное вхождение, его адрес — в Content-Location)
+//	DELETE /api/v1/events/{id}       удаление события (с ?occurrence= — одного вхождения серии)
+//
+// Параметр conflicts=reject|report у POST, PUT и PATCH включает проверку пересечений с другими событиями
+// пользователя: reject отвечает 409 со списком пересечений, report записывает событие и возвращает их в поле conflicts.
+// GET, POST, PUT и PATCH возвращают версию события в заголовке ETag. PUT, PATCH и DELETE с заголовком If-Match
+// выполняются, только если событие не изменилось с тех пор, иначе отвечают 412.
+// Пользователь берётся из тела, из параметра user_id или из аутентификации, как и в остальных методах.
+// Время без смещения в параметрах относится к часовому поясу из параметра tz (по умолчанию UTC).
+// Ошибки бизнес-логики возвращаются с кодами 404, 422, 409 и 403 вместо 503.
+
+// APIEventsHandler обрабатывает запросы к коллекции событий
+func (s *Server) APIEventsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.apiListEvents(w, r)
+	case http.MethodPost:
+		s.apiCreateEvent(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		s.RespondWithAPIError(w, methodNotAllowed)
+	}
+}
+
+// APIEventHandler обрабатывает запросы к отдельному событию
+func (s *Server) APIEventHandler(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, apiEventsPath+"/"))
+	if err != nil || eventID < 1 {
+		s.RespondWithAPIError(w, &HTTPError{Status: http.StatusNotFound, Code: "not_found", Err: fmt.Errorf("ресурс %s не найден", r.URL.Path)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.apiGetEvent(w, r, eventID)
+	case http.MethodPut:
+		s.apiReplaceEvent(w, r, eventID)
+	case http.MethodPatch:
+		s.apiUpdateEvent(w, r, eventID)
+	case http.MethodDelete:
+		s.apiDeleteEvent(w, r, eventID)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		s.RespondWithAPIError(w, methodNotAllowed)
+	}
+}
+
+// eventsPathPrefix префикс адресов действий над событием
+const eventsPathPrefix = "/events/"
+
+// EventActionHandler обрабатывает действия над событием:
+//
+//	POST /events/{id}/rsvp     ответ участника на приглашение
+//	GET  /events/{id}/history  журнал изменений события
+//	POST /events/{id}/restore  восстановление удалённого события
+func (s *Server) EventActionHandler(w http.ResponseWriter, r *http.Request) {
+	idStr, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, eventsPathPrefix), "/")
+	eventID, err := strconv.Atoi(idStr)
+
+	var handler func(http.ResponseWriter, *http.Request, int)
+	method := http.MethodPost
+	switch action {
+	case "rsvp":
+		handler = s.rsvpHandler
+	case "history":
+		handler, method = s.historyHandler, http.MethodGet
+	case "restore":
+		handler = s.restoreHandler
+	}
+
+	if err != nil || eventID < 1 || handler == nil {
+		s.RespondWithAPIError(w, &HTTPError{Status: http.StatusNotFound, Code: "not_found", Err: fmt.Errorf("ресурс %s не найден", r.URL.Path)})
+		return
+	}
+
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		s.RespondWithAPIError(w, methodNotAllowed)
+		return
+	}
+
+	handler(w, r, eventID)
+}
+
+// requestUserID возвращает пользователя запроса к ресурсу: указанного в теле, иначе в queryString или аутентифицированного
+func (s *Server) requestUserID(r *http.Request, bodyUserID int) (int, error) {
+	if bodyUserID != 0 {
+		return s.BodyUserID(r, bodyUserID)
+	}
+	return s.ParseQueryUserID(r)
+}
+
+// ParseAPITime разбирает момент времени так же, как ParseDateTime, в часовом поясе location.
+// Дата без времени означает начало дня, а при endOfDay — его последний момент, чтобы to=дата включала весь день
+func ParseAPITime(value string, location *time.Location, endOfDay bool) (time.Time, error) {
+	t, err := ParseDateTime(value, location)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if endOfDay && len(value) == len("2006-01-02") {
+		_, end := dayBounds(t)
+		return end, nil
+	}
+	return t, nil
+}
+
+// apiListEvents возвращает события пользователя
+func (s *Server) apiListEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	location, err := s.RequestLocation(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	options, err := s.ParseListOptions(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	fromStr, toStr := query.Get("from"), query.Get("to")
+	if fromStr == "" && toStr == "" {
+		events, err := s.Calendar.GetUserEvents(userID)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		s.RespondWithEvents(w, events, options)
+		return
+	}
+
+	from, to := time.Time{}, maxEventTime
+	if fromStr != "" {
+		if from, err = ParseAPITime(fromStr, location, false); err != nil {
+			s.RespondWithAPIError(w, badRequestf("from: %v", err))
+			return
+		}
+	}
+	if toStr != "" {
+		if to, err = ParseAPITime(toStr, location, true); err != nil {
+			s.RespondWithAPIError(w, badRequestf("to: %v", err))
+			return
+		}
+	}
+
+	if to.Before(from) {
+		s.RespondWithAPIError(w, badRequestf("to не может быть раньше from"))
+		return
+	}
+
+	events, err := s.Calendar.GetEventsForRange(userID, from, to)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	s.RespondWithEvents(w, events, options)
+}
+
+// apiCreateEvent создаёт событие
+func (s *Server) apiCreateEvent(w http.ResponseWriter, r *http.Request) {
+	event, err := s.DecodeEventBody(r)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе парсинга нового события: %w", err))
+		return
+	}
+
+	if event.ID != 0 {
+		s.RespondWithAPIError(w, badRequestf("ID нового события назначает сервер"))
+		return
+	}
+
+	event.CreatedAt = time.Time{}
+	event.UpdatedAt = time.Time{}
+
+	event.UserID, err = s.requestUserID(r, event.UserID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, event.UserID, PermissionWrite); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	opts, conflicts, err := s.conflictOptions(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	eventID, err := s.Calendar.AddEvent(event, append(opts, s.actorOptions(r)...)...)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе добавления нового события: %w", err))
+		return
+	}
+
+	created, err := s.Calendar.GetEvent(event.UserID, eventID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%s/%d", apiEventsPath, eventID))
+	setETag(w, created)
+	s.respondWithConflicts(w, http.StatusCreated, created, conflicts)
+}
+
+// apiGetEvent возвращает событие
+func (s *Server) apiGetEvent(w http.ResponseWriter, r *http.Request, eventID int) {
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	event, err := s.Calendar.GetEvent(userID, eventID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	setETag(w, event)
+	s.RespondWithResult(w, event)
+}
+
+// decodeAPIEvent разбирает тело запроса к событию eventID и определяет пользователя запроса.
+// Кроме события возвращает параметр UpdateFields с присутствующими в теле полями — для PATCH
+func (s *Server) decodeAPIEvent(r *http.Request, eventID int) (Event, int, WriteOption, error) {
+	event, fields, err := s.DecodeEventPatch(r)
+	if err != nil {
+		return Event{}, 0, nil, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err)
+	}
+
+	if event.ID != 0 && event.ID != eventID {
+		return Event{}, 0, nil, badRequestf("ID в теле запроса (%d) не совпадает с ID в адресе (%d)", event.ID, eventID)
+	}
+	event.ID = eventID
+
+	userID, err := s.requestUserID(r, event.UserID)
+	if err != nil {
+		return Event{}, 0, nil, err
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		return Event{}, 0, nil, err
+	}
+
+	return event, userID, fields, nil
+}
+
+// apiReplaceEvent заменяет событие целиком
+func (s *Server) apiReplaceEvent(w http.ResponseWriter, r *http.Request, eventID int) {
+	event, userID, _, err := s.decodeAPIEvent(r, eventID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if event.Occurrence != nil {
+		s.RespondWithAPIError(w, badRequestf("изменить одно вхождение серии можно только методом PATCH"))
+		return
+	}
+
+	opts, conflicts, err := s.conflictOptions(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+	opts = append(opts, s.ifMatchOptions(r)...)
+	opts = append(opts, s.actorOptions(r)...)
+
+	replaced, err := s.Calendar.ReplaceEvent(userID, event, opts...)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе обновления события [ID:%d]: %w", eventID, err))
+		return
+	}
+
+	s.respondWithEvent(w, replaced, conflicts)
+}
+
+// apiUpdateEvent изменяет присутствующие в теле поля события или выделяет вхождение серии
+func (s *Server) apiUpdateEvent(w http.ResponseWriter, r *http.Request, eventID int) {
+	event, userID, fields, err := s.decodeAPIEvent(r, eventID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	opts, conflicts, err := s.conflictOptions(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+	opts = append(opts, fields)
+	opts = append(opts, s.ifMatchOptions(r)...)
+	opts = append(opts, s.actorOptions(r)...)
+
+	updated, err := s.Calendar.UpdateEvent(userID, event, opts...)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе обновления события [ID:%d]: %w", eventID, err))
+		return
+	}
+
+	// С occurrence записано выделенное вхождение, а не серия: ответ описывает его и указывает его адрес
+	if updated.ID != eventID {
+		w.Header().Set("Content-Location", fmt.Sprintf("%s/%d", apiEventsPath, updated.ID))
+	}
+	s.respondWithEvent(w, updated, conflicts)
+}
+
+// respondWithEvent отправляет клиенту записанное событие с его ETag и найденные при записи пересечения.
+// Событие берётся из результата записи, а не читается заново: иначе ответ мог бы описать чужую запись
+func (s *Server) respondWithEvent(w http.ResponseWriter, event Event, conflicts *[]Event) {
+	setETag(w, event)
+	s.respondWithConflicts(w, http.StatusOK, event, conflicts)
+}
+
+// apiDeleteEvent удаляет событие или одно вхождение серии
+func (s *Server) apiDeleteEvent(w http.ResponseWriter, r *http.Request, eventID int) {
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	opts := append(s.ifMatchOptions(r), s.actorOptions(r)...)
+	if occurrenceStr := r.URL.Query().Get("occurrence"); occurrenceStr != "" {
+		var location *time.Location
+		if location, err = s.RequestLocation(r); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		var occurrence time.Time
+		if occurrence, err = ParseDateTime(occurrenceStr, location); err != nil {
+			s.RespondWithAPIError(w, badRequestf("occurrence: %v", err))
+			return
+		}
+		err = s.Calendar.DeleteOccurrence(userID, eventID, occurrence, opts...)
+	} else {
+		err = s.Calendar.DeleteEvent(userID, eventID, opts...)
+	}
+
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе удаления события [ID:%d]: %w", eventID, err))
+		return
+	}
+
+	s.RespondWithResult(w, "удаление события успешно")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseAPITime(t *testing.T) {
+	moscow, err := LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+	}{
+		{value: "2024-03-05", want: at(2024, time.March, 4, 21, 0)},
+		{value: "2024-03-05", endOfDay: true, want: at(2024, time.March, 5, 21, 0).Add(-time.Nanosecond)},
+		// Время и смещение из значения не сдвигаются к концу дня
+		{value: "2024-03-05T10:00", endOfDay: true, want: at(2024, time.March, 5, 7, 0)},
+		{value: "2024-03-05T10:00:00Z", endOfDay: true, want: at(2024, time.March, 5, 10, 0)},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAPITime(tt.value, moscow, tt.endOfDay)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseAPITime(%q, %v) = %v, %v; ожидалось %v", tt.value, tt.endOfDay, got, err, tt.want)
+		}
+	}
+
+	if _, err := ParseAPITime("завтра", moscow, false); err == nil {
+		t.Error("ParseAPITime() с некорректным значением должен завершиться ошибкой")
+	}
+}
+
+func TestAPIRouting(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+	}{
+		{name: "ID не число", method: "GET", path: "/api/v1/events/abc?user_id=1", wantStatus: http.StatusNotFound},
+		{name: "нулевой ID", method: "GET", path: "/api/v1/events/0?user_id=1", wantStatus: http.StatusNotFound},
+		{name: "вложенный путь", method: "GET", path: "/api/v1/events/1/x?user_id=1", wantStatus: http.StatusNotFound},
+		{name: "метод коллекции", method: "DELETE", path: "/api/v1/events?user_id=1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, POST"},
+		{name: "метод события", method: "POST", path: "/api/v1/events/1?user_id=1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET, PUT, PATCH, DELETE"},
+		{name: "неизвестное действие", method: "POST", path: "/events/1/archive?user_id=1", wantStatus: http.StatusNotFound},
+		{name: "метод действия", method: "GET", path: "/events/1/rsvp?user_id=1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "POST"},
+		{name: "метод истории", method: "POST", path: "/events/1/history?user_id=1", wantStatus: http.StatusMethodNotAllowed, wantAllow: "GET"},
+		{name: "прежний адрес", method: "GET", path: "/events_for_day?user_id=1&date=2024-03-04", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.do(t, testRequest{method: tt.method, path: tt.path})
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа = %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if got := resp.Header.Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, ожидался %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+// TestServersIndependent проверяет, что у каждого сервера своя система маршрутизации и свой календарь
+func TestServersIndependent(t *testing.T) {
+	first := newTestServer(t)
+	second := newTestServerWithStore(t, InitNewEventStore())
+
+	if _, err := second.server.Calendar.AddEvent(Event{UserID: 1, Title: "Только во втором", Date: at(2024, time.March, 4, 10, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/api/v1/events?user_id=1&from=2024-03-04&to=2024-03-04"
+	resp, body := first.do(t, testRequest{method: "GET", path: path})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("первый сервер ответил %d\n%s", resp.StatusCode, body)
+	}
+	expectTitles("Планёрка")(t, resp, body)
+
+	resp, body = second.do(t, testRequest{method: "GET", path: path})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("второй сервер ответил %d\n%s", resp.StatusCode, body)
+	}
+	expectTitles("Только во втором")(t, resp, body)
+}
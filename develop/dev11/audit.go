@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+	  = == ==         == == =
+	= ==== ЖУРНАЛ АУДИТА ==== =
+	  = == ==         == == =
+*/
+
+// auditFileName имя файла журнала аудита в каталоге файлового хранилища
+const auditFileName = "audit.log"
+
+// AuditAction вид изменения в журнале аудита
+type AuditAction string
+
+// Виды изменений в журнале аудита
+const (
+	AuditCreated  AuditAction = "created"
+	AuditUpdated  AuditAction = "updated"
+	AuditDeleted  AuditAction = "deleted"
+	AuditRestored AuditAction = "restored"
+	AuditShared   AuditAction = "shared"   // выдача или изменение доступа к календарю
+	AuditUnshared AuditAction = "unshared" // отмена доступа к календарю
+)
+
+// AuditEntry запись журнала аудита: кто, когда и как изменил событие, со снимками события до и после изменения.
+// Записи о доступе к календарю не относятся к событию (EventID 0) и вместо снимков события содержат доступ Share:
+// выданный или, при отмене, отменённый. Записи одной операции (например, удаления серии вместе с выделенными
+// из неё вхождениями) имеют общий номер Operation
+type AuditEntry struct {
+	Operation uint64      `json:"operation"`
+	Time      time.Time   `json:"time"`
+	ActorID   int         `json:"actor_id"`
+	Action    AuditAction `json:"action"`
+	EventID   int         `json:"event_id"`
+	Before    *Event      `json:"before,omitempty"`
+	After     *Event      `json:"after,omitempty"`
+	Share     *Share      `json:"share,omitempty"`
+}
+
+// snapshot возвращает состояние события после изменения, а для удаления — последнее состояние до него
+func (e AuditEntry) snapshot() Event {
+	if e.After != nil {
+		return *e.After
+	}
+	return *e.Before
+}
+
+// AuditLog журнал аудита, записи в который только дописываются. Как и Storage, изменяется под эксклюзивной
+// блокировкой EventStore, а читается параллельно
+type AuditLog interface {
+	// Append дописывает записи операций, присваивая записям каждой операции следующий номер. Операции
+	// дописываются все вместе или ни одна
+	Append(operations ...[]AuditEntry) error
+	// History возвращает записи о событии eventID в порядке их добавления
+	History(eventID int) ([]AuditEntry, error)
+	// Operation возвращает записи операции с номером operation
+	Operation(operation uint64) ([]AuditEntry, error)
+	// Close освобождает ресурсы журнала
+	Close() error
+}
+
+// Actor указывает пользователя, выполняющего изменение, для журнала аудита. Без него автором изменения считается
+// владелец календаря
+func Actor(userID int) WriteOption {
+	return func(o *writeOptions) error { o.actor = userID; return nil }
+}
+
+// actorOr возвращает автора изменения, а если он не указан — владельца календаря owner
+func (o writeOptions) actorOr(owner int) int {
+	if o.actor > 0 {
+		return o.actor
+	}
+	return owner
+}
+
+// === MemoryAuditLog (журнал аудита в памяти) ===
+
+// MemoryAuditLog хранит журнал аудита в памяти и теряет его при перезапуске
+type MemoryAuditLog struct {
+	entries       []AuditEntry
+	byEvent       map[int][]int // позиции записей о событии в entries
+	lastOperation uint64
+}
+
+// InitNewMemoryAuditLog возвращает указатель на новый пустой журнал аудита в памяти
+func InitNewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{byEvent: make(map[int][]int)}
+}
+
+// Append дописывает записи операций под следующими номерами
+func (ml *MemoryAuditLog) Append(operations ...[]AuditEntry) error {
+	for _, entry := range numberOperations(ml.lastOperation, operations) {
+		ml.add(entry)
+	}
+
+	return nil
+}
+
+// numberOperations присваивает записям операций номера, следующие за last, и возвращает их одним списком
+func numberOperations(last uint64, operations [][]AuditEntry) []AuditEntry {
+	var numbered []AuditEntry
+	for i, entries := range operations {
+		for _, entry := range entries {
+			entry.Operation = last + uint64(i) + 1
+			numbered = append(numbered, entry)
+		}
+	}
+
+	return numbered
+}
+
+// add дописывает запись с уже присвоенным номером операции
+func (ml *MemoryAuditLog) add(entry AuditEntry) {
+	ml.byEvent[entry.EventID] = append(ml.byEvent[entry.EventID], len(ml.entries))
+	ml.entries = append(ml.entries, entry)
+	if entry.Operation > ml.lastOperation {
+		ml.lastOperation = entry.Operation
+	}
+}
+
+// History возвращает записи о событии в порядке их добавления
+func (ml *MemoryAuditLog) History(eventID int) ([]AuditEntry, error) {
+	positions := ml.byEvent[eventID]
+	result := make([]AuditEntry, 0, len(positions))
+	for _, position := range positions {
+		result = append(result, ml.entries[position])
+	}
+
+	return result, nil
+}
+
+// Operation возвращает записи операции. Номера операций возрастают, поэтому записи ищутся двоичным поиском
+func (ml *MemoryAuditLog) Operation(operation uint64) ([]AuditEntry, error) {
+	start := sort.Search(len(ml.entries), func(i int) bool { return ml.entries[i].Operation >= operation })
+
+	var result []AuditEntry
+	for _, entry := range ml.entries[start:] {
+		if entry.Operation != operation {
+			break
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// Close ничего не делает: журналу в памяти нечего освобождать
+func (ml *MemoryAuditLog) Close() error {
+	return nil
+}
+
+// === FileAuditLog (журнал аудита в файле) ===
+
+// FileAuditLog дописывает журнал аудита в файл — по строке с JSON-массивом записей на вызов Append. Операции
+// дописываются одной строкой, поэтому сбой не оставляет их записанными частично. В памяти хранится только индекс
+// строк, а записи читаются из файла по запросу. Журнал, открытый через FileStorage.AuditLog, в единице работы
+// хранилища передаёт записи ей (см. FileStorage.Commit)
+type FileAuditLog struct {
+	file          *os.File
+	size          int64
+	lines         []auditLine   // строки файла в порядке записи
+	byEvent       map[int][]int // номера строк с записями о событии
+	lastOperation uint64
+	storage       *FileStorage // хранилище, с изменениями которого записи фиксируются вместе; nil — журнал сам по себе
+	unflushed     []AuditEntry // записи, зафиксированные в журнале хранилища, но не дописанные в файл из-за ошибки
+}
+
+// auditLine положение строки журнала аудита в файле
+type auditLine struct {
+	offset    int64
+	size      int
+	operation uint64 // номер первой операции строки
+}
+
+// OpenFileAuditLog открывает (или создаёт) журнал аудита в каталоге dir и строит индекс его записей
+func OpenFileAuditLog(dir string) (*FileAuditLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("невозможно создать каталог журнала аудита: %v", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, auditFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно открыть журнал аудита: %v", err)
+	}
+
+	al := &FileAuditLog{file: file, byEvent: make(map[int][]int)}
+	if err := al.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return al, nil
+}
+
+// load читает записи журнала и строит индекс. Недописанная последняя строка отбрасывается вместе со всей операцией,
+// а файл обрезается до последней целой операции
+func (al *FileAuditLog) load() error {
+	reader := bufio.NewReader(al.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := al.file.Truncate(offset); err != nil {
+					return fmt.Errorf("невозможно обрезать недописанную запись журнала аудита: %v", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+		}
+
+		var entries []AuditEntry
+		if err := json.Unmarshal(line, &entries); err != nil {
+			return fmt.Errorf("запись журнала аудита по смещению %d повреждена: %v", offset, err)
+		}
+		al.index(offset, len(line), entries)
+
+		offset += int64(len(line))
+	}
+
+	al.size = offset
+
+	return nil
+}
+
+// index добавляет в индекс строку файла с записями entries
+func (al *FileAuditLog) index(offset int64, size int, entries []AuditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	number := len(al.lines)
+	al.lines = append(al.lines, auditLine{offset: offset, size: size, operation: entries[0].Operation})
+	for _, entry := range entries {
+		if lines := al.byEvent[entry.EventID]; len(lines) == 0 || lines[len(lines)-1] != number {
+			al.byEvent[entry.EventID] = append(lines, number)
+		}
+		al.advance(entry)
+	}
+}
+
+// advance запоминает номер операции записи, если он больше последнего
+func (al *FileAuditLog) advance(entry AuditEntry) {
+	if entry.Operation > al.lastOperation {
+		al.lastOperation = entry.Operation
+	}
+}
+
+// readLine читает записи строки файла с номером number
+func (al *FileAuditLog) readLine(number int) ([]AuditEntry, error) {
+	line := al.lines[number]
+	data := make([]byte, line.size)
+	if _, err := al.file.ReadAt(data, line.offset); err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала аудита: %v", err)
+	}
+
+	var entries []AuditEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("запись журнала аудита по смещению %d повреждена: %v", line.offset, err)
+	}
+
+	return entries, nil
+}
+
+// Append дописывает записи операций в файл и сбрасывает их на диск. При ошибке файл обрезается до прежнего размера.
+// В единице работы хранилища записи передаются ей: в файл их допишет committed после фиксации единицы работы
+func (al *FileAuditLog) Append(operations ...[]AuditEntry) error {
+	if al.storage != nil && al.storage.unit != nil {
+		unit := al.storage.unit
+		last := al.lastOperation
+		if n := len(unit.Audit); n > 0 {
+			last = unit.Audit[n-1].Operation
+		}
+		unit.Audit = append(unit.Audit, numberOperations(last, operations)...)
+		return nil
+	}
+
+	return al.write(numberOperations(al.lastOperation, operations))
+}
+
+// write дописывает записи в файл одной строкой, сбрасывает их на диск и добавляет строку в индекс.
+// При ошибке файл обрезается до прежнего размера
+func (al *FileAuditLog) write(entries []AuditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи журнала аудита: %v", err)
+	}
+	data = append(data, '\n')
+
+	n, err := al.file.Write(data)
+	if err == nil {
+		err = al.file.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			_ = al.file.Truncate(al.size)
+		}
+		return fmt.Errorf("ошибка записи в журнал аудита: %v", err)
+	}
+	al.index(al.size, n, entries)
+	al.size += int64(n)
+
+	return nil
+}
+
+// committed дописывает в файл записи единицы работы, уже зафиксированные в журнале хранилища. Изменение к этому
+// моменту сохранено, поэтому ошибка записи файла только записывается в лог: записи остаются в памяти и дописываются
+// в файл при следующей фиксации, а после сбоя — из журнала хранилища (см. FileStorage.AuditLog)
+func (al *FileAuditLog) committed(entries []AuditEntry) {
+	for _, entry := range entries {
+		al.advance(entry)
+	}
+
+	pending := append(al.unflushed, entries...)
+	if err := al.write(pending); err != nil {
+		log.Printf("Ошибка записи журнала аудита, повтор при следующем изменении: %v", err)
+		al.unflushed = pending
+		return
+	}
+	al.unflushed = nil
+}
+
+// History возвращает записи о событии в порядке их добавления, читая из файла только строки с ними
+func (al *FileAuditLog) History(eventID int) ([]AuditEntry, error) {
+	result := []AuditEntry{}
+	for _, number := range al.byEvent[eventID] {
+		entries, err := al.readLine(number)
+		if err != nil {
+			return nil, err
+		}
+		result = appendEventEntries(result, entries, eventID)
+	}
+
+	return appendEventEntries(result, al.unflushed, eventID), nil
+}
+
+// appendEventEntries дописывает к result записи entries о событии eventID
+func appendEventEntries(result, entries []AuditEntry, eventID int) []AuditEntry {
+	for _, entry := range entries {
+		if entry.EventID == eventID {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// Operation возвращает записи операции. Операция целиком записана в одной строке, а номера операций возрастают,
+// поэтому строка ищется двоичным поиском
+func (al *FileAuditLog) Operation(operation uint64) ([]AuditEntry, error) {
+	entries := al.unflushed
+	if number := sort.Search(len(al.lines), func(i int) bool { return al.lines[i].operation > operation }) - 1; number >= 0 {
+		line, err := al.readLine(number)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(line, entries...)
+	}
+
+	var result []AuditEntry
+	for _, entry := range entries {
+		if entry.Operation == operation {
+			result = append(result, entry)
+		}
+	}
+
+	return result, nil
+}
+
+// Close закрывает файл журнала
+func (al *FileAuditLog) Close() error {
+	return al.file.Close()
+}
+
+// === История и восстановление событий ===
+
+// lastAuditEntry возвращает последнюю запись журнала аудита о событии. Вызывается под блокировкой
+func (es *EventStore) lastAuditEntry(eventID int) (AuditEntry, bool, error) {
+	history, err := es.audit.History(eventID)
+	if err != nil {
+		return AuditEntry{}, false, storageError(err)
+	}
+	if len(history) == 0 {
+		return AuditEntry{}, false, nil
+	}
+	return history[len(history)-1], true, nil
+}
+
+// History возвращает журнал изменений события eventID, если пользователь userID его владелец или участник
+// (для удалённого события — по последнему снимку). У событий, сохранённых до появления журнала, история пуста
+func (es *EventStore) History(userID, eventID int) ([]AuditEntry, error) {
+	// Записи о доступах к календарю не относятся к событию и лежат в журнале под нулевым EventID
+	if eventID < 1 {
+		return nil, validationErrorf("некорректный ID события: %d", eventID)
+	}
+
+	es.RLock()
+	defer es.RUnlock()
+
+	history, err := es.audit.History(eventID)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	var latest Event
+	if len(history) > 0 {
+		latest = history[len(history)-1].snapshot()
+	} else {
+		event, exists, err := es.storage.GetEvent(eventID)
+		if err != nil {
+			return nil, storageError(err)
+		}
+		if !exists {
+			return nil, notFoundErrorf("событие с ID %d не найдено", eventID)
+		}
+		latest = event
+	}
+
+	if !latest.visibleTo(userID) {
+		return nil, forbiddenErrorf("событие с ID %d принадлежит другому пользователю", eventID)
+	}
+
+	for i := range history {
+		if history[i].Before != nil {
+			before := *history[i].Before
+			localizeEvent(&before)
+			history[i].Before = &before
+		}
+		if history[i].After != nil {
+			after := *history[i].After
+			localizeEvent(&after)
+			history[i].After = &after
+		}
+	}
+
+	return history, nil
+}
+
+// RestoreEvent возвращает удалённое событие eventID пользователя userID под прежним ID. Вместе с ним возвращается
+// всё, что было удалено той же операцией, например выделенные вхождения удалённой серии. Версия восстановленного
+// события продолжает версию удалённого. Параметры opts включают проверку пересечений; IfMatch сверяется с версией
+// удалённого события. Возвращает восстановленное событие eventID
+func (es *EventStore) RestoreEvent(userID, eventID int, opts ...WriteOption) (Event, error) {
+	if eventID < 1 {
+		return Event{}, validationErrorf("некорректный ID события: %d", eventID)
+	}
+
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return Event{}, err
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	_, exists, err := es.storage.GetEvent(eventID)
+	if err != nil {
+		return Event{}, storageError(err)
+	}
+	if exists {
+		return Event{}, conflictErrorf("событие с ID %d не удалено", eventID)
+	}
+
+	deletion, ok, err := es.lastAuditEntry(eventID)
+	if err != nil {
+		return Event{}, err
+	}
+	if !ok || deletion.Action != AuditDeleted {
+		return Event{}, notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	if deletion.Before.UserID != userID {
+		return Event{}, forbiddenErrorf("событие с ID %d принадлежит другому пользователю", eventID)
+	}
+
+	if err := checkVersion(*deletion.Before, options); err != nil {
+		return Event{}, err
+	}
+
+	operation, err := es.audit.Operation(deletion.Operation)
+	if err != nil {
+		return Event{}, storageError(err)
+	}
+
+	now := time.Now()
+	var restored Event
+	var commands []Command
+	for _, entry := range operation {
+		if entry.Action != AuditDeleted {
+			continue
+		}
+		// Событие, которое после этой операции уже восстановили, повторно не возвращается
+		last, _, err := es.lastAuditEntry(entry.EventID)
+		if err != nil {
+			return Event{}, err
+		}
+		if last.Operation != deletion.Operation {
+			continue
+		}
+
+		event := *entry.Before
+		event.UpdatedAt = now
+		event.Version++
+
+		if event.ID == eventID {
+			restored = event
+			if err := es.checkConflicts(event, options); err != nil {
+				return Event{}, err
+			}
+		}
+
+		commands = append(commands, &restoreCommand{storage: es.storage, event: event})
+	}
+
+	if err := es.execute(options.actorOr(userID), commands...); err != nil {
+		return Event{}, err
+	}
+
+	localizeEvent(&restored)
+	return restored, nil
+}
+
+// === HTTP ===
+
+// actorOptions возвращает параметр Actor с аутентифицированным пользователем, чтобы журнал аудита
+// фиксировал, кто выполнил изменение в чужом календаре
+func (s *Server) actorOptions(r *http.Request) []WriteOption {
+	if userID, ok := AuthenticatedUserID(r.Context()); ok {
+		return []WriteOption{Actor(userID)}
+	}
+	return nil
+}
+
+// historyHandler возвращает журнал изменений события:
+//
+//	GET /events/{id}/history?user_id=1
+//
+// Каждая запись содержит автора, время, вид изменения и снимки события до и после него
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request, eventID int) {
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	history, err := s.Calendar.History(userID, eventID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	s.RespondWithResult(w, history)
+}
+
+// restoreHandler возвращает удалённое событие:
+//
+//	POST /events/{id}/restore?user_id=1
+//
+// Как и при записи события, поддерживаются conflicts=reject|report и If-Match (с версией удалённого события).
+// В ответе — восстановленное событие и его ETag; если событие не удалено, ответ 409
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request, eventID int) {
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	opts, conflicts, err := s.conflictOptions(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+	opts = append(opts, s.ifMatchOptions(r)...)
+	opts = append(opts, s.actorOptions(r)...)
+
+	restored, err := s.Calendar.RestoreEvent(userID, eventID, opts...)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе восстановления события [ID:%d]: %w", eventID, err))
+		return
+	}
+
+	s.respondWithEvent(w, restored, conflicts)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditHistoryAndRestore(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2, "carol": 3}}
+	as := func(token string) map[string]string { return map[string]string{"Authorization": "Bearer " + token} }
+
+	if err := ts.server.Calendar.ShareCalendar(Share{OwnerID: 1, SharedWith: 3, Permission: PermissionWrite}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Кэрол меняет событие Алисы по выданному доступу, Алиса его удаляет
+	if resp, body := ts.do(t, testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, header: as("carol"), body: `{"user_id":1,"title":"Планёрка (перенос)"}`}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("изменение: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	if resp, body := ts.do(t, testRequest{method: "DELETE", path: "/api/v1/events/1", header: as("alice")}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("удаление: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	resp, body := ts.do(t, testRequest{method: "GET", path: "/events/1/history", header: as("alice")})
+	var history []AuditEntry
+	if err := json.Unmarshal(decodeResponse(t, body).Result, &history); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("история: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	want := []struct {
+		action AuditAction
+		actor  int
+	}{{AuditCreated, 1}, {AuditUpdated, 3}, {AuditDeleted, 1}}
+	if len(history) != len(want) {
+		t.Fatalf("записей в истории %d, ожидалось %d: %+v", len(history), len(want), history)
+	}
+	for i, entry := range history {
+		if entry.Action != want[i].action || entry.ActorID != want[i].actor {
+			t.Errorf("запись %d: %s пользователем %d, ожидалось %s пользователем %d", i, entry.Action, entry.ActorID, want[i].action, want[i].actor)
+		}
+	}
+	if updated := history[1]; updated.Before.Title != "Планёрка" || updated.After.Title != "Планёрка (перенос)" {
+		t.Errorf("снимки изменения: до %q, после %q", updated.Before.Title, updated.After.Title)
+	}
+	if deleted := history[2]; deleted.After != nil || deleted.Before == nil || deleted.Before.Version != 2 {
+		t.Errorf("снимок удаления %+v", deleted)
+	}
+
+	tests := []struct {
+		name       string
+		req        testRequest
+		wantStatus int
+		check      func(*testing.T, *http.Response, []byte)
+	}{
+		{"история без доступа", testRequest{method: "GET", path: "/events/1/history?user_id=1", header: as("bob")}, http.StatusForbidden, nil},
+		{"история несуществующего события", testRequest{method: "GET", path: "/events/999/history", header: as("alice")}, http.StatusNotFound, nil},
+		{"восстановление с устаревшей версией", testRequest{method: "POST", path: "/events/1/restore", header: map[string]string{"Authorization": "Bearer alice", "If-Match": `"1"`}}, http.StatusPreconditionFailed, nil},
+		{"восстановление методом GET", testRequest{method: "GET", path: "/events/1/restore", header: as("alice")}, http.StatusMethodNotAllowed, expectHeader("Allow", "POST")},
+		{"восстановление", testRequest{method: "POST", path: "/events/1/restore", header: as("alice")}, http.StatusOK, expectHeader("ETag", `"3"`)},
+		{"повторное восстановление", testRequest{method: "POST", path: "/events/1/restore", header: as("alice")}, http.StatusConflict, nil},
+		{"восстановление существующего события", testRequest{method: "POST", path: "/events/2/restore", header: as("alice")}, http.StatusConflict, nil},
+		{"неизвестное действие", testRequest{method: "POST", path: "/events/1/archive", header: as("alice")}, http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.do(t, tt.req)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.check != nil {
+				tt.check(t, resp, body)
+			}
+		})
+	}
+
+	event, err := ts.server.Calendar.GetEvent(1, 1)
+	if err != nil || event.Title != "Планёрка (перенос)" {
+		t.Errorf("восстановленное событие %+v, ошибка %v", event, err)
+	}
+	if history, _ := ts.server.Calendar.History(1, 1); history[len(history)-1].Action != AuditRestored {
+		t.Errorf("последняя запись истории %+v, ожидалось восстановление", history[len(history)-1])
+	}
+}
+
+func TestRestoreSeries(t *testing.T) {
+	store := InitNewEventStore()
+
+	masterID, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrence := at(2024, time.March, 6, 7, 0)
+	if _, err := store.UpdateEvent(1, Event{ID: masterID, Occurrence: &occurrence, Date: at(2024, time.March, 6, 8, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	start, end := at(2024, time.March, 1, 0, 0), at(2024, time.March, 31, 0, 0)
+	before, err := store.GetEventsForRange(1, start, end)
+	if err != nil || len(before) != 3 {
+		t.Fatalf("вхождений до удаления %d, ожидалось 3", len(before))
+	}
+
+	if err := store.DeleteEvent(1, masterID); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := store.GetEventsForRange(1, start, end); err != nil || len(events) != 0 {
+		t.Fatalf("после удаления серии осталось %d событий", len(events))
+	}
+
+	// Вхождение удалено той же операцией, что и серия, поэтому возвращается вместе с ней
+	if _, err := store.RestoreEvent(2, masterID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("восстановление чужой серии: ошибка %v, ожидался отказ в доступе", err)
+	}
+	if _, err := store.RestoreEvent(1, masterID); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := store.GetEventsForRange(1, start, end)
+	if err != nil || len(after) != len(before) {
+		t.Fatalf("после восстановления %d вхождений, ожидалось %d", len(after), len(before))
+	}
+	for i := range after {
+		if after[i].ID != before[i].ID || !after[i].Date.Equal(before[i].Date) || after[i].Version != before[i].Version+1 {
+			t.Errorf("вхождение %d: %+v, ожидалось %+v с версией на 1 больше", i, after[i], before[i])
+		}
+	}
+}
+
+// failingAuditLog журнал аудита, отказывающийся принимать записи
+type failingAuditLog struct {
+	*MemoryAuditLog
+}
+
+func (failingAuditLog) Append(...[]AuditEntry) error {
+	return errors.New("диск переполнен")
+}
+
+func TestExecuteRollback(t *testing.T) {
+	storage := InitNewMemoryStorage()
+	store := InitNewEventStoreWithStorage(storage, InitNewMemoryAuditLog())
+
+	masterID, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrence := at(2024, time.March, 6, 7, 0)
+	if _, err := store.UpdateEvent(1, Event{ID: masterID, Occurrence: &occurrence, Title: "Пробежка"}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.audit = failingAuditLog{InitNewMemoryAuditLog()}
+	var changes []EventChange
+	store.Watch(func(change EventChange) { changes = append(changes, change) })
+
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "x", Date: at(2024, time.March, 7, 10, 0)}); err == nil {
+		t.Error("AddEvent() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	if err := store.DeleteEvent(1, masterID); err == nil {
+		t.Error("DeleteEvent() без записи в журнал аудита должен завершиться ошибкой")
+	}
+
+	if len(storage.Events) != 2 {
+		t.Errorf("после отмены в хранилище %d событий, ожидалось 2", len(storage.Events))
+	}
+	if len(changes) != 0 {
+		t.Errorf("подписчики получили отменённые изменения: %+v", changes)
+	}
+}
+
+func TestDeleteRollback(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 100)
+	audit, err := storage.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := InitNewEventStoreWithStorage(storage, audit)
+
+	id, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 5, 15, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Удаление, которое не удалось записать в журнал аудита, не попадает и в журнал хранилища
+	store.audit = failingAuditLog{InitNewMemoryAuditLog()}
+	if err := store.DeleteEvent(1, id); err == nil {
+		t.Fatal("DeleteEvent() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	store.audit = audit
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestFileStorage(t, dir, 100)
+	reopenedAudit, err := reopened.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedAudit.Close()
+
+	if _, exists, err := reopened.GetEvent(id); err != nil || !exists {
+		t.Errorf("событие, удаление которого отменено, потеряно после перезапуска: %v", err)
+	}
+	if history, err := reopenedAudit.History(id); err != nil || len(history) != 1 || history[0].Action != AuditCreated {
+		t.Errorf("история события после перезапуска %+v, %v", history, err)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	dir := t.TempDir()
+
+	audit, err := OpenFileAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := Event{ID: 1, UserID: 1, Title: "Ретро", Date: at(2024, time.March, 5, 15, 0)}
+	operations := [][]AuditEntry{
+		{{ActorID: 1, Action: AuditCreated, EventID: 1, After: &event}},
+		{{ActorID: 1, Action: AuditDeleted, EventID: 2, Before: &event}, {ActorID: 1, Action: AuditDeleted, EventID: 1, Before: &event}},
+	}
+	for _, entries := range operations {
+		if err := audit.Append(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сбой посреди записи операции оставляет недописанную строку
+	f, err := os.OpenFile(filepath.Join(dir, auditFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`[{"operation":3,"action":"crea`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reopened, err := OpenFileAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	history, err := reopened.History(1)
+	if err != nil || len(history) != 2 || history[0].Action != AuditCreated || history[1].Action != AuditDeleted || history[1].Before.Title != "Ретро" {
+		t.Fatalf("история после перезапуска %+v", history)
+	}
+	if operation, err := reopened.Operation(2); err != nil || len(operation) != 2 || operation[0].EventID != 2 || operation[1].EventID != 1 {
+		t.Errorf("операция 2 после перезапуска %+v", operation)
+	}
+
+	if err := reopened.Append([]AuditEntry{{ActorID: 1, Action: AuditRestored, EventID: 1, After: &event}}); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ := reopened.History(1); history[len(history)-1].Operation != 3 {
+		t.Errorf("номер операции после перезапуска %d, ожидался 3", history[len(history)-1].Operation)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// === Аутентификация ===
+
+// Authenticator определяет пользователя, от имени которого выполняется запрос
+type Authenticator interface {
+	// Authenticate возвращает ID пользователя или ошибку, если запрос не удалось аутентифицировать
+	Authenticate(r *http.Request) (int, error)
+}
+
+// UserDirectory знает, какие пользователи существуют. Authenticator, реализующий его, позволяет проверять
+// пользователей, которым выдаётся доступ к календарю
+type UserDirectory interface {
+	// UserExists сообщает, существует ли пользователь userID
+	UserExists(userID int) bool
+}
+
+// userIDContextKey ключ контекста запроса, под которым хранится ID аутентифицированного пользователя
+type userIDContextKey struct{}
+
+// AuthenticatedUserID возвращает ID пользователя, аутентифицированного AuthMiddleware
+func AuthenticatedUserID(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDContextKey{}).(int)
+	return userID, ok
+}
+
+// TokenAuthenticator аутентифицирует запросы по заголовку "Authorization: Bearer <токен>". Клиенты CalDAV, умеющие
+// только Basic, передают токен паролем, а имя пользователя не учитывается
+type TokenAuthenticator struct {
+	Tokens map[string]int // токен -> ID пользователя
+}
+
+// LoadTokenFile читает файл токенов. Каждая непустая строка имеет вид "<токен> <user_id>", строки с # — комментарии
+func LoadTokenFile(filename string) (*TokenAuthenticator, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла токенов: %v", err)
+	}
+	defer file.Close()
+
+	auth := &TokenAuthenticator{Tokens: make(map[string]int)}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("строка %d файла токенов: ожидается \"<токен> <user_id>\"", lineNumber)
+		}
+
+		userID, err := strconv.Atoi(fields[1])
+		if err != nil || userID < 1 {
+			return nil, fmt.Errorf("строка %d файла токенов: некорректный user_id", lineNumber)
+		}
+
+		if _, exists := auth.Tokens[fields[0]]; exists {
+			return nil, fmt.Errorf("строка %d файла токенов: токен повторяется", lineNumber)
+		}
+		auth.Tokens[fields[0]] = userID
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла токенов: %v", err)
+	}
+
+	return auth, nil
+}
+
+// Authenticate ищет токен запроса (bearer-токен или пароль Basic) среди известных
+func (ta *TokenAuthenticator) Authenticate(r *http.Request) (int, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return 0, fmt.Errorf("отсутствует заголовок Authorization")
+	}
+
+	scheme, token, found := strings.Cut(header, " ")
+	if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	} else if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return 0, fmt.Errorf("ожидается заголовок вида \"Authorization: Bearer <токен>\"")
+	}
+
+	userID, ok := ta.Tokens[strings.TrimSpace(token)]
+	if !ok {
+		return 0, fmt.Errorf("неизвестный токен")
+	}
+
+	return userID, nil
+}
+
+// UserExists сообщает, выдан ли пользователю userID хотя бы один токен
+func (ta *TokenAuthenticator) UserExists(userID int) bool {
+	for _, id := range ta.Tokens {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// === Middleware для аутентификации запросов ===
+
+// AuthMiddleware аутентифицирует каждый запрос и сохраняет ID пользователя в контексте запроса.
+// Если аутентификация не настроена, запросы пропускаются как есть, а пользователь берётся из параметра user_id.
+// Метрики доступны без аутентификации: они не содержат данных пользователей, а сборщику метрик не выдаётся токен.
+// Документ OpenAPI тоже открыт, чтобы клиент мог узнать из него, как аутентифицироваться.
+func (s *Server) AuthMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Auth == nil || r.URL.Path == metricsPath || r.URL.Path == openAPIPath {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := s.Auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="calendar"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="calendar"`)
+			s.RespondWithError(w, &HTTPError{
+				Status: http.StatusUnauthorized,
+				Code:   "unauthorized",
+				Err:    fmt.Errorf("Ошибка аутентификации: %w", err),
+			})
+			return
+		}
+
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDContextKey{}, userID)))
+	})
+}
+
+// AuthorizeUser проверяет, что запрос вправе действовать от имени пользователя userID.
+// Без настроенной аутентификации доверяет переданному user_id.
+func (s *Server) AuthorizeUser(r *http.Request, userID int) error {
+	authenticated, ok := AuthenticatedUserID(r.Context())
+	if !ok {
+		return nil
+	}
+
+	if authenticated != userID {
+		return &HTTPError{
+			Status: http.StatusForbidden,
+			Code:   "forbidden",
+			Err:    fmt.Errorf("нет доступа к календарю пользователя %d", userID),
+		}
+	}
+
+	return nil
+}
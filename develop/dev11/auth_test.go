@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadTokenFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]int
+		wantErr bool
+	}{
+		{
+			name:    "токены и комментарии",
+			content: "# токены команды\nalice 1\n\n  bob   2  \n",
+			want:    map[string]int{"alice": 1, "bob": 2},
+		},
+		{name: "нет user_id", content: "alice\n", wantErr: true},
+		{name: "лишнее поле", content: "alice 1 admin\n", wantErr: true},
+		{name: "некорректный user_id", content: "alice first\n", wantErr: true},
+		{name: "нулевой user_id", content: "alice 0\n", wantErr: true},
+		{name: "повтор токена", content: "alice 1\nalice 2\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "tokens")
+			if err := os.WriteFile(filename, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			auth, err := LoadTokenFile(filename)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadTokenFile() должен завершиться ошибкой, прочитано %v", auth.Tokens)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(auth.Tokens) != len(tt.want) {
+				t.Fatalf("токены %v, ожидались %v", auth.Tokens, tt.want)
+			}
+			for token, userID := range tt.want {
+				if auth.Tokens[token] != userID {
+					t.Errorf("токен %q принадлежит %d, ожидался %d", token, auth.Tokens[token], userID)
+				}
+			}
+		})
+	}
+
+	if _, err := LoadTokenFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadTokenFile() без файла должен завершиться ошибкой")
+	}
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	auth := &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2}}
+
+	tests := []struct {
+		name     string
+		header   string
+		basic    []string
+		wantUser int
+		wantErr  bool
+	}{
+		{name: "bearer", header: "Bearer alice", wantUser: 1},
+		{name: "схема в другом регистре", header: "bearer bob", wantUser: 2},
+		{name: "пароль basic", basic: []string{"кто угодно", "bob"}, wantUser: 2},
+		{name: "без заголовка", wantErr: true},
+		{name: "неизвестный токен", header: "Bearer mallory", wantErr: true},
+		{name: "другая схема", header: "Token alice", wantErr: true},
+		{name: "пустой токен", header: "Bearer ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/events_for_day", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.basic != nil {
+				r.SetBasicAuth(tt.basic[0], tt.basic[1])
+			}
+
+			userID, err := auth.Authenticate(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Authenticate() должен завершиться ошибкой, получен пользователь %d", userID)
+				}
+				return
+			}
+			if err != nil || userID != tt.wantUser {
+				t.Errorf("Authenticate() = %d, %v; ожидался пользователь %d", userID, err, tt.wantUser)
+			}
+		})
+	}
+
+	if !auth.UserExists(2) || auth.UserExists(3) {
+		t.Error("UserExists() должен знать только пользователей с токенами")
+	}
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+	server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1}}
+
+	var authorizeErr error
+	handler := server.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizeErr = server.AuthorizeUser(r, 2)
+	}))
+
+	r := httptest.NewRequest("GET", "/events_for_day", nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	var httpErr *HTTPError
+	if !errors.As(authorizeErr, &httpErr) || httpErr.Status != http.StatusForbidden {
+		t.Errorf("доступ к чужому календарю: %v, ожидался код 403", authorizeErr)
+	}
+
+	// Без настроенной аутентификации user_id из запроса принимается на веру
+	if err := server.AuthorizeUser(httptest.NewRequest("GET", "/events_for_day", nil), 2); err != nil {
+		t.Errorf("AuthorizeUser() без аутентификации: %v", err)
+	}
+}
+
+func TestEventStoreUserScope(t *testing.T) {
+	store := InitNewEventStore()
+	own, err := store.AddEvent(Event{UserID: 1, Title: "Своё", Date: at(2024, time.March, 4, 10, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.AddEvent(Event{UserID: 2, Title: "Чужое", Date: at(2024, time.March, 4, 12, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := store.GetEventsByDate(1, at(2024, time.March, 4, 0, 0))
+	if err != nil || len(events) != 1 || events[0].ID != own {
+		t.Errorf("события пользователя 1 за день: %+v, %v", events, err)
+	}
+	events, err = store.GetEventsForRange(2, at(2024, time.March, 1, 0, 0), at(2024, time.April, 1, 0, 0))
+	if err != nil || len(events) != 1 || events[0].ID != other {
+		t.Errorf("события пользователя 2 за месяц: %+v, %v", events, err)
+	}
+
+	if _, err := store.GetEvent(1, other); !errors.Is(err, ErrForbidden) {
+		t.Errorf("чтение чужого события: %v, ожидалась ErrForbidden", err)
+	}
+	if _, err := store.UpdateEvent(1, Event{ID: other, Title: "Взлом", Date: at(2024, time.March, 4, 12, 0)}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("изменение чужого события: %v, ожидалась ErrForbidden", err)
+	}
+	if err := store.DeleteEvent(1, other); !errors.Is(err, ErrForbidden) {
+		t.Errorf("удаление чужого события: %v, ожидалась ErrForbidden", err)
+	}
+	if err := store.DeleteEvent(1, 100); !errors.Is(err, ErrNotFound) {
+		t.Errorf("удаление несуществующего события: %v, ожидалась ErrNotFound", err)
+	}
+
+	if event, err := store.GetEvent(2, other); err != nil || event.Title != "Чужое" {
+		t.Errorf("чужое событие изменилось: %+v, %v", event, err)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+/*
+	  = == ==                  == == =
+	= ==== ПАКЕТНЫЕ ОПЕРАЦИИ ==== =
+	  = == ==                  == == =
+*/
+
+// BatchAction вид операции пакета
+type BatchAction string
+
+// Виды операций пакета
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// maxBatchSize наибольшее число операций в пакете
+const maxBatchSize = 10000
+
+// BatchOperation операция пакета над календарём пользователя, выполняющего пакет: create создаёт Event,
+// update изменяет событие Event.ID как UpdateEvent, delete удаляет событие Event.ID, а с Event.Occurrence —
+// одно вхождение серии. Options — параметры записи этой операции
+type BatchOperation struct {
+	Action  BatchAction
+	Event   Event
+	Options []WriteOption
+}
+
+// BatchResult результат операции пакета: ID события и, кроме удаления, его состояние после операции.
+// Для изменения вхождения серии это выделенное из серии событие
+type BatchResult struct {
+	Action BatchAction `json:"action"`
+	ID     int         `json:"id"`
+	Event  *Event      `json:"event,omitempty"`
+}
+
+// BatchError ошибка операции пакета с номером Index (с нуля), из-за которой пакет не выполнен
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+// Error возвращает текст ошибки с номером операции
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("операция %d: %v", e.Index, e.Err)
+}
+
+// Unwrap возвращает ошибку операции: по ней определяется код ответа
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyBatch выполняет операции пакета пользователя userID по порядку под одной блокировкой в одной единице работы
+// хранилища, поэтому каждая операция видит результаты предыдущих, а другие запросы — только пакет целиком. Если операция
+// не выполнилась, единица работы откатывается и возвращается *BatchError: хранилище и журнал аудита остаются прежними.
+// В журнал аудита пакет записывается одним вызовом Append и фиксируется вместе с изменениями, но каждая операция —
+// под своим номером, чтобы удалённые пакетом события восстанавливались по отдельности. Параметры opts применяются
+// к каждой операции перед её Options
+func (es *EventStore) ApplyBatch(userID int, operations []BatchOperation, opts ...WriteOption) ([]BatchResult, error) {
+	if len(operations) > maxBatchSize {
+		return nil, validationErrorf("в пакете больше %d операций", maxBatchSize)
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	results := make([]BatchResult, 0, len(operations))
+	err := es.transaction(func() error {
+		for i, operation := range operations {
+			result, err := es.applyOperation(userID, operation, opts)
+			if err != nil {
+				return &BatchError{Index: i, Err: err}
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// applyOperation выполняет операцию пакета. Вызывается под блокировкой
+func (es *EventStore) applyOperation(userID int, operation BatchOperation, opts []WriteOption) (BatchResult, error) {
+	options, err := newWriteOptions(append(append([]WriteOption{}, opts...), operation.Options...))
+	if err != nil {
+		return BatchResult{}, err
+	}
+
+	event := operation.Event
+	if event.UserID > 0 && event.UserID != userID {
+		return BatchResult{}, validationErrorf("операции пакета относятся только к календарю пользователя %d", userID)
+	}
+
+	result := BatchResult{Action: operation.Action, ID: event.ID}
+	switch operation.Action {
+	case BatchCreate:
+		event.UserID = userID
+		created, err := es.addEvent(event, options)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		localizeEvent(&created)
+		result.ID, result.Event = created.ID, &created
+
+	case BatchUpdate:
+		updated, err := es.updateEvent(userID, event, options)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		localizeEvent(&updated)
+		result.ID, result.Event = updated.ID, &updated
+
+	case BatchDelete:
+		if event.Occurrence != nil {
+			err = es.deleteOccurrence(userID, event.ID, *event.Occurrence, options)
+		} else {
+			err = es.deleteEvent(userID, event.ID, options)
+		}
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+	default:
+		return BatchResult{}, validationErrorf("неизвестная операция %q: ожидается create, update или delete", operation.Action)
+	}
+
+	return result, nil
+}
+
+// === HTTP ===
+
+// batchPath адрес пакетной обработки событий
+const batchPath = "/events/batch"
+
+// batchRequest тело запроса POST /events/batch
+type batchRequest struct {
+	UserID     int                     `json:"user_id"`
+	Operations []batchRequestOperation `json:"operations"`
+}
+
+// batchRequestOperation операция пакета в запросе
+type batchRequestOperation struct {
+	Action  BatchAction     `json:"action"`
+	Event   json.RawMessage `json:"event"`
+	Version int             `json:"version,omitempty"` // ожидаемая версия события, как в заголовке If-Match
+}
+
+// batchResponseItem результат операции пакета в ответе
+type batchResponseItem struct {
+	BatchResult
+	Conflicts *[]Event `json:"conflicts,omitempty"` // пересечения операции в режиме conflicts=report
+}
+
+// BatchHandler выполняет пакет операций над событиями одного календаря:
+//
+//	POST /events/batch  {"user_id": 1, "operations": [
+//	    {"action": "create", "event": {"title": "Ретро", "date": "2024-03-05T15:00:00Z"}},
+//	    {"action": "update", "event": {"id": 3, "title": "Планёрка"}, "version": 2},
+//	    {"action": "delete", "event": {"id": 4}}
+//	]}
+//
+// Пакет выполняется целиком или не выполняется совсем. В ответе — результаты операций в порядке запроса.
+// update изменяет только присутствующие в event поля (null очищает поле), version задаёт ожидаемую версию
+// события, как If-Match. Параметр conflicts=reject|report действует на каждую операцию; в режиме report
+// пересечения возвращаются в результате каждой операции. Если операция не выполнилась, ответ — ошибка
+// с кодом, как у REST API, и номером операции (с нуля) в поле index
+func (s *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.RespondWithAPIError(w, methodNotAllowed)
+		return
+	}
+
+	var request batchRequest
+	if err := s.DecodeJSONBody(r, &request); err != nil {
+		if tooLarge := bodyTooLarge(err); tooLarge != nil {
+			s.RespondWithAPIError(w, tooLarge)
+			return
+		}
+		s.RespondWithAPIError(w, badRequestf("Ошибка в ходе парсинга входных параметров: %v", err))
+		return
+	}
+
+	if len(request.Operations) > maxBatchSize {
+		s.RespondWithAPIError(w, badRequestf("в пакете больше %d операций", maxBatchSize))
+		return
+	}
+
+	userID, err := s.BodyUserID(r, request.UserID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	mode, err := s.conflictMode(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	operations := make([]BatchOperation, 0, len(request.Operations))
+	items := make([]batchResponseItem, 0, len(request.Operations))
+	for i, item := range request.Operations {
+		operation, err := s.decodeBatchOperation(item)
+		if err != nil {
+			s.RespondWithAPIError(w, &BatchError{Index: i, Err: err})
+			return
+		}
+
+		// Пересечения каждой операции собираются в свой срез
+		opts, conflicts := conflictModeOptions(mode)
+		operation.Options = append(operation.Options, opts...)
+
+		operations = append(operations, operation)
+		items = append(items, batchResponseItem{Conflicts: conflicts})
+	}
+
+	results, err := s.Calendar.ApplyBatch(userID, operations, s.actorOptions(r)...)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	for i, result := range results {
+		items[i].BatchResult = result
+	}
+
+	s.RespondWithResult(w, items)
+}
+
+// decodeBatchOperation разбирает операцию пакета. Событие разбирается так же строго, как тело запроса:
+// неизвестные поля — ошибка входных данных
+func (s *Server) decodeBatchOperation(item batchRequestOperation) (BatchOperation, error) {
+	if len(item.Event) == 0 {
+		return BatchOperation{}, badRequestf("не указано событие операции")
+	}
+
+	operation := BatchOperation{Action: item.Action}
+
+	decoder := json.NewDecoder(bytes.NewReader(item.Event))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&operation.Event); err != nil {
+		return BatchOperation{}, badRequestf("%v", err)
+	}
+
+	if item.Action != BatchCreate && operation.Event.ID < 1 {
+		return BatchOperation{}, badRequestf("некорректный ID события")
+	}
+
+	if item.Action == BatchUpdate {
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(item.Event, &present); err != nil {
+			return BatchOperation{}, badRequestf("%v", err)
+		}
+
+		keys := make([]string, 0, len(present))
+		for key := range present {
+			keys = append(keys, key)
+		}
+		operation.Options = append(operation.Options, UpdateFields(fieldNames(keys)...))
+	}
+
+	if item.Version > 0 {
+		operation.Options = append(operation.Options, IfMatch(item.Version))
+	}
+
+	return operation, nil
+}
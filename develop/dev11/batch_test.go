@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// === HTTP ===
+
+// batchItems разбирает поле result с результатами операций пакета
+func batchItems(t *testing.T, body []byte) []batchResponseItem {
+	t.Helper()
+
+	var items []batchResponseItem
+	if err := json.Unmarshal(decodeResponse(t, body).Result, &items); err != nil {
+		t.Fatalf("result не является списком результатов: %v\n%s", err, body)
+	}
+	return items
+}
+
+// batchErrorIndex разбирает номер операции из ответа с ошибкой
+func batchErrorIndex(t *testing.T, body []byte) *int {
+	t.Helper()
+
+	var response ErrorResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("ответ не является JSON: %v\n%s", err, body)
+	}
+	return response.Index
+}
+
+func TestBatchHandler(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := ts.do(t, testRequest{method: "POST", path: batchPath, contentType: jsonType, body: `{"user_id":1,"operations":[
+		{"action":"create","event":{"title":"Ретро","date":"2024-03-06T15:00:00Z"}},
+		{"action":"update","event":{"id":1,"title":"Стендап","location":null},"version":1},
+		{"action":"delete","event":{"id":2}}
+	]}`})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("код ответа %d:\n%s", resp.StatusCode, body)
+	}
+
+	items := batchItems(t, body)
+	if len(items) != 3 {
+		t.Fatalf("результатов %d, ожидалось 3", len(items))
+	}
+	if items[0].Action != BatchCreate || items[0].ID != 8 || items[0].Event == nil || items[0].Event.Title != "Ретро" {
+		t.Errorf("создание: %+v", items[0].BatchResult)
+	}
+	if items[1].Event == nil || items[1].Event.Title != "Стендап" || items[1].Event.Place != "" || items[1].Event.Version != 2 {
+		t.Errorf("изменение: %+v", items[1].Event)
+	}
+	if len(items[1].Event.Reminders) != 1 {
+		t.Errorf("изменение затронуло поля, которых нет в запросе: %+v", items[1].Event)
+	}
+	if items[2].Action != BatchDelete || items[2].ID != 2 || items[2].Event != nil {
+		t.Errorf("удаление: %+v", items[2].BatchResult)
+	}
+
+	store := ts.server.Calendar
+	if _, err := store.GetEvent(1, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("удалённое пакетом событие: ошибка %v", err)
+	}
+
+	// Каждая операция пакета записана в журнал аудита под своим номером
+	operations := map[uint64]bool{}
+	for _, id := range []int{8, 1, 2} {
+		history, err := store.History(1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := history[len(history)-1]
+		if operations[last.Operation] {
+			t.Errorf("операции пакета записаны под одним номером %d", last.Operation)
+		}
+		operations[last.Operation] = true
+	}
+
+	// Удалённое пакетом событие восстанавливается отдельно
+	if _, err := store.RestoreEvent(1, 2); err != nil {
+		t.Errorf("восстановление удалённого пакетом события: %v", err)
+	}
+}
+
+func TestBatchHandlerAtomic(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantIndex  int
+	}{
+		{
+			name:       "событие не найдено",
+			path:       batchPath,
+			body:       `{"user_id":1,"operations":[{"action":"create","event":{"title":"Ретро","date":"2024-03-06T15:00:00Z"}},{"action":"delete","event":{"id":1}},{"action":"delete","event":{"id":100}}]}`,
+			wantStatus: http.StatusNotFound,
+			wantIndex:  2,
+		},
+		{
+			name:       "пересечение с событием того же пакета",
+			path:       batchPath + "?conflicts=reject",
+			body:       `{"user_id":1,"operations":[{"action":"create","event":{"title":"Ретро","date":"2024-03-06T15:00:00Z","end":"2024-03-06T16:00:00Z"}},{"action":"create","event":{"title":"Обед","date":"2024-03-06T15:30:00Z","end":"2024-03-06T16:30:00Z"}}]}`,
+			wantStatus: http.StatusConflict,
+			wantIndex:  1,
+		},
+		{
+			name:       "устаревшая версия",
+			path:       batchPath,
+			body:       `{"user_id":1,"operations":[{"action":"update","event":{"id":1,"title":"Стендап"}},{"action":"update","event":{"id":1,"location":"Кухня"},"version":1}]}`,
+			wantStatus: http.StatusPreconditionFailed,
+			wantIndex:  1,
+		},
+		{
+			name:       "ошибка валидации",
+			path:       batchPath,
+			body:       `{"user_id":1,"operations":[{"action":"delete","event":{"id":1}},{"action":"create","event":{"title":"","date":"2024-03-06T15:00:00Z"}}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantIndex:  1,
+		},
+		{
+			name:       "событие другого календаря",
+			path:       batchPath,
+			body:       `{"user_id":1,"operations":[{"action":"delete","event":{"id":1}},{"action":"create","event":{"user_id":2,"title":"Чужое","date":"2024-03-06T15:00:00Z"}}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantIndex:  1,
+		},
+		{
+			name:       "изменение без ID",
+			path:       batchPath,
+			body:       `{"user_id":1,"operations":[{"action":"delete","event":{"id":1}},{"action":"update","event":{"title":"x"}}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantIndex:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			before, err := ts.server.Calendar.GetEventsForRange(1, at(2024, time.January, 1, 0, 0), at(2024, time.December, 31, 0, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, body := ts.do(t, testRequest{method: "POST", path: tt.path, contentType: jsonType, body: tt.body})
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа %d, ожидался %d:\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if index := batchErrorIndex(t, body); index == nil || *index != tt.wantIndex {
+				t.Errorf("номер операции %v, ожидался %d:\n%s", index, tt.wantIndex, body)
+			}
+
+			after, err := ts.server.Calendar.GetEventsForRange(1, at(2024, time.January, 1, 0, 0), at(2024, time.December, 31, 0, 0))
+			if err != nil || len(after) != len(before) {
+				t.Fatalf("после отклонённого пакета %d событий, ожидалось %d", len(after), len(before))
+			}
+			for i := range after {
+				if after[i].ID != before[i].ID || after[i].Version != before[i].Version || after[i].Title != before[i].Title {
+					t.Errorf("событие %d изменено отклонённым пакетом: %+v", before[i].ID, after[i])
+				}
+			}
+
+			if history, err := ts.server.Calendar.History(1, 1); err != nil || len(history) != 1 {
+				t.Errorf("журнал аудита события 1: %+v, %v", history, err)
+			}
+		})
+	}
+}
+
+func TestBatchHandlerConflicts(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, body := ts.do(t, testRequest{method: "POST", path: batchPath + "?conflicts=report", contentType: jsonType, body: `{"user_id":1,"operations":[
+		{"action":"create","event":{"title":"Ретро","date":"2030-01-10T15:00:00Z","end":"2030-01-10T16:00:00Z"}},
+		{"action":"create","event":{"title":"Обед","date":"2030-01-10T15:30:00Z","end":"2030-01-10T16:30:00Z"}},
+		{"action":"create","event":{"title":"Ужин","date":"2030-01-11T19:00:00Z","end":"2030-01-11T20:00:00Z"}}
+	]}`})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("код ответа %d:\n%s", resp.StatusCode, body)
+	}
+
+	// Пересечения каждой операции возвращаются отдельно
+	items := batchItems(t, body)
+	for i, want := range []int{0, 1, 0} {
+		if items[i].Conflicts == nil || len(*items[i].Conflicts) != want {
+			t.Errorf("пересечения операции %d: %+v, ожидалось %d", i, items[i].Conflicts, want)
+		}
+	}
+
+	// Неверный режим отклоняется и в пустом пакете
+	resp, body = ts.do(t, testRequest{method: "POST", path: batchPath + "?conflicts=maybe", contentType: jsonType, body: `{"user_id":1,"operations":[]}`})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("неверный режим conflicts: код ответа %d:\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestBatchHandlerAccess(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2}}
+
+	resp, body := ts.do(t, testRequest{method: "POST", path: batchPath, contentType: jsonType,
+		body:   `{"user_id":1,"operations":[{"action":"delete","event":{"id":1}}]}`,
+		header: map[string]string{"Authorization": "Bearer bob"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("пакет в чужом календаре: код ответа %d:\n%s", resp.StatusCode, body)
+	}
+
+	resp, body = ts.do(t, testRequest{method: "GET", path: batchPath, header: map[string]string{"Authorization": "Bearer alice"}})
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("GET: код ответа %d, Allow %q:\n%s", resp.StatusCode, resp.Header.Get("Allow"), body)
+	}
+
+	resp, body = ts.do(t, testRequest{method: "POST", path: batchPath, contentType: jsonType,
+		body:   `{"operations":[{"action":"delete","event":{"id":1}}]}`,
+		header: map[string]string{"Authorization": "Bearer alice"}})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("пакет в своём календаре: код ответа %d:\n%s", resp.StatusCode, body)
+	}
+}
+
+// === Хранилище ===
+
+func TestApplyBatchRollback(t *testing.T) {
+	storage := InitNewMemoryStorage()
+	store := InitNewEventStoreWithStorage(storage, InitNewMemoryAuditLog())
+
+	masterID, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.audit = failingAuditLog{InitNewMemoryAuditLog()}
+	var changes []EventChange
+	store.Watch(func(change EventChange) { changes = append(changes, change) })
+
+	occurrence := at(2024, time.March, 6, 7, 0)
+	_, err = store.ApplyBatch(1, []BatchOperation{
+		{Action: BatchCreate, Event: Event{Title: "Ретро", Date: at(2024, time.March, 6, 15, 0)}},
+		{Action: BatchUpdate, Event: Event{ID: masterID, Occurrence: &occurrence, Title: "Пробежка"}},
+		{Action: BatchDelete, Event: Event{ID: masterID}},
+	})
+	if err == nil {
+		t.Fatal("ApplyBatch() без записи в журнал аудита должен завершиться ошибкой")
+	}
+
+	if len(storage.Events) != 1 || storage.NextID != masterID+1 {
+		t.Errorf("после отмены пакета в хранилище %d событий и следующий ID %d, ожидалось 1 и %d", len(storage.Events), storage.NextID, masterID+1)
+	}
+	if event, err := store.GetEvent(1, masterID); err != nil || event.Version != 1 {
+		t.Errorf("серия после отмены пакета: %+v, %v", event, err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("подписчики получили отменённые изменения: %+v", changes)
+	}
+
+	if results, err := store.ApplyBatch(1, nil); err != nil || len(results) != 0 {
+		t.Errorf("пустой пакет: %+v, %v", results, err)
+	}
+	if _, err := store.ApplyBatch(1, []BatchOperation{{Action: "move"}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("неизвестная операция: ошибка %v, ожидалась ошибка валидации", err)
+	}
+}
+
+func TestApplyBatchUnit(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 100)
+	audit, err := storage.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := InitNewEventStoreWithStorage(storage, audit)
+	defer audit.Close()
+
+	walPath, auditPath := filepath.Join(dir, walFileName), filepath.Join(dir, auditFileName)
+	sizes := func() [2]int64 {
+		t.Helper()
+		var sizes [2]int64
+		for i, path := range []string{walPath, auditPath} {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes[i] = info.Size()
+		}
+		return sizes
+	}
+
+	// Пакет записывается в журнал хранилища одной строкой
+	if _, err := store.ApplyBatch(1, []BatchOperation{
+		{Action: BatchCreate, Event: Event{Title: "Ретро", Date: at(2024, time.March, 6, 15, 0)}},
+		{Action: BatchCreate, Event: Event{Title: "Обед", Date: at(2024, time.March, 6, 13, 0)}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("пакет записан %d строками журнала, ожидалась одна", lines)
+	}
+
+	// Отклонённый пакет не оставляет записей ни в журнале хранилища, ни в журнале аудита
+	before := sizes()
+	_, err = store.ApplyBatch(1, []BatchOperation{
+		{Action: BatchDelete, Event: Event{ID: 1}},
+		{Action: BatchDelete, Event: Event{ID: 100}},
+	})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 {
+		t.Fatalf("ApplyBatch(): ошибка %v, ожидалась ошибка операции 1", err)
+	}
+	if after := sizes(); after != before {
+		t.Errorf("отклонённый пакет изменил журналы: размеры %v, были %v", after, before)
+	}
+	if _, err := store.GetEvent(1, 1); err != nil {
+		t.Errorf("событие, удаление которого отменено: %v", err)
+	}
+}
+
+func TestApplyBatchSQLRollback(t *testing.T) {
+	storage := openTestSQLStorage(t, ":memory:")
+	defer storage.Close()
+	store := InitNewEventStoreWithStorage(storage, storage.AuditLog())
+
+	id, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 6, 15, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = store.ApplyBatch(1, []BatchOperation{
+		{Action: BatchCreate, Event: Event{Title: "Обед", Date: at(2024, time.March, 6, 13, 0)}},
+		{Action: BatchDelete, Event: Event{ID: id}},
+		{Action: BatchDelete, Event: Event{ID: 100}},
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ApplyBatch(): ошибка %v, ожидалась ошибка поиска", err)
+	}
+
+	events, err := store.GetUserEvents(1)
+	if err != nil || len(events) != 1 || events[0].ID != id {
+		t.Errorf("события после отката пакета: %+v, %v", events, err)
+	}
+	if history, err := store.History(1, id); err != nil || len(history) != 1 {
+		t.Errorf("журнал аудита после отката пакета: %+v, %v", history, err)
+	}
+	if next, err := store.AddEvent(Event{UserID: 1, Title: "Обед", Date: at(2024, time.March, 6, 13, 0)}); err != nil || next != id+1 {
+		t.Errorf("ID события после отката пакета %d (ошибка %v), ожидался %d", next, err, id+1)
+	}
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==                          == == =
+	= ==== CalDAV (RFC 4791): подмножество ==== =
+	  = == ==                          == == =
+*/
+
+// caldavPath корень ресурсов CalDAV. Календарь пользователя — коллекция /caldav/{user_id}/, каждое событие вместе
+// с выделенными из его серии вхождениями — ресурс /caldav/{user_id}/{uid}.ics
+const caldavPath = "/caldav/"
+
+// Пространства имён XML WebDAV и CalDAV
+const (
+	davNS    = "DAV:"
+	caldavNS = "urn:ietf:params:xml:ns:caldav"
+)
+
+// caldavAllow методы, поддерживаемые ресурсами CalDAV
+const caldavAllow = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+
+// === Ресурсы календаря ===
+
+// calendarResource ресурс календаря: событие и выделенные из его серии вхождения. Клиенты CalDAV хранят
+// изменённые вхождения в одном ресурсе с серией, поэтому и сервер отдаёт их одним документом iCalendar
+type calendarResource struct {
+	events []Event // первым идёт событие или серия, за ним выделенные вхождения
+}
+
+// master возвращает событие или серию ресурса
+func (cr calendarResource) master() Event {
+	return cr.events[0]
+}
+
+// name возвращает имя ресурса в коллекции: UID события с расширением .ics
+func (cr calendarResource) name() string {
+	return eventUID(cr.master()) + ".ics"
+}
+
+// etag возвращает ETag ресурса: версию события, а при выделенных вхождениях — ещё их ID и версии,
+// чтобы изменение любого вхождения меняло ETag ресурса
+func (cr calendarResource) etag() string {
+	parts := []string{strconv.Itoa(cr.master().Version)}
+	for _, instance := range cr.events[1:] {
+		parts = append(parts, fmt.Sprintf("%d.%d", instance.ID, instance.Version))
+	}
+	return strconv.Quote(strings.Join(parts, "-"))
+}
+
+// instanceAt возвращает выделенное вхождение ресурса, приходящееся на дату occurrence
+func (cr calendarResource) instanceAt(occurrence time.Time) (Event, bool) {
+	for _, instance := range cr.events[1:] {
+		if instance.Occurrence != nil && containsDay([]time.Time{*instance.Occurrence}, occurrence) {
+			return instance, true
+		}
+	}
+	return Event{}, false
+}
+
+// calendarData возвращает ресурс в формате iCalendar
+func (cr calendarResource) calendarData() (string, error) {
+	var buf bytes.Buffer
+	if err := WriteICalendar(&buf, cr.events); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// calendarResources собирает события пользователя в ресурсы календаря. Чужие события, в которых пользователь
+// участник, в его календарь CalDAV не попадают: изменять их он не может
+func calendarResources(events []Event) []calendarResource {
+	position := make(map[int]int)
+	var resources []calendarResource
+	for _, event := range events {
+		if event.SeriesID == 0 {
+			position[event.ID] = len(resources)
+			resources = append(resources, calendarResource{events: []Event{event}})
+		}
+	}
+
+	for _, event := range events {
+		if event.SeriesID == 0 {
+			continue
+		}
+		if i, ok := position[event.SeriesID]; ok {
+			resources[i].events = append(resources[i].events, event)
+			continue
+		}
+		// Вхождение, серия которого удалена отдельно, остаётся самостоятельным ресурсом
+		position[event.ID] = len(resources)
+		resources = append(resources, calendarResource{events: []Event{event}})
+	}
+
+	return resources
+}
+
+// findResource возвращает ресурс с именем name
+func findResource(resources []calendarResource, name string) (calendarResource, bool) {
+	for _, resource := range resources {
+		if resource.name() == name {
+			return resource, true
+		}
+	}
+	return calendarResource{}, false
+}
+
+// resourcesInRange возвращает ресурсы, хотя бы одно вхождение которых пересекается с полуинтервалом [start, end).
+// Событие без продолжительности попадает в период, если начинается в нём (RFC 4791, 9.9)
+func (s *Server) resourcesInRange(userID int, resources []calendarResource, start, end time.Time) ([]calendarResource, error) {
+	owner := make(map[int]int)
+	for i, resource := range resources {
+		for _, event := range resource.events {
+			owner[event.ID] = i
+		}
+	}
+
+	occurrences, err := s.Calendar.GetEventsForRange(userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make(map[int]bool)
+	for _, occurrence := range occurrences {
+		i, ok := owner[occurrence.ID]
+		if !ok || occurrence.UserID != userID {
+			continue
+		}
+
+		if from, to, ok := eventSpan(occurrence); ok {
+			matched[i] = matched[i] || (from.Before(end) && to.After(start))
+		} else {
+			matched[i] = matched[i] || (!occurrence.Date.Before(start) && occurrence.Date.Before(end))
+		}
+	}
+
+	var result []calendarResource
+	for i, resource := range resources {
+		if matched[i] {
+			result = append(result, resource)
+		}
+	}
+	return result, nil
+}
+
+// ReplaceICalResource заменяет ресурс календаря пользователя userID содержимым items: серию — событием без
+// RECURRENCE-ID, выделенные вхождения — изменёнными вхождениями. Вхождения, которых больше нет в документе,
+// удаляются, а новые выделяются из серии. Участники через CalDAV не передаются и сохраняются прежними.
+// Параметры opts (например, Actor) применяются к каждой записи; версия серии сверяется с версией ресурса.
+// Ресурс заменяется в одной единице работы хранилища: если одна из записей не выполнилась, ресурс остаётся прежним
+func ReplaceICalResource(store *EventStore, userID int, resource calendarResource, items []ICalEvent, opts ...WriteOption) error {
+	current := resource.master()
+
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return err
+	}
+	masterOptions, err := newWriteOptions(append(append([]WriteOption{}, opts...), IfMatch(current.Version)))
+	if err != nil {
+		return err
+	}
+
+	var master Event
+	var overrides []ICalEvent
+	for _, item := range items {
+		if item.RecurrenceID == nil {
+			master = item.Event
+		} else {
+			overrides = append(overrides, item)
+		}
+	}
+
+	if master.Recurrence == nil && len(overrides) > 0 {
+		return validationErrorf("изменённые вхождения допустимы только у повторяющегося события")
+	}
+
+	kept := make(map[int]ICalEvent)
+	var detached []ICalEvent
+	var detachedDates []time.Time
+	for _, item := range overrides {
+		if instance, ok := resource.instanceAt(*item.RecurrenceID); ok {
+			kept[instance.ID] = item
+		} else {
+			detached = append(detached, item)
+			detachedDates = append(detachedDates, *item.RecurrenceID)
+		}
+	}
+
+	// Дату нового изменённого вхождения исключит из серии UpdateEvent, а даты сохраняемых вхождений уже исключены
+	if master.Recurrence != nil {
+		master.Recurrence = cloneRecurrence(master.Recurrence)
+		exceptions := master.Recurrence.Exceptions[:0]
+		for _, exception := range master.Recurrence.Exceptions {
+			if !containsDay(detachedDates, exception) {
+				exceptions = append(exceptions, exception)
+			}
+		}
+		master.Recurrence.Exceptions = exceptions
+
+		for _, instance := range resource.events[1:] {
+			if _, ok := kept[instance.ID]; ok {
+				master.Recurrence.AddException(*instance.Occurrence)
+			}
+		}
+	}
+
+	master.ID = current.ID
+	master.Attendees = current.Attendees
+
+	store.Lock()
+	defer store.Unlock()
+
+	return store.transaction(func() error {
+		if _, err := store.replaceEvent(userID, master, masterOptions); err != nil {
+			return err
+		}
+
+		// Серия, переставшая повторяться, удалена вместе с выделенными вхождениями
+		if master.Recurrence == nil {
+			return nil
+		}
+
+		for _, instance := range resource.events[1:] {
+			item, ok := kept[instance.ID]
+			if !ok {
+				if err := store.deleteEvent(userID, instance.ID, options); err != nil {
+					return err
+				}
+				continue
+			}
+
+			changes := item.Event
+			changes.ID = instance.ID
+			changes.Attendees = instance.Attendees
+			if _, err := store.replaceEvent(userID, changes, options); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range detached {
+			changes := item.Event
+			changes.ID = current.ID
+			changes.Occurrence = item.RecurrenceID
+			if _, err := store.updateEvent(userID, changes, options); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// === XML запросов и ответов ===
+
+// davPropNames имена свойств из элемента DAV:prop запроса
+type davPropNames []xml.Name
+
+// UnmarshalXML собирает имена дочерних элементов, пропуская их содержимое
+func (names *davPropNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			*names = append(*names, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// davPropfind тело запроса PROPFIND. Без тела и с allprop возвращаются все свойства, кроме calendar-data
+type davPropfind struct {
+	AllProp  *struct{}    `xml:"DAV: allprop"`
+	PropName *struct{}    `xml:"DAV: propname"`
+	Prop     davPropNames `xml:"DAV: prop"`
+}
+
+// calDAVTimeRange ограничение времени фильтра calendar-query: моменты UTC в формате iCalendar
+type calDAVTimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// calDAVCompFilter фильтр компонентов calendar-query
+type calDAVCompFilter struct {
+	Name        string             `xml:"name,attr"`
+	TimeRange   *calDAVTimeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	CompFilters []calDAVCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+// davReport тело запроса REPORT: calendar-query или calendar-multiget
+type davReport struct {
+	XMLName xml.Name
+	Prop    davPropNames `xml:"DAV: prop"`
+	Filter  *struct {
+		CompFilter calDAVCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+// eventRange возвращает полуинтервал, которым фильтр calendar-query ограничивает события.
+// ok == false, если фильтр выбирает компоненты, отличные от VEVENT
+func (f calDAVCompFilter) eventRange() (start, end time.Time, ok bool, err error) {
+	start, end = time.Time{}, maxEventTime
+	if f.Name != "VCALENDAR" {
+		return start, end, false, nil
+	}
+
+	for _, component := range f.CompFilters {
+		if component.Name != "VEVENT" {
+			return start, end, false, nil
+		}
+		if component.TimeRange == nil {
+			continue
+		}
+
+		if component.TimeRange.Start != "" {
+			if start, err = time.Parse(icalDateTimeUTC, component.TimeRange.Start); err != nil {
+				return start, end, false, fmt.Errorf("time-range: некорректное начало %q", component.TimeRange.Start)
+			}
+		}
+		if component.TimeRange.End != "" {
+			if end, err = time.Parse(icalDateTimeUTC, component.TimeRange.End); err != nil {
+				return start, end, false, fmt.Errorf("time-range: некорректное окончание %q", component.TimeRange.End)
+			}
+		}
+	}
+
+	return start, end, true, nil
+}
+
+// davProp свойство ресурса в ответе: текст или готовая XML-разметка
+type davProp struct {
+	XMLName xml.Name
+	Text    string `xml:",chardata"`
+	Inner   string `xml:",innerxml"`
+}
+
+// davPropList содержимое элемента DAV:prop ответа
+type davPropList struct {
+	Props []davProp
+}
+
+// davPropstat свойства ресурса с общим статусом
+type davPropstat struct {
+	Prop   davPropList `xml:"D:prop"`
+	Status string      `xml:"D:status"`
+}
+
+// davResponse свойства одного ресурса в ответе multistatus
+type davResponse struct {
+	Href      string        `xml:"D:href"`
+	Propstats []davPropstat `xml:"D:propstat,omitempty"`
+	Status    string        `xml:"D:status,omitempty"`
+}
+
+// davMultistatus ответ 207 Multi-Status. Префиксы D и C объявлены в корне и используются в разметке свойств
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	DAV       string        `xml:"xmlns:D,attr"`
+	CalDAV    string        `xml:"xmlns:C,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// davStatus возвращает строку статуса для элемента DAV:status
+func davStatus(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// propstats раскладывает запрошенные свойства по статусам: найденные — 200, неизвестные — 404
+func propstats(requested []xml.Name, lookup func(xml.Name) (davProp, bool)) []davPropstat {
+	var found, missing []davProp
+	for _, name := range requested {
+		if prop, ok := lookup(name); ok {
+			prop.XMLName = name
+			found = append(found, prop)
+		} else {
+			missing = append(missing, davProp{XMLName: name})
+		}
+	}
+
+	var result []davPropstat
+	if len(found) > 0 {
+		result = append(result, davPropstat{Prop: davPropList{found}, Status: davStatus(http.StatusOK)})
+	}
+	if len(missing) > 0 {
+		result = append(result, davPropstat{Prop: davPropList{missing}, Status: davStatus(http.StatusNotFound)})
+	}
+	return result
+}
+
+// Свойства, возвращаемые на allprop и PROPFIND без тела
+var (
+	collectionAllProps = []xml.Name{
+		{Space: davNS, Local: "resourcetype"}, {Space: davNS, Local: "displayname"},
+		{Space: davNS, Local: "current-user-principal"}, {Space: caldavNS, Local: "calendar-home-set"},
+		{Space: caldavNS, Local: "supported-calendar-component-set"},
+	}
+	resourceAllProps = []xml.Name{
+		{Space: davNS, Local: "resourcetype"}, {Space: davNS, Local: "getetag"}, {Space: davNS, Local: "getcontenttype"},
+	}
+)
+
+// === HTTP ===
+
+// caldavTarget разобранный адрес ресурса CalDAV
+type caldavTarget struct {
+	userID   int    // 0 — корень /caldav/
+	resource string // имя ресурса в коллекции; пусто — сама коллекция
+}
+
+// collectionHref возвращает адрес коллекции пользователя
+func collectionHref(userID int) string {
+	return fmt.Sprintf("%s%d/", caldavPath, userID)
+}
+
+// resourceHref возвращает адрес ресурса коллекции пользователя
+func resourceHref(userID int, name string) string {
+	return collectionHref(userID) + url.PathEscape(name)
+}
+
+// parseCalDAVPath разбирает адрес /caldav/[{user_id}/[{name}.ics]]
+func parseCalDAVPath(path string) (caldavTarget, bool) {
+	rest := strings.TrimPrefix(path, caldavPath)
+	if rest == "" {
+		return caldavTarget{}, true
+	}
+
+	userStr, name, _ := strings.Cut(rest, "/")
+	userID, err := strconv.Atoi(userStr)
+	if err != nil || userID < 1 {
+		return caldavTarget{}, false
+	}
+
+	if name == "" {
+		return caldavTarget{userID: userID}, true
+	}
+	if strings.Contains(name, "/") || !strings.HasSuffix(name, ".ics") {
+		return caldavTarget{}, false
+	}
+	return caldavTarget{userID: userID, resource: name}, true
+}
+
+// CalDAVHandler обслуживает календари пользователей по CalDAV для стандартных клиентов:
+//
+//	OPTIONS  /caldav/...                  возможности сервера (заголовки DAV и Allow)
+//	PROPFIND /caldav/                     текущий пользователь (current-user-principal)
+//	PROPFIND /caldav/{user_id}/           свойства календаря, с Depth: 1 — ещё и его ресурсы
+//	REPORT   /caldav/{user_id}/           calendar-query с time-range или calendar-multiget
+//	GET      /caldav/{user_id}/           весь календарь в формате iCalendar
+//	GET      /caldav/{user_id}/{uid}.ics  ресурс: событие с выделенными вхождениями
+//	PUT      /caldav/{user_id}/{uid}.ics  создание или замена ресурса; UID в документе совпадает с именем ресурса
+//	DELETE   /caldav/{user_id}/{uid}.ics  удаление ресурса
+//
+// PUT и DELETE учитывают If-Match, PUT — ещё и If-None-Match: *. Ошибки возвращаются в формате REST API.
+// Права проверяются как у остальных методов: чтение — доступ read, изменение — доступ write
+func (s *Server) CalDAVHandler(w http.ResponseWriter, r *http.Request) {
+	target, ok := parseCalDAVPath(r.URL.Path)
+	if !ok {
+		s.RespondWithAPIError(w, &HTTPError{Status: http.StatusNotFound, Code: "not_found", Err: fmt.Errorf("ресурс %s не найден", r.URL.Path)})
+		return
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.Header().Set("Allow", caldavAllow)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch {
+	case target.userID == 0 && r.Method == "PROPFIND":
+		s.caldavPropfindRoot(w, r)
+	case target.userID == 0:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+		s.RespondWithAPIError(w, methodNotAllowed)
+	case target.resource == "":
+		s.caldavCollection(w, r, target.userID)
+	default:
+		s.caldavResource(w, r, target)
+	}
+}
+
+// caldavCollection обрабатывает запросы к календарю пользователя
+func (s *Server) caldavCollection(w http.ResponseWriter, r *http.Request, userID int) {
+	switch r.Method {
+	case "PROPFIND", "REPORT", http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND, REPORT")
+		s.RespondWithAPIError(w, methodNotAllowed)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	events, err := s.Calendar.GetUserEvents(userID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+	resources := calendarResources(events)
+
+	switch r.Method {
+	case "PROPFIND":
+		s.caldavPropfindCollection(w, r, userID, resources)
+	case "REPORT":
+		s.caldavReport(w, r, userID, resources)
+	default:
+		var events []Event
+		for _, resource := range resources {
+			events = append(events, resource.events...)
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if err := WriteICalendar(w, events); err != nil {
+			log.Printf("Ошибка при записи календаря в соединение: %v", err)
+		}
+	}
+}
+
+// caldavResource обрабатывает запросы к ресурсу календаря
+func (s *Server) caldavResource(w http.ResponseWriter, r *http.Request, target caldavTarget) {
+	need := PermissionRead
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, "PROPFIND":
+	case http.MethodPut, http.MethodDelete:
+		need = PermissionWrite
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND")
+		s.RespondWithAPIError(w, methodNotAllowed)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, target.userID, need); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	events, err := s.Calendar.GetUserEvents(target.userID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	resource, exists := findResource(calendarResources(events), target.resource)
+	if r.Method == http.MethodPut {
+		s.caldavPut(w, r, target, resource, exists)
+		return
+	}
+
+	if !exists {
+		s.RespondWithAPIError(w, notFoundErrorf("ресурс %s не найден", target.resource))
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		request, err := s.decodePropfind(r)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+		s.respondMultistatus(w, []davResponse{s.resourceResponse(target.userID, resource, request.props(resourceAllProps))})
+	case http.MethodDelete:
+		if err := checkResourceETag(r, resource, true); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		opts := append([]WriteOption{IfMatch(resource.master().Version)}, s.actorOptions(r)...)
+		if err := s.Calendar.DeleteEvent(target.userID, resource.master().ID, opts...); err != nil {
+			s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе удаления ресурса %s: %w", target.resource, err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		data, err := resource.calendarData()
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("ETag", resource.etag())
+		io.WriteString(w, data)
+	}
+}
+
+// checkResourceETag проверяет условие If-Match запроса к существующему ресурсу (или его отсутствие при exists == false)
+func checkResourceETag(r *http.Request, resource calendarResource, exists bool) error {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || (header == "*" && exists) {
+		return nil
+	}
+
+	if exists {
+		etag := resource.etag()
+		for _, tag := range strings.Split(header, ",") {
+			if strings.TrimSpace(tag) == etag {
+				return nil
+			}
+		}
+	}
+
+	return preconditionErrorf("ресурс изменён другим клиентом")
+}
+
+// caldavPut создаёт ресурс из документа iCalendar или заменяет существующий
+func (s *Server) caldavPut(w http.ResponseWriter, r *http.Request, target caldavTarget, resource calendarResource, exists bool) {
+	if exists && strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		s.RespondWithAPIError(w, preconditionErrorf("ресурс %s уже существует", target.resource))
+		return
+	}
+	if err := checkResourceETag(r, resource, exists); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	items, err := ParseICalendar(r.Body)
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		s.RespondWithAPIError(w, tooLarge)
+		return
+	}
+	if err != nil {
+		s.RespondWithAPIError(w, badRequestf("Ошибка в процессе разбора iCalendar: %v", err))
+		return
+	}
+
+	if err := checkResourceItems(items, strings.TrimSuffix(target.resource, ".ics")); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	status := http.StatusNoContent
+	if exists {
+		err = ReplaceICalResource(s.Calendar, target.userID, resource, items, s.actorOptions(r)...)
+	} else {
+		status = http.StatusCreated
+		_, err = ImportICalEvents(s.Calendar, target.userID, items, s.actorOptions(r)...)
+	}
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в процессе записи ресурса %s: %w", target.resource, err))
+		return
+	}
+
+	// Ресурс уже записан, поэтому ошибка чтения лишает ответ только ETag
+	if events, err := s.Calendar.GetUserEvents(target.userID); err != nil {
+		log.Printf("Ошибка чтения записанного ресурса %s: %v", target.resource, err)
+	} else if stored, ok := findResource(calendarResources(events), target.resource); ok {
+		w.Header().Set("ETag", stored.etag())
+	}
+	w.WriteHeader(status)
+}
+
+// checkResourceItems проверяет, что документ описывает ровно один ресурс uid: все компоненты с этим UID и одна серия
+func checkResourceItems(items []ICalEvent, uid string) error {
+	masters := 0
+	for _, item := range items {
+		if item.Event.UID != uid {
+			return badRequestf("UID события %q не совпадает с именем ресурса %q", item.Event.UID, uid+".ics")
+		}
+		if item.RecurrenceID == nil {
+			masters++
+		}
+	}
+
+	if masters != 1 {
+		return badRequestf("ресурс должен содержать ровно одно событие без RECURRENCE-ID, получено %d", masters)
+	}
+	return nil
+}
+
+// decodeDAVBody разбирает XML-тело запроса WebDAV. Пустое тело не ошибка: v остаётся нулевым
+func decodeDAVBody(r *http.Request, v interface{}) error {
+	err := xml.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		return tooLarge
+	}
+	if err != nil {
+		return badRequestf("некорректное XML-тело запроса: %v", err)
+	}
+	return nil
+}
+
+// decodePropfind разбирает тело запроса PROPFIND
+func (s *Server) decodePropfind(r *http.Request) (davPropfind, error) {
+	var request davPropfind
+	err := decodeDAVBody(r, &request)
+	return request, err
+}
+
+// props возвращает запрошенные свойства, а для allprop, propname и пустого запроса — all
+func (p davPropfind) props(all []xml.Name) []xml.Name {
+	if p.AllProp != nil || p.PropName != nil || len(p.Prop) == 0 {
+		return all
+	}
+	return p.Prop
+}
+
+// caldavPropfindRoot возвращает свойства корня: по current-user-principal клиент находит календарь пользователя
+func (s *Server) caldavPropfindRoot(w http.ResponseWriter, r *http.Request) {
+	request, err := s.decodePropfind(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	names := request.props([]xml.Name{{Space: davNS, Local: "resourcetype"}, {Space: davNS, Local: "current-user-principal"}})
+	response := davResponse{Href: caldavPath, Propstats: propstats(names, func(name xml.Name) (davProp, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return davProp{Inner: "<D:collection/>"}, true
+		case xml.Name{Space: davNS, Local: "current-user-principal"}:
+			return currentUserPrincipal(r), true
+		}
+		return davProp{}, false
+	})}
+
+	s.respondMultistatus(w, []davResponse{response})
+}
+
+// currentUserPrincipal возвращает свойство current-user-principal: коллекцию аутентифицированного пользователя
+func currentUserPrincipal(r *http.Request) davProp {
+	if userID, ok := AuthenticatedUserID(r.Context()); ok {
+		return davProp{Inner: "<D:href>" + collectionHref(userID) + "</D:href>"}
+	}
+	return davProp{Inner: "<D:unauthenticated/>"}
+}
+
+// caldavPropfindCollection возвращает свойства календаря, а с заголовком Depth: 1 — и свойства его ресурсов
+func (s *Server) caldavPropfindCollection(w http.ResponseWriter, r *http.Request, userID int, resources []calendarResource) {
+	request, err := s.decodePropfind(r)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	names := request.props(collectionAllProps)
+	responses := []davResponse{{Href: collectionHref(userID), Propstats: propstats(names, func(name xml.Name) (davProp, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return davProp{Inner: "<D:collection/><C:calendar/>"}, true
+		case xml.Name{Space: davNS, Local: "displayname"}:
+			return davProp{Text: fmt.Sprintf("Календарь пользователя %d", userID)}, true
+		case xml.Name{Space: davNS, Local: "current-user-principal"}:
+			return currentUserPrincipal(r), true
+		case xml.Name{Space: caldavNS, Local: "calendar-home-set"}:
+			return davProp{Inner: "<D:href>" + collectionHref(userID) + "</D:href>"}, true
+		case xml.Name{Space: caldavNS, Local: "supported-calendar-component-set"}:
+			return davProp{Inner: `<C:comp name="VEVENT"/>`}, true
+		}
+		return davProp{}, false
+	})}}
+
+	// Depth: infinity для календаря равносилен Depth: 1 — вложенных коллекций в нём нет
+	if r.Header.Get("Depth") != "0" {
+		for _, resource := range resources {
+			responses = append(responses, s.resourceResponse(userID, resource, request.props(resourceAllProps)))
+		}
+	}
+
+	s.respondMultistatus(w, responses)
+}
+
+// resourceResponse возвращает запрошенные свойства ресурса календаря
+func (s *Server) resourceResponse(userID int, resource calendarResource, names []xml.Name) davResponse {
+	return davResponse{Href: resourceHref(userID, resource.name()), Propstats: propstats(names, func(name xml.Name) (davProp, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return davProp{}, true
+		case xml.Name{Space: davNS, Local: "getetag"}:
+			return davProp{Text: resource.etag()}, true
+		case xml.Name{Space: davNS, Local: "getcontenttype"}:
+			return davProp{Text: "text/calendar; charset=utf-8; component=vevent"}, true
+		case xml.Name{Space: caldavNS, Local: "calendar-data"}:
+			data, err := resource.calendarData()
+			if err != nil {
+				log.Printf("Ошибка формирования ресурса %s: %v", resource.name(), err)
+				return davProp{}, false
+			}
+			return davProp{Text: data}, true
+		}
+		return davProp{}, false
+	})}
+}
+
+// caldavReport выполняет REPORT calendar-query или calendar-multiget над календарём пользователя
+func (s *Server) caldavReport(w http.ResponseWriter, r *http.Request, userID int, resources []calendarResource) {
+	var request davReport
+	if err := decodeDAVBody(r, &request); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	names := []xml.Name(request.Prop)
+	if len(names) == 0 {
+		names = resourceAllProps
+	}
+
+	var responses []davResponse
+	switch request.XMLName {
+	case xml.Name{Space: caldavNS, Local: "calendar-query"}:
+		matched := resources
+		if request.Filter != nil {
+			start, end, ok, err := request.Filter.CompFilter.eventRange()
+			if err != nil {
+				s.RespondWithAPIError(w, badRequestf("%v", err))
+				return
+			}
+
+			matched = nil
+			if ok {
+				if matched, err = s.resourcesInRange(userID, resources, start, end); err != nil {
+					s.RespondWithAPIError(w, err)
+					return
+				}
+			}
+		}
+
+		for _, resource := range matched {
+			responses = append(responses, s.resourceResponse(userID, resource, names))
+		}
+	case xml.Name{Space: caldavNS, Local: "calendar-multiget"}:
+		for _, href := range request.Hrefs {
+			name, err := url.PathUnescape(strings.TrimPrefix(strings.TrimSpace(href), collectionHref(userID)))
+			if resource, ok := findResource(resources, name); err == nil && ok {
+				responses = append(responses, s.resourceResponse(userID, resource, names))
+			} else {
+				responses = append(responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+			}
+		}
+	default:
+		s.RespondWithAPIError(w, &HTTPError{
+			Status: http.StatusForbidden,
+			Code:   "unsupported_report",
+			Err:    fmt.Errorf("отчёт %s %s не поддерживается", request.XMLName.Space, request.XMLName.Local),
+		})
+		return
+	}
+
+	s.respondMultistatus(w, responses)
+}
+
+// respondMultistatus отправляет клиенту ответ 207 Multi-Status
+func (s *Server) respondMultistatus(w http.ResponseWriter, responses []davResponse) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(davMultistatus{DAV: davNS, CalDAV: caldavNS, Responses: responses}); err != nil {
+		log.Printf("Ошибка при записи ответа multistatus в соединение: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMultistatus разобранный ответ 207 Multi-Status
+type testMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Status    string `xml:"DAV: status"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ETag         string `xml:"DAV: getetag"`
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+				Inner        string `xml:",innerxml"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// hrefs возвращает адреса ресурсов ответа по алфавиту
+func (ms testMultistatus) hrefs() []string {
+	var result []string
+	for _, response := range ms.Responses {
+		result = append(result, response.Href)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// caldavRecording возвращает тело записанного запроса клиента CalDAV из testdata/caldav
+func caldavRecording(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "caldav", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// decodeMultistatus разбирает ответ 207 Multi-Status
+func decodeMultistatus(t *testing.T, resp *http.Response, body []byte) testMultistatus {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("код ответа %d, ожидался 207\n%s", resp.StatusCode, body)
+	}
+
+	var ms testMultistatus
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		t.Fatalf("ответ не разбирается как multistatus: %v\n%s", err, body)
+	}
+	return ms
+}
+
+func TestCalDAVDiscovery(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice-token": 1, "bob-token": 2}}
+	basic := func(token string) string { return "Basic " + base64.StdEncoding.EncodeToString([]byte("user:"+token)) }
+
+	resp, _ := ts.do(t, testRequest{method: "OPTIONS", path: "/caldav/1/", header: map[string]string{"Authorization": basic("alice-token")}})
+	if dav := resp.Header.Get("DAV"); !strings.Contains(dav, "calendar-access") {
+		t.Errorf("DAV = %q, ожидалось calendar-access", dav)
+	}
+
+	// Клиент с паролем-токеном находит свой календарь через current-user-principal
+	resp, body := ts.do(t, testRequest{method: "PROPFIND", path: "/caldav/", contentType: "application/xml", body: caldavRecording(t, "propfind_principal.xml"),
+		header: map[string]string{"Authorization": basic("alice-token"), "Depth": "0"}})
+	ms := decodeMultistatus(t, resp, body)
+	if len(ms.Responses) != 1 || !strings.Contains(ms.Responses[0].Propstats[0].Prop.Inner, "/caldav/1/") {
+		t.Fatalf("current-user-principal: %s", body)
+	}
+
+	resp, body = ts.do(t, testRequest{method: "PROPFIND", path: "/caldav/1/", contentType: "application/xml", body: caldavRecording(t, "propfind_calendar.xml"),
+		header: map[string]string{"Authorization": basic("alice-token"), "Depth": "1"}})
+	ms = decodeMultistatus(t, resp, body)
+
+	want := []string{"/caldav/1/", "/caldav/1/1@dev11.ics", "/caldav/1/2@dev11.ics", "/caldav/1/3@dev11.ics", "/caldav/1/4@dev11.ics", "/caldav/1/5@dev11.ics", "/caldav/1/6@dev11.ics"}
+	if got := ms.hrefs(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ресурсы %v, ожидались %v", got, want)
+	}
+
+	collection := ms.Responses[0]
+	if len(collection.Propstats) != 2 || !strings.Contains(collection.Propstats[0].Prop.Inner, "calendar") || !strings.Contains(collection.Propstats[1].Status, "404") {
+		t.Errorf("свойства календаря: найденные и неизвестные (getctag, getetag) должны быть в разных propstat: %+v", collection.Propstats)
+	}
+	for _, response := range ms.Responses[1:] {
+		if response.Href == "/caldav/1/1@dev11.ics" && response.Propstats[0].Prop.ETag != `"1"` {
+			t.Errorf("ETag ресурса %q, ожидался \"1\"", response.Propstats[0].Prop.ETag)
+		}
+	}
+
+	// Без доступа к чужому календарю
+	if resp, body := ts.do(t, testRequest{method: "PROPFIND", path: "/caldav/1/", header: map[string]string{"Authorization": basic("bob-token")}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("PROPFIND чужого календаря: код ответа %d\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestCalDAVReport(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name      string
+		recording string
+		wantHrefs []string
+		check     func(*testing.T, testMultistatus)
+	}{
+		{
+			name:      "calendar-query за неделю",
+			recording: "report_query.xml",
+			wantHrefs: []string{"/caldav/1/1@dev11.ics", "/caldav/1/2@dev11.ics", "/caldav/1/6@dev11.ics"},
+			check: func(t *testing.T, ms testMultistatus) {
+				for _, response := range ms.Responses {
+					data := response.Propstats[0].Prop.CalendarData
+					if !strings.HasPrefix(data, "BEGIN:VCALENDAR\r\n") || response.Propstats[0].Prop.ETag == "" {
+						t.Errorf("%s: calendar-data %q, ETag %q", response.Href, data, response.Propstats[0].Prop.ETag)
+					}
+					if response.Href == "/caldav/1/6@dev11.ics" && !strings.Contains(data, "RRULE:FREQ=DAILY;COUNT=3") {
+						t.Errorf("серия должна отдаваться одним ресурсом с RRULE:\n%s", data)
+					}
+				}
+			},
+		},
+		{
+			name:      "calendar-query задач",
+			recording: "report_todo.xml",
+		},
+		{
+			name:      "calendar-multiget",
+			recording: "report_multiget.xml",
+			wantHrefs: []string{"/caldav/1/1@dev11.ics", "/caldav/1/missing.ics"},
+			check: func(t *testing.T, ms testMultistatus) {
+				for _, response := range ms.Responses {
+					switch response.Href {
+					case "/caldav/1/1@dev11.ics":
+						if !strings.Contains(response.Propstats[0].Prop.CalendarData, "SUMMARY:Планёрка") {
+							t.Errorf("calendar-data: %q", response.Propstats[0].Prop.CalendarData)
+						}
+					default:
+						if !strings.Contains(response.Status, "404") {
+							t.Errorf("%s: статус %q, ожидался 404", response.Href, response.Status)
+						}
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.do(t, testRequest{method: "REPORT", path: "/caldav/1/?user_id=1", contentType: "application/xml", body: caldavRecording(t, tt.recording), header: map[string]string{"Depth": "1"}})
+			ms := decodeMultistatus(t, resp, body)
+
+			if got := ms.hrefs(); strings.Join(got, " ") != strings.Join(tt.wantHrefs, " ") {
+				t.Errorf("ресурсы %v, ожидались %v", got, tt.wantHrefs)
+			}
+			if tt.check != nil {
+				tt.check(t, ms)
+			}
+		})
+	}
+}
+
+func TestCalDAVPutDelete(t *testing.T) {
+	ts := newTestServer(t)
+	const ics = "text/calendar; charset=utf-8"
+
+	resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/retro-2024.ics", contentType: ics, body: caldavRecording(t, "put_event.ics"), header: map[string]string{"If-None-Match": "*"}})
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("ETag") != `"1"` {
+		t.Fatalf("создание: код ответа %d, ETag %q\n%s", resp.StatusCode, resp.Header.Get("ETag"), body)
+	}
+
+	changed := strings.Replace(caldavRecording(t, "put_event.ics"), "SUMMARY:Ретро", "SUMMARY:Ретроспектива", 1)
+	tests := []struct {
+		name       string
+		req        testRequest
+		wantStatus int
+		wantETag   string
+	}{
+		{"повторное создание", testRequest{method: "PUT", path: "/caldav/1/retro-2024.ics", contentType: ics, body: changed, header: map[string]string{"If-None-Match": "*"}}, http.StatusPreconditionFailed, ""},
+		{"UID не совпадает с именем", testRequest{method: "PUT", path: "/caldav/1/other.ics", contentType: ics, body: changed}, http.StatusBadRequest, ""},
+		{"устаревший ETag", testRequest{method: "PUT", path: "/caldav/1/retro-2024.ics", contentType: ics, body: changed, header: map[string]string{"If-Match": `"7"`}}, http.StatusPreconditionFailed, ""},
+		{"замена", testRequest{method: "PUT", path: "/caldav/1/retro-2024.ics", contentType: ics, body: changed, header: map[string]string{"If-Match": `"1"`}}, http.StatusNoContent, `"2"`},
+		{"чтение", testRequest{method: "GET", path: "/caldav/1/retro-2024.ics"}, http.StatusOK, `"2"`},
+		{"удаление с устаревшим ETag", testRequest{method: "DELETE", path: "/caldav/1/retro-2024.ics", header: map[string]string{"If-Match": `"1"`}}, http.StatusPreconditionFailed, ""},
+		{"удаление", testRequest{method: "DELETE", path: "/caldav/1/retro-2024.ics", header: map[string]string{"If-Match": `"2"`}}, http.StatusNoContent, ""},
+		{"чтение удалённого", testRequest{method: "GET", path: "/caldav/1/retro-2024.ics"}, http.StatusNotFound, ""},
+		{"не ресурс календаря", testRequest{method: "GET", path: "/caldav/1/retro-2024.txt"}, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ts.do(t, tt.req)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+			if tt.wantETag != "" && resp.Header.Get("ETag") != tt.wantETag {
+				t.Errorf("ETag %q, ожидался %q", resp.Header.Get("ETag"), tt.wantETag)
+			}
+			if tt.req.method == "GET" && tt.wantStatus == http.StatusOK && !strings.Contains(string(body), "SUMMARY:Ретроспектива") {
+				t.Errorf("ресурс не изменён:\n%s", body)
+			}
+		})
+	}
+}
+
+func TestCalDAVPutSeries(t *testing.T) {
+	ts := newTestServer(t)
+	const ics = "text/calendar; charset=utf-8"
+	series := caldavRecording(t, "put_series.ics")
+
+	if resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/standup.ics", contentType: ics, body: series}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("создание серии: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	occurrences := func() []string {
+		events, err := ts.server.Calendar.GetEventsForRange(1, at(2024, time.March, 11, 0, 0), at(2024, time.March, 14, 0, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result []string
+		for _, event := range events {
+			if strings.HasPrefix(event.Title, "Стендап") {
+				result = append(result, event.Date.Format("02 15:04")+" "+event.Title)
+			}
+		}
+		return result
+	}
+
+	want := "11 09:00 Стендап|12 10:00 Стендап (перенос)|13 09:00 Стендап"
+	if got := strings.Join(occurrences(), "|"); got != want {
+		t.Fatalf("вхождения после создания %q, ожидались %q", got, want)
+	}
+
+	// Клиент сохраняет полученный ресурс как есть: серия и изменённое вхождение не должны раздвоиться
+	resp, body := ts.do(t, testRequest{method: "GET", path: "/caldav/1/standup.ics"})
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "RECURRENCE-ID") {
+		t.Fatalf("чтение серии: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	if resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/standup.ics", contentType: ics, body: string(body), header: map[string]string{"If-Match": resp.Header.Get("ETag")}}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("повторная запись серии: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	if got := strings.Join(occurrences(), "|"); got != want {
+		t.Errorf("вхождения после повторной записи %q, ожидались %q", got, want)
+	}
+
+	// Изменённое вхождение пропало из документа — вхождение возвращается в серию
+	master := series[:strings.Index(series, "BEGIN:VEVENT\r\nUID:standup\r\nDTSTAMP:20240301T090000Z\r\nRECURRENCE-ID")] + "END:VCALENDAR\r\n"
+	if resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/standup.ics", contentType: ics, body: master}); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("запись серии без вхождения: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	want = "11 09:00 Стендап|12 09:00 Стендап|13 09:00 Стендап"
+	if got := strings.Join(occurrences(), "|"); got != want {
+		t.Errorf("вхождения после удаления изменённого %q, ожидались %q", got, want)
+	}
+}
+
+func TestCalDAVPutRollback(t *testing.T) {
+	ts := newTestServer(t)
+	const ics = "text/calendar; charset=utf-8"
+	series := caldavRecording(t, "put_series.ics")
+
+	if resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/standup.ics", contentType: ics, body: series}); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("создание серии: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	before, stored := ts.do(t, testRequest{method: "GET", path: "/caldav/1/standup.ics"})
+
+	// Серия изменяется раньше вхождений, а вхождения 20 марта в ней нет: запись должна отмениться целиком
+	changed := strings.Replace(series, "SUMMARY:Стендап\r\n", "SUMMARY:Стендап команды\r\n", 1)
+	changed = strings.Replace(changed, "RECURRENCE-ID:20240312T090000Z", "RECURRENCE-ID:20240320T090000Z", 1)
+	resp, body := ts.do(t, testRequest{method: "PUT", path: "/caldav/1/standup.ics", contentType: ics, body: changed, header: map[string]string{"If-Match": before.Header.Get("ETag")}})
+	if resp.StatusCode < 400 {
+		t.Fatalf("запись с лишним вхождением: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	after, body := ts.do(t, testRequest{method: "GET", path: "/caldav/1/standup.ics"})
+	if after.Header.Get("ETag") != before.Header.Get("ETag") || string(body) != string(stored) {
+		t.Errorf("ресурс после отменённой записи: ETag %q, ожидался %q\n%s", after.Header.Get("ETag"), before.Header.Get("ETag"), body)
+	}
+}
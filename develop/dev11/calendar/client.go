@@ -0,0 +1,299 @@
+// Package calendar клиент HTTP API сервера календаря (dev11): создание, изменение и удаление событий
+// и выборка событий на день, неделю и месяц. Ответы сервера разбираются в типизированные события и ошибки
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==         == == =
+	= ==== КЛИЕНТ API ==== =
+	  = == ==         == == =
+*/
+
+// Значения по умолчанию для NewClient
+const (
+	DefaultMaxRetries = 3
+	DefaultRetryDelay = 200 * time.Millisecond
+)
+
+// maxRetryDelay наибольшая пауза между повторами, в том числе запрошенная сервером в Retry-After
+const maxRetryDelay = 30 * time.Second
+
+// maxErrorBody сколько байт тела ответа не в формате API попадает в текст ошибки
+const maxErrorBody = 512
+
+// Client клиент API календаря. Поля можно менять до первого запроса; после этого клиент безопасно
+// использовать из нескольких горутин
+type Client struct {
+	BaseURL    string        // адрес сервера, например http://localhost:8080
+	HTTPClient *http.Client  // nil — http.DefaultClient
+	Token      string        // токен доступа для заголовка Authorization; пусто — запросы без аутентификации
+	MaxRetries int           // сколько раз повторить запрос, отклонённый из-за перегрузки сервера
+	RetryDelay time.Duration // пауза перед первым повтором, каждая следующая вдвое дольше
+}
+
+// NewClient создаёт клиент сервера baseURL с параметрами повторов по умолчанию
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: http.DefaultClient,
+		MaxRetries: DefaultMaxRetries,
+		RetryDelay: DefaultRetryDelay,
+	}
+}
+
+// createdIDPattern находит ID созданного события в ответе /create_event
+var createdIDPattern = regexp.MustCompile(`\[ID:(\d+)\]`)
+
+// CreateEvent создаёт событие и возвращает его ID
+func (c *Client) CreateEvent(ctx context.Context, event Event) (int, error) {
+	var result string
+	if err := c.post(ctx, "/create_event", nil, event, &result); err != nil {
+		return 0, err
+	}
+
+	match := createdIDPattern.FindStringSubmatch(result)
+	if match == nil {
+		return 0, fmt.Errorf("календарь: в ответе %q нет ID созданного события", result)
+	}
+	return strconv.Atoi(match[1])
+}
+
+// UpdateEvent изменяет событие event.ID пользователя event.UserID. Изменяются только заполненные поля event;
+// если заполнено Occurrence, изменение касается одного вхождения серии. Если заполнено Version, изменение
+// выполняется, только если событие с тех пор не менялось, иначе возвращается ошибка ErrPrecondition
+func (c *Client) UpdateEvent(ctx context.Context, event Event) error {
+	header := http.Header{}
+	if event.Version > 0 {
+		header.Set("If-Match", strconv.Quote(strconv.Itoa(event.Version)))
+	}
+	return c.post(ctx, "/update_event", header, event.changes(), nil)
+}
+
+// DeleteEvent удаляет событие пользователя userID. Удаление серии удаляет и все её выделенные вхождения
+func (c *Client) DeleteEvent(ctx context.Context, userID, eventID int) error {
+	return c.post(ctx, "/delete_event", nil, map[string]int{"id": eventID, "user_id": userID}, nil)
+}
+
+// EventsForDay возвращает события пользователя за день date
+func (c *Client) EventsForDay(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.events(ctx, "/events_for_day", userID, date)
+}
+
+// EventsForWeek возвращает события пользователя за неделю (с понедельника), в которую входит date
+func (c *Client) EventsForWeek(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.events(ctx, "/events_for_week", userID, date)
+}
+
+// EventsForMonth возвращает события пользователя за месяц, в который входит date
+func (c *Client) EventsForMonth(ctx context.Context, userID int, date time.Time) ([]Event, error) {
+	return c.events(ctx, "/events_for_month", userID, date)
+}
+
+// events запрашивает выборку событий. Границы дня, недели и месяца сервер считает в часовом поясе date,
+// если это пояс IANA; для UTC и time.Local — в UTC
+func (c *Client) events(ctx context.Context, path string, userID int, date time.Time) ([]Event, error) {
+	query := url.Values{}
+	query.Set("user_id", strconv.Itoa(userID))
+	query.Set("date", date.Format("2006-01-02"))
+	if name := date.Location().String(); name != "UTC" && name != "Local" {
+		query.Set("tz", name)
+	}
+
+	var events []Event
+	if err := c.do(ctx, http.MethodGet, path+"?"+query.Encode(), nil, nil, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// post отправляет body в формате JSON
+func (c *Client) post(ctx context.Context, path string, header http.Header, body interface{}, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("календарь: %w", err)
+	}
+	return c.do(ctx, http.MethodPost, path, header, data, result)
+}
+
+// do выполняет запрос и разбирает поле result ответа в result (nil — ответ не нужен).
+// Запрос, отклонённый из-за перегрузки сервера, повторяется до MaxRetries раз с растущей паузой;
+// ожидание прерывается отменой ctx. Ошибки сети не повторяются: сервер мог успеть выполнить запрос
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body []byte, result interface{}) error {
+	delay := c.RetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, header, body)
+		if err != nil {
+			return err
+		}
+
+		wait, retry := retryDelay(resp, delay)
+		if !retry || attempt >= c.MaxRetries {
+			defer resp.Body.Close()
+			return decodeResponse(resp, result)
+		}
+
+		// Тело ответа дочитывается, чтобы соединение вернулось в пул
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// send отправляет одну попытку запроса
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.BaseURL, "/")+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("календарь: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("календарь: %w", err)
+	}
+	return resp, nil
+}
+
+// retryDelay решает, стоит ли повторить запрос, и возвращает паузу перед повтором: заданную сервером
+// в Retry-After или delay. Повторяются ответы 429 и 503, кроме ошибок бизнес-логики, на которые сервер
+// тоже отвечает 503
+func retryDelay(resp *http.Response, delay time.Duration) (time.Duration, bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+	case http.StatusServiceUnavailable:
+		// Тело читается целиком и подменяется копией: если повтора не будет, его разберёт decodeResponse
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+
+		var envelope struct {
+			Code string `json:"code"`
+		}
+		if json.Unmarshal(data, &envelope) == nil && businessCodes[envelope.Code] {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		delay = after
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay, true
+}
+
+// parseRetryAfter разбирает заголовок Retry-After: число секунд или дату HTTP
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// decodeResponse разбирает ответ сервера: {"result": ...} при успехе и {"error", "code", "conflicts"} при ошибке
+func decodeResponse(resp *http.Response, result interface{}) error {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("календарь: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var envelope struct {
+			Error     string  `json:"error"`
+			Code      string  `json:"code"`
+			Conflicts []Event `json:"conflicts"`
+		}
+		if json.Unmarshal(data, &envelope) != nil || envelope.Error == "" {
+			return &Error{StatusCode: resp.StatusCode, Message: errorBody(resp.StatusCode, data)}
+		}
+		return &Error{StatusCode: resp.StatusCode, Code: envelope.Code, Message: envelope.Error, Conflicts: envelope.Conflicts}
+	}
+
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return fmt.Errorf("календарь: ответ не в формате API: %w", err)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("календарь: некорректный результат: %w", err)
+	}
+	return nil
+}
+
+// errorBody текст ошибки для ответа не в формате API: начало тела или стандартное описание кода ответа
+func errorBody(status int, data []byte) string {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return http.StatusText(status)
+	}
+	if len(text) > maxErrorBody {
+		text = strings.ToValidUTF8(text[:maxErrorBody], "")
+	}
+	return text
+}
@@ -0,0 +1,149 @@
+package calendar
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scriptedServer отвечает на запросы по очереди ответами responses; последний ответ повторяется
+func scriptedServer(t *testing.T, responses ...func(http.ResponseWriter)) (*Client, *int32) {
+	t.Helper()
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Повторный запрос должен нести то же тело, что и первый
+		if body, _ := io.ReadAll(r.Body); r.Method == http.MethodPost && len(body) == 0 {
+			t.Errorf("попытка %d: пустое тело запроса", atomic.LoadInt32(&calls)+1)
+		}
+
+		n := int(atomic.AddInt32(&calls, 1)) - 1
+		if n >= len(responses) {
+			n = len(responses) - 1
+		}
+		responses[n](w)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewClient(server.URL)
+	client.RetryDelay = time.Millisecond
+	return client, &calls
+}
+
+// reply ответ с кодом status и телом body
+func reply(status int, body string, header ...string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	overloaded := reply(http.StatusServiceUnavailable, "upstream unavailable")
+	created := reply(http.StatusOK, `{"result":"Событие [ID:42] успешно создано"}`)
+
+	tests := []struct {
+		name      string
+		responses []func(http.ResponseWriter)
+		wantCalls int32
+		wantID    int
+		wantErr   error
+	}{
+		{"перегрузка, затем успех", []func(http.ResponseWriter){overloaded, overloaded, created}, 3, 42, nil},
+		{"лимит запросов", []func(http.ResponseWriter){reply(http.StatusTooManyRequests, `{"error":"слишком много запросов","code":"rate_limited"}`, "Retry-After", "0"), created}, 2, 42, nil},
+		{"ошибка бизнес-логики не повторяется", []func(http.ResponseWriter){reply(http.StatusServiceUnavailable, `{"error":"событие пересекается","code":"conflict","conflicts":[{"id":3}]}`)}, 1, 0, ErrConflict},
+		{"повторы исчерпаны", []func(http.ResponseWriter){overloaded}, DefaultMaxRetries + 1, 0, &Error{StatusCode: http.StatusServiceUnavailable, Message: "upstream unavailable"}},
+		{"невалидный запрос", []func(http.ResponseWriter){reply(http.StatusBadRequest, `{"error":"некорректный ID","code":"bad_request"}`)}, 1, 0, ErrBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, calls := scriptedServer(t, tt.responses...)
+
+			id, err := client.CreateEvent(context.Background(), Event{UserID: 1, Title: "Ретро", Date: time.Date(2024, time.March, 5, 15, 0, 0, 0, time.UTC)})
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("запросов %d, ожидалось %d", got, tt.wantCalls)
+			}
+			if id != tt.wantID {
+				t.Errorf("ID %d, ожидался %d", id, tt.wantID)
+			}
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("неожиданная ошибка %v", err)
+				}
+			case *Error:
+				var got *Error
+				if !errors.As(err, &got) {
+					t.Fatalf("ошибка %v, ожидалась *Error", err)
+				}
+				if want.Code != "" && !errors.Is(err, want) {
+					t.Errorf("ошибка %v, ожидался код %s", err, want.Code)
+				}
+				if want.StatusCode != 0 && (got.StatusCode != want.StatusCode || got.Message != want.Message) {
+					t.Errorf("ошибка %+v, ожидалась %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConflictDetails(t *testing.T) {
+	client, _ := scriptedServer(t, reply(http.StatusServiceUnavailable, `{"error":"событие пересекается","code":"conflict","conflicts":[{"id":3,"title":"Планёрка"}]}`))
+
+	err := client.UpdateEvent(context.Background(), Event{ID: 1, UserID: 1, Title: "Ретро"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || len(apiErr.Conflicts) != 1 || apiErr.Conflicts[0].Title != "Планёрка" {
+		t.Fatalf("ошибка %+v, ожидался конфликт с событием 3", err)
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	client, calls := scriptedServer(t, reply(http.StatusServiceUnavailable, "", "Retry-After", "10"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := client.EventsForDay(ctx, 1, time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ошибка %v, ожидалось истечение контекста", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("ожидание повтора не прервано отменой контекста: %v", elapsed)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("запросов %d, ожидался 1", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+		{"скоро", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; ожидалось %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
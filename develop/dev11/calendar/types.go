@@ -0,0 +1,131 @@
+package calendar
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+	  = == ==                  == == =
+	= ==== СОБЫТИЯ И ОШИБКИ API ==== =
+	  = == ==                  == == =
+*/
+
+// Event событие календаря в том виде, в котором его передаёт сервер
+type Event struct {
+	ID          int         `json:"id"`
+	UserID      int         `json:"user_id"`
+	Title       string      `json:"title"`
+	UID         string      `json:"uid,omitempty"` // идентификатор события во внешних календарях (iCalendar UID)
+	Date        time.Time   `json:"date"`
+	End         *time.Time  `json:"end,omitempty"`      // окончание события; nil — событие без продолжительности
+	TimeZone    string      `json:"tz,omitempty"`       // часовой пояс IANA, в котором повторяется событие
+	AllDay      bool        `json:"all_day,omitempty"`  // событие на весь день (или несколько дней до End)
+	Location    string      `json:"location,omitempty"` // место проведения
+	Description string      `json:"description,omitempty"`
+	Recurrence  *Recurrence `json:"recurrence,omitempty"` // правило повторения; nil — разовое событие
+	SeriesID    int         `json:"series_id,omitempty"`  // ID серии, из которой выделено отредактированное вхождение
+	Occurrence  *time.Time  `json:"occurrence,omitempty"` // исходная дата вхождения серии
+	Reminders   []int       `json:"reminders,omitempty"`  // за сколько минут до начала напомнить о событии
+	Attendees   []Attendee  `json:"attendees,omitempty"`  // участники события кроме владельца
+	Version     int         `json:"version,omitempty"`    // номер версии события, назначается сервером
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// Recurrence правило повторения события
+type Recurrence struct {
+	Freq       string      `json:"freq"`                 // DAILY, WEEKLY, MONTHLY или YEARLY
+	Interval   int         `json:"interval,omitempty"`   // шаг повторения в единицах Freq, по умолчанию 1
+	ByDay      []string    `json:"by_day,omitempty"`     // дни недели: MO..SU, для MONTHLY допустим порядковый номер (1MO, -1FR)
+	Count      int         `json:"count,omitempty"`      // общее количество вхождений, включая исключённые
+	Until      *time.Time  `json:"until,omitempty"`      // последний допустимый момент начала вхождения (включительно)
+	Exceptions []time.Time `json:"exceptions,omitempty"` // даты вхождений, исключённых из серии
+}
+
+// Attendee участник события и его ответ на приглашение (accepted, declined, tentative или пусто)
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status,omitempty"`
+}
+
+// changes возвращает тело запроса на изменение события: сервер переносит в событие только переданные поля,
+// поэтому незаполненные поля в запрос не попадают
+func (e Event) changes() map[string]interface{} {
+	body := map[string]interface{}{"id": e.ID, "user_id": e.UserID}
+
+	set := func(field string, filled bool, value interface{}) {
+		if filled {
+			body[field] = value
+		}
+	}
+
+	set("title", e.Title != "", e.Title)
+	set("uid", e.UID != "", e.UID)
+	set("date", !e.Date.IsZero(), e.Date)
+	set("end", e.End != nil, e.End)
+	set("tz", e.TimeZone != "", e.TimeZone)
+	set("all_day", e.AllDay, e.AllDay)
+	set("location", e.Location != "", e.Location)
+	set("description", e.Description != "", e.Description)
+	set("recurrence", e.Recurrence != nil, e.Recurrence)
+	set("occurrence", e.Occurrence != nil, e.Occurrence)
+	set("reminders", e.Reminders != nil, e.Reminders)
+	set("attendees", e.Attendees != nil, e.Attendees)
+
+	return body
+}
+
+// Коды ошибок, которые возвращает сервер в поле code
+const (
+	CodeNotFound     = "not_found"           // событие или вхождение не существует
+	CodeValidation   = "validation"          // событие нарушает правила предметной области
+	CodeConflict     = "conflict"            // изменение противоречит текущему состоянию календаря
+	CodeForbidden    = "forbidden"           // нет доступа к календарю пользователя
+	CodePrecondition = "precondition_failed" // версия события не совпала с ожидаемой
+	CodeBadRequest   = "bad_request"         // некорректные параметры запроса
+	CodeUnauthorized = "unauthorized"        // запрос без действительного токена
+	CodeRateLimited  = "rate_limited"        // превышен лимит запросов
+)
+
+// Error ошибка, которой сервер ответил на запрос
+type Error struct {
+	StatusCode int     // код ответа HTTP
+	Code       string  // машиночитаемый код ошибки; пусто, если ответ не в формате API (например, от прокси)
+	Message    string  // текст ошибки
+	Conflicts  []Event // события, из-за пересечения с которыми отклонена запись
+}
+
+// Error возвращает текст ошибки
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("календарь: HTTP %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("календарь: HTTP %d (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is позволяет сравнивать ошибки с ErrNotFound, ErrValidation и т.д. через errors.Is
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.StatusCode == 0 && t.Message == "" && t.Code == e.Code
+}
+
+// Образцы для errors.Is
+var (
+	ErrNotFound     = &Error{Code: CodeNotFound}
+	ErrValidation   = &Error{Code: CodeValidation}
+	ErrConflict     = &Error{Code: CodeConflict}
+	ErrForbidden    = &Error{Code: CodeForbidden}
+	ErrPrecondition = &Error{Code: CodePrecondition}
+	ErrBadRequest   = &Error{Code: CodeBadRequest}
+	ErrUnauthorized = &Error{Code: CodeUnauthorized}
+	ErrRateLimited  = &Error{Code: CodeRateLimited}
+)
+
+// businessCodes коды ошибок бизнес-логики. Сервер по заданию отвечает на них 503, но повторять такие
+// запросы бессмысленно: ответ не изменится
+var businessCodes = map[string]bool{
+	CodeNotFound:   true,
+	CodeValidation: true,
+	CodeConflict:   true,
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"dev11/calendar"
+)
+
+// === Клиентская библиотека против настоящего сервера ===
+
+func TestCalendarClient(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2}}
+
+	client := calendar.NewClient(ts.URL)
+	client.HTTPClient = ts.Client()
+	client.Token = "alice"
+	client.RetryDelay = time.Millisecond
+	ctx := context.Background()
+
+	end := at(2024, time.March, 6, 16, 0)
+	id, err := client.CreateEvent(ctx, calendar.Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 6, 15, 0), End: &end, Location: "Переговорная"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != len(seedEvents())+1 {
+		t.Errorf("ID созданного события %d, ожидался %d", id, len(seedEvents())+1)
+	}
+
+	find := func(events []calendar.Event) *calendar.Event {
+		for i := range events {
+			if events[i].ID == id {
+				return &events[i]
+			}
+		}
+		return nil
+	}
+
+	week, err := client.EventsForWeek(ctx, 1, at(2024, time.March, 4, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := find(week)
+	if created == nil || created.Location != "Переговорная" || !created.End.Equal(end) || created.Version != 1 {
+		t.Fatalf("созданное событие в выборке за неделю: %+v", created)
+	}
+
+	// Изменение проверяет версию, если она передана
+	if err := client.UpdateEvent(ctx, calendar.Event{ID: id, UserID: 1, Title: "Ретро (перенос)", Version: 7}); !errors.Is(err, calendar.ErrPrecondition) {
+		t.Errorf("изменение с устаревшей версией: ошибка %v, ожидалось несовпадение версии", err)
+	}
+	if err := client.UpdateEvent(ctx, calendar.Event{ID: id, UserID: 1, Title: "Ретро (перенос)", Version: created.Version}); err != nil {
+		t.Fatal(err)
+	}
+
+	// В Москве 6 марта начинается в 21:00 UTC 5 марта, поэтому утреннее вхождение зарядки 5 марта не попадает в выборку
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day, err := client.EventsForDay(ctx, 1, time.Date(2024, time.March, 6, 0, 0, 0, 0, moscow))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated := find(day); updated == nil || updated.Title != "Ретро (перенос)" || updated.Location != "Переговорная" {
+		t.Errorf("изменённое событие в выборке за день: %+v", updated)
+	}
+	for _, event := range day {
+		if event.Date.Before(time.Date(2024, time.March, 6, 0, 0, 0, 0, moscow)) {
+			t.Errorf("событие %q до начала дня по Москве", event.Title)
+		}
+	}
+
+	month, err := client.EventsForMonth(ctx, 1, at(2024, time.March, 15, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if find(month) == nil {
+		t.Error("изменённого события нет в выборке за месяц")
+	}
+
+	if err := client.DeleteEvent(ctx, 2, 7); !errors.Is(err, calendar.ErrForbidden) {
+		t.Errorf("удаление из чужого календаря: ошибка %v, ожидался отказ в доступе", err)
+	}
+	if err := client.DeleteEvent(ctx, 1, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// На ошибку бизнес-логики сервер отвечает 503, но клиент её не повторяет
+	started := time.Now()
+	err = client.DeleteEvent(ctx, 1, id)
+	var apiErr *calendar.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 || !errors.Is(err, calendar.ErrNotFound) {
+		t.Errorf("повторное удаление: ошибка %v, ожидалось 503 not_found", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("повторное удаление заняло %v", elapsed)
+	}
+
+	early := at(2024, time.March, 6, 14, 0)
+	if _, err := client.CreateEvent(ctx, calendar.Event{UserID: 1, Title: "Наоборот", Date: at(2024, time.March, 6, 15, 0), End: &early}); !errors.Is(err, calendar.ErrValidation) {
+		t.Errorf("создание события, заканчивающегося до начала: ошибка %v, ожидалась ошибка валидации", err)
+	}
+
+	client.Token = "mallory"
+	if _, err := client.EventsForDay(ctx, 1, at(2024, time.March, 4, 0, 0)); !errors.Is(err, calendar.ErrUnauthorized) {
+		t.Errorf("запрос с чужим токеном: ошибка %v, ожидался отказ в аутентификации", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+	  = == ==                  == == =
+	= ==== КОМАНДЫ ИЗМЕНЕНИЯ ==== =
+	  = == ==                  == == =
+*/
+
+// Command изменение хранилища событий (паттерн «команда», см. pattern/04_command.go). EventStore выполняет
+// каждую операцию набором команд в единице работы хранилища: если одна из них не выполнилась, единица работы
+// откатывается, а выполненная операция целиком попадает в журнал аудита вместе с изменениями
+type Command interface {
+	Execute() error
+	// Audit возвращает запись журнала аудита о выполненной команде без времени, автора и номера операции
+	Audit() AuditEntry
+}
+
+// createCommand создаёт событие
+type createCommand struct {
+	storage Storage
+	event   Event
+}
+
+func (c *createCommand) Execute() error {
+	id, err := c.storage.AddEvent(c.event)
+	if err != nil {
+		return err
+	}
+	c.event.ID = id
+	return nil
+}
+
+func (c *createCommand) Audit() AuditEntry {
+	after := c.event
+	return AuditEntry{Action: AuditCreated, EventID: c.event.ID, After: &after}
+}
+
+// updateCommand заменяет событие before событием after
+type updateCommand struct {
+	storage Storage
+	before  Event
+	after   Event
+}
+
+func (c *updateCommand) Execute() error {
+	return c.storage.UpdateEvent(c.after)
+}
+
+func (c *updateCommand) Audit() AuditEntry {
+	before, after := c.before, c.after
+	return AuditEntry{Action: AuditUpdated, EventID: c.after.ID, Before: &before, After: &after}
+}
+
+// deleteCommand удаляет событие. Снимок удалённого события остаётся в журнале аудита, откуда его возвращает restoreCommand
+type deleteCommand struct {
+	storage Storage
+	event   Event
+}
+
+func (c *deleteCommand) Execute() error {
+	return c.storage.DeleteEvent(c.event.ID)
+}
+
+func (c *deleteCommand) Audit() AuditEntry {
+	before := c.event
+	return AuditEntry{Action: AuditDeleted, EventID: c.event.ID, Before: &before}
+}
+
+// restoreCommand возвращает удалённое событие под прежним ID
+type restoreCommand struct {
+	storage Storage
+	event   Event
+}
+
+func (c *restoreCommand) Execute() error {
+	return c.storage.RestoreEvent(c.event)
+}
+
+func (c *restoreCommand) Audit() AuditEntry {
+	after := c.event
+	return AuditEntry{Action: AuditRestored, EventID: c.event.ID, After: &after}
+}
+
+// shareCommand заменяет доступ к календарю before доступом after; nil — доступа нет
+type shareCommand struct {
+	storage Storage
+	before  *Share
+	after   *Share
+}
+
+func (c *shareCommand) Execute() error {
+	if c.after != nil {
+		return c.storage.PutShare(*c.after)
+	}
+	return c.storage.DeleteShare(c.before.OwnerID, c.before.SharedWith)
+}
+
+func (c *shareCommand) Audit() AuditEntry {
+	if c.after == nil {
+		before := *c.before
+		return AuditEntry{Action: AuditUnshared, Share: &before}
+	}
+	after := *c.after
+	return AuditEntry{Action: AuditShared, Share: &after}
+}
+
+// pendingOperation операция, уже выполненная в единице работы хранилища, но ещё не записанная в журнал аудита
+type pendingOperation struct {
+	actorID  int
+	commands []Command
+}
+
+// execute выполняет команды одной операции пользователя actorID по порядку. Вне единицы работы операция
+// выполняется в своей (см. transaction), а в единице работы, например во время пакета, только выполняется:
+// в журнал её запишет transaction вместе с остальными операциями. Вызывается под блокировкой
+func (es *EventStore) execute(actorID int, commands ...Command) error {
+	if es.pending == nil {
+		return es.transaction(func() error { return es.execute(actorID, commands...) })
+	}
+
+	for _, command := range commands {
+		if err := command.Execute(); err != nil {
+			return storageError(err)
+		}
+	}
+
+	*es.pending = append(*es.pending, pendingOperation{actorID: actorID, commands: commands})
+	return nil
+}
+
+// transaction выполняет fn в единице работы хранилища. Операции, выполненные fn через execute, записываются
+// в журнал аудита одним вызовом Append, каждая под своим номером, и фиксируются в хранилище вместе с ним,
+// после чего подписчики получают изменения. Если fn, журнал или фиксация вернули ошибку, единица работы
+// откатывается: хранилище и журнал остаются прежними. Вызывается под блокировкой
+func (es *EventStore) transaction(fn func() error) error {
+	if err := es.storage.Begin(); err != nil {
+		return storageError(err)
+	}
+
+	operations := []pendingOperation{}
+	es.pending = &operations
+	defer func() { es.pending = nil }()
+
+	if err := fn(); err != nil {
+		es.rollback()
+		return err
+	}
+
+	now := time.Now()
+	entries := make([][]AuditEntry, 0, len(operations))
+	for _, operation := range operations {
+		operationEntries := make([]AuditEntry, 0, len(operation.commands))
+		for _, command := range operation.commands {
+			entry := command.Audit()
+			entry.Time = now
+			entry.ActorID = operation.actorID
+			operationEntries = append(operationEntries, entry)
+		}
+		entries = append(entries, operationEntries)
+	}
+
+	if len(entries) > 0 {
+		if err := es.audit.Append(entries...); err != nil {
+			es.rollback()
+			return storageError(fmt.Errorf("изменение отменено: не удалось записать журнал аудита: %w", err))
+		}
+	}
+
+	if err := es.storage.Commit(); err != nil {
+		es.rollback()
+		return storageError(fmt.Errorf("изменение отменено: не удалось сохранить: %w", err))
+	}
+
+	for _, operationEntries := range entries {
+		for _, entry := range operationEntries {
+			switch entry.Action {
+			case AuditCreated, AuditRestored:
+				es.notify(ChangeCreated, *entry.After)
+			case AuditUpdated:
+				es.notify(ChangeUpdated, *entry.After)
+			case AuditDeleted:
+				es.notify(ChangeDeleted, *entry.Before)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rollback откатывает единицу работы хранилища. Вызывается под блокировкой
+func (es *EventStore) rollback() {
+	if err := es.storage.Rollback(); err != nil {
+		log.Printf("Ошибка отката изменения: %v", err)
+	}
+}
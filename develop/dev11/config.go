@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==          == == =
+	= ==== КОНФИГУРАЦИЯ ==== =
+	  = == ==          == == =
+*/
+
+// Значения по умолчанию для параметров, не указанных в конфигурации
+const (
+	defaultHost            = "localhost"
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 10 * time.Second
+	defaultIdleTimeout     = 60 * time.Second
+	defaultShutdownTimeout = 15 * time.Second
+	defaultMaxBodyBytes    = 1 << 20
+)
+
+// configEnvPrefix префикс переменных окружения, переопределяющих параметры конфигурационного файла
+const configEnvPrefix = "CALENDAR_"
+
+type Config struct {
+	Host            string           `json:"host"` // адрес, на котором слушает сервер; пусто — localhost
+	Port            string           `json:"port"`
+	ReadTimeout     Duration         `json:"read_timeout"`     // наибольшее время чтения запроса вместе с телом
+	WriteTimeout    Duration         `json:"write_timeout"`    // наибольшее время от конца чтения заголовков до конца записи ответа
+	IdleTimeout     Duration         `json:"idle_timeout"`     // наибольшее время ожидания следующего запроса в keep-alive соединении
+	ShutdownTimeout Duration         `json:"shutdown_timeout"` // сколько ждать завершения начатых запросов при остановке
+	TLSCertFile     string           `json:"tls_cert_file"`    // сертификат TLS; вместе с tls_key_file включает HTTPS
+	TLSKeyFile      string           `json:"tls_key_file"`     // закрытый ключ TLS
+	StoragePath     string           `json:"storage_path"`     // каталог файлового хранилища; пусто без storage_dsn — события хранятся только в памяти
+	SnapshotEvery   int              `json:"snapshot_every"`   // количество записей в журнале, после которого делается снимок
+	StorageDriver   string           `json:"storage_driver"`   // драйвер database/sql для storage_dsn; пусто — sqlite
+	StorageDSN      string           `json:"storage_dsn"`      // строка подключения к базе данных; задаётся вместо storage_path
+	TokensFile      string           `json:"tokens_file"`      // файл bearer-токенов; пусто — аутентификация отключена
+	Notifiers       []NotifierConfig `json:"notifiers"`        // способы доставки напоминаний; пусто — только лог сервера
+	RateLimit       float64          `json:"rate_limit"`       // запросов в секунду от одного клиента; 0 — без ограничения
+	RateBurst       int              `json:"rate_burst"`       // сколько запросов клиент может прислать разом; 0 — rate_limit, но не меньше 1
+	AddrRateLimit   float64          `json:"addr_rate_limit"`  // запросов в секунду с одного IP-адреса, включая неаутентифицированные; 0 — без ограничения
+	AddrRateBurst   int              `json:"addr_rate_burst"`  // сколько запросов можно прислать с адреса разом; 0 — addr_rate_limit, но не меньше 1
+	MaxBodyBytes    int64            `json:"max_body_bytes"`   // наибольший размер тела запроса; 0 — 1 МиБ
+}
+
+// Duration длительность, записываемая в конфигурации строкой вида "10s", "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON разбирает длительность из строки формата time.ParseDuration
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("длительность должна быть строкой вида \"10s\"")
+	}
+
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// TLSEnabled сообщает, должен ли сервер работать по HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// ReadConfigFromFile читает конфиг из конфигурационного файла, применяет переопределения из переменных
+// окружения CALENDAR_* и проверяет результат. Неуказанные параметры получают значения по умолчанию.
+func ReadConfigFromFile(filename string) (Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, fmt.Errorf("ошибка чтения конфигурационного файла: %v", err)
+	}
+
+	var config Config
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return Config{}, fmt.Errorf("ошибка парсинга содежимого конфигурационного файла: %v", err)
+	}
+
+	if err := applyConfigEnv(&config, os.LookupEnv); err != nil {
+		return Config{}, err
+	}
+
+	if err := validateConfig(&config); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// configEnv переменные окружения (без префикса CALENDAR_) и параметры конфигурации, которые они переопределяют
+var configEnv = []struct {
+	name  string
+	apply func(config *Config, value string) error
+}{
+	{"HOST", func(c *Config, v string) error { c.Host = v; return nil }},
+	{"PORT", func(c *Config, v string) error { c.Port = v; return nil }},
+	{"READ_TIMEOUT", func(c *Config, v string) error { return parseEnvDuration(&c.ReadTimeout, v) }},
+	{"WRITE_TIMEOUT", func(c *Config, v string) error { return parseEnvDuration(&c.WriteTimeout, v) }},
+	{"IDLE_TIMEOUT", func(c *Config, v string) error { return parseEnvDuration(&c.IdleTimeout, v) }},
+	{"SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return parseEnvDuration(&c.ShutdownTimeout, v) }},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.TLSCertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.TLSKeyFile = v; return nil }},
+	{"STORAGE_PATH", func(c *Config, v string) error { c.StoragePath = v; return nil }},
+	{"STORAGE_DRIVER", func(c *Config, v string) error { c.StorageDriver = v; return nil }},
+	{"STORAGE_DSN", func(c *Config, v string) error { c.StorageDSN = v; return nil }},
+	{"SNAPSHOT_EVERY", func(c *Config, v string) (err error) { c.SnapshotEvery, err = strconv.Atoi(v); return err }},
+	{"TOKENS_FILE", func(c *Config, v string) error { c.TokensFile = v; return nil }},
+	{"RATE_LIMIT", func(c *Config, v string) (err error) { c.RateLimit, err = strconv.ParseFloat(v, 64); return err }},
+	{"RATE_BURST", func(c *Config, v string) (err error) { c.RateBurst, err = strconv.Atoi(v); return err }},
+	{"ADDR_RATE_LIMIT", func(c *Config, v string) (err error) { c.AddrRateLimit, err = strconv.ParseFloat(v, 64); return err }},
+	{"ADDR_RATE_BURST", func(c *Config, v string) (err error) { c.AddrRateBurst, err = strconv.Atoi(v); return err }},
+	{"MAX_BODY_BYTES", func(c *Config, v string) (err error) { c.MaxBodyBytes, err = strconv.ParseInt(v, 10, 64); return err }},
+}
+
+// applyConfigEnv переопределяет параметры конфигурации заданными переменными окружения.
+// Заданная пустая переменная сбрасывает параметр: так, например, отключается TLS из файла
+func applyConfigEnv(config *Config, lookup func(string) (string, bool)) error {
+	for _, env := range configEnv {
+		value, ok := lookup(configEnvPrefix + env.name)
+		if !ok {
+			continue
+		}
+
+		if err := env.apply(config, value); err != nil {
+			return fmt.Errorf("некорректное значение переменной окружения %s%s: %v", configEnvPrefix, env.name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseEnvDuration разбирает длительность из переменной окружения. Пустое значение — значение по умолчанию
+func parseEnvDuration(d *Duration, value string) error {
+	if value == "" {
+		*d = 0
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// validateConfig проверяет конфигурацию и подставляет значения по умолчанию
+func validateConfig(config *Config) error {
+	if config.Port == "" {
+		return fmt.Errorf("в конфигурационном файле не указан порт")
+	}
+
+	if port, err := strconv.Atoi(config.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("порт должен быть числом от 1 до 65535, получено %q", config.Port)
+	}
+
+	if config.Host == "" {
+		config.Host = defaultHost
+	}
+
+	timeouts := []struct {
+		name  string
+		value *Duration
+		def   time.Duration
+	}{
+		{"read_timeout", &config.ReadTimeout, defaultReadTimeout},
+		{"write_timeout", &config.WriteTimeout, defaultWriteTimeout},
+		{"idle_timeout", &config.IdleTimeout, defaultIdleTimeout},
+		{"shutdown_timeout", &config.ShutdownTimeout, defaultShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if *timeout.value < 0 {
+			return fmt.Errorf("%s не может быть отрицательным", timeout.name)
+		}
+		if *timeout.value == 0 {
+			*timeout.value = Duration(timeout.def)
+		}
+	}
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file и tls_key_file указываются только вместе")
+	}
+
+	if config.TLSEnabled() {
+		if _, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile); err != nil {
+			return fmt.Errorf("невозможно загрузить сертификат TLS: %v", err)
+		}
+	}
+
+	if config.StorageDSN != "" && config.StoragePath != "" {
+		return fmt.Errorf("storage_path и storage_dsn не указываются вместе")
+	}
+	if config.StorageDSN == "" && config.StorageDriver != "" {
+		return fmt.Errorf("storage_driver указывается только вместе с storage_dsn")
+	}
+	if config.StorageDSN != "" {
+		if config.StorageDriver == "" {
+			config.StorageDriver = sqliteDriver
+		}
+		if !driverRegistered(config.StorageDriver) {
+			return fmt.Errorf("драйвер базы данных %q не поддерживается, доступны: %s", config.StorageDriver, strings.Join(sql.Drivers(), ", "))
+		}
+	}
+
+	if config.SnapshotEvery < 0 {
+		return fmt.Errorf("snapshot_every не может быть отрицательным")
+	}
+
+	if config.RateLimit < 0 || math.IsNaN(config.RateLimit) || math.IsInf(config.RateLimit, 0) {
+		return fmt.Errorf("rate_limit должен быть неотрицательным числом")
+	}
+	if config.RateBurst < 0 {
+		return fmt.Errorf("rate_burst не может быть отрицательным")
+	}
+	if config.RateLimit > 0 && config.RateBurst == 0 {
+		config.RateBurst = int(math.Ceil(config.RateLimit))
+	}
+
+	if config.AddrRateLimit < 0 || math.IsNaN(config.AddrRateLimit) || math.IsInf(config.AddrRateLimit, 0) {
+		return fmt.Errorf("addr_rate_limit должен быть неотрицательным числом")
+	}
+	if config.AddrRateBurst < 0 {
+		return fmt.Errorf("addr_rate_burst не может быть отрицательным")
+	}
+	if config.AddrRateLimit > 0 && config.AddrRateBurst == 0 {
+		config.AddrRateBurst = int(math.Ceil(config.AddrRateLimit))
+	}
+
+	if config.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes не может быть отрицательным")
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	for i, notifier := range config.Notifiers {
+		if _, err := NewNotifier(notifier); err != nil {
+			return fmt.Errorf("notifiers[%d]: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+// driverRegistered сообщает, подключён ли к серверу драйвер database/sql с именем name
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==                       == == =
+	= ==== ПЕРЕСЕЧЕНИЯ И ЗАНЯТОСТЬ ==== =
+	  = == ==                       == == =
+*/
+
+// freeBusyPath адрес метода, возвращающего занятое время пользователя
+const freeBusyPath = "/free_busy"
+
+// conflictHorizon на сколько вперёд проверяются пересечения вхождений повторяющегося события
+const conflictHorizon = 366 * 24 * time.Hour
+
+// RejectConflicts запрещает запись события, пересекающегося по времени с другими событиями пользователя.
+// В этом случае запись возвращает *ConflictError
+func RejectConflicts() WriteOption {
+	return func(o *writeOptions) error { o.rejectConflicts = true; return nil }
+}
+
+// ReportConflicts записывает событие несмотря на пересечения и сохраняет в conflicts события, с которыми оно пересекается
+func ReportConflicts(conflicts *[]Event) WriteOption {
+	return func(o *writeOptions) error { o.conflicts = conflicts; return nil }
+}
+
+// ConflictError отказ в записи события, пересекающегося с другими событиями пользователя.
+// Для errors.As и errors.Is это ошибка бизнес-логики вида KindConflict
+type ConflictError struct {
+	Conflicts []Event
+}
+
+// Error возвращает текст ошибки
+func (e *ConflictError) Error() string {
+	ids := make([]string, 0, len(e.Conflicts))
+	for _, event := range e.Conflicts {
+		ids = append(ids, fmt.Sprintf("[ID:%d] %s", event.ID, event.Date.Format(time.RFC3339)))
+	}
+	return "событие пересекается с другими событиями пользователя: " + strings.Join(ids, ", ")
+}
+
+// Unwrap возвращает ошибку бизнес-логики, по которой сервер выбирает код ответа
+func (e *ConflictError) Unwrap() error {
+	return &DomainError{Kind: KindConflict, Message: e.Error()}
+}
+
+// checkConflicts проверяет пересечения event с другими событиями пользователя согласно options. Вызывается под блокировкой
+func (es *EventStore) checkConflicts(event Event, options writeOptions) error {
+	if !options.rejectConflicts && options.conflicts == nil {
+		return nil
+	}
+
+	conflicts, err := es.findConflicts(event)
+	if err != nil {
+		return err
+	}
+	if options.conflicts != nil {
+		*options.conflicts = conflicts
+	}
+
+	if options.rejectConflicts && len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+// findConflicts возвращает вхождения событий пользователя, пересекающиеся по времени с event.
+// Само событие, вхождения его серии и выделенные из неё события пересечениями не считаются.
+// У повторяющегося события проверяются вхождения на conflictHorizon вперёд. Вызывается под блокировкой
+func (es *EventStore) findConflicts(event Event) ([]Event, error) {
+	own := occupiedOccurrences(event)
+	if len(own) == 0 {
+		return nil, nil
+	}
+
+	from, to := own[0].Date, own[0].Date
+	for _, occurrence := range own {
+		start, end, _ := eventSpan(occurrence)
+		if start.Before(from) {
+			from = start
+		}
+		if end.After(to) {
+			to = end
+		}
+	}
+
+	others, err := es.eventsForRange(event.UserID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := []Event{}
+	for _, other := range others {
+		if sameSeries(event, other) {
+			continue
+		}
+
+		otherStart, otherEnd, ok := eventSpan(other)
+		if !ok || other.declinedBy(event.UserID) {
+			continue
+		}
+
+		for _, occurrence := range own {
+			start, end, _ := eventSpan(occurrence)
+			if start.Before(otherEnd) && otherStart.Before(end) {
+				conflicts = append(conflicts, other)
+				break
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// occupiedOccurrences возвращает вхождения события, занимающие время: само событие или,
+// для серии, её вхождения от текущего момента на conflictHorizon вперёд
+func occupiedOccurrences(event Event) []Event {
+	start, end, ok := eventSpan(event)
+	if !ok {
+		return nil
+	}
+
+	if event.Recurrence == nil {
+		return []Event{event}
+	}
+
+	// Уже закончившиеся вхождения ни с чем новым не пересекутся
+	from := event.Date
+	if now := time.Now(); now.Add(-end.Sub(start)).After(from) {
+		from = now.Add(-end.Sub(start))
+	}
+
+	return expandSeries(event, from, from.Add(conflictHorizon))
+}
+
+// sameSeries сообщает, относится ли other к тому же событию или серии, что и event
+func sameSeries(event, other Event) bool {
+	if event.ID != 0 && (other.ID == event.ID || other.SeriesID == event.ID) {
+		return true
+	}
+
+	// Выделяемое вхождение ещё не исключено из серии и не должно конфликтовать само с собой
+	return event.SeriesID != 0 && other.ID == event.SeriesID &&
+		event.Occurrence != nil && other.Occurrence != nil && other.Occurrence.Equal(*event.Occurrence)
+}
+
+// === Занятость ===
+
+// BusyInterval полуинтервал [Start, End), занятый событиями пользователя
+type BusyInterval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// FreeBusy возвращает занятое событиями пользователя время в полуинтервале [from, to).
+// Пересекающиеся и смежные интервалы объединяются, события без продолжительности и события,
+// от участия в которых пользователь отказался, время не занимают
+func (es *EventStore) FreeBusy(userID int, from, to time.Time) ([]BusyInterval, error) {
+	es.RLock()
+	events, err := es.eventsForRange(userID, from, to)
+	es.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var spans []BusyInterval
+	for _, event := range events {
+		start, end, ok := eventSpan(event)
+		if !ok || event.declinedBy(userID) || !end.After(from) || !start.Before(to) {
+			continue
+		}
+
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		spans = append(spans, BusyInterval{Start: start, End: end})
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	busy := []BusyInterval{}
+	for _, span := range spans {
+		if last := len(busy) - 1; last >= 0 && !span.Start.After(busy[last].End) {
+			if span.End.After(busy[last].End) {
+				busy[last].End = span.End
+			}
+			continue
+		}
+		busy = append(busy, span)
+	}
+
+	return busy, nil
+}
+
+// === HTTP ===
+
+// conflictOptions возвращает параметры записи по параметру запроса conflicts:
+// reject — отказать в записи пересекающегося события, report — записать и вернуть пересечения в поле conflicts ответа.
+// Без параметра пересечения не проверяются
+func (s *Server) conflictOptions(r *http.Request) ([]WriteOption, *[]Event, error) {
+	mode, err := s.conflictMode(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts, conflicts := conflictModeOptions(mode)
+	return opts, conflicts, nil
+}
+
+// conflictMode возвращает режим проверки пересечений из параметра запроса conflicts
+func (s *Server) conflictMode(r *http.Request) (string, error) {
+	switch mode := r.URL.Query().Get("conflicts"); mode {
+	case "", "reject", "report":
+		return mode, nil
+	default:
+		return "", badRequestf("conflicts должен быть reject или report, получено %q", mode)
+	}
+}
+
+// conflictModeOptions возвращает параметры записи для режима mode. В режиме report пересечения собираются
+// в возвращаемый срез, поэтому для каждой записи параметры создаются заново
+func conflictModeOptions(mode string) ([]WriteOption, *[]Event) {
+	switch mode {
+	case "reject":
+		return []WriteOption{RejectConflicts()}, nil
+	case "report":
+		conflicts := []Event{}
+		return []WriteOption{ReportConflicts(&conflicts)}, &conflicts
+	default:
+		return nil, nil
+	}
+}
+
+// respondWithConflicts отправляет клиенту результат записи события и, в режиме report, найденные пересечения
+func (s *Server) respondWithConflicts(w http.ResponseWriter, status int, result interface{}, conflicts *[]Event) {
+	payload := map[string]interface{}{"result": result}
+	if conflicts != nil {
+		payload["conflicts"] = *conflicts
+	}
+	s.RespondWithJSON(w, status, payload)
+}
+
+// maxFreeBusyRange наибольший период, за который можно запросить занятость
+const maxFreeBusyRange = 366 * 24 * time.Hour
+
+// FreeBusyHandler обрабатывает запрос занятости пользователя GET /free_busy?user_id=&from=&to=[&tz=].
+// Возвращает {"result": [{"start": ..., "end": ...}]} в часовом поясе запроса. to не входит в период;
+// дата без времени в to означает конец этого дня
+func (s *Server) FreeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.RespondWithError(w, errMethodNotAllowed)
+		return
+	}
+
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	location, err := s.RequestLocation(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	fromStr, toStr := query.Get("from"), query.Get("to")
+	if fromStr == "" || toStr == "" {
+		s.RespondWithError(w, badRequestf("обязательны параметры from и to"))
+		return
+	}
+
+	from, err := ParseDateTime(fromStr, location)
+	if err != nil {
+		s.RespondWithError(w, badRequestf("from: %v", err))
+		return
+	}
+
+	to, err := ParseDateTime(toStr, location)
+	if err != nil {
+		s.RespondWithError(w, badRequestf("to: %v", err))
+		return
+	}
+	if len(toStr) == len("2006-01-02") {
+		to = to.AddDate(0, 0, 1)
+	}
+
+	if !to.After(from) {
+		s.RespondWithError(w, badRequestf("to должен быть позже from"))
+		return
+	}
+
+	if to.Sub(from) > maxFreeBusyRange {
+		s.RespondWithError(w, badRequestf("период не может превышать %d дней", int(maxFreeBusyRange.Hours()/24)))
+		return
+	}
+
+	busy, err := s.Calendar.FreeBusy(userID, from, to)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+	for i := range busy {
+		busy[i].Start = busy[i].Start.In(location)
+		busy[i].End = busy[i].End.In(location)
+	}
+
+	s.RespondWithResult(w, busy)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// span возвращает указатель на окончание события, начинающегося в start и длящегося duration
+func span(start time.Time, duration time.Duration) *time.Time {
+	end := start.Add(duration)
+	return &end
+}
+
+// newConflictStore возвращает календарь с планёркой пользователя 1 на 4 марта 10:00–11:00 и событиями,
+// которые с ней совпадают по времени, но пересечениями для пользователя 1 не считаются
+func newConflictStore(t *testing.T) *EventStore {
+	t.Helper()
+
+	store := InitNewEventStore()
+	meeting := at(2024, time.March, 4, 10, 0)
+	for _, event := range []Event{
+		{UserID: 1, Title: "Планёрка", Date: meeting, End: span(meeting, time.Hour)},
+		{UserID: 1, Title: "Напоминание", Date: meeting.Add(30 * time.Minute)},
+		{UserID: 2, Title: "Отклонённое", Date: meeting, End: span(meeting, time.Hour), Attendees: []Attendee{{UserID: 1}}},
+		{UserID: 2, Title: "Чужая встреча", Date: meeting, End: span(meeting, time.Hour)},
+	} {
+		if _, err := store.AddEvent(event); err != nil {
+			t.Fatalf("%s: %v", event.Title, err)
+		}
+	}
+	if _, err := store.RespondToEvent(1, 3, RSVPDeclined); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestConflicts(t *testing.T) {
+	meeting := at(2024, time.March, 4, 10, 0)
+
+	tests := []struct {
+		name  string
+		event Event
+		want  []int
+	}{
+		{"пересечение", Event{Title: "Созвон", Date: meeting.Add(45 * time.Minute), End: span(meeting.Add(45*time.Minute), time.Hour)}, []int{1}},
+		{"смежное событие", Event{Title: "Созвон", Date: meeting.Add(time.Hour), End: span(meeting.Add(time.Hour), time.Hour)}, nil},
+		{"событие без продолжительности", Event{Title: "Дедлайн", Date: meeting.Add(15 * time.Minute)}, nil},
+		{"весь день", Event{Title: "Отпуск", Date: at(2024, time.March, 4, 0, 0), AllDay: true}, []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newConflictStore(t)
+			event := tt.event
+			event.UserID = 1
+
+			var conflicts []Event
+			if _, err := store.AddEvent(event, ReportConflicts(&conflicts)); err != nil {
+				t.Fatal(err)
+			}
+			if len(conflicts) != len(tt.want) {
+				t.Fatalf("пересечения %+v, ожидались события %v", conflicts, tt.want)
+			}
+			for i, id := range tt.want {
+				if conflicts[i].ID != id {
+					t.Errorf("пересечение %d: событие %d, ожидалось %d", i, conflicts[i].ID, id)
+				}
+			}
+		})
+	}
+
+	// Изменение события не пересекается с ним самим
+	store := newConflictStore(t)
+	var conflicts []Event
+	if _, err := store.UpdateEvent(1, Event{ID: 1, Title: "Планёрка", Date: meeting.Add(15 * time.Minute), End: span(meeting, time.Hour)},
+		ReportConflicts(&conflicts)); err != nil {
+		t.Fatal(err)
+	}
+	for _, conflict := range conflicts {
+		if conflict.ID == 1 {
+			t.Error("событие пересекается само с собой")
+		}
+	}
+
+	before, err := store.GetUserEvents(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddEvent(Event{UserID: 1, Title: "Наложение", Date: meeting, End: span(meeting, time.Hour)}, RejectConflicts())
+	var conflictErr *ConflictError
+	if !errors.As(err, &conflictErr) || !errors.Is(err, ErrConflict) || len(conflictErr.Conflicts) == 0 {
+		t.Fatalf("запись пересекающегося события: %v, ожидалась ConflictError", err)
+	}
+	if after, err := store.GetUserEvents(1); err != nil || len(after) != len(before) {
+		t.Errorf("отклонённое событие записано: %d событий, было %d (%v)", len(after), len(before), err)
+	}
+}
+
+func TestSeriesConflicts(t *testing.T) {
+	store := InitNewEventStore()
+
+	// Вхождения серии проверяются от текущего момента, поэтому серия начинается в будущем
+	start := truncateToDay(time.Now().UTC()).AddDate(0, 0, 2).Add(10 * time.Hour)
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "Встреча", Date: start.AddDate(0, 0, 3).Add(30 * time.Minute), End: span(start.AddDate(0, 0, 3), 2*time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	series := Event{UserID: 1, Title: "Стендап", Date: start, End: span(start, time.Hour), Recurrence: &Recurrence{Freq: FreqDaily, Count: 3}}
+	if _, err := store.AddEvent(series, RejectConflicts()); err != nil {
+		t.Fatalf("серия до встречи: %v", err)
+	}
+
+	series.Recurrence = &Recurrence{Freq: FreqDaily, Count: 5}
+	if _, err := store.AddEvent(series, RejectConflicts()); !errors.Is(err, ErrConflict) {
+		t.Errorf("четвёртое вхождение серии пересекается со встречей: %v, ожидалась ErrConflict", err)
+	}
+}
+
+func TestFreeBusy(t *testing.T) {
+	store := InitNewEventStore()
+	day := at(2024, time.March, 4, 0, 0)
+	for _, event := range []Event{
+		{UserID: 1, Title: "Ночная смена", Date: day.Add(-2 * time.Hour), End: span(day.Add(-2*time.Hour), 4*time.Hour)},
+		{UserID: 1, Title: "Планёрка", Date: day.Add(9 * time.Hour), End: span(day.Add(9*time.Hour), time.Hour)},
+		{UserID: 1, Title: "Сразу после", Date: day.Add(10 * time.Hour), End: span(day.Add(10*time.Hour), 30*time.Minute)},
+		{UserID: 1, Title: "Внутри", Date: day.Add(9*time.Hour + 15*time.Minute), End: span(day.Add(9*time.Hour+15*time.Minute), 15*time.Minute)},
+		{UserID: 1, Title: "Дедлайн", Date: day.Add(12 * time.Hour)},
+		{UserID: 2, Title: "Отклонённое", Date: day.Add(14 * time.Hour), End: span(day.Add(14*time.Hour), time.Hour), Attendees: []Attendee{{UserID: 1}}},
+		{UserID: 2, Title: "Приглашение", Date: day.Add(16 * time.Hour), End: span(day.Add(16*time.Hour), time.Hour), Attendees: []Attendee{{UserID: 1}}},
+	} {
+		if _, err := store.AddEvent(event); err != nil {
+			t.Fatalf("%s: %v", event.Title, err)
+		}
+	}
+	if _, err := store.RespondToEvent(1, 6, RSVPDeclined); err != nil {
+		t.Fatal(err)
+	}
+
+	busy, err := store.FreeBusy(1, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []BusyInterval{
+		{Start: day, End: day.Add(2 * time.Hour)}, // смена обрезана началом периода
+		{Start: day.Add(9 * time.Hour), End: day.Add(10*time.Hour + 30*time.Minute)},
+		{Start: day.Add(16 * time.Hour), End: day.Add(17 * time.Hour)},
+	}
+	if len(busy) != len(want) {
+		t.Fatalf("занятость %+v, ожидалась %+v", busy, want)
+	}
+	for i := range want {
+		if !busy[i].Start.Equal(want[i].Start) || !busy[i].End.Equal(want[i].End) {
+			t.Errorf("интервал %d: %v–%v, ожидался %v–%v", i, busy[i].Start, busy[i].End, want[i].Start, want[i].End)
+		}
+	}
+}
+
+func TestConflictMode(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	for _, mode := range []string{"", "reject", "report"} {
+		if got, err := server.conflictMode(httptest.NewRequest("POST", "/create_event?conflicts="+mode, nil)); err != nil || got != mode {
+			t.Errorf("conflicts=%s: %q, %v", mode, got, err)
+		}
+	}
+	if _, err := server.conflictMode(httptest.NewRequest("POST", "/create_event?conflicts=ignore", nil)); err == nil {
+		t.Error("conflicts=ignore должен быть ошибкой входных данных")
+	}
+
+	if opts, conflicts := conflictModeOptions("report"); len(opts) != 1 || conflicts == nil || *conflicts == nil {
+		t.Error("в режиме report пересечения собираются в заранее созданный срез")
+	}
+	if opts, conflicts := conflictModeOptions(""); opts != nil || conflicts != nil {
+		t.Error("без режима пересечения не проверяются")
+	}
+}
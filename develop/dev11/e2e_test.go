@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// === Сквозные тесты HTTP-обработчиков ===
+
+// TestMain отключает журнал доступа и лог сервера: в выводе тестов они только мешают
+func TestMain(m *testing.M) {
+	accessLog.SetOutput(io.Discard)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// seedTime момент, которым помечены создание и изменение всех заранее созданных событий
+var seedTime = time.Date(2024, time.February, 1, 12, 0, 0, 0, time.UTC)
+
+// at возвращает момент времени в UTC
+func at(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+// seedEvents события, с которыми запускается каждый тестовый сервер. ID назначаются по порядку, начиная с 1.
+// События 2–5 лежат на границах недели 4–10 марта 2024 и марта 2024
+func seedEvents() []Event {
+	end := at(2024, time.March, 4, 11, 0)
+	return []Event{
+		{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0), End: &end, Place: "Переговорная", Reminders: []int{15}},
+		{UserID: 1, Title: "Поздний созвон", Date: at(2024, time.March, 10, 23, 30)},
+		{UserID: 1, Title: "Следующая неделя", Date: at(2024, time.March, 11, 0, 0)},
+		{UserID: 1, Title: "Конец месяца", Date: at(2024, time.March, 31, 23, 59)},
+		{UserID: 1, Title: "Апрель", Date: at(2024, time.April, 1, 0, 0)},
+		{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}},
+		{UserID: 2, Title: "Чужое событие", Date: at(2024, time.March, 4, 12, 0)},
+	}
+}
+
+// testServer сервер календаря, запущенный на httptest.Server
+type testServer struct {
+	*httptest.Server
+	server *Server
+}
+
+// newTestServer запускает сервер с событиями seedEvents и останавливает его по завершении теста
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	calendar := InitNewEventStore()
+	for _, event := range seedEvents() {
+		event.CreatedAt, event.UpdatedAt = seedTime, seedTime
+		if _, err := calendar.AddEvent(event); err != nil {
+			t.Fatalf("не удалось создать событие %q: %v", event.Title, err)
+		}
+	}
+
+	return newTestServerWithStore(t, calendar)
+}
+
+// newTestServerWithStore запускает тестовый сервер поверх календаря calendar
+func newTestServerWithStore(t *testing.T, calendar *EventStore) *testServer {
+	t.Helper()
+
+	server := NewServer(calendar)
+	ts := &testServer{Server: httptest.NewServer(server.Handler()), server: server}
+	t.Cleanup(func() {
+		server.Changes.Close()
+		ts.Close()
+	})
+	return ts
+}
+
+// testRequest запрос к тестовому серверу
+type testRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+	header      map[string]string
+}
+
+// do выполняет запрос и возвращает ответ с прочитанным телом
+func (ts *testServer) do(t *testing.T, req testRequest) (*http.Response, []byte) {
+	t.Helper()
+
+	httpReq, err := http.NewRequest(req.method, ts.URL+req.path, strings.NewReader(req.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	for name, value := range req.header {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := ts.Client().Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// apiResponse общий вид JSON-ответов сервера
+type apiResponse struct {
+	Result     json.RawMessage `json:"result"`
+	NextCursor string          `json:"next_cursor"`
+	Conflicts  []Event         `json:"conflicts"`
+	Error      string          `json:"error"`
+	Code       string          `json:"code"`
+}
+
+// decodeResponse разбирает JSON-ответ сервера
+func decodeResponse(t *testing.T, body []byte) apiResponse {
+	t.Helper()
+
+	var response apiResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("ответ не является JSON: %v\n%s", err, body)
+	}
+	return response
+}
+
+// resultEvents разбирает поле result со списком событий
+func resultEvents(t *testing.T, body []byte) []Event {
+	t.Helper()
+
+	var events []Event
+	if err := json.Unmarshal(decodeResponse(t, body).Result, &events); err != nil {
+		t.Fatalf("result не является списком событий: %v\n%s", err, body)
+	}
+	return events
+}
+
+// titles возвращает названия событий по порядку
+func titles(events []Event) []string {
+	result := make([]string, 0, len(events))
+	for _, event := range events {
+		result = append(result, event.Title)
+	}
+	return result
+}
+
+// expectTitles возвращает проверку, что ответ содержит события с названиями want в указанном порядке
+func expectTitles(want ...string) func(*testing.T, *http.Response, []byte) {
+	return func(t *testing.T, _ *http.Response, body []byte) {
+		t.Helper()
+		if got := titles(resultEvents(t, body)); strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("события = %q, ожидались %q", got, want)
+		}
+	}
+}
+
+// expectHeader возвращает проверку значения заголовка ответа
+func expectHeader(name, want string) func(*testing.T, *http.Response, []byte) {
+	return func(t *testing.T, resp *http.Response, _ []byte) {
+		t.Helper()
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("%s = %q, ожидался %q", name, got, want)
+		}
+	}
+}
+
+const (
+	jsonType = "application/json"
+	formType = "application/x-www-form-urlencoded"
+)
+
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        testRequest
+		wantStatus int
+		wantCode   string // код ошибки в ответе; пусто — ответ без ошибки
+		check      func(t *testing.T, resp *http.Response, body []byte)
+	}{
+		// POST /create_event
+		{
+			name:       "создание из JSON",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"title":"Новое","date":"2024-03-06T10:00:00Z"}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "создание из формы",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: formType, body: "user_id=1&title=Форма&date=2024-03-06&tz=Europe/Moscow"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "создание без названия",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"date":"2024-03-06T10:00:00Z"}`},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "создание с пустым названием",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"title":"","date":"2024-03-06T10:00:00Z"}`},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "validation",
+		},
+		{
+			name:       "создание с неизвестным полем",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"title":"x","color":"red"}`},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "создание с неподдерживаемым Content-Type",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: "text/plain", body: "title"},
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantCode:   "unsupported_media_type",
+		},
+		{
+			name:       "создание методом GET",
+			req:        testRequest{method: "GET", path: "/create_event"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "создание с пересечением в режиме reject",
+			req:        testRequest{method: "POST", path: "/create_event?conflicts=reject", contentType: jsonType, body: `{"user_id":1,"title":"Наложение","date":"2024-03-04T10:30:00Z","end":"2024-03-04T12:00:00Z"}`},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "conflict",
+			check: func(t *testing.T, _ *http.Response, body []byte) {
+				if conflicts := decodeResponse(t, body).Conflicts; len(conflicts) != 1 || conflicts[0].ID != 1 {
+					t.Errorf("conflicts = %+v, ожидалось событие 1", conflicts)
+				}
+			},
+		},
+
+		// POST /update_event
+		{
+			name:       "изменение названия",
+			req:        testRequest{method: "POST", path: "/update_event", contentType: jsonType, body: `{"user_id":1,"id":1,"title":"Планёрка (перенос)"}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "изменение чужого события",
+			req:        testRequest{method: "POST", path: "/update_event", contentType: jsonType, body: `{"user_id":2,"id":1,"title":"Захват"}`},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "forbidden",
+		},
+		{
+			name:       "изменение несуществующего события",
+			req:        testRequest{method: "POST", path: "/update_event", contentType: jsonType, body: `{"user_id":1,"id":100,"title":"x"}`},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCode:   "not_found",
+		},
+		{
+			name:       "изменение с устаревшей версией",
+			req:        testRequest{method: "POST", path: "/update_event", contentType: jsonType, body: `{"user_id":1,"id":1,"title":"x"}`, header: map[string]string{"If-Match": `"7"`}},
+			wantStatus: http.StatusPreconditionFailed,
+			wantCode:   "precondition_failed",
+		},
+
+		// POST /delete_event
+		{
+			name:       "удаление",
+			req:        testRequest{method: "POST", path: "/delete_event", contentType: jsonType, body: `{"user_id":1,"id":2}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "удаление вхождения серии",
+			req:        testRequest{method: "POST", path: "/delete_event", contentType: formType, body: "user_id=1&id=6&occurrence=2024-03-06"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "удаление без ID",
+			req:        testRequest{method: "POST", path: "/delete_event", contentType: jsonType, body: `{"user_id":1}`},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+
+		// GET /events_for_day, /events_for_week, /events_for_month
+		{
+			name:       "события за день",
+			req:        testRequest{method: "GET", path: "/events_for_day?user_id=1&date=2024-03-05"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Зарядка"),
+		},
+		{
+			name:       "события за день в часовом поясе запроса",
+			req:        testRequest{method: "GET", path: "/events_for_day?user_id=1&date=2024-03-11&tz=Asia/Tokyo"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Поздний созвон", "Следующая неделя"),
+		},
+		{
+			name:       "неделя включает воскресный вечер и не включает следующий понедельник",
+			req:        testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-07"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Планёрка", "Зарядка", "Зарядка", "Зарядка", "Поздний созвон"),
+		},
+		{
+			name:       "месяц включает последнюю минуту и не включает первое число следующего",
+			req:        testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-03-15"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Планёрка", "Зарядка", "Зарядка", "Зарядка", "Поздний созвон", "Следующая неделя", "Конец месяца"),
+		},
+		{
+			name:       "первая страница списка",
+			req:        testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-03-15&limit=2"},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response, body []byte) {
+				expectTitles("Планёрка", "Зарядка")(t, resp, body)
+				if decodeResponse(t, body).NextCursor == "" {
+					t.Error("нет next_cursor у неполной выдачи")
+				}
+			},
+		},
+		{
+			name:       "поиск по названию без учёта регистра",
+			req:        testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-03-15&q=%D0%97%D0%90%D0%A0%D0%AF%D0%94"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Зарядка", "Зарядка", "Зарядка"),
+		},
+		{
+			name:       "некорректная дата",
+			req:        testRequest{method: "GET", path: "/events_for_day?user_id=1&date=05.03.2024"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "без user_id",
+			req:        testRequest{method: "GET", path: "/events_for_day?date=2024-03-05"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "некорректный часовой пояс",
+			req:        testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-05&tz=Mars/Olympus"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+
+		// iCalendar
+		{
+			name:       "экспорт",
+			req:        testRequest{method: "GET", path: "/export.ics?user_id=2"},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response, body []byte) {
+				if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+					t.Errorf("Content-Type = %q", resp.Header.Get("Content-Type"))
+				}
+				if !strings.Contains(string(body), "SUMMARY:Чужое событие") {
+					t.Errorf("в выгрузке нет события:\n%s", body)
+				}
+			},
+		},
+		{
+			name: "импорт",
+			req: testRequest{method: "POST", path: "/import?user_id=3", contentType: "text/calendar",
+				body: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:a@test\r\nDTSTART:20240301T100000Z\r\nSUMMARY:Импорт\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "импорт некорректного документа",
+			req:        testRequest{method: "POST", path: "/import?user_id=3", contentType: "text/calendar", body: "BEGIN:VEVENT\r\n"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+
+		// REST API
+		{
+			name:       "API: список за период",
+			req:        testRequest{method: "GET", path: "/api/v1/events?user_id=1&from=2024-03-10&to=2024-03-11"},
+			wantStatus: http.StatusOK,
+			check:      expectTitles("Поздний созвон", "Следующая неделя"),
+		},
+		{
+			name:       "API: to раньше from",
+			req:        testRequest{method: "GET", path: "/api/v1/events?user_id=1&from=2024-03-11&to=2024-03-10"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "API: создание",
+			req:        testRequest{method: "POST", path: "/api/v1/events", contentType: jsonType, body: `{"user_id":1,"title":"Через API","date":"2024-03-06T10:00:00Z"}`},
+			wantStatus: http.StatusCreated,
+			check:      expectHeader("Location", "/api/v1/events/8"),
+		},
+		{
+			name:       "API: событие с ETag",
+			req:        testRequest{method: "GET", path: "/api/v1/events/1?user_id=1"},
+			wantStatus: http.StatusOK,
+			check:      expectHeader("ETag", `"1"`),
+		},
+		{
+			name:       "API: несуществующее событие",
+			req:        testRequest{method: "GET", path: "/api/v1/events/100?user_id=1"},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+		},
+		{
+			name:       "API: некорректный ID",
+			req:        testRequest{method: "GET", path: "/api/v1/events/abc?user_id=1"},
+			wantStatus: http.StatusNotFound,
+			wantCode:   "not_found",
+		},
+		{
+			name:       "API: замена",
+			req:        testRequest{method: "PUT", path: "/api/v1/events/3", contentType: jsonType, body: `{"user_id":1,"title":"Заменено","date":"2024-03-12T09:00:00Z"}`, header: map[string]string{"If-Match": `"1"`}},
+			wantStatus: http.StatusOK,
+			check:      expectHeader("ETag", `"2"`),
+		},
+		{
+			name:       "API: замена без даты",
+			req:        testRequest{method: "PUT", path: "/api/v1/events/3", contentType: jsonType, body: `{"user_id":1,"title":"Заменено"}`},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "validation",
+		},
+		{
+			name:       "API: очистка поля",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, body: `{"user_id":1,"location":null}`},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, _ *http.Response, body []byte) {
+				var event Event
+				if err := json.Unmarshal(decodeResponse(t, body).Result, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.Place != "" || event.Title != "Планёрка" {
+					t.Errorf("место = %q, название = %q: ожидалось очищенное место и прежнее название", event.Place, event.Title)
+				}
+			},
+		},
+		{
+			name:       "API: пустое поле формы не очищает значение",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: formType, body: "user_id=1&title=Стендап&location="},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, _ *http.Response, body []byte) {
+				var event Event
+				if err := json.Unmarshal(decodeResponse(t, body).Result, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.Title != "Стендап" || event.Place == "" {
+					t.Errorf("пустое поле формы изменило событие: %+v", event)
+				}
+			},
+		},
+		{
+			name:       "API: изменение вхождения серии",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/6", contentType: jsonType, body: `{"user_id":1,"occurrence":"2024-03-06T07:00:00Z","title":"Пробежка"}`},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, resp *http.Response, body []byte) {
+				var event Event
+				if err := json.Unmarshal(decodeResponse(t, body).Result, &event); err != nil {
+					t.Fatal(err)
+				}
+				if event.ID != 8 || event.SeriesID != 6 || event.Title != "Пробежка" {
+					t.Errorf("в ответе %+v, ожидалось выделенное вхождение 8 серии 6", event)
+				}
+				expectHeader("ETag", `"1"`)(t, resp, body)
+				expectHeader("Content-Location", "/api/v1/events/8")(t, resp, body)
+			},
+		},
+		{
+			name:       "API: изменение с устаревшей версией",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, body: `{"user_id":1,"title":"x"}`, header: map[string]string{"If-Match": `"0"`}},
+			wantStatus: http.StatusPreconditionFailed,
+			wantCode:   "precondition_failed",
+		},
+		{
+			name:       "API: пересечение",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/3?conflicts=reject", contentType: jsonType, body: `{"user_id":1,"date":"2024-03-04T10:30:00Z","end":"2024-03-04T10:45:00Z"}`},
+			wantStatus: http.StatusConflict,
+			wantCode:   "conflict",
+		},
+		{
+			name:       "API: удаление",
+			req:        testRequest{method: "DELETE", path: "/api/v1/events/5?user_id=1"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "API: чужое событие",
+			req:        testRequest{method: "DELETE", path: "/api/v1/events/7?user_id=1"},
+			wantStatus: http.StatusForbidden,
+			wantCode:   "forbidden",
+		},
+		{
+			name:       "API: неподдерживаемый метод",
+			req:        testRequest{method: "POST", path: "/api/v1/events/1"},
+			wantStatus: http.StatusMethodNotAllowed,
+			wantCode:   "method_not_allowed",
+			check:      expectHeader("Allow", "GET, PUT, PATCH, DELETE"),
+		},
+
+		// Занятость и метрики
+		{
+			name:       "занятость",
+			req:        testRequest{method: "GET", path: "/free_busy?user_id=1&from=2024-03-04&to=2024-03-04"},
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, _ *http.Response, body []byte) {
+				var busy []BusyInterval
+				if err := json.Unmarshal(decodeResponse(t, body).Result, &busy); err != nil {
+					t.Fatal(err)
+				}
+				if len(busy) != 1 || !busy[0].Start.Equal(at(2024, time.March, 4, 10, 0)) || !busy[0].End.Equal(at(2024, time.March, 4, 11, 0)) {
+					t.Errorf("занятость = %+v, ожидался интервал 10:00–11:00", busy)
+				}
+			},
+		},
+		{
+			name:       "занятость без периода",
+			req:        testRequest{method: "GET", path: "/free_busy?user_id=1"},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "bad_request",
+		},
+		{
+			name:       "метрики",
+			req:        testRequest{method: "GET", path: "/metrics"},
+			wantStatus: http.StatusOK,
+			check:      expectHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8"),
+		},
+		{
+			name:       "неизвестный адрес",
+			req:        testRequest{method: "GET", path: "/nope"},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			resp, body := ts.do(t, tt.req)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа = %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+
+			if resp.Header.Get("X-Request-ID") == "" {
+				t.Error("в ответе нет X-Request-ID")
+			}
+
+			if strings.HasPrefix(resp.Header.Get("Content-Type"), jsonType) {
+				if code := decodeResponse(t, body).Code; code != tt.wantCode {
+					t.Errorf("код ошибки = %q, ожидался %q\n%s", code, tt.wantCode, body)
+				}
+			} else if tt.wantCode != "" {
+				t.Errorf("ожидалась ошибка %q в JSON, получен Content-Type %q", tt.wantCode, resp.Header.Get("Content-Type"))
+			}
+
+			if tt.check != nil {
+				tt.check(t, resp, body)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2}}
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"без токена", "/events_for_day?date=2024-03-04", "", http.StatusUnauthorized},
+		{"неизвестный токен", "/events_for_day?date=2024-03-04", "mallory", http.StatusUnauthorized},
+		{"свой календарь", "/events_for_day?date=2024-03-04", "alice", http.StatusOK},
+		{"свой календарь с user_id", "/events_for_day?user_id=2&date=2024-03-04", "bob", http.StatusOK},
+		{"чужой календарь", "/events_for_day?user_id=1&date=2024-03-04", "bob", http.StatusForbidden},
+		{"метрики без токена", "/metrics", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest{method: "GET", path: tt.path}
+			if tt.token != "" {
+				req.header = map[string]string{"Authorization": "Bearer " + tt.token}
+			}
+
+			if resp, body := ts.do(t, req); resp.StatusCode != tt.wantStatus {
+				t.Errorf("код ответа = %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+}
+
+// readSSE читает из потока следующее событие Server-Sent Events, пропуская служебные строки
+func readSSE(t *testing.T, scanner *bufio.Scanner) (id, name string, change EventChange) {
+	t.Helper()
+
+	var data string
+	for data == "" && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	if err := json.Unmarshal([]byte(data), &change); err != nil {
+		t.Fatalf("data не является изменением события: %v (%q)", err, data)
+	}
+	return id, name, change
+}
+
+func TestEventStream(t *testing.T) {
+	ts := newTestServer(t)
+
+	// Лента создаётся вместе с сервером, поэтому изменения нумеруются с 1 после заранее созданных событий
+	calendar := ts.server.Calendar
+	if _, err := calendar.AddEvent(Event{UserID: 2, Title: "Не для клиента", Date: at(2024, time.March, 6, 8, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := calendar.AddEvent(Event{UserID: 1, Title: "Пропущенное", Date: at(2024, time.March, 6, 9, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+streamPath+"?user_id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("код ответа %d, Content-Type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	if _, err := calendar.AddEvent(Event{UserID: 1, Title: "Живое", Date: at(2024, time.March, 6, 10, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for _, want := range []struct{ id, name, title string }{
+		{"2", "created", "Пропущенное"},
+		{"3", "created", "Живое"},
+	} {
+		id, name, change := readSSE(t, scanner)
+		if id != want.id || name != want.name || change.Event.Title != want.title {
+			t.Errorf("получено id=%s event=%s %q, ожидалось id=%s event=%s %q", id, name, change.Event.Title, want.id, want.name, want.title)
+		}
+	}
+}
+
+func TestEventStreamWriteTimeout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Config.WriteTimeout = Duration(200 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+streamPath+"?user_id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// Тестовый сервер не ограничивает запись, поэтому поток завершается только сам, незадолго до write_timeout
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("поток оборвался: %v", err)
+	}
+	if !strings.HasPrefix(string(body), "retry: ") {
+		t.Errorf("тело потока %q", body)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+/*
+	  = == ==        == == =
+	= ==== ОШИБКИ ==== =
+	  = == ==        == == =
+*/
+
+// === Ошибки бизнес-логики ===
+
+// ErrorKind вид ошибки бизнес-логики
+type ErrorKind string
+
+// Виды ошибок бизнес-логики
+const (
+	KindNotFound   ErrorKind = "not_found"  // событие или вхождение не существует
+	KindValidation ErrorKind = "validation" // событие нарушает правила предметной области
+	KindConflict   ErrorKind = "conflict"   // изменение противоречит текущему состоянию хранилища
+	KindForbidden  ErrorKind = "forbidden"  // событие принадлежит другому пользователю
+
+	KindPrecondition ErrorKind = "precondition_failed" // версия события не совпала с ожидаемой клиентом
+)
+
+// DomainError ошибка бизнес-логики EventStore. Не зависит от HTTP: коды ответа назначает сервер
+type DomainError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+// Error возвращает текст ошибки
+func (e *DomainError) Error() string {
+	return e.Message
+}
+
+// Is позволяет сравнивать ошибки с ErrNotFound, ErrValidation и т.д. через errors.Is
+func (e *DomainError) Is(target error) bool {
+	t, ok := target.(*DomainError)
+	return ok && t.Message == "" && t.Kind == e.Kind
+}
+
+// Образцы для errors.Is
+var (
+	ErrNotFound   = &DomainError{Kind: KindNotFound}
+	ErrValidation = &DomainError{Kind: KindValidation}
+	ErrConflict   = &DomainError{Kind: KindConflict}
+	ErrForbidden  = &DomainError{Kind: KindForbidden}
+
+	ErrPrecondition = &DomainError{Kind: KindPrecondition}
+)
+
+// notFoundErrorf создаёт ошибку "не найдено"
+func notFoundErrorf(format string, args ...interface{}) error {
+	return &DomainError{Kind: KindNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// validationErrorf создаёт ошибку нарушения правил предметной области
+func validationErrorf(format string, args ...interface{}) error {
+	return &DomainError{Kind: KindValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+// conflictErrorf создаёт ошибку конфликта с текущим состоянием хранилища
+func conflictErrorf(format string, args ...interface{}) error {
+	return &DomainError{Kind: KindConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// forbiddenErrorf создаёт ошибку доступа к чужому событию
+func forbiddenErrorf(format string, args ...interface{}) error {
+	return &DomainError{Kind: KindForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// preconditionErrorf создаёт ошибку несовпадения версии события
+func preconditionErrorf(format string, args ...interface{}) error {
+	return &DomainError{Kind: KindPrecondition, Message: fmt.Sprintf(format, args...)}
+}
+
+// === Ошибки хранилища ===
+
+// StorageError сбой хранилища или журнала аудита: в отличие от DomainError, не зависит от запроса
+type StorageError struct {
+	Err error
+}
+
+// Error возвращает текст ошибки
+func (e *StorageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap возвращает исходную ошибку
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// storageError оборачивает ошибку хранилища в StorageError. Ошибки бизнес-логики, которые хранилище
+// тоже возвращает (например, не найденное при изменении событие), и уже обёрнутые ошибки возвращаются как есть
+func storageError(err error) error {
+	var domainErr *DomainError
+	var storageErr *StorageError
+	if err == nil || errors.As(err, &domainErr) || errors.As(err, &storageErr) {
+		return err
+	}
+	return &StorageError{Err: err}
+}
+
+// === Ошибки HTTP-уровня ===
+
+// HTTPError ошибка обработки запроса с заранее известным кодом ответа (невалидные входные данные и т.п.)
+type HTTPError struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+// Error возвращает текст ошибки
+func (e *HTTPError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap возвращает исходную ошибку
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// badRequestf создаёт ошибку входных данных (HTTP 400)
+func badRequestf(format string, args ...interface{}) error {
+	return &HTTPError{Status: http.StatusBadRequest, Code: "bad_request", Err: fmt.Errorf(format, args...)}
+}
+
+// errMethodNotAllowed ответ на запрос с неверным HTTP методом. По заданию это ошибка входных данных
+var errMethodNotAllowed = &HTTPError{Status: http.StatusBadRequest, Code: "bad_request", Err: errors.New("неверный http метод")}
+
+// methodNotAllowed ответ REST API на запрос с методом, который ресурс не поддерживает. Заголовок Allow
+// выставляет обработчик
+var methodNotAllowed = &HTTPError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Err: errors.New("метод не поддерживается ресурсом")}
+
+// domainErrorStatus коды ответа для ошибок бизнес-логики: по заданию это HTTP 503,
+// кроме попытки обратиться к чужому календарю и несовпадения версии из If-Match
+var domainErrorStatus = map[ErrorKind]int{
+	KindNotFound:     http.StatusServiceUnavailable,
+	KindValidation:   http.StatusServiceUnavailable,
+	KindConflict:     http.StatusServiceUnavailable,
+	KindForbidden:    http.StatusForbidden,
+	KindPrecondition: http.StatusPreconditionFailed,
+}
+
+// apiDomainErrorStatus коды ответа REST API для ошибок бизнес-логики. Условие задания касается только
+// методов из него, поэтому API отвечает принятыми для ресурсов кодами
+var apiDomainErrorStatus = map[ErrorKind]int{
+	KindNotFound:   http.StatusNotFound,
+	KindValidation: http.StatusUnprocessableEntity,
+	KindConflict:   http.StatusConflict,
+	KindForbidden:  http.StatusForbidden,
+
+	KindPrecondition: http.StatusPreconditionFailed,
+}
+
+// ErrorResponse тело ответа с ошибкой
+type ErrorResponse struct {
+	Error     string  `json:"error"`
+	Code      string  `json:"code"`
+	Conflicts []Event `json:"conflicts,omitempty"` // события, из-за пересечения с которыми отклонена запись
+	Index     *int    `json:"index,omitempty"`     // номер операции пакета, из-за которой пакет не выполнен
+}
+
+// storageErrorCode машиночитаемый код ошибки хранилища
+const storageErrorCode = "storage"
+
+// ErrorStatus определяет код ответа и машиночитаемый код ошибки. Сбой хранилища — HTTP 500: по заданию
+// 503 отвечают только на ошибки бизнес-логики, а остальные ошибки — это 500
+func ErrorStatus(err error) (int, string) {
+	return errorStatus(err, domainErrorStatus)
+}
+
+// APIErrorStatus определяет код ответа REST API и машиночитаемый код ошибки. Сбой хранилища — HTTP 500
+func APIErrorStatus(err error) (int, string) {
+	return errorStatus(err, apiDomainErrorStatus)
+}
+
+// errorStatus определяет код ответа по таблице кодов для ошибок бизнес-логики. Сбой хранилища — HTTP 500
+// с кодом storageErrorCode, по которому его можно отличить от внутренней ошибки
+func errorStatus(err error, domainStatus map[ErrorKind]int) (int, string) {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status, httpErr.Code
+	}
+
+	var domainErr *DomainError
+	if errors.As(err, &domainErr) {
+		if status, ok := domainStatus[domainErr.Kind]; ok {
+			return status, string(domainErr.Kind)
+		}
+	}
+
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return http.StatusInternalServerError, storageErrorCode
+	}
+
+	return http.StatusInternalServerError, "internal"
+}
+
+// RespondWithError отправляет клиенту ошибку с кодом ответа, соответствующим её типу
+func (s *Server) RespondWithError(w http.ResponseWriter, err error) {
+	s.respondWithError(w, err, ErrorStatus)
+}
+
+// RespondWithAPIError отправляет клиенту REST API ошибку с кодом ответа, соответствующим её типу
+func (s *Server) RespondWithAPIError(w http.ResponseWriter, err error) {
+	s.respondWithError(w, err, APIErrorStatus)
+}
+
+// respondWithError отправляет клиенту ошибку, определяя код ответа функцией statusOf
+func (s *Server) respondWithError(w http.ResponseWriter, err error, statusOf func(error) (int, string)) {
+	status, code := statusOf(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Внутренняя ошибка: %v", err)
+	}
+
+	response := ErrorResponse{Error: err.Error(), Code: code}
+
+	var conflictErr *ConflictError
+	if errors.As(err, &conflictErr) {
+		response.Conflicts = conflictErr.Conflicts
+	}
+
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		response.Index = &batchErr.Index
+	}
+
+	s.RespondWithJSON(w, status, response)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantStatus    int
+		wantAPIStatus int
+		wantCode      string
+	}{
+		{"входные данные", badRequestf("некорректный id"), http.StatusBadRequest, http.StatusBadRequest, "bad_request"},
+		{"не найдено", notFoundErrorf("нет события"), http.StatusServiceUnavailable, http.StatusNotFound, "not_found"},
+		{"нарушение правил", validationErrorf("пустой title"), http.StatusServiceUnavailable, http.StatusUnprocessableEntity, "validation"},
+		{"конфликт", conflictErrorf("пересечение"), http.StatusServiceUnavailable, http.StatusConflict, "conflict"},
+		{"чужое событие", forbiddenErrorf("чужое"), http.StatusForbidden, http.StatusForbidden, "forbidden"},
+		{"версия", preconditionErrorf("версия 2"), http.StatusPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+		{"обёрнутая ошибка бизнес-логики", fmt.Errorf("импорт: %w", notFoundErrorf("нет серии")), http.StatusServiceUnavailable, http.StatusNotFound, "not_found"},
+		{"сбой хранилища", storageError(errors.New("диск заполнен")), http.StatusInternalServerError, http.StatusInternalServerError, storageErrorCode},
+		{"прочая ошибка", errors.New("неожиданно"), http.StatusInternalServerError, http.StatusInternalServerError, "internal"},
+	}
+
+	for _, tt := range tests {
+		if status, code := ErrorStatus(tt.err); status != tt.wantStatus || code != tt.wantCode {
+			t.Errorf("%s: ErrorStatus() = %d %q, ожидалось %d %q", tt.name, status, code, tt.wantStatus, tt.wantCode)
+		}
+		if status, code := APIErrorStatus(tt.err); status != tt.wantAPIStatus || code != tt.wantCode {
+			t.Errorf("%s: APIErrorStatus() = %d %q, ожидалось %d %q", tt.name, status, code, tt.wantAPIStatus, tt.wantCode)
+		}
+	}
+}
+
+func TestDomainErrorIs(t *testing.T) {
+	err := fmt.Errorf("обновление: %w", notFoundErrorf("событие с ID %d не найдено", 5))
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrValidation) {
+		t.Errorf("errors.Is не различает виды ошибок: %v", err)
+	}
+	if errors.Is(ErrNotFound, notFoundErrorf("другое событие")) {
+		t.Error("с образцом сравнивается любая ошибка вида, но не наоборот")
+	}
+}
+
+func TestStorageErrorWrapping(t *testing.T) {
+	if storageError(nil) != nil {
+		t.Error("storageError(nil) должен вернуть nil")
+	}
+
+	domainErr := notFoundErrorf("нет события")
+	if storageError(domainErr) != domainErr {
+		t.Error("ошибка бизнес-логики не должна оборачиваться в StorageError")
+	}
+
+	cause := errors.New("database is locked")
+	wrapped := storageError(cause)
+	var storageErr *StorageError
+	if !errors.As(wrapped, &storageErr) || !errors.Is(wrapped, cause) {
+		t.Fatalf("storageError() = %#v, ожидалась StorageError с исходной ошибкой", wrapped)
+	}
+	if storageError(wrapped) != wrapped {
+		t.Error("уже обёрнутая ошибка не должна оборачиваться повторно")
+	}
+}
+
+// failingResponseWriter ResponseWriter, запись тела в который всегда завершается ошибкой
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+// Write имитирует разорванное соединение
+func (w failingResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestRespondWithError(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	w := httptest.NewRecorder()
+	server.RespondWithError(w, &BatchError{Index: 2, Err: validationErrorf("пустой title")})
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("ответ %d с Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	var response ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != "validation" || response.Error != "операция 2: пустой title" || response.Index == nil || *response.Index != 2 {
+		t.Errorf("тело ответа %+v", response)
+	}
+
+	// Ошибка записи в соединение касается только этого клиента и не должна завершать процесс
+	failing := failingResponseWriter{httptest.NewRecorder()}
+	server.RespondWithJSON(failing, http.StatusOK, map[string]string{"result": "ok"})
+	if failing.Code != http.StatusOK {
+		t.Errorf("код ответа %d, ожидался 200", failing.Code)
+	}
+
+	// Несериализуемый ответ заменяется внутренней ошибкой
+	w = httptest.NewRecorder()
+	server.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"result": make(chan int)})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("код ответа на несериализуемый результат %d, ожидался 500", w.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// === Эталонные ответы ===
+
+// update перезаписывает эталонные файлы текущими ответами: go test -run TestGolden -update
+var update = flag.Bool("update", false, "перезаписать эталонные файлы testdata/*.golden")
+
+// goldenResponse возвращает код ответа, Content-Type и тело ответа с отступами — в таком виде ответ хранится в эталоне
+func goldenResponse(t *testing.T, status int, contentType string, body []byte) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d %s\n", status, contentType)
+	if err := json.Indent(&b, body, "", "  "); err != nil {
+		t.Fatalf("ответ не является JSON: %v\n%s", err, body)
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{"day", testRequest{method: "GET", path: "/events_for_day?user_id=1&date=2024-03-04"}},
+		{"day_tz", testRequest{method: "GET", path: "/events_for_day?user_id=1&date=2024-03-11&tz=Asia/Tokyo"}},
+		{"week", testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-10"}},
+		{"week_next", testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-11"}},
+		{"month", testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-03-01"}},
+		{"month_next", testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-04-30"}},
+		{"month_page", testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-03-01&limit=3"}},
+		{"api_event", testRequest{method: "GET", path: "/api/v1/events/1?user_id=1"}},
+		{"api_list", testRequest{method: "GET", path: "/api/v1/events?user_id=1&from=2024-03-05&to=2024-03-08"}},
+		{"free_busy", testRequest{method: "GET", path: "/free_busy?user_id=1&from=2024-03-04&to=2024-03-10&tz=Europe/Moscow"}},
+		{"error_bad_request", testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-13-01"}},
+		{"error_not_found", testRequest{method: "GET", path: "/api/v1/events/100?user_id=1"}},
+		{"error_conflict", testRequest{method: "POST", path: "/api/v1/events?conflicts=reject", contentType: jsonType,
+			body: `{"user_id":1,"title":"Наложение","date":"2024-03-04T10:30:00Z","end":"2024-03-04T12:00:00Z"}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			resp, body := ts.do(t, tt.req)
+			got := goldenResponse(t, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.MkdirAll("testdata", 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("нет эталона (запустите с -update): %v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("ответ отличается от %s:\n--- получено\n%s\n--- ожидалось\n%s", path, got, want)
+			}
+		})
+	}
+}
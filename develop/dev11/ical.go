@@ -0,0 +1,760 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+	  = == ==                         == == =
+	= ==== iCalendar (RFC 5545): импорт и экспорт ==== =
+	  = == ==                         == == =
+*/
+
+const (
+	icalProdID       = "-//dev11//calendar//RU"
+	icalUIDDomain    = "dev11"
+	icalDateTimeUTC  = "20060102T150405Z"
+	icalDateTimeZone = "20060102T150405"
+	icalDate         = "20060102"
+	icalMaxLineLen   = 75
+)
+
+// unsupportedICalComponents компоненты календаря, которые сервер не умеет хранить
+var unsupportedICalComponents = map[string]bool{
+	"VTODO":         true,
+	"VJOURNAL":      true,
+	"VFREEBUSY":     true,
+	"VAVAILABILITY": true,
+}
+
+// skippedICalComponents вспомогательные компоненты, которые допустимы, но не переносятся в события
+var skippedICalComponents = map[string]bool{
+	"VTIMEZONE": true,
+	"STANDARD":  true,
+	"DAYLIGHT":  true,
+}
+
+// eventUID возвращает UID события для iCalendar: сохранённый при импорте или построенный из ID
+func eventUID(event Event) string {
+	if event.UID != "" {
+		return event.UID
+	}
+	return fmt.Sprintf("%d@%s", event.ID, icalUIDDomain)
+}
+
+// === Экспорт ===
+
+// icalWriter пишет строки содержимого iCalendar с переносом длинных строк по RFC 5545
+type icalWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line записывает свойство name со значением value, перенося строку длиннее 75 октетов
+func (iw *icalWriter) line(name, value string) {
+	if iw.err != nil {
+		return
+	}
+
+	content := name + ":" + value
+	for len(content) > icalMaxLineLen {
+		// Перенос не должен разрывать многобайтовый символ UTF-8
+		cut := icalMaxLineLen
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		if _, iw.err = iw.w.WriteString(content[:cut] + "\r\n"); iw.err != nil {
+			return
+		}
+		content = " " + content[cut:]
+	}
+
+	_, iw.err = iw.w.WriteString(content + "\r\n")
+}
+
+// escapeICalText экранирует текстовое значение свойства
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// unescapeICalText снимает экранирование с текстового значения свойства
+func unescapeICalText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// timeLine записывает свойство со временем: в местном времени с TZID, если у события задан часовой пояс, иначе в UTC.
+// Без TZID повторения серии в других календарях сдвигались бы на час при переходе на летнее время
+func (iw *icalWriter) timeLine(name string, t time.Time, timeZone string) {
+	if timeZone == "" {
+		iw.line(name, formatICalTime(t))
+		return
+	}
+
+	location, err := LoadLocation(timeZone)
+	if err != nil {
+		iw.line(name, formatICalTime(t))
+		return
+	}
+
+	iw.line(name+";TZID="+timeZone, t.In(location).Format(icalDateTimeZone))
+}
+
+// eventTimeLine записывает свойство со временем события: для события на весь день — датой (VALUE=DATE)
+// в часовом поясе события, иначе так же, как timeLine
+func (iw *icalWriter) eventTimeLine(name string, t time.Time, event Event) {
+	if event.AllDay {
+		iw.line(name+";VALUE=DATE", t.In(event.Location()).Format(icalDate))
+		return
+	}
+
+	iw.timeLine(name, t, event.TimeZone)
+}
+
+// formatICalTime форматирует момент времени в UTC
+func formatICalTime(t time.Time) string {
+	return t.UTC().Format(icalDateTimeUTC)
+}
+
+// FormatRRule возвращает правило повторения в виде значения свойства RRULE (без исключений)
+func FormatRRule(r *Recurrence) string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.ToUpper(strings.Join(r.ByDay, ",")))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+formatICalTime(*r.Until))
+	}
+	return strings.Join(parts, ";")
+}
+
+// WriteICalendar записывает события в формате VCALENDAR
+func WriteICalendar(w io.Writer, events []Event) error {
+	// Выделенные вхождения публикуются с UID своей серии и RECURRENCE-ID
+	seriesUID := make(map[int]string)
+	for _, event := range events {
+		if event.Recurrence != nil {
+			seriesUID[event.ID] = eventUID(event)
+		}
+	}
+
+	iw := &icalWriter{w: bufio.NewWriter(w)}
+	iw.line("BEGIN", "VCALENDAR")
+	iw.line("VERSION", "2.0")
+	iw.line("PRODID", icalProdID)
+	iw.line("CALSCALE", "GREGORIAN")
+
+	stamp := formatICalTime(time.Now())
+	for _, event := range events {
+		uid := eventUID(event)
+		if event.SeriesID != 0 {
+			if parent, ok := seriesUID[event.SeriesID]; ok {
+				uid = parent
+			}
+		}
+
+		iw.line("BEGIN", "VEVENT")
+		iw.line("UID", escapeICalText(uid))
+		iw.line("DTSTAMP", stamp)
+		iw.eventTimeLine("DTSTART", event.Date, event)
+		if event.End != nil {
+			iw.eventTimeLine("DTEND", *event.End, event)
+		}
+		iw.line("SUMMARY", escapeICalText(event.Title))
+		if event.Place != "" {
+			iw.line("LOCATION", escapeICalText(event.Place))
+		}
+		if event.Description != "" {
+			iw.line("DESCRIPTION", escapeICalText(event.Description))
+		}
+		iw.line("CREATED", formatICalTime(event.CreatedAt))
+		iw.line("LAST-MODIFIED", formatICalTime(event.UpdatedAt))
+
+		if event.Recurrence != nil {
+			iw.line("RRULE", FormatRRule(event.Recurrence))
+			for _, exception := range event.Recurrence.Exceptions {
+				iw.eventTimeLine("EXDATE", exception, event)
+			}
+		}
+
+		if event.SeriesID != 0 && event.Occurrence != nil {
+			iw.eventTimeLine("RECURRENCE-ID", *event.Occurrence, event)
+		}
+
+		for _, minutes := range event.Reminders {
+			iw.line("BEGIN", "VALARM")
+			iw.line("ACTION", "DISPLAY")
+			iw.line("DESCRIPTION", escapeICalText(event.Title))
+			iw.line("TRIGGER", fmt.Sprintf("-PT%dM", minutes))
+			iw.line("END", "VALARM")
+		}
+
+		iw.line("END", "VEVENT")
+	}
+
+	iw.line("END", "VCALENDAR")
+
+	if iw.err != nil {
+		return iw.err
+	}
+	return iw.w.Flush()
+}
+
+// === Импорт ===
+
+// icalProperty одна разобранная строка содержимого
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+	line   int
+}
+
+// ICalEvent событие, прочитанное из iCalendar, вместе с данными о его принадлежности к серии
+type ICalEvent struct {
+	Event        Event
+	RecurrenceID *time.Time // для изменённого вхождения — исходная дата вхождения серии с тем же UID
+
+	duration *time.Duration // DURATION: окончание вычисляется, когда известно начало
+}
+
+// readICalLines читает строки содержимого, склеивая перенесённые строки
+func readICalLines(r io.Reader) ([]string, []int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	var numbers []int
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		if (text[0] == ' ' || text[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += text[1:]
+			continue
+		}
+		lines = append(lines, text)
+		numbers = append(numbers, number)
+	}
+
+	return lines, numbers, scanner.Err()
+}
+
+// parseICalProperty разбирает строку вида NAME;PARAM=VALUE:ЗНАЧЕНИЕ
+func parseICalProperty(text string, line int) (icalProperty, error) {
+	// Двоеточие внутри кавычек в параметрах не является разделителем
+	inQuotes := false
+	colon := -1
+	for i, c := range text {
+		if c == '"' {
+			inQuotes = !inQuotes
+		}
+		if c == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icalProperty{}, fmt.Errorf("строка %d: отсутствует разделитель ':'", line)
+	}
+
+	head := strings.Split(text[:colon], ";")
+	prop := icalProperty{
+		name:   strings.ToUpper(head[0]),
+		params: make(map[string]string),
+		value:  text[colon+1:],
+		line:   line,
+	}
+	for _, param := range head[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+
+	return prop, nil
+}
+
+// parseICalTime разбирает значение даты или даты-времени с учётом параметров TZID и VALUE=DATE
+func parseICalTime(prop icalProperty, value string) (time.Time, error) {
+	if prop.params["VALUE"] == "DATE" || len(value) == len(icalDate) {
+		t, err := time.Parse(icalDate, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("строка %d: некорректная дата %q в %s", prop.line, value, prop.name)
+		}
+		return t, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalDateTimeUTC, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("строка %d: некорректное время %q в %s", prop.line, value, prop.name)
+		}
+		return t, nil
+	}
+
+	location := time.UTC
+	if tzid := prop.params["TZID"]; tzid != "" {
+		loc, err := LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("строка %d: неизвестный часовой пояс %q", prop.line, tzid)
+		}
+		location = loc
+	}
+
+	t, err := time.ParseInLocation(icalDateTimeZone, value, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("строка %d: некорректное время %q в %s", prop.line, value, prop.name)
+	}
+	return t, nil
+}
+
+// ParseRRule разбирает значение свойства RRULE
+func ParseRRule(value string) (*Recurrence, error) {
+	rule := &Recurrence{}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("некорректная часть RRULE %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			interval, err := strconv.Atoi(val)
+			if err != nil || interval < 1 {
+				return nil, fmt.Errorf("некорректный INTERVAL %q", val)
+			}
+			rule.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(val)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("некорректный COUNT %q", val)
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := parseICalTime(icalProperty{name: "UNTIL", params: map[string]string{}}, val)
+			if err != nil {
+				return nil, fmt.Errorf("некорректный UNTIL %q", val)
+			}
+			rule.Until = &until
+		case "BYDAY":
+			rule.ByDay = strings.Split(strings.ToUpper(val), ",")
+		case "WKST":
+			// Неделя всегда начинается с понедельника; другое начало недели не поддерживается
+			if strings.ToUpper(val) != "MO" {
+				return nil, fmt.Errorf("неподдерживаемое значение WKST %q", val)
+			}
+		default:
+			return nil, fmt.Errorf("неподдерживаемая часть RRULE %q", key)
+		}
+	}
+
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// ParseICalendar разбирает документ VCALENDAR в список событий.
+// Неподдерживаемые компоненты (VTODO, VJOURNAL и т.п.) приводят к ошибке, чтобы данные не терялись молча.
+func ParseICalendar(r io.Reader) ([]ICalEvent, error) {
+	lines, numbers, err := readICalLines(r)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения iCalendar: %w", err)
+	}
+
+	var result []ICalEvent
+	var stack []string
+	var current *ICalEvent
+	seenCalendar := false
+
+	for i, text := range lines {
+		prop, err := parseICalProperty(text, numbers[i])
+		if err != nil {
+			return nil, err
+		}
+
+		switch prop.name {
+		case "BEGIN":
+			component := strings.ToUpper(prop.value)
+			switch {
+			case len(stack) == 0 && component != "VCALENDAR":
+				return nil, fmt.Errorf("строка %d: документ должен начинаться с BEGIN:VCALENDAR", prop.line)
+			case unsupportedICalComponents[component]:
+				return nil, fmt.Errorf("строка %d: неподдерживаемый компонент %s", prop.line, component)
+			case component == "VEVENT":
+				if current != nil {
+					return nil, fmt.Errorf("строка %d: вложенный VEVENT", prop.line)
+				}
+				current = &ICalEvent{}
+			case component == "VALARM":
+				// Напоминания событий переносятся, напоминания других компонентов пропускаются
+			case component == "VCALENDAR":
+				if len(stack) != 0 {
+					return nil, fmt.Errorf("строка %d: вложенный VCALENDAR", prop.line)
+				}
+				seenCalendar = true
+			case !skippedICalComponents[component] && !strings.HasPrefix(component, "X-"):
+				return nil, fmt.Errorf("строка %d: неподдерживаемый компонент %s", prop.line, component)
+			}
+			stack = append(stack, component)
+			continue
+
+		case "END":
+			component := strings.ToUpper(prop.value)
+			if len(stack) == 0 || stack[len(stack)-1] != component {
+				return nil, fmt.Errorf("строка %d: END:%s не соответствует открытому компоненту", prop.line, component)
+			}
+			stack = stack[:len(stack)-1]
+			if component == "VEVENT" {
+				if err := finishICalEvent(current, prop.line); err != nil {
+					return nil, err
+				}
+				result = append(result, *current)
+				current = nil
+			}
+			continue
+		}
+
+		if current != nil && stack[len(stack)-1] == "VALARM" {
+			if err := applyICalAlarmProperty(current, prop); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Свойства вне VEVENT (заголовок календаря, часовые пояса) не переносятся в события
+		if current == nil || stack[len(stack)-1] != "VEVENT" {
+			continue
+		}
+
+		if err := applyICalProperty(current, prop); err != nil {
+			return nil, err
+		}
+	}
+
+	if !seenCalendar {
+		return nil, fmt.Errorf("документ не содержит VCALENDAR")
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("компонент %s не закрыт", stack[len(stack)-1])
+	}
+
+	return result, nil
+}
+
+// applyICalProperty переносит свойство VEVENT в событие
+func applyICalProperty(current *ICalEvent, prop icalProperty) error {
+	event := &current.Event
+
+	switch prop.name {
+	case "UID":
+		event.UID = unescapeICalText(prop.value)
+	case "SUMMARY":
+		event.Title = unescapeICalText(prop.value)
+	case "LOCATION":
+		event.Place = unescapeICalText(prop.value)
+	case "DESCRIPTION":
+		event.Description = unescapeICalText(prop.value)
+	case "DTSTART":
+		date, err := parseICalTime(prop, prop.value)
+		if err != nil {
+			return err
+		}
+		event.Date = date
+		event.TimeZone = prop.params["TZID"]
+		event.AllDay = prop.params["VALUE"] == "DATE" || len(prop.value) == len(icalDate)
+	case "DTEND":
+		end, err := parseICalTime(prop, prop.value)
+		if err != nil {
+			return err
+		}
+		event.End = &end
+	case "DURATION":
+		duration, err := parseICalDuration(prop.value)
+		if err != nil || duration < 0 {
+			return fmt.Errorf("строка %d: некорректная продолжительность %q", prop.line, prop.value)
+		}
+		current.duration = &duration
+	case "CREATED":
+		created, err := parseICalTime(prop, prop.value)
+		if err != nil {
+			return err
+		}
+		event.CreatedAt = created
+	case "LAST-MODIFIED":
+		modified, err := parseICalTime(prop, prop.value)
+		if err != nil {
+			return err
+		}
+		event.UpdatedAt = modified
+	case "RRULE":
+		rule, err := ParseRRule(prop.value)
+		if err != nil {
+			return fmt.Errorf("строка %d: %v", prop.line, err)
+		}
+		if event.Recurrence != nil {
+			rule.Exceptions = event.Recurrence.Exceptions
+		}
+		event.Recurrence = rule
+	case "EXDATE":
+		if event.Recurrence == nil {
+			event.Recurrence = &Recurrence{}
+		}
+		for _, value := range strings.Split(prop.value, ",") {
+			exception, err := parseICalTime(prop, value)
+			if err != nil {
+				return err
+			}
+			event.Recurrence.Exceptions = append(event.Recurrence.Exceptions, exception)
+		}
+	case "RECURRENCE-ID":
+		occurrence, err := parseICalTime(prop, prop.value)
+		if err != nil {
+			return err
+		}
+		current.RecurrenceID = &occurrence
+	case "RDATE":
+		return fmt.Errorf("строка %d: свойство RDATE не поддерживается", prop.line)
+	}
+
+	return nil
+}
+
+// applyICalAlarmProperty переносит в событие напоминание VALARM. Учитывается только TRIGGER: напоминания,
+// заданные абсолютным временем, относительно конца события, после его начала или раньше чем за maxReminderMinutes,
+// не представимы в Event.Reminders и пропускаются
+func applyICalAlarmProperty(current *ICalEvent, prop icalProperty) error {
+	if prop.name != "TRIGGER" {
+		return nil
+	}
+
+	if strings.EqualFold(prop.params["VALUE"], "DATE-TIME") || strings.EqualFold(prop.params["RELATED"], "END") {
+		return nil
+	}
+
+	offset, err := parseICalDuration(prop.value)
+	if err != nil {
+		return fmt.Errorf("строка %d: %v", prop.line, err)
+	}
+
+	if offset > 0 || -offset > maxReminderLead {
+		return nil
+	}
+
+	current.Event.Reminders = append(current.Event.Reminders, int(-offset/time.Minute))
+	return nil
+}
+
+// parseICalDuration разбирает длительность iCalendar (RFC 5545, 3.3.6), например -PT15M или P1DT2H
+func parseICalDuration(value string) (time.Duration, error) {
+	text := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(text, "-") {
+		sign = -1
+		text = text[1:]
+	} else {
+		text = strings.TrimPrefix(text, "+")
+	}
+
+	if !strings.HasPrefix(text, "P") || len(text) == 1 {
+		return 0, fmt.Errorf("некорректная длительность %q", value)
+	}
+	text = text[1:]
+
+	var total time.Duration
+	inTime := false
+	for text != "" {
+		if text[0] == 'T' && !inTime {
+			inTime = true
+			text = text[1:]
+			continue
+		}
+
+		digits := 0
+		for digits < len(text) && text[digits] >= '0' && text[digits] <= '9' {
+			digits++
+		}
+		if digits == 0 || digits == len(text) {
+			return 0, fmt.Errorf("некорректная длительность %q", value)
+		}
+
+		// Ограничение с запасом покрывает любое представимое напоминание и исключает переполнение
+		n, err := strconv.Atoi(text[:digits])
+		if err != nil || n > 1e7 {
+			return 0, fmt.Errorf("слишком большая длительность %q", value)
+		}
+
+		var unit time.Duration
+		switch {
+		case !inTime && text[digits] == 'W':
+			unit = 7 * 24 * time.Hour
+		case !inTime && text[digits] == 'D':
+			unit = 24 * time.Hour
+		case inTime && text[digits] == 'H':
+			unit = time.Hour
+		case inTime && text[digits] == 'M':
+			unit = time.Minute
+		case inTime && text[digits] == 'S':
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("некорректная длительность %q", value)
+		}
+
+		total += time.Duration(n) * unit
+		text = text[digits+1:]
+	}
+
+	return sign * total, nil
+}
+
+// finishICalEvent проверяет, что прочитанное событие содержит всё необходимое
+func finishICalEvent(current *ICalEvent, line int) error {
+	if current.Event.UID == "" {
+		return fmt.Errorf("строка %d: у VEVENT отсутствует UID", line)
+	}
+	if current.Event.Date.IsZero() {
+		return fmt.Errorf("строка %d: у VEVENT %s отсутствует DTSTART", line, current.Event.UID)
+	}
+	if current.duration != nil {
+		if current.Event.End != nil {
+			return fmt.Errorf("строка %d: у VEVENT %s указаны и DTEND, и DURATION", line, current.Event.UID)
+		}
+		end := current.Event.Date.Add(*current.duration)
+		current.Event.End = &end
+	}
+	if current.Event.Recurrence != nil && current.Event.Recurrence.Freq == "" {
+		return fmt.Errorf("строка %d: у VEVENT %s есть EXDATE, но нет RRULE", line, current.Event.UID)
+	}
+	return nil
+}
+
+// ImportICalEvents создаёт в хранилище события пользователя userID, прочитанные из iCalendar.
+// Серии создаются через AddEvent, изменённые вхождения — через UpdateEvent с указанием Occurrence.
+// Параметры opts (например, Actor) применяются к каждой записи. Возвращает идентификаторы созданных событий.
+// Документ импортируется в одной единице работы хранилища: если одна из записей не выполнилась, не создаётся ничего
+func ImportICalEvents(store *EventStore, userID int, items []ICalEvent, opts ...WriteOption) ([]int, error) {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	masters := make(map[string]bool)
+	for _, item := range items {
+		if item.RecurrenceID == nil {
+			if item.Event.Title == "" {
+				return nil, validationErrorf("у события %s отсутствует SUMMARY", item.Event.UID)
+			}
+			masters[item.Event.UID] = true
+		}
+	}
+	for _, item := range items {
+		if item.RecurrenceID != nil && !masters[item.Event.UID] {
+			return nil, validationErrorf("изменённое вхождение %s не имеет серии в импортируемом документе", item.Event.UID)
+		}
+	}
+
+	// Вхождения, выделенные из серии, исключены в ней через EXDATE; исключение заново добавит UpdateEvent
+	detached := make(map[string][]time.Time)
+	for _, item := range items {
+		if item.RecurrenceID != nil {
+			detached[item.Event.UID] = append(detached[item.Event.UID], *item.RecurrenceID)
+		}
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	var ids []int
+	err = store.transaction(func() error {
+		seriesID := make(map[string]int)
+		for _, item := range items {
+			if item.RecurrenceID != nil {
+				continue
+			}
+
+			event := item.Event
+			event.UserID = userID
+			if event.Recurrence != nil && len(detached[event.UID]) > 0 {
+				event.Recurrence = cloneRecurrence(event.Recurrence)
+				exceptions := event.Recurrence.Exceptions[:0]
+				for _, exception := range event.Recurrence.Exceptions {
+					if !containsDay(detached[event.UID], exception) {
+						exceptions = append(exceptions, exception)
+					}
+				}
+				event.Recurrence.Exceptions = exceptions
+			}
+
+			created, err := store.addEvent(event, options)
+			if err != nil {
+				return fmt.Errorf("событие %s не импортировано: %w", event.UID, err)
+			}
+			ids = append(ids, created.ID)
+			seriesID[event.UID] = created.ID
+		}
+
+		for _, item := range items {
+			if item.RecurrenceID == nil {
+				continue
+			}
+
+			changes := item.Event
+			changes.ID = seriesID[changes.UID]
+			changes.UserID = userID
+			changes.Occurrence = item.RecurrenceID
+			if _, err := store.updateEvent(userID, changes, options); err != nil {
+				return fmt.Errorf("вхождение %s серии %s не импортировано: %w", item.RecurrenceID.Format(icalDateTimeUTC), changes.UID, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// containsDay сообщает, есть ли среди dates момент, приходящийся на ту же дату, что и date
+func containsDay(dates []time.Time, date time.Time) bool {
+	for _, d := range dates {
+		if sameDay(d.In(date.Location()), date) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestImportICalEventsRollback(t *testing.T) {
+	// Вторая серия создаётся, а изменённого вхождения 20 марта в ней нет: импорт должен отмениться целиком
+	document := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:retro\r\nDTSTART:20240301T100000Z\r\nSUMMARY:Ретро\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nDTSTART:20240311T090000Z\r\nRRULE:FREQ=DAILY;COUNT=3\r\nSUMMARY:Стендап\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nRECURRENCE-ID:20240320T090000Z\r\nDTSTART:20240320T100000Z\r\nSUMMARY:Стендап (перенос)\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	items, err := ParseICalendar(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := InitNewEventStore()
+	ids, err := ImportICalEvents(store, 1, items)
+	if err == nil {
+		t.Fatal("ImportICalEvents() с лишним вхождением должен завершиться ошибкой")
+	}
+	if ids != nil {
+		t.Errorf("ImportICalEvents() вернул ID %v вместе с ошибкой", ids)
+	}
+
+	if events, err := store.GetUserEvents(1); err != nil || len(events) != 0 {
+		t.Errorf("события после отменённого импорта: %+v, %v", events, err)
+	}
+	if id, err := store.AddEvent(Event{UserID: 1, Title: "После импорта", Date: items[0].Event.Date}); err != nil || id != 1 {
+		t.Errorf("ID первого события после отменённого импорта %d, %v; ожидался 1", id, err)
+	}
+}
+
+func TestICalendarRoundTrip(t *testing.T) {
+	end := at(2024, time.March, 4, 9, 30)
+	series := Event{
+		ID: 1, UserID: 1, Title: "Стендап; ежедневный, короткий", Place: "Переговорка \\ 3",
+		Description: strings.Repeat("Очень длинное описание встречи. ", 5),
+		Date:        at(2024, time.March, 4, 9, 0), End: &end, TimeZone: "Europe/Berlin", Reminders: []int{15},
+		Recurrence: &Recurrence{Freq: FreqWeekly, Interval: 2, ByDay: []string{"MO", "TH"}, Count: 6,
+			Exceptions: []time.Time{at(2024, time.March, 7, 9, 0)}},
+	}
+	occurrence := at(2024, time.March, 18, 9, 0)
+	detached := Event{ID: 2, UserID: 1, SeriesID: 1, Occurrence: &occurrence, Title: "Стендап (перенос)", Date: at(2024, time.March, 18, 10, 0)}
+
+	var buf strings.Builder
+	if err := WriteICalendar(&buf, []Event{series, detached}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > icalMaxLineLen {
+			t.Errorf("строка длиннее %d октетов: %q", icalMaxLineLen, line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("перенос разорвал символ UTF-8: %q", line)
+		}
+	}
+
+	items, err := ParseICalendar(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("прочитано %d событий, ожидалось 2", len(items))
+	}
+
+	got := items[0].Event
+	if got.UID != "1@dev11" || got.Title != series.Title || got.Place != series.Place || got.Description != series.Description {
+		t.Errorf("текстовые поля серии %+v", got)
+	}
+	if !got.Date.Equal(series.Date) || got.End == nil || !got.End.Equal(end) || got.TimeZone != "Europe/Berlin" {
+		t.Errorf("время серии %v–%v в %q", got.Date, got.End, got.TimeZone)
+	}
+	if r := got.Recurrence; r == nil || FormatRRule(r) != "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=6" ||
+		len(r.Exceptions) != 1 || !r.Exceptions[0].Equal(series.Recurrence.Exceptions[0]) {
+		t.Errorf("правило повторения серии %+v", got.Recurrence)
+	}
+	if len(got.Reminders) != 1 || got.Reminders[0] != 15 {
+		t.Errorf("напоминания серии %v", got.Reminders)
+	}
+
+	if item := items[1]; item.Event.UID != "1@dev11" || item.RecurrenceID == nil || !item.RecurrenceID.Equal(occurrence) {
+		t.Errorf("выделенное вхождение %+v", item)
+	}
+}
+
+func TestParseICalendarAllDayAndDuration(t *testing.T) {
+	document := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\nUID:holiday\r\nDTSTART;VALUE=DATE:20240308\r\nSUMMARY:Праздник\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:call\r\nDTSTART;TZID=Europe/Berlin:20240305T100000\r\nDURATION:PT1H30M\r\n" +
+		"SUMMARY:Созвон с очень\r\n  длинным названием\r\n" +
+		"BEGIN:VALARM\r\nTRIGGER:-P1D\r\nEND:VALARM\r\nBEGIN:VALARM\r\nTRIGGER;RELATED=END:-PT5M\r\nEND:VALARM\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	items, err := ParseICalendar(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("прочитано %d событий, ожидалось 2", len(items))
+	}
+
+	if holiday := items[0].Event; !holiday.AllDay || !holiday.Date.Equal(at(2024, time.March, 8, 0, 0)) {
+		t.Errorf("событие на весь день %+v", holiday)
+	}
+
+	call := items[1].Event
+	if call.Title != "Созвон с очень длинным названием" {
+		t.Errorf("перенесённая строка прочитана как %q", call.Title)
+	}
+	if !call.Date.Equal(at(2024, time.March, 5, 9, 0)) || call.End == nil || call.End.Sub(call.Date) != 90*time.Minute {
+		t.Errorf("время события %v–%v", call.Date, call.End)
+	}
+	if len(call.Reminders) != 1 || call.Reminders[0] != 24*60 {
+		t.Errorf("напоминания %v, ожидалось [1440]", call.Reminders)
+	}
+}
+
+func TestParseICalendarErrors(t *testing.T) {
+	event := func(props string) string {
+		return "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n" + props + "END:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"пустой документ", ""},
+		{"не VCALENDAR", "BEGIN:VEVENT\r\nEND:VEVENT\r\n"},
+		{"незакрытый компонент", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\n"},
+		{"неподдерживаемый компонент", "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"},
+		{"строка без двоеточия", event("UID:a\r\nDTSTART:20240305T100000Z\r\nSUMMARY\r\n")},
+		{"без UID", event("DTSTART:20240305T100000Z\r\n")},
+		{"без DTSTART", event("UID:a\r\n")},
+		{"неизвестный часовой пояс", event("UID:a\r\nDTSTART;TZID=Mars/Olympus:20240305T100000\r\n")},
+		{"DTEND и DURATION", event("UID:a\r\nDTSTART:20240305T100000Z\r\nDTEND:20240305T110000Z\r\nDURATION:PT1H\r\n")},
+		{"EXDATE без RRULE", event("UID:a\r\nDTSTART:20240305T100000Z\r\nEXDATE:20240306T100000Z\r\n")},
+		{"неподдерживаемый RDATE", event("UID:a\r\nDTSTART:20240305T100000Z\r\nRDATE:20240306T100000Z\r\n")},
+		{"неподдерживаемая часть RRULE", event("UID:a\r\nDTSTART:20240305T100000Z\r\nRRULE:FREQ=DAILY;BYHOUR=9\r\n")},
+		{"COUNT и UNTIL", event("UID:a\r\nDTSTART:20240305T100000Z\r\nRRULE:FREQ=DAILY;COUNT=2;UNTIL=20240310T000000Z\r\n")},
+		{"некорректная длительность", event("UID:a\r\nDTSTART:20240305T100000Z\r\nDURATION:1H\r\n")},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseICalendar(strings.NewReader(tt.document)); err == nil {
+			t.Errorf("%s: ParseICalendar() должен завершиться ошибкой", tt.name)
+		}
+	}
+}
+
+func TestImportICalEventsDetachedOccurrence(t *testing.T) {
+	document := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nDTSTART:20240311T090000Z\r\nRRULE:FREQ=DAILY;COUNT=3\r\n" +
+		"EXDATE:20240312T090000Z\r\nSUMMARY:Стендап\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:standup\r\nRECURRENCE-ID:20240312T090000Z\r\nDTSTART:20240312T100000Z\r\nSUMMARY:Стендап (перенос)\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	items, err := ParseICalendar(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := InitNewEventStore()
+	ids, err := ImportICalEvents(store, 1, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("создано событий %v, ожидалась одна серия", ids)
+	}
+
+	events, err := store.GetEventsByDate(1, at(2024, time.March, 12, 12, 0))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("события 12 марта: %+v, %v", events, err)
+	}
+	if moved := events[0]; moved.SeriesID != ids[0] || moved.Title != "Стендап (перенос)" || !moved.Date.Equal(at(2024, time.March, 12, 10, 0)) {
+		t.Errorf("перенесённое вхождение %+v", moved)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+	  = == ==                              == == =
+	= ==== ПОРЯДОК, ПОСТРАНИЧНАЯ ВЫДАЧА И ПОИСК ==== =
+	  = == ==                              == == =
+*/
+
+// maxPageSize наибольшее значение параметра limit
+const maxPageSize = 1000
+
+// sortEvents упорядочивает события по началу, а при совпадении — по ID
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Date.Equal(events[j].Date) {
+			return events[i].Date.Before(events[j].Date)
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
+// Cursor позиция в упорядоченном sortEvents списке событий: следующая страница начинается
+// с первого события после события с датой Date и идентификатором ID. В отличие от смещения
+// позиция не сдвигается, если между запросами страниц добавлены или удалены события
+type Cursor struct {
+	Date time.Time
+	ID   int
+}
+
+// String кодирует позицию для передачи клиенту. Клиент не должен разбирать её сам
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Date.UnixNano(), c.ID)))
+}
+
+// ParseCursor разбирает позицию, полученную от Cursor.String
+func ParseCursor(value string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("некорректный cursor")
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, fmt.Errorf("некорректный cursor")
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("некорректный cursor")
+	}
+
+	eventID, err := strconv.Atoi(id)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("некорректный cursor")
+	}
+
+	return Cursor{Date: time.Unix(0, unixNano), ID: eventID}, nil
+}
+
+// before сообщает, стоит ли событие в порядке sortEvents не позже позиции курсора
+func (c Cursor) before(event Event) bool {
+	if !event.Date.Equal(c.Date) {
+		return event.Date.Before(c.Date)
+	}
+	return event.ID <= c.ID
+}
+
+// ListOptions параметры выдачи списка событий
+type ListOptions struct {
+	Query string  // подстрока названия без учёта регистра; пусто — все события
+	Limit int     // размер страницы; 0 — без ограничения
+	After *Cursor // позиция, после которой начинается страница; nil — с начала
+}
+
+// SelectEvents отбирает из упорядоченного sortEvents списка events события, подходящие под options,
+// и возвращает одну страницу. next — позиция следующей страницы, nil для последней
+func SelectEvents(events []Event, options ListOptions) (page []Event, next *Cursor) {
+	query := foldCase(options.Query)
+
+	page = []Event{}
+	for _, event := range events {
+		if options.After != nil && options.After.before(event) {
+			continue
+		}
+
+		if query != "" && !strings.Contains(foldCase(event.Title), query) {
+			continue
+		}
+
+		if options.Limit > 0 && len(page) == options.Limit {
+			last := page[len(page)-1]
+			return page, &Cursor{Date: last.Date, ID: last.ID}
+		}
+
+		page = append(page, event)
+	}
+
+	return page, nil
+}
+
+// foldCase приводит строку к виду, в котором совпадают все варианты написания букв в разных регистрах
+// (как в strings.EqualFold): каждая буква заменяется наименьшей из своих регистровых пар Unicode.
+// В отличие от strings.ToLower так сравниваются и буквы вроде «ſ» и «s» или «K» (знак кельвина) и «k»
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < smallest {
+				smallest = f
+			}
+		}
+		return smallest
+	}, s)
+}
+
+// === HTTP ===
+
+// ParseListOptions разбирает параметры выдачи списка из queryString: q — поиск по названию,
+// limit — размер страницы (до maxPageSize), cursor — позиция из next_cursor предыдущей страницы
+func (s *Server) ParseListOptions(r *http.Request) (ListOptions, error) {
+	query := r.URL.Query()
+	options := ListOptions{Query: strings.TrimSpace(query.Get("q"))}
+
+	if limit := query.Get("limit"); limit != "" {
+		if err := limitSchema.validateString(limit); err != nil {
+			return ListOptions{}, badRequestf("%v", err)
+		}
+		options.Limit, _ = strconv.Atoi(limit)
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := ParseCursor(cursor)
+		if err != nil {
+			return ListOptions{}, badRequestf("%v", err)
+		}
+		options.After = &after
+	}
+
+	return options, nil
+}
+
+// RespondWithEvents отправляет клиенту страницу списка событий: {"result": [...], "next_cursor": "..."}.
+// next_cursor присутствует, только если есть следующая страница
+func (s *Server) RespondWithEvents(w http.ResponseWriter, events []Event, options ListOptions) {
+	page, next := SelectEvents(events, options)
+
+	payload := map[string]interface{}{"result": page}
+	if next != nil {
+		payload["next_cursor"] = next.String()
+	}
+
+	s.RespondWithJSON(w, http.StatusOK, payload)
+}
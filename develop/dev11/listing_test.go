@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// eventIDs возвращает идентификаторы событий по порядку
+func eventIDs(events []Event) []int {
+	ids := make([]int, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestSortEvents(t *testing.T) {
+	events := []Event{
+		{ID: 4, Date: at(2024, time.March, 4, 12, 0)},
+		{ID: 3, Date: at(2024, time.March, 4, 10, 0)},
+		{ID: 1, Date: at(2024, time.March, 4, 12, 0)},
+		{ID: 2, Date: at(2024, time.March, 3, 9, 0)},
+	}
+
+	sortEvents(events)
+	if got := fmt.Sprint(eventIDs(events)); got != "[2 3 1 4]" {
+		t.Errorf("порядок событий %s, ожидался [2 3 1 4]", got)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	cursor := Cursor{Date: at(2024, time.March, 4, 10, 0).Add(123 * time.Nanosecond), ID: 42}
+	parsed, err := ParseCursor(cursor.String())
+	if err != nil || !parsed.Date.Equal(cursor.Date) || parsed.ID != cursor.ID {
+		t.Errorf("ParseCursor(%q) = %+v, %v; ожидалось %+v", cursor.String(), parsed, err, cursor)
+	}
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, value := range []string{"не base64", encode("1709546400000000000"), encode("вчера:1"), encode("1709546400000000000:x")} {
+		if _, err := ParseCursor(value); err == nil {
+			t.Errorf("ParseCursor(%q) должен завершиться ошибкой", value)
+		}
+	}
+}
+
+func TestSelectEvents(t *testing.T) {
+	events := []Event{
+		{ID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0)},
+		{ID: 2, Title: "Обед", Date: at(2024, time.March, 4, 13, 0)},
+		{ID: 3, Title: "ПЛАНЁРКА отдела", Date: at(2024, time.March, 4, 13, 0)},
+		{ID: 4, Title: "Ретро", Date: at(2024, time.March, 5, 10, 0)},
+	}
+
+	tests := []struct {
+		name     string
+		options  ListOptions
+		wantIDs  string
+		wantNext *Cursor
+	}{
+		{name: "все события", wantIDs: "[1 2 3 4]"},
+		{name: "поиск без учёта регистра", options: ListOptions{Query: "планёрка"}, wantIDs: "[1 3]"},
+		{name: "первая страница", options: ListOptions{Limit: 2}, wantIDs: "[1 2]", wantNext: &Cursor{Date: events[1].Date, ID: 2}},
+		{name: "последняя страница ровно по размеру", options: ListOptions{Limit: 2, After: &Cursor{Date: events[1].Date, ID: 2}}, wantIDs: "[3 4]"},
+		{name: "курсор между событиями с одной датой", options: ListOptions{After: &Cursor{Date: events[2].Date, ID: 2}}, wantIDs: "[3 4]"},
+		{name: "поиск на странице", options: ListOptions{Query: "планёрка", Limit: 1}, wantIDs: "[1]", wantNext: &Cursor{Date: events[0].Date, ID: 1}},
+		{name: "ничего не найдено", options: ListOptions{Query: "отпуск"}, wantIDs: "[]"},
+	}
+
+	for _, tt := range tests {
+		page, next := SelectEvents(events, tt.options)
+		if got := fmt.Sprint(eventIDs(page)); got != tt.wantIDs {
+			t.Errorf("%s: страница %s, ожидалась %s", tt.name, got, tt.wantIDs)
+		}
+		if (next == nil) != (tt.wantNext == nil) || (next != nil && (next.ID != tt.wantNext.ID || !next.Date.Equal(tt.wantNext.Date))) {
+			t.Errorf("%s: следующая страница %+v, ожидалась %+v", tt.name, next, tt.wantNext)
+		}
+	}
+
+	// Удаление уже выданного события не сдвигает следующую страницу
+	_, next := SelectEvents(events, ListOptions{Limit: 2})
+	if page, _ := SelectEvents(events[1:], ListOptions{Limit: 2, After: next}); fmt.Sprint(eventIDs(page)) != "[3 4]" {
+		t.Errorf("страница после удаления события %v, ожидалась [3 4]", eventIDs(page))
+	}
+}
+
+func TestParseListOptions(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	cursor := Cursor{Date: at(2024, time.March, 4, 10, 0), ID: 7}
+	options, err := server.ParseListOptions(httptest.NewRequest("GET", "/api/v1/events?q=+Планёрка+&limit=20&cursor="+cursor.String(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Query != "Планёрка" || options.Limit != 20 || options.After == nil || options.After.ID != 7 || !options.After.Date.Equal(cursor.Date) {
+		t.Errorf("параметры выдачи %+v", options)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "limit=десять", "cursor=abc"} {
+		_, err := server.ParseListOptions(httptest.NewRequest("GET", "/api/v1/events?"+query, nil))
+		if status, code := ErrorStatus(err); status != http.StatusBadRequest || code != "bad_request" {
+			t.Errorf("%s: %v, ожидалась ошибка входных данных", query, err)
+		}
+	}
+}
+
+func TestRespondWithEvents(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	events := []Event{
+		{ID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0)},
+		{ID: 2, Title: "Ретро", Date: at(2024, time.March, 4, 16, 0)},
+	}
+
+	tests := []struct {
+		options  ListOptions
+		wantNext bool
+	}{
+		{options: ListOptions{Limit: 1}, wantNext: true},
+		{options: ListOptions{Limit: 2}},
+		{options: ListOptions{}},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		server.RespondWithEvents(w, events, tt.options)
+
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &payload); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := payload["result"]; !ok {
+			t.Errorf("limit=%d: в ответе нет result: %s", tt.options.Limit, w.Body)
+		}
+		if _, ok := payload["next_cursor"]; ok != tt.wantNext {
+			t.Errorf("limit=%d: next_cursor присутствует: %v, ожидалось %v", tt.options.Limit, ok, tt.wantNext)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==     == == =
+	= ==== МЕТРИКИ ==== =
+	  = == ==     == == =
+*/
+
+// metricsPath адрес, по которому отдаются метрики
+const metricsPath = "/metrics"
+
+// latencyBuckets верхние границы корзин гистограммы времени обработки запроса, в секундах
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// knownMethods HTTP-методы, попадающие в метки метрик как есть. Остальные учитываются как OTHER,
+// чтобы произвольные методы из запросов не раздували число временных рядов
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	"PROPFIND": true, "REPORT": true,
+}
+
+// requestSeries набор меток одного временного ряда
+type requestSeries struct {
+	route  string
+	method string
+	status int
+}
+
+// requestStats счётчик и гистограмма времени обработки запросов одного временного ряда
+type requestStats struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // buckets[i] — количество запросов не дольше latencyBuckets[i] (не накопительно)
+}
+
+// Metrics собирает метрики обработанных запросов и отдаёт их в текстовом формате Prometheus
+type Metrics struct {
+	sync.Mutex
+	requests map[requestSeries]*requestStats
+}
+
+// NewMetrics возвращает пустой набор метрик
+func NewMetrics() *Metrics {
+	return &Metrics{requests: make(map[requestSeries]*requestStats)}
+}
+
+// ObserveRequest учитывает обработанный запрос
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+
+	series := requestSeries{route: route, method: method, status: status}
+	seconds := duration.Seconds()
+
+	m.Lock()
+	defer m.Unlock()
+
+	stats, ok := m.requests[series]
+	if !ok {
+		stats = &requestStats{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[series] = stats
+	}
+
+	stats.count++
+	stats.sum += seconds
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		stats.buckets[i]++
+	}
+}
+
+// WritePrometheus записывает метрики в текстовом формате Prometheus 0.0.4
+func (m *Metrics) WritePrometheus(w *bufio.Writer) error {
+	m.Lock()
+	series := make([]requestSeries, 0, len(m.requests))
+	snapshot := make(map[requestSeries]requestStats, len(m.requests))
+	for key, stats := range m.requests {
+		series = append(series, key)
+		copied := *stats
+		copied.buckets = append([]uint64(nil), stats.buckets...)
+		snapshot[key] = copied
+	}
+	m.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+
+	fmt.Fprintln(w, "# HELP http_requests_total Количество обработанных HTTP-запросов.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, key := range series {
+		fmt.Fprintf(w, "http_requests_total{%s} %d\n", key.labels(), snapshot[key].count)
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds Время обработки HTTP-запросов.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, key := range series {
+		stats := snapshot[key]
+		labels := key.labels()
+
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += stats.buckets[i]
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, stats.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(stats.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, stats.count)
+	}
+
+	return w.Flush()
+}
+
+// labels форматирует метки временного ряда
+func (rs requestSeries) labels() string {
+	return fmt.Sprintf(`route="%s",method="%s",status="%d"`, escapeLabelValue(rs.route), rs.method, rs.status)
+}
+
+// escapeLabelValue экранирует значение метки по правилам текстового формата Prometheus
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// MetricsHandler отдаёт метрики сервера в текстовом формате Prometheus
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.RespondWithError(w, errMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if s.Metrics == nil {
+		return
+	}
+
+	if err := s.Metrics.WritePrometheus(bufio.NewWriter(w)); err != nil {
+		log.Printf("Ошибка при попытке записи ответа в соединение: %v", err)
+	}
+}
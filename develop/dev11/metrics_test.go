@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsPrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.ObserveRequest("/events_for_day", http.MethodGet, http.StatusOK, 3*time.Millisecond)
+	metrics.ObserveRequest("/events_for_day", http.MethodGet, http.StatusOK, 300*time.Millisecond)
+	metrics.ObserveRequest("/events_for_day", "BREW", http.StatusBadRequest, time.Minute)
+	metrics.ObserveRequest(`/a"b`, http.MethodPost, http.StatusOK, time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(bufio.NewWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	for _, want := range []string{
+		`http_requests_total{route="/events_for_day",method="GET",status="200"} 2`,
+		// Неизвестный метод учитывается как OTHER, а запрос дольше последней корзины — только в +Inf
+		`http_requests_total{route="/events_for_day",method="OTHER",status="400"} 1`,
+		`http_request_duration_seconds_bucket{route="/events_for_day",method="OTHER",status="400",le="10"} 0`,
+		`http_request_duration_seconds_bucket{route="/events_for_day",method="OTHER",status="400",le="+Inf"} 1`,
+		// Корзины накопительные
+		`http_request_duration_seconds_bucket{route="/events_for_day",method="GET",status="200",le="0.005"} 1`,
+		`http_request_duration_seconds_bucket{route="/events_for_day",method="GET",status="200",le="0.25"} 1`,
+		`http_request_duration_seconds_bucket{route="/events_for_day",method="GET",status="200",le="0.5"} 2`,
+		`http_request_duration_seconds_sum{route="/events_for_day",method="GET",status="200"} 0.303`,
+		`http_requests_total{route="/a\"b",method="POST",status="200"} 1`,
+	} {
+		if !strings.Contains(output, want+"\n") {
+			t.Errorf("в метриках нет строки %s\n%s", want, output)
+		}
+	}
+
+	// Временные ряды упорядочены, чтобы вывод не зависел от порядка обхода map
+	if strings.Index(output, `route="/a\"b"`) > strings.Index(output, `route="/events_for_day"`) {
+		t.Error("временные ряды не упорядочены по маршруту")
+	}
+}
+
+func TestRequestIDFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "от клиента", header: "req-42", keep: true},
+		{name: "без заголовка"},
+		{name: "с пробелом", header: "req 42"},
+		{name: "не ASCII", header: "запрос-42"},
+		{name: "слишком длинный", header: strings.Repeat("x", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/events_for_day", nil)
+		if tt.header != "" {
+			r.Header.Set(requestIDHeader, tt.header)
+		}
+
+		id := requestIDFor(r)
+		switch {
+		case tt.keep && id != tt.header:
+			t.Errorf("%s: идентификатор %q, ожидался %q", tt.name, id, tt.header)
+		case !tt.keep && (id == tt.header || len(id) != 32 || !isPrintableASCII(id)):
+			t.Errorf("%s: идентификатор %q, ожидался новый случайный", tt.name, id)
+		}
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var logged bytes.Buffer
+	accessLog.SetOutput(&logged)
+	defer accessLog.SetOutput(io.Discard)
+
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	var handlerID string
+	handler := server.LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerID = RequestID(r.Context())
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusInternalServerError) // повторный WriteHeader не меняет записанный код
+		io.WriteString(w, "создано")
+	}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/api/v1/events/5?user_id=1", nil)
+	r.Header.Set(requestIDHeader, "req-42")
+	handler.ServeHTTP(w, r)
+
+	if w.Header().Get(requestIDHeader) != "req-42" || handlerID != "req-42" {
+		t.Errorf("идентификатор в ответе %q, у обработчика %q", w.Header().Get(requestIDHeader), handlerID)
+	}
+
+	var entry accessLogEntry
+	if err := json.Unmarshal(logged.Bytes(), &entry); err != nil {
+		t.Fatalf("запись журнала доступа не является JSON: %v\n%s", err, logged.String())
+	}
+	if entry.RequestID != "req-42" || entry.Method != "POST" || entry.URI != "/api/v1/events/5?user_id=1" ||
+		entry.Route != apiEventsPath+"/" || entry.Status != http.StatusCreated || entry.Bytes != int64(len("создано")) {
+		t.Errorf("запись журнала доступа %+v", entry)
+	}
+
+	// Метрики учитывают шаблон маршрута, а неизвестные адреса — под общим именем
+	logged.Reset()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/no/such/path", nil))
+	if err := json.Unmarshal(logged.Bytes(), &entry); err != nil || entry.Route != "other" {
+		t.Errorf("маршрут неизвестного адреса %q, %v", entry.Route, err)
+	}
+
+	var buf bytes.Buffer
+	if err := server.Metrics.WritePrometheus(bufio.NewWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `http_requests_total{route="/api/v1/events/",method="POST",status="201"} 1`) {
+		t.Errorf("запрос не учтён в метриках:\n%s", buf.String())
+	}
+}
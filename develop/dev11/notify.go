@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==                  == == =
+	= ==== ДОСТАВКА УВЕДОМЛЕНИЙ ==== =
+	  = == ==                  == == =
+*/
+
+// Notifier способ доставки напоминаний
+type Notifier interface {
+	SendNotification(ctx context.Context, reminder Reminder) error
+}
+
+// NotifierConfig настройки способа доставки из конфигурационного файла
+type NotifierConfig struct {
+	Type string `json:"type"`           // log, webhook или file
+	URL  string `json:"url,omitempty"`  // адрес webhook
+	Path string `json:"path,omitempty"` // файл, в который дописываются напоминания
+}
+
+// NotifierFactory фабричный метод: создаёт способ доставки по его настройкам
+type NotifierFactory interface {
+	CreateNotifier(config NotifierConfig) (Notifier, error)
+}
+
+// notifierFactories фабрики способов доставки по значению NotifierConfig.Type.
+// Новый способ доставки добавляется реализацией Notifier и регистрацией его фабрики здесь
+var notifierFactories = map[string]NotifierFactory{
+	"log":     LogNotifierFactory{},
+	"webhook": WebhookNotifierFactory{},
+	"file":    FileNotifierFactory{},
+}
+
+// NewNotifier создаёт способ доставки фабрикой, соответствующей типу из настроек
+func NewNotifier(config NotifierConfig) (Notifier, error) {
+	factory, ok := notifierFactories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный способ доставки уведомлений %q", config.Type)
+	}
+
+	return factory.CreateNotifier(config)
+}
+
+// === Запись в лог ===
+
+// LogNotifier пишет напоминания в лог сервера
+type LogNotifier struct{}
+
+// SendNotification пишет напоминание в лог
+func (LogNotifier) SendNotification(_ context.Context, reminder Reminder) error {
+	log.Print(reminder.Message())
+	return nil
+}
+
+// LogNotifierFactory фабрика LogNotifier
+type LogNotifierFactory struct{}
+
+// CreateNotifier создаёт LogNotifier. Настройки ему не нужны
+func (LogNotifierFactory) CreateNotifier(NotifierConfig) (Notifier, error) {
+	return LogNotifier{}, nil
+}
+
+// === Webhook ===
+
+// webhookTimeout наибольшее время ожидания ответа webhook
+const webhookTimeout = 5 * time.Second
+
+// WebhookNotifier отправляет напоминания POST-запросом с JSON-телом Reminder
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// SendNotification отправляет напоминание на webhook. Ответ не из группы 2xx считается ошибкой доставки
+func (wn *WebhookNotifier) SendNotification(ctx context.Context, reminder Reminder) error {
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := wn.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("webhook %s: %v", wn.URL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook %s ответил %s", wn.URL, response.Status)
+	}
+
+	return nil
+}
+
+// WebhookNotifierFactory фабрика WebhookNotifier
+type WebhookNotifierFactory struct{}
+
+// CreateNotifier создаёт WebhookNotifier. Webhook должен находиться на этой же машине:
+// напоминания содержат данные пользователей и не должны уходить на внешние адреса
+func (WebhookNotifierFactory) CreateNotifier(config NotifierConfig) (Notifier, error) {
+	target, err := url.Parse(config.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("webhook: ожидается http(s) URL, получено %q", config.URL)
+	}
+
+	if !isLoopbackHost(target.Hostname()) {
+		return nil, fmt.Errorf("webhook: адрес %q не является локальным", config.URL)
+	}
+
+	return &WebhookNotifier{
+		URL:    target.String(),
+		Client: &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+// isLoopbackHost сообщает, указывает ли имя хоста на эту же машину
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// === Запись в файл ===
+
+// FileNotifier дописывает напоминания в файл по одному JSON-объекту на строку.
+// Файл открывается заново для каждой записи, поэтому его можно ротировать, не перезапуская сервер
+type FileNotifier struct {
+	sync.Mutex
+	Path string
+}
+
+// SendNotification дописывает напоминание в файл
+func (fn *FileNotifier) SendNotification(_ context.Context, reminder Reminder) error {
+	line, err := json.Marshal(reminder)
+	if err != nil {
+		return err
+	}
+
+	fn.Lock()
+	defer fn.Unlock()
+
+	file, err := os.OpenFile(fn.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// FileNotifierFactory фабрика FileNotifier
+type FileNotifierFactory struct{}
+
+// CreateNotifier создаёт FileNotifier
+func (FileNotifierFactory) CreateNotifier(config NotifierConfig) (Notifier, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("file: не указан путь к файлу напоминаний")
+	}
+
+	return &FileNotifier{Path: config.Path}, nil
+}
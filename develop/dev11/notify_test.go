@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testReminder напоминание, которое отправляют тесты способов доставки
+var testReminder = Reminder{
+	EventID: 1, UserID: 1, Title: "Планёрка", Start: at(2024, time.March, 4, 10, 0),
+	MinutesBefore: 15, FireAt: at(2024, time.March, 4, 9, 45),
+}
+
+func TestNewNotifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  NotifierConfig
+		wantErr bool
+	}{
+		{name: "лог", config: NotifierConfig{Type: "log"}},
+		{name: "файл", config: NotifierConfig{Type: "file", Path: "reminders.jsonl"}},
+		{name: "локальный webhook", config: NotifierConfig{Type: "webhook", URL: "http://localhost:9000/hook"}},
+		{name: "webhook на loopback IPv6", config: NotifierConfig{Type: "webhook", URL: "https://[::1]/hook"}},
+		{name: "неизвестный тип", config: NotifierConfig{Type: "sms"}, wantErr: true},
+		{name: "файл без пути", config: NotifierConfig{Type: "file"}, wantErr: true},
+		{name: "webhook без схемы", config: NotifierConfig{Type: "webhook", URL: "localhost:9000"}, wantErr: true},
+		{name: "внешний webhook", config: NotifierConfig{Type: "webhook", URL: "http://example.com/hook"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		notifier, err := NewNotifier(tt.config)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: NewNotifier() должен завершиться ошибкой, создан %T", tt.name, notifier)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	status := http.StatusNoContent
+	received := make(chan Reminder, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reminder Reminder
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" ||
+			json.NewDecoder(r.Body).Decode(&reminder) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Код ответа читается до отправки в канал: после получения напоминания тест меняет его
+		code := status
+		received <- reminder
+		w.WriteHeader(code)
+	}))
+	defer hook.Close()
+
+	notifier, err := NewNotifier(NotifierConfig{Type: "webhook", URL: hook.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifier.SendNotification(context.Background(), testReminder); err != nil {
+		t.Fatal(err)
+	}
+	if reminder := <-received; reminder.EventID != 1 || !reminder.Start.Equal(testReminder.Start) || reminder.MinutesBefore != 15 {
+		t.Errorf("webhook получил %+v", reminder)
+	}
+
+	status = http.StatusBadGateway
+	if err := notifier.SendNotification(context.Background(), testReminder); err == nil {
+		t.Error("ответ 502 должен считаться ошибкой доставки")
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reminders.jsonl")
+	notifier, err := NewNotifier(NotifierConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := notifier.SendNotification(context.Background(), testReminder); err != nil {
+			t.Fatal(err)
+		}
+		// Файл открывается заново при каждой записи, поэтому переименованный файл создаётся снова
+		if i == 0 {
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, name := range []string{path + ".1", path} {
+		file, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		lines := 0
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var reminder Reminder
+			if err := json.Unmarshal(scanner.Bytes(), &reminder); err != nil || reminder.Title != "Планёрка" {
+				t.Errorf("строка %q: %+v, %v", scanner.Text(), reminder, err)
+			}
+			lines++
+		}
+		if lines != 1 {
+			t.Errorf("в файле %s %d напоминаний, ожидалось 1", name, lines)
+		}
+	}
+}
+
+func TestParseReminders(t *testing.T) {
+	reminders, err := ParseReminders(" 60, 15,60,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 3 || reminders[0] != 0 || reminders[1] != 15 || reminders[2] != 60 {
+		t.Errorf("напоминания %v, ожидалось [0 15 60]", reminders)
+	}
+
+	for _, value := range []string{"", "15,", "четверть часа", "-5", "99999999"} {
+		if _, err := ParseReminders(value); err == nil {
+			t.Errorf("ParseReminders(%q) должен завершиться ошибкой", value)
+		}
+	}
+}
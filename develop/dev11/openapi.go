@@ -0,0 +1,1249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==            == == =
+	= ==== ДОКУМЕНТ OPENAPI ==== =
+	  = == ==            == == =
+*/
+
+// openAPIPath адрес документа OpenAPI
+const openAPIPath = "/openapi.json"
+
+// Документ OpenAPI 3 описывает все маршруты сервера и одновременно служит правилами проверки запросов:
+// ValidationMiddleware сверяет с ним параметры и тела JSON и форм до вызова обработчика. Правила форматов
+// (user_id, дата гггг-мм-дд, limit) заданы схемами ниже, и ValidateDate, ValidateUserID и ParseListOptions
+// проверяют значения по тем же схемам.
+//
+// Проверки бизнес-логики (допустимые статусы ответа на приглашение, уровни доступа, частота повторения)
+// в документе перечислены в описаниях полей, а выполняет их EventStore, отвечая ошибкой validation
+
+// === Структуры ===
+
+// openAPIDocument документ OpenAPI 3.0
+type openAPIDocument struct {
+	OpenAPI    string                      `json:"openapi"`
+	Info       openAPIInfo                 `json:"info"`
+	Paths      map[string]*openAPIPathItem `json:"paths"`
+	Components openAPIComponents           `json:"components"`
+	Security   []map[string][]string       `json:"security"`
+}
+
+// openAPIInfo сведения о сервисе
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// openAPIComponents переиспользуемые схемы и способы аутентификации
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema         `json:"schemas"`
+	SecuritySchemes map[string]*openAPISecurityScheme `json:"securitySchemes"`
+}
+
+// openAPISecurityScheme способ аутентификации
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme"`
+	Description string `json:"description,omitempty"`
+}
+
+// openAPIPathItem операции над одним адресом. Parameters — общие для всех операций параметры пути
+type openAPIPathItem struct {
+	Description string              `json:"description,omitempty"`
+	Parameters  []*openAPIParameter `json:"parameters,omitempty"`
+	Get         *openAPIOperation   `json:"get,omitempty"`
+	Put         *openAPIOperation   `json:"put,omitempty"`
+	Post        *openAPIOperation   `json:"post,omitempty"`
+	Delete      *openAPIOperation   `json:"delete,omitempty"`
+	Options     *openAPIOperation   `json:"options,omitempty"`
+	Patch       *openAPIOperation   `json:"patch,omitempty"`
+}
+
+// operation возвращает операцию для HTTP метода method; nil — метод не описан
+func (item *openAPIPathItem) operation(method string) *openAPIOperation {
+	switch method {
+	case http.MethodGet:
+		return item.Get
+	case http.MethodPut:
+		return item.Put
+	case http.MethodPost:
+		return item.Post
+	case http.MethodDelete:
+		return item.Delete
+	case http.MethodOptions:
+		return item.Options
+	case http.MethodPatch:
+		return item.Patch
+	}
+	return nil
+}
+
+// openAPIOperation операция над адресом
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+// openAPIParameter параметр запроса: in — query, path или header
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+// openAPIRequestBody тело запроса по типам содержимого
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+// openAPIResponse ответ операции
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+// openAPIMediaType содержимое тела одного типа
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema,omitempty"`
+}
+
+// openAPISchema схема значения (подмножество JSON Schema, принятое в OpenAPI 3.0)
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *int64                    `json:"minimum,omitempty"`
+	Maximum              *int64                    `json:"maximum,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	ReadOnly             bool                      `json:"readOnly,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *bool                     `json:"additionalProperties,omitempty"`
+	AllOf                []*openAPISchema          `json:"allOf,omitempty"`
+
+	message string // текст ошибки для значения, не подходящего под схему; пусто — текст конкретной проверки
+}
+
+// === Схемы ===
+
+// schemaRefPrefix префикс ссылки на схему из components
+const schemaRefPrefix = "#/components/schemas/"
+
+// ref возвращает ссылку на схему name из components
+func ref(name string) *openAPISchema {
+	return &openAPISchema{Ref: schemaRefPrefix + name}
+}
+
+// bound возвращает указатель на границу диапазона
+func bound(n int64) *int64 {
+	return &n
+}
+
+// noExtraFields запрет свойств объекта, не описанных в схеме. Соответствует DisallowUnknownFields в DecodeJSONBody
+var noExtraFields = new(bool)
+
+// Форматы строк. Кроме стандартных date и date-time используются собственные: date-or-date-time — значение,
+// которое принимает ParseDateTime (время без смещения относится к часовому поясу tz), и time-zone — имя часового
+// пояса IANA
+var schemaFormats = map[string]func(value string) error{
+	"date": func(value string) error {
+		_, err := time.Parse(dateLayout, value)
+		return err
+	},
+	"date-time": func(value string) error {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("ожидается время в формате RFC 3339, получено %q", value)
+		}
+		return nil
+	},
+	"date-or-date-time": func(value string) error {
+		_, err := ParseDateTime(value, time.UTC)
+		return err
+	},
+	"time-zone": func(value string) error {
+		_, err := LoadLocation(value)
+		return err
+	},
+}
+
+// dateLayout формат даты гггг-мм-дд
+const dateLayout = "2006-01-02"
+
+var (
+	// userIDSchema идентификатор пользователя в параметрах запроса
+	userIDSchema = &openAPISchema{Type: "integer", Minimum: bound(1), message: "некорректный user_id"}
+
+	// dateSchema дата гггг-мм-дд в параметре date методов events_for_*
+	dateSchema = &openAPISchema{Type: "string", Format: "date", message: "формат даты должен соответствовать шаблону гггг-мм-дд"}
+
+	// limitSchema размер страницы списка
+	limitSchema = &openAPISchema{
+		Type:    "integer",
+		Minimum: bound(1),
+		Maximum: bound(maxPageSize),
+		message: fmt.Sprintf("limit должен быть числом от 1 до %d", maxPageSize),
+	}
+
+	// timeSchema момент времени в параметрах запроса
+	timeSchema = &openAPISchema{Type: "string", Format: "date-or-date-time"}
+
+	// timeZoneSchema часовой пояс IANA
+	timeZoneSchema = &openAPISchema{Type: "string", Format: "time-zone", Description: "часовой пояс IANA, например Europe/Moscow"}
+)
+
+// openAPISchemas схемы тел запросов и ответов
+var openAPISchemas = map[string]*openAPISchema{
+	"Event": {
+		Type:        "object",
+		Description: "событие календаря; null или отсутствие поля в PATCH /api/v1/events/{id} и /update_event различаются",
+		Properties: map[string]*openAPISchema{
+			"id":          {Type: "integer", Nullable: true},
+			"user_id":     {Type: "integer", Nullable: true, Description: "владелец календаря; по умолчанию — аутентифицированный пользователь"},
+			"title":       {Type: "string", Nullable: true},
+			"uid":         {Type: "string", Nullable: true, Description: "идентификатор события во внешних календарях (iCalendar UID)"},
+			"date":        {Type: "string", Format: "date-time", Nullable: true},
+			"end":         {Type: "string", Format: "date-time", Nullable: true},
+			"tz":          {Type: "string", Format: "time-zone", Nullable: true, Description: "часовой пояс IANA, в котором повторяется событие"},
+			"all_day":     {Type: "boolean", Nullable: true},
+			"location":    {Type: "string", Nullable: true},
+			"description": {Type: "string", Nullable: true},
+			"recurrence":  {AllOf: []*openAPISchema{ref("Recurrence")}, Nullable: true},
+			"series_id":   {Type: "integer", Nullable: true, ReadOnly: true},
+			"occurrence":  {Type: "string", Format: "date-time", Nullable: true, Description: "исходная дата вхождения серии"},
+			"reminders":   {Type: "array", Items: &openAPISchema{Type: "integer"}, Nullable: true, Description: "за сколько минут до начала напомнить"},
+			"attendees":   {Type: "array", Items: ref("Attendee"), Nullable: true},
+			"version":     {Type: "integer", Nullable: true, ReadOnly: true},
+			"created_at":  {Type: "string", Format: "date-time", Nullable: true, ReadOnly: true},
+			"updated_at":  {Type: "string", Format: "date-time", Nullable: true, ReadOnly: true},
+		},
+		AdditionalProperties: noExtraFields,
+	},
+	"Recurrence": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"freq":       {Type: "string", Description: "DAILY, WEEKLY, MONTHLY или YEARLY"},
+			"interval":   {Type: "integer", Description: "шаг повторения, по умолчанию 1"},
+			"by_day":     {Type: "array", Items: &openAPISchema{Type: "string"}, Description: "дни недели MO..SU, для MONTHLY — с порядковым номером (1MO, -1FR)"},
+			"count":      {Type: "integer"},
+			"until":      {Type: "string", Format: "date-time", Nullable: true},
+			"exceptions": {Type: "array", Items: &openAPISchema{Type: "string", Format: "date-time"}},
+		},
+		AdditionalProperties: noExtraFields,
+	},
+	"Attendee": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"user_id": {Type: "integer"},
+			"status":  {Type: "string", Description: "accepted, declined или tentative; пусто — нет ответа"},
+		},
+		AdditionalProperties: noExtraFields,
+	},
+	"RSVP": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"user_id": {Type: "integer", Description: "участник; по умолчанию — аутентифицированный пользователь"},
+			"status":  {Type: "string", Description: "accepted, declined или tentative"},
+		},
+		Required:             []string{"status"},
+		AdditionalProperties: noExtraFields,
+	},
+	"Share": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"user_id":     {Type: "integer", Description: "владелец календаря; по умолчанию — аутентифицированный пользователь"},
+			"owner_id":    {Type: "integer", ReadOnly: true},
+			"shared_with": {Type: "integer"},
+			"permission":  {Type: "string", Description: "read или write"},
+		},
+	},
+	"ShareRequest": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"user_id":     {Type: "integer"},
+			"shared_with": {Type: "integer"},
+			"permission":  {Type: "string", Description: "read или write"},
+		},
+		Required:             []string{"shared_with", "permission"},
+		AdditionalProperties: noExtraFields,
+	},
+	"BatchRequest": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"user_id":    {Type: "integer", Description: "владелец календаря; по умолчанию — аутентифицированный пользователь"},
+			"operations": {Type: "array", Items: ref("BatchOperation"), Description: "операции в порядке выполнения; пакет выполняется целиком или не выполняется совсем"},
+		},
+		Required:             []string{"operations"},
+		AdditionalProperties: noExtraFields,
+	},
+	"BatchOperation": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"action": {Type: "string", Enum: []string{string(BatchCreate), string(BatchUpdate), string(BatchDelete)}},
+			"event": {
+				AllOf:       []*openAPISchema{ref("Event")},
+				Description: "create — новое событие; update — id и изменяемые поля; delete — id и, для вхождения серии, occurrence",
+			},
+			"version": {Type: "integer", Minimum: bound(1), Description: "ожидаемая версия события, как в заголовке If-Match"},
+		},
+		Required:             []string{"action", "event"},
+		AdditionalProperties: noExtraFields,
+	},
+	"BatchResult": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"action":    {Type: "string", Enum: []string{string(BatchCreate), string(BatchUpdate), string(BatchDelete)}},
+			"id":        {Type: "integer"},
+			"event":     ref("Event"),
+			"conflicts": {Type: "array", Items: ref("Event"), Description: "пересечения в режиме conflicts=report"},
+		},
+	},
+	"Busy": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"start": {Type: "string", Format: "date-time"},
+			"end":   {Type: "string", Format: "date-time"},
+		},
+	},
+	"AuditEntry": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"operation": {Type: "integer"},
+			"time":      {Type: "string", Format: "date-time"},
+			"actor_id":  {Type: "integer"},
+			"action":    {Type: "string", Enum: []string{string(AuditCreated), string(AuditUpdated), string(AuditDeleted), string(AuditRestored), string(AuditShared), string(AuditUnshared)}},
+			"event_id":  {Type: "integer"},
+			"before":    ref("Event"),
+			"after":     ref("Event"),
+			"share":     ref("Share"),
+		},
+	},
+	"Error": {
+		Type:        "object",
+		Description: "ошибка: текст, машиночитаемый код и события, из-за пересечения с которыми отклонена запись",
+		Properties: map[string]*openAPISchema{
+			"error":     {Type: "string"},
+			"code":      {Type: "string", Enum: errorCodes},
+			"conflicts": {Type: "array", Items: ref("Event")},
+			"index":     {Type: "integer", Description: "номер операции пакета (с нуля), из-за которой пакет не выполнен"},
+		},
+		Required: []string{"error", "code"},
+	},
+	"EventList": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"result":      {Type: "array", Items: ref("Event")},
+			"next_cursor": {Type: "string", Description: "курсор следующей страницы; нет — страница последняя"},
+		},
+	},
+	"EventResult": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"result":    ref("Event"),
+			"conflicts": {Type: "array", Items: ref("Event"), Description: "пересечения в режиме conflicts=report"},
+		},
+	},
+	"MessageResult": {
+		Type: "object",
+		Properties: map[string]*openAPISchema{
+			"result":    {Type: "string"},
+			"conflicts": {Type: "array", Items: ref("Event"), Description: "пересечения в режиме conflicts=report"},
+		},
+	},
+}
+
+// errorCodes машиночитаемые коды ошибок в поле code
+var errorCodes = []string{
+	"bad_request", "unauthorized", "forbidden", "not_found", "method_not_allowed", "validation", "conflict",
+	"precondition_failed", "request_too_large", "unsupported_media_type", "rate_limited", "unavailable", "storage", "internal",
+}
+
+// resolve возвращает схему, на которую ссылается $ref, или саму схему
+func (schema *openAPISchema) resolve() *openAPISchema {
+	if schema.Ref == "" {
+		return schema
+	}
+	return openAPISchemas[strings.TrimPrefix(schema.Ref, schemaRefPrefix)]
+}
+
+// === Проверка значений ===
+
+// schemaError ошибка проверки значения поля path тела запроса
+type schemaError struct {
+	path string
+	err  error
+}
+
+// Error возвращает текст ошибки с путём к полю
+func (e *schemaError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+// inField дополняет ошибку проверки вложенного значения именем поля или индексом элемента field
+func inField(field string, err error) error {
+	var nested *schemaError
+	if !errors.As(err, &nested) {
+		return &schemaError{path: field, err: err}
+	}
+
+	if strings.HasPrefix(nested.path, "[") {
+		return &schemaError{path: field + nested.path, err: nested.err}
+	}
+	return &schemaError{path: field + "." + nested.path, err: nested.err}
+}
+
+// explain заменяет ошибку проверки текстом из схемы, если он задан
+func (schema *openAPISchema) explain(err error) error {
+	if err != nil && schema.message != "" {
+		return errors.New(schema.message)
+	}
+	return err
+}
+
+// validateString проверяет значение параметра запроса, переданное строкой
+func (schema *openAPISchema) validateString(value string) error {
+	schema = schema.resolve()
+
+	var err error
+	switch schema.Type {
+	case "integer":
+		n, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil {
+			err = errors.New("ожидается целое число")
+		} else {
+			err = schema.checkRange(n)
+		}
+	case "boolean":
+		if _, parseErr := strconv.ParseBool(value); parseErr != nil {
+			err = errors.New("ожидается true или false")
+		}
+	default:
+		err = schema.checkString(value)
+	}
+
+	return schema.explain(err)
+}
+
+// validateValue проверяет значение, полученное из JSON с json.Decoder.UseNumber
+func (schema *openAPISchema) validateValue(value interface{}) error {
+	schema = schema.resolve()
+
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return errors.New("значение не может быть null")
+	}
+
+	for _, part := range schema.AllOf {
+		if err := part.validateValue(value); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return schema.explain(errors.New("ожидается объект"))
+		}
+		return schema.validateObject(object)
+
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return schema.explain(errors.New("ожидается массив"))
+		}
+		for i, item := range list {
+			if err := schema.Items.validateValue(item); err != nil {
+				return inField(fmt.Sprintf("[%d]", i), err)
+			}
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return schema.explain(errors.New("ожидается целое число"))
+		}
+		n, parseErr := number.Int64()
+		if parseErr != nil {
+			return schema.explain(errors.New("ожидается целое число"))
+		}
+		err = schema.checkRange(n)
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			err = errors.New("ожидается true или false")
+		}
+
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return schema.explain(errors.New("ожидается строка"))
+		}
+		err = schema.checkString(s)
+	}
+
+	return schema.explain(err)
+}
+
+// validateObject проверяет обязательные и известные поля объекта
+func (schema *openAPISchema) validateObject(object map[string]interface{}) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return &schemaError{path: name, err: errors.New("обязательное поле")}
+		}
+	}
+
+	// Поля проверяются по порядку имён, чтобы из нескольких ошибок сообщалась всегда одна и та же
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				return fmt.Errorf("неизвестное поле %q", name)
+			}
+			continue
+		}
+
+		if err := property.validateValue(object[name]); err != nil {
+			return inField(name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateForm проверяет поля формы: обязательные, известные и значения по схемам свойств, как параметры запроса.
+// Как и в ParseEventForm, пустое поле равносильно отсутствующему
+func (schema *openAPISchema) validateForm(values url.Values) error {
+	for _, name := range schema.Required {
+		if strings.TrimSpace(values.Get(name)) == "" {
+			return &schemaError{path: name, err: errors.New("обязательное поле")}
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				return fmt.Errorf("неизвестное поле %q", name)
+			}
+			continue
+		}
+
+		if len(values[name]) != 1 {
+			return fmt.Errorf("поле %q должно быть указано один раз", name)
+		}
+		value := strings.TrimSpace(values[name][0])
+		if value == "" {
+			continue
+		}
+		if err := property.validateString(value); err != nil {
+			return inField(name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseFormBody разбирает поля формы из тела data. Файлы multipart-формы не нужны для проверки и удаляются сразу
+func parseFormBody(mediaType string, params map[string]string, data []byte) (url.Values, error) {
+	if mediaType == "application/x-www-form-urlencoded" {
+		return url.ParseQuery(string(data))
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(data), params["boundary"]).ReadForm(maxMultipartMemory)
+	if err != nil {
+		return nil, err
+	}
+	defer form.RemoveAll()
+
+	return url.Values(form.Value), nil
+}
+
+// checkRange проверяет границы целого числа
+func (schema *openAPISchema) checkRange(n int64) error {
+	if schema.Minimum != nil && n < *schema.Minimum {
+		return fmt.Errorf("значение должно быть не меньше %d", *schema.Minimum)
+	}
+	if schema.Maximum != nil && n > *schema.Maximum {
+		return fmt.Errorf("значение должно быть не больше %d", *schema.Maximum)
+	}
+	return nil
+}
+
+// checkString проверяет строку на перечисление допустимых значений и формат
+func (schema *openAPISchema) checkString(value string) error {
+	if len(schema.Enum) > 0 {
+		allowed := false
+		for _, option := range schema.Enum {
+			allowed = allowed || option == value
+		}
+		if !allowed {
+			return fmt.Errorf("допустимые значения: %s, получено %q", strings.Join(schema.Enum, ", "), value)
+		}
+	}
+
+	if check, ok := schemaFormats[schema.Format]; ok {
+		return check(value)
+	}
+	return nil
+}
+
+// === Проверка запросов ===
+
+// openAPIRoute адрес документа, разобранный для сопоставления с путём запроса
+type openAPIRoute struct {
+	segments []string // части адреса; {name} — параметр пути
+	item     *openAPIPathItem
+}
+
+// params — число параметров пути: при совпадении нескольких адресов выбирается адрес с меньшим числом параметров
+func (route openAPIRoute) params() int {
+	n := 0
+	for _, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") {
+			n++
+		}
+	}
+	return n
+}
+
+// match проверяет, соответствует ли путь path адресу. Значения параметров пути проверяются по их схемам:
+// путь с некорректным параметром адресу не соответствует, и на него отвечает обработчик (обычно 404)
+func (route openAPIRoute) match(path string) bool {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) != len(route.segments) {
+		return false
+	}
+
+	for i, segment := range route.segments {
+		if !strings.HasPrefix(segment, "{") {
+			if segments[i] != segment {
+				return false
+			}
+			continue
+		}
+
+		name := strings.Trim(segment, "{}")
+		if segments[i] == "" {
+			return false
+		}
+		for _, param := range route.item.Parameters {
+			if param.Name == name && param.Schema.validateString(segments[i]) != nil {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// compileRoutes разбирает адреса документа для сопоставления с путями запросов
+func compileRoutes(doc *openAPIDocument) []openAPIRoute {
+	routes := make([]openAPIRoute, 0, len(doc.Paths))
+	for path, item := range doc.Paths {
+		routes = append(routes, openAPIRoute{segments: strings.Split(strings.TrimPrefix(path, "/"), "/"), item: item})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].params() != routes[j].params() {
+			return routes[i].params() < routes[j].params()
+		}
+		return strings.Join(routes[i].segments, "/") < strings.Join(routes[j].segments, "/")
+	})
+
+	return routes
+}
+
+// findOperation возвращает операцию документа для метода и пути запроса; nil — запрос документом не описан
+func findOperation(routes []openAPIRoute, method, path string) *openAPIOperation {
+	for _, route := range routes {
+		if route.match(path) {
+			return route.item.operation(method)
+		}
+	}
+	return nil
+}
+
+// validateRequest проверяет параметры запроса и тело JSON или формы по описанию операции
+func (operation *openAPIOperation) validateRequest(r *http.Request) error {
+	query := r.URL.Query()
+	for _, param := range operation.Parameters {
+		var value string
+		switch param.In {
+		case "query":
+			value = query.Get(param.Name)
+		case "header":
+			value = r.Header.Get(param.Name)
+		default:
+			continue
+		}
+
+		// Пустой параметр равносилен отсутствующему, как и в обработчиках
+		if value == "" {
+			if param.Required {
+				return badRequestf("отсутствует обязательный параметр %s", param.Name)
+			}
+			continue
+		}
+
+		if err := param.Schema.validateString(value); err != nil {
+			return badRequestf("валидация параметра %s не пройдена: %v", param.Name, err)
+		}
+	}
+
+	if operation.RequestBody != nil {
+		return operation.RequestBody.validateRequest(r)
+	}
+	return nil
+}
+
+// validateRequest проверяет тело JSON или формы по схеме. Тела других типов (iCalendar) проверяют обработчики.
+// Прочитанное тело возвращается в запрос, чтобы обработчик разобрал его заново
+func (body *openAPIRequestBody) validateRequest(r *http.Request) error {
+	// Запрос без Content-Type считается JSON, как и в обработчиках
+	mediaType, params := "application/json", map[string]string(nil)
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, params, err = mime.ParseMediaType(contentType); err != nil {
+			return nil
+		}
+	}
+
+	media, ok := body.Content[mediaType]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+	switch mediaType {
+	case "application/json", "application/x-www-form-urlencoded", "multipart/form-data":
+	default:
+		return nil
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		return tooLarge
+	}
+	if err != nil {
+		return badRequestf("%v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+
+	if mediaType != "application/json" {
+		values, err := parseFormBody(mediaType, params, data)
+		if err != nil {
+			return badRequestf("%v", err)
+		}
+		if err := media.Schema.validateForm(values); err != nil {
+			return badRequestf("валидация тела запроса не пройдена: %v", err)
+		}
+		return nil
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return badRequestf("отсутствует тело запроса")
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return badRequestf("некорректный JSON: %v", err)
+	}
+
+	if err := media.Schema.validateValue(value); err != nil {
+		return badRequestf("валидация тела запроса не пройдена: %v", err)
+	}
+	return nil
+}
+
+// === Адреса ===
+
+// Параметры запросов, общие для нескольких операций
+var (
+	userIDParam    = &openAPIParameter{Name: "user_id", In: "query", Description: "владелец календаря; по умолчанию — аутентифицированный пользователь", Schema: userIDSchema}
+	tzParam        = &openAPIParameter{Name: "tz", In: "query", Description: "часовой пояс дат и времени без смещения в параметрах, по умолчанию UTC", Schema: timeZoneSchema}
+	queryParam     = &openAPIParameter{Name: "q", In: "query", Description: "поиск по названию без учёта регистра", Schema: &openAPISchema{Type: "string"}}
+	limitParam     = &openAPIParameter{Name: "limit", In: "query", Description: "размер страницы", Schema: limitSchema}
+	cursorParam    = &openAPIParameter{Name: "cursor", In: "query", Description: "next_cursor предыдущей страницы", Schema: &openAPISchema{Type: "string"}}
+	conflictsParam = &openAPIParameter{
+		Name:        "conflicts",
+		In:          "query",
+		Description: "reject — отказать в записи пересекающегося события, report — записать и вернуть пересечения в поле conflicts",
+		Schema:      &openAPISchema{Type: "string", Enum: []string{"reject", "report"}},
+	}
+	ifMatchParam = &openAPIParameter{Name: "If-Match", In: "header", Description: "ETag события: запись выполняется, только если событие не изменилось", Schema: &openAPISchema{Type: "string"}}
+	eventIDParam = &openAPIParameter{Name: "id", In: "path", Required: true, Schema: &openAPISchema{Type: "integer", Minimum: bound(1)}}
+)
+
+// errorDescriptions описания ответов с ошибкой по кодам
+var errorDescriptions = map[int]string{
+	http.StatusBadRequest:            "некорректные параметры или тело запроса (bad_request)",
+	http.StatusUnauthorized:          "запрос не аутентифицирован (unauthorized)",
+	http.StatusForbidden:             "нет доступа к календарю (forbidden)",
+	http.StatusNotFound:              "событие или ресурс не найдены (not_found)",
+	http.StatusConflict:              "пересечение с другими событиями или событие не удалено (conflict)",
+	http.StatusPreconditionFailed:    "событие изменилось после получения ETag из If-Match (precondition_failed)",
+	http.StatusRequestEntityTooLarge: "тело запроса больше max_body_bytes (request_too_large)",
+	http.StatusUnsupportedMediaType:  "неподдерживаемый Content-Type (unsupported_media_type)",
+	http.StatusUnprocessableEntity:   "событие не прошло проверку (validation)",
+	http.StatusTooManyRequests:       "превышена частота запросов (rate_limited)",
+	http.StatusInternalServerError:   "сбой хранилища (storage) или внутренняя ошибка (internal)",
+	http.StatusServiceUnavailable:    "ошибка бизнес-логики (not_found, validation или conflict)",
+}
+
+// Коды ошибок методов из условия задания и REST API
+var (
+	legacyErrors = []int{http.StatusBadRequest, http.StatusForbidden, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusInternalServerError, http.StatusServiceUnavailable}
+	apiErrors    = []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity, http.StatusInternalServerError}
+)
+
+// jsonContent содержимое JSON по схеме schema
+func jsonContent(schema *openAPISchema) map[string]*openAPIMediaType {
+	return map[string]*openAPIMediaType{"application/json": {Schema: schema}}
+}
+
+// jsonBody обязательное тело JSON по схеме schema
+func jsonBody(schema *openAPISchema) *openAPIRequestBody {
+	return &openAPIRequestBody{Required: true, Content: jsonContent(schema)}
+}
+
+// eventFormFields поля формы события (см. ParseEventForm). Значения проверяются как параметры запроса
+var eventFormFields = map[string]*openAPISchema{
+	"id":          {Type: "integer", Minimum: bound(1), message: "некорректный id"},
+	"user_id":     userIDSchema,
+	"title":       {Type: "string"},
+	"uid":         {Type: "string"},
+	"date":        timeSchema,
+	"end":         timeSchema,
+	"tz":          timeZoneSchema,
+	"all_day":     {Type: "boolean"},
+	"location":    {Type: "string"},
+	"description": {Type: "string"},
+	"occurrence":  timeSchema,
+	"rrule":       {Type: "string", Description: "правило повторения RRULE, например FREQ=WEEKLY;BYDAY=MO"},
+	"reminders":   {Type: "string", Description: "минуты до начала через запятую"},
+	"attendees":   {Type: "string", Description: "ID участников через запятую"},
+}
+
+// eventBody тело записи события: JSON по схеме schema или форма с полями события (см. ParseEventForm).
+// required — обязательные поля формы; в JSON их задаёт schema
+func eventBody(schema *openAPISchema, required ...string) *openAPIRequestBody {
+	form := &openAPIMediaType{Schema: &openAPISchema{
+		Type: "object",
+		Description: "поля события; даты в формате гггг-мм-дд, гггг-мм-ддTчч:мм или RFC 3339 в часовом поясе из поля tz; " +
+			"пустое поле равносильно отсутствующему",
+		Properties:           eventFormFields,
+		Required:             required,
+		AdditionalProperties: noExtraFields,
+	}}
+
+	return &openAPIRequestBody{Required: true, Content: map[string]*openAPIMediaType{
+		"application/json":                  {Schema: schema},
+		"application/x-www-form-urlencoded": form,
+		"multipart/form-data":               form,
+	}}
+}
+
+// result схема успешного ответа {"result": ...}
+func result(schema *openAPISchema) *openAPISchema {
+	return &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{"result": schema}}
+}
+
+// responses возвращает ответы операции: успешный ответ success с кодом status и ответы с ошибками errorStatuses.
+// Ответы 401 и 429 возможны у любой операции
+func responses(status int, success *openAPIResponse, errorStatuses ...int) map[string]*openAPIResponse {
+	all := map[string]*openAPIResponse{strconv.Itoa(status): success}
+	for _, code := range append([]int{http.StatusUnauthorized, http.StatusTooManyRequests}, errorStatuses...) {
+		all[strconv.Itoa(code)] = &openAPIResponse{Description: errorDescriptions[code], Content: jsonContent(ref("Error"))}
+	}
+	return all
+}
+
+// ok успешный ответ JSON
+func ok(description string, schema *openAPISchema) *openAPIResponse {
+	return &openAPIResponse{Description: description, Content: jsonContent(schema)}
+}
+
+// newOpenAPIDocument возвращает документ OpenAPI со всеми маршрутами SetupRoutes
+func newOpenAPIDocument() *openAPIDocument {
+	eventWithID := &openAPISchema{AllOf: []*openAPISchema{ref("Event"), {Type: "object", Required: []string{"id"}}}}
+	eventCreate := &openAPISchema{AllOf: []*openAPISchema{ref("Event"), {Type: "object", Required: []string{"title", "date"}}}}
+	writeParams := []*openAPIParameter{conflictsParam, ifMatchParam}
+	calendarText := map[string]*openAPIMediaType{"text/calendar": {Schema: &openAPISchema{Type: "string", Description: "документ iCalendar (RFC 5545)"}}}
+
+	paths := map[string]*openAPIPathItem{
+		// Методы из условия задания: ошибки бизнес-логики возвращаются с кодом 503
+		"/create_event": {Post: &openAPIOperation{
+			OperationID: "createEvent",
+			Summary:     "Создание события",
+			Parameters:  []*openAPIParameter{conflictsParam},
+			RequestBody: eventBody(eventCreate, "title", "date"),
+			Responses:   responses(http.StatusOK, ok("событие создано; result — сообщение с его ID", ref("MessageResult")), legacyErrors...),
+		}},
+		"/update_event": {Post: &openAPIOperation{
+			OperationID: "updateEvent",
+			Summary:     "Изменение события",
+			Description: "изменяются только поля, присутствующие в теле; null очищает поле",
+			Parameters:  writeParams,
+			RequestBody: eventBody(eventWithID, "id"),
+			Responses:   responses(http.StatusOK, ok("событие изменено", ref("MessageResult")), legacyErrors...),
+		}},
+		"/delete_event": {Post: &openAPIOperation{
+			OperationID: "deleteEvent",
+			Summary:     "Удаление события или, с occurrence, одного вхождения серии",
+			Parameters:  []*openAPIParameter{ifMatchParam},
+			RequestBody: eventBody(eventWithID, "id"),
+			Responses:   responses(http.StatusOK, ok("событие удалено", ref("MessageResult")), legacyErrors...),
+		}},
+
+		"/export.ics": {Get: &openAPIOperation{
+			OperationID: "exportICal",
+			Summary:     "Выгрузка событий пользователя в формате iCalendar",
+			Parameters:  []*openAPIParameter{userIDParam},
+			Responses:   responses(http.StatusOK, &openAPIResponse{Description: "календарь пользователя", Content: calendarText}, http.StatusBadRequest, http.StatusForbidden),
+		}},
+		"/import": {Post: &openAPIOperation{
+			OperationID: "importICal",
+			Summary:     "Загрузка событий из документа iCalendar",
+			Description: "события с уже известным UID заменяются",
+			Parameters:  []*openAPIParameter{userIDParam},
+			RequestBody: &openAPIRequestBody{Required: true, Content: calendarText},
+			Responses:   responses(http.StatusOK, ok("число загруженных событий", ref("MessageResult")), legacyErrors...),
+		}},
+
+		// REST API
+		apiEventsPath: {
+			Get: &openAPIOperation{
+				OperationID: "listEvents",
+				Summary:     "События пользователя",
+				Description: "с from и to — вхождения за период, без них — все хранимые события",
+				Parameters: []*openAPIParameter{
+					userIDParam,
+					{Name: "from", In: "query", Description: "начало периода", Schema: timeSchema},
+					{Name: "to", In: "query", Description: "конец периода; дата без времени — конец этого дня", Schema: timeSchema},
+					tzParam, queryParam, limitParam, cursorParam,
+				},
+				Responses: responses(http.StatusOK, ok("страница событий", ref("EventList")), http.StatusBadRequest, http.StatusForbidden),
+			},
+			Post: &openAPIOperation{
+				OperationID: "apiCreateEvent",
+				Summary:     "Создание события",
+				Parameters:  []*openAPIParameter{conflictsParam},
+				RequestBody: eventBody(eventCreate, "title", "date"),
+				Responses:   responses(http.StatusCreated, ok("событие создано; адрес — в заголовке Location, версия — в ETag", ref("EventResult")), apiErrors...),
+			},
+		},
+		apiEventsPath + "/{id}": {
+			Parameters: []*openAPIParameter{eventIDParam},
+			Get: &openAPIOperation{
+				OperationID: "getEvent",
+				Summary:     "Событие",
+				Parameters:  []*openAPIParameter{userIDParam},
+				Responses:   responses(http.StatusOK, ok("событие; версия — в ETag", result(ref("Event"))), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound),
+			},
+			Put: &openAPIOperation{
+				OperationID: "replaceEvent",
+				Summary:     "Замена события целиком",
+				Parameters:  append([]*openAPIParameter{userIDParam}, writeParams...),
+				RequestBody: eventBody(ref("Event")),
+				Responses:   responses(http.StatusOK, ok("событие заменено", ref("EventResult")), apiErrors...),
+			},
+			Patch: &openAPIOperation{
+				OperationID: "patchEvent",
+				Summary:     "Изменение присутствующих в теле полей",
+				Description: "null очищает поле; с occurrence изменяется одно вхождение серии: ответ описывает выделенное вхождение и его ETag, а Content-Location — его адрес",
+				Parameters:  append([]*openAPIParameter{userIDParam}, writeParams...),
+				RequestBody: eventBody(ref("Event")),
+				Responses:   responses(http.StatusOK, ok("событие изменено", ref("EventResult")), apiErrors...),
+			},
+			Delete: &openAPIOperation{
+				OperationID: "apiDeleteEvent",
+				Summary:     "Удаление события или, с occurrence, одного вхождения серии",
+				Parameters: []*openAPIParameter{
+					userIDParam,
+					{Name: "occurrence", In: "query", Description: "исходная дата удаляемого вхождения", Schema: timeSchema},
+					tzParam, ifMatchParam,
+				},
+				Responses: responses(http.StatusOK, ok("событие удалено", ref("MessageResult")), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed),
+			},
+		},
+
+		freeBusyPath: {Get: &openAPIOperation{
+			OperationID: "freeBusy",
+			Summary:     "Занятость пользователя за период",
+			Description: "to не входит в период; дата без времени в to означает конец этого дня",
+			Parameters: []*openAPIParameter{
+				userIDParam,
+				{Name: "from", In: "query", Required: true, Schema: timeSchema},
+				{Name: "to", In: "query", Required: true, Schema: timeSchema},
+				tzParam,
+			},
+			Responses: responses(http.StatusOK, ok("занятые промежутки в часовом поясе запроса", result(&openAPISchema{Type: "array", Items: ref("Busy")})), http.StatusBadRequest, http.StatusForbidden),
+		}},
+		batchPath: {Post: &openAPIOperation{
+			OperationID: "applyBatch",
+			Summary:     "Пакет операций над событиями",
+			Description: "операции create, update и delete выполняются по порядку под одной блокировкой: пакет выполняется целиком или не выполняется совсем",
+			Parameters:  []*openAPIParameter{conflictsParam},
+			RequestBody: jsonBody(ref("BatchRequest")),
+			Responses:   responses(http.StatusOK, ok("результаты операций в порядке запроса", result(&openAPISchema{Type: "array", Items: ref("BatchResult")})), apiErrors...),
+		}},
+		streamPath: {Get: &openAPIOperation{
+			OperationID: "eventStream",
+			Summary:     "Изменения событий пользователя (Server-Sent Events)",
+			Description: "с Last-Event-ID сначала отправляются пропущенные изменения; если их уже нет, клиент получает событие reset",
+			Parameters: []*openAPIParameter{
+				userIDParam,
+				{Name: "Last-Event-ID", In: "header", Schema: &openAPISchema{Type: "integer", Minimum: bound(0)}},
+				{Name: "last_event_id", In: "query", Description: "то же, что Last-Event-ID, для клиентов без заголовков", Schema: &openAPISchema{Type: "integer", Minimum: bound(0)}},
+			},
+			Responses: responses(http.StatusOK, &openAPIResponse{
+				Description: "поток изменений",
+				Content:     map[string]*openAPIMediaType{"text/event-stream": {Schema: &openAPISchema{Type: "string"}}},
+			}, http.StatusBadRequest, http.StatusForbidden),
+		}},
+		"/events/{id}/rsvp": {
+			Parameters: []*openAPIParameter{eventIDParam},
+			Post: &openAPIOperation{
+				OperationID: "rsvp",
+				Summary:     "Ответ участника на приглашение",
+				Parameters:  []*openAPIParameter{ifMatchParam},
+				RequestBody: &openAPIRequestBody{Required: true, Content: map[string]*openAPIMediaType{
+					"application/json":                  {Schema: ref("RSVP")},
+					"application/x-www-form-urlencoded": {Schema: ref("RSVP")},
+				}},
+				Responses: responses(http.StatusOK, ok("событие с обновлённым списком участников", ref("EventResult")), apiErrors...),
+			},
+		},
+		"/events/{id}/history": {
+			Parameters: []*openAPIParameter{eventIDParam},
+			Get: &openAPIOperation{
+				OperationID: "eventHistory",
+				Summary:     "Журнал изменений события",
+				Parameters:  []*openAPIParameter{userIDParam},
+				Responses:   responses(http.StatusOK, ok("записи журнала по порядку", result(&openAPISchema{Type: "array", Items: ref("AuditEntry")})), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound),
+			},
+		},
+		"/events/{id}/restore": {
+			Parameters: []*openAPIParameter{eventIDParam},
+			Post: &openAPIOperation{
+				OperationID: "restoreEvent",
+				Summary:     "Восстановление удалённого события",
+				Parameters:  append([]*openAPIParameter{userIDParam}, writeParams...),
+				Responses:   responses(http.StatusOK, ok("восстановленное событие", ref("EventResult")), apiErrors...),
+			},
+		},
+		sharesPath: {
+			Get: &openAPIOperation{
+				OperationID: "listShares",
+				Summary:     "Доступы к календарю пользователя и выданные ему",
+				Parameters:  []*openAPIParameter{userIDParam},
+				Responses:   responses(http.StatusOK, ok("доступы", result(&openAPISchema{Type: "array", Items: ref("Share")})), http.StatusBadRequest, http.StatusForbidden),
+			},
+			Post: &openAPIOperation{
+				OperationID: "shareCalendar",
+				Summary:     "Выдача или изменение доступа к календарю",
+				RequestBody: jsonBody(ref("ShareRequest")),
+				Responses:   responses(http.StatusOK, ok("выданный доступ", result(ref("Share"))), http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity),
+			},
+			Delete: &openAPIOperation{
+				OperationID: "unshareCalendar",
+				Summary:     "Отмена доступа к календарю",
+				Parameters:  []*openAPIParameter{userIDParam, {Name: "shared_with", In: "query", Required: true, Schema: userIDSchema}},
+				Responses:   responses(http.StatusOK, ok("доступ отменён", ref("MessageResult")), http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound),
+			},
+		},
+
+		// CalDAV. PROPFIND и REPORT не входят в набор методов OpenAPI, поэтому описаны только в тексте
+		caldavPath: {
+			Description: "корень CalDAV: PROPFIND возвращает текущего пользователя (current-user-principal)",
+			Options:     caldavOptions("caldavRootOptions"),
+		},
+		caldavPath + "{user_id}/": {
+			Description: "календарь пользователя: PROPFIND — свойства календаря (с Depth: 1 — и его ресурсов), " +
+				"REPORT — calendar-query с time-range или calendar-multiget",
+			Parameters: []*openAPIParameter{{Name: "user_id", In: "path", Required: true, Schema: userIDSchema}},
+			Get: &openAPIOperation{
+				OperationID: "caldavCalendar",
+				Summary:     "Календарь пользователя в формате iCalendar",
+				Responses:   responses(http.StatusOK, &openAPIResponse{Description: "календарь", Content: calendarText}, http.StatusForbidden),
+			},
+			Options: caldavOptions("caldavCalendarOptions"),
+		},
+		caldavPath + "{user_id}/{resource}": {
+			Parameters: []*openAPIParameter{
+				{Name: "user_id", In: "path", Required: true, Schema: userIDSchema},
+				{Name: "resource", In: "path", Required: true, Description: "{uid}.ics", Schema: &openAPISchema{Type: "string"}},
+			},
+			Get: &openAPIOperation{
+				OperationID: "caldavGetResource",
+				Summary:     "Событие с выделенными вхождениями в формате iCalendar",
+				Responses:   responses(http.StatusOK, &openAPIResponse{Description: "ресурс; версия — в ETag", Content: calendarText}, http.StatusForbidden, http.StatusNotFound),
+			},
+			Put: &openAPIOperation{
+				OperationID: "caldavPutResource",
+				Summary:     "Создание или замена ресурса",
+				Description: "UID в документе совпадает с именем ресурса; учитываются If-Match и If-None-Match: *",
+				Parameters:  []*openAPIParameter{ifMatchParam, {Name: "If-None-Match", In: "header", Schema: &openAPISchema{Type: "string", Enum: []string{"*"}}}},
+				RequestBody: &openAPIRequestBody{Required: true, Content: calendarText},
+				Responses:   responses(http.StatusCreated, &openAPIResponse{Description: "ресурс создан (при замене — 204)"}, http.StatusBadRequest, http.StatusForbidden, http.StatusConflict, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType),
+			},
+			Delete: &openAPIOperation{
+				OperationID: "caldavDeleteResource",
+				Summary:     "Удаление ресурса",
+				Parameters:  []*openAPIParameter{ifMatchParam},
+				Responses:   responses(http.StatusNoContent, &openAPIResponse{Description: "ресурс удалён"}, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed),
+			},
+			Options: caldavOptions("caldavResourceOptions"),
+		},
+		"/.well-known/caldav": {Get: &openAPIOperation{
+			OperationID: "caldavWellKnown",
+			Summary:     "Перенаправление на корень CalDAV (RFC 6764)",
+			Responses:   map[string]*openAPIResponse{strconv.Itoa(http.StatusMovedPermanently): {Description: "адрес корня — в заголовке Location"}},
+		}},
+
+		metricsPath: {Get: &openAPIOperation{
+			OperationID: "metrics",
+			Summary:     "Метрики в формате Prometheus; доступны без аутентификации",
+			Responses: map[string]*openAPIResponse{strconv.Itoa(http.StatusOK): {
+				Description: "метрики",
+				Content:     map[string]*openAPIMediaType{"text/plain": {Schema: &openAPISchema{Type: "string"}}},
+			}},
+		}},
+		openAPIPath: {Get: &openAPIOperation{
+			OperationID: "openAPI",
+			Summary:     "Этот документ; доступен без аутентификации",
+			Responses:   map[string]*openAPIResponse{strconv.Itoa(http.StatusOK): {Description: "документ OpenAPI 3", Content: jsonContent(&openAPISchema{Type: "object"})}},
+		}},
+	}
+
+	periods := []struct{ path, operationID, name string }{
+		{"/events_for_day", "eventsForDay", "день"},
+		{"/events_for_week", "eventsForWeek", "неделю"},
+		{"/events_for_month", "eventsForMonth", "месяц"},
+	}
+	for _, period := range periods {
+		paths[period.path] = &openAPIPathItem{Get: &openAPIOperation{
+			OperationID: period.operationID,
+			Summary:     "События пользователя за " + period.name,
+			Parameters: []*openAPIParameter{
+				userIDParam,
+				{Name: "date", In: "query", Required: true, Description: "дата гггг-мм-дд в часовом поясе tz", Schema: dateSchema},
+				tzParam, queryParam, limitParam, cursorParam,
+			},
+			Responses: responses(http.StatusOK, ok("страница вхождений событий по времени начала", ref("EventList")), http.StatusBadRequest, http.StatusForbidden),
+		}}
+	}
+
+	return &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   "Календарь",
+			Version: "1.0",
+			Description: "HTTP-сервер календаря. Методы из условия задания отвечают на ошибки бизнес-логики кодом 503, " +
+				"REST API /api/v1 — кодами 404, 422 и 409. Ошибка возвращается в теле по схеме Error, успешный ответ — в поле result",
+		},
+		Paths: paths,
+		Components: openAPIComponents{
+			Schemas: openAPISchemas,
+			SecuritySchemes: map[string]*openAPISecurityScheme{
+				"bearer": {Type: "http", Scheme: "bearer", Description: "токен из файла tokens_file"},
+				"basic":  {Type: "http", Scheme: "basic", Description: "токен паролем, имя пользователя не учитывается; для клиентов CalDAV"},
+			},
+		},
+		// Пустое требование: без tokens_file аутентификация отключена
+		Security: []map[string][]string{{}, {"bearer": {}}, {"basic": {}}},
+	}
+}
+
+// caldavOptions операция OPTIONS ресурса CalDAV
+func caldavOptions(operationID string) *openAPIOperation {
+	return &openAPIOperation{
+		OperationID: operationID,
+		Summary:     "Возможности сервера в заголовках DAV и Allow",
+		Responses:   map[string]*openAPIResponse{strconv.Itoa(http.StatusOK): {Description: "возможности сервера"}},
+	}
+}
+
+// === HTTP ===
+
+// openAPI документ сервера и его адреса для сопоставления с запросами
+var (
+	openAPI       = newOpenAPIDocument()
+	openAPIRoutes = compileRoutes(openAPI)
+)
+
+// ValidationMiddleware проверяет запросы по документу OpenAPI до вызова обработчика: обязательные параметры,
+// их типы и форматы, тело JSON или формы. Ошибка — ответ 400. Запросы, которых нет в документе (неизвестный адрес
+// или метод), передаются обработчику без проверки, чтобы он ответил 404 или 405 как обычно
+func (s *Server) ValidationMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := findOperation(openAPIRoutes, r.Method, r.URL.Path)
+		if operation == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if err := operation.validateRequest(r); err != nil {
+			s.RespondWithError(w, err)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// OpenAPIHandler отдаёт документ OpenAPI: GET /openapi.json
+func (s *Server) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		s.RespondWithAPIError(w, methodNotAllowed)
+		return
+	}
+
+	s.RespondWithJSON(w, http.StatusOK, openAPI)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// === Документ OpenAPI ===
+
+// collectRefs собирает значения всех $ref документа
+func collectRefs(value interface{}, refs map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if s, ok := item.(string); ok && key == "$ref" {
+				refs[s] = true
+			}
+			collectRefs(item, refs)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectRefs(item, refs)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1}}
+
+	// Документ доступен без токена
+	resp, body := ts.do(t, testRequest{method: "GET", path: openAPIPath})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("код ответа %d:\n%s", resp.StatusCode, body)
+	}
+
+	var doc struct {
+		OpenAPI    string                     `json:"openapi"`
+		Paths      map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("версия документа %q", doc.OpenAPI)
+	}
+	for _, name := range []string{"Event", "Error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("в документе нет схемы %s", name)
+		}
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		t.Fatal(err)
+	}
+	refs := map[string]bool{}
+	collectRefs(raw, refs)
+	for ref := range refs {
+		if _, ok := doc.Components.Schemas[strings.TrimPrefix(ref, schemaRefPrefix)]; !ok || !strings.HasPrefix(ref, schemaRefPrefix) {
+			t.Errorf("ссылка %s ведёт на несуществующую схему", ref)
+		}
+	}
+}
+
+// TestOpenAPICoversRoutes сверяет адреса документа с маршрутами сервера: каждый маршрут описан в документе,
+// и каждый адрес документа обслуживается маршрутом
+func TestOpenAPICoversRoutes(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	samples := strings.NewReplacer("{id}", "1", "{user_id}", "1", "{resource}", "event.ics")
+
+	covered := map[string]bool{}
+	for path, item := range openAPI.Paths {
+		sample := samples.Replace(path)
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions, http.MethodPatch} {
+			if item.operation(method) == nil {
+				continue
+			}
+
+			_, pattern := server.mux.Handler(httptest.NewRequest(method, sample, nil))
+			if pattern == "" || pattern == "/" {
+				t.Errorf("%s %s описан в документе, но не обслуживается сервером", method, path)
+			}
+			covered[pattern] = true
+
+			if findOperation(openAPIRoutes, method, sample) != item.operation(method) {
+				t.Errorf("%s %s не сопоставляется со своим описанием", method, sample)
+			}
+		}
+	}
+
+	for _, route := range server.routes {
+		if !covered[route] {
+			t.Errorf("маршрут %s не описан в документе OpenAPI", route)
+		}
+	}
+}
+
+// === Проверка запросов ===
+
+func TestValidationMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        testRequest
+		wantStatus int
+		wantError  string // фрагмент текста ошибки; пусто — не проверяется
+	}{
+		{
+			name:       "без обязательной даты",
+			req:        testRequest{method: "GET", path: "/events_for_day?user_id=1"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "отсутствует обязательный параметр date",
+		},
+		{
+			name:       "дата не по шаблону",
+			req:        testRequest{method: "GET", path: "/events_for_month?user_id=1&date=2024-3-1"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "формат даты должен соответствовать шаблону гггг-мм-дд",
+		},
+		{
+			name:       "нечисловой user_id",
+			req:        testRequest{method: "GET", path: "/api/v1/events?user_id=alice"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "валидация параметра user_id не пройдена: некорректный user_id",
+		},
+		{
+			name:       "limit за пределами страницы",
+			req:        testRequest{method: "GET", path: "/api/v1/events?user_id=1&limit=5000"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "limit должен быть числом от 1 до 1000",
+		},
+		{
+			name:       "неизвестный режим пересечений",
+			req:        testRequest{method: "POST", path: "/api/v1/events?conflicts=ignore", contentType: jsonType, body: `{"user_id":1,"title":"x","date":"2024-03-06T10:00:00Z"}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "допустимые значения: reject, report",
+		},
+		{
+			name:       "без обязательного to",
+			req:        testRequest{method: "GET", path: "/free_busy?user_id=1&from=2024-03-04"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "отсутствует обязательный параметр to",
+		},
+		{
+			name:       "дата события без времени",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"title":"x","date":"2024-03-06"}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "date: ожидается время в формате RFC 3339",
+		},
+		{
+			name:       "ошибка во вложенном поле",
+			req:        testRequest{method: "POST", path: "/api/v1/events", contentType: jsonType, body: `{"user_id":1,"title":"x","date":"2024-03-06T10:00:00Z","attendees":[{"user_id":"bob"}]}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "attendees[0].user_id: ожидается целое число",
+		},
+		{
+			name:       "изменение без ID",
+			req:        testRequest{method: "POST", path: "/update_event", body: `{"user_id":1,"title":"x"}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "id: обязательное поле",
+		},
+		{
+			name:       "пустое тело",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: jsonType},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "отсутствует тело запроса",
+		},
+		{
+			name:       "доступ без уровня",
+			req:        testRequest{method: "POST", path: "/shares", contentType: jsonType, body: `{"user_id":1,"shared_with":2}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "permission: обязательное поле",
+		},
+		{
+			name:       "null очищает поле",
+			req:        testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, body: `{"user_id":1,"end":null,"recurrence":null}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "форма по схеме",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: formType, body: "user_id=1&title=Форма&date=2024-03-06&location="},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "создание без даты",
+			req:        testRequest{method: "POST", path: "/api/v1/events", contentType: jsonType, body: `{"user_id":1,"title":"x"}`},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "date: обязательное поле",
+		},
+		{
+			name:       "форма с пустым обязательным полем",
+			req:        testRequest{method: "POST", path: "/api/v1/events", contentType: formType, body: "user_id=1&title=&date=2024-03-06"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "title: обязательное поле",
+		},
+		{
+			name:       "форма с некорректным значением",
+			req:        testRequest{method: "POST", path: "/create_event", contentType: formType, body: "user_id=1&title=x&date=2024-03-06&all_day=yes"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "all_day: ожидается true или false",
+		},
+		{
+			name:       "форма с неизвестным полем",
+			req:        testRequest{method: "POST", path: "/update_event", contentType: formType, body: "user_id=1&id=1&color=red"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  `неизвестное поле "color"`,
+		},
+		{
+			name: "multipart-форма без ID",
+			req: testRequest{method: "POST", path: "/delete_event", contentType: "multipart/form-data; boundary=xyz",
+				body: "--xyz\r\nContent-Disposition: form-data; name=\"user_id\"\r\n\r\n1\r\n--xyz--\r\n"},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "id: обязательное поле",
+		},
+		{
+			name:       "некорректный ID в пути — ответ обработчика",
+			req:        testRequest{method: "DELETE", path: "/api/v1/events/0?user_id=1"},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			resp, body := ts.do(t, tt.req)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("код ответа %d, ожидался %d:\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+
+			if tt.wantError != "" {
+				response := decodeResponse(t, body)
+				if response.Code != "bad_request" || !strings.Contains(response.Error, tt.wantError) {
+					t.Errorf("ошибка %q (%s), ожидалась содержащая %q", response.Error, response.Code, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+// TestValidatorsUseSchemas проверяет, что ValidateDate и ValidateUserID следуют схемам документа
+func TestValidatorsUseSchemas(t *testing.T) {
+	s := NewServer(InitNewEventStore())
+
+	for _, value := range []string{"1", "42"} {
+		if _, err := s.ValidateUserID(value); err != nil {
+			t.Errorf("ValidateUserID(%q): %v", value, err)
+		}
+	}
+	for _, value := range []string{"", "0", "-3", "1.5", "bob"} {
+		if _, err := s.ValidateUserID(value); err == nil || err.Error() != userIDSchema.message {
+			t.Errorf("ValidateUserID(%q) = %v, ожидалось %q", value, err, userIDSchema.message)
+		}
+	}
+
+	if date, err := s.ValidateDate("2024-02-29", time.UTC); err != nil || date.Day() != 29 {
+		t.Errorf("ValidateDate(2024-02-29) = %v, %v", date, err)
+	}
+	for _, value := range []string{"", "2024-02-30", "05.03.2024", "2024-03-05T10:00:00Z"} {
+		if _, err := s.ValidateDate(value, time.UTC); err == nil || err.Error() != dateSchema.message {
+			t.Errorf("ValidateDate(%q) = %v, ожидалось %q", value, err, dateSchema.message)
+		}
+	}
+}
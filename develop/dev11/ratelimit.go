@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==                    == == =
+	= ==== ОГРАНИЧЕНИЕ НАГРУЗКИ ==== =
+	  = == ==                    == == =
+*/
+
+// rateLimiterSweep как часто RateLimiter удаляет корзины клиентов, давно не присылавших запросов
+const rateLimiterSweep = time.Minute
+
+// tokenBucket корзина маркеров одного клиента. Маркеры не пересчитываются в фоне:
+// при каждом запросе добавляется накопленное с last количество
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter ограничивает частоту запросов каждого клиента алгоритмом token bucket:
+// у клиента есть до burst маркеров, каждый запрос тратит один, а маркеры восполняются со скоростью rate в секунду.
+// Так клиент может прислать burst запросов разом, но в среднем — не чаще rate в секунду
+type RateLimiter struct {
+	sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter возвращает ограничитель на rate запросов в секунду с запасом burst запросов
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow тратит маркер клиента key. Если маркеров нет, возвращает false и время, через которое появится следующий
+func (rl *RateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+	}
+
+	bucket.tokens = rl.refill(bucket, now)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// refill возвращает количество маркеров в корзине на момент now
+func (rl *RateLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	tokens := bucket.tokens + now.Sub(bucket.last).Seconds()*rl.rate
+	if tokens > rl.burst {
+		tokens = rl.burst
+	}
+	return tokens
+}
+
+// sweep удаляет заполнившиеся корзины: они не отличаются от новых, а без удаления
+// ограничитель хранил бы корзину каждого когда-либо обращавшегося адреса. Вызывается под блокировкой
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimiterSweep {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, bucket := range rl.buckets {
+		if rl.refill(bucket, now) >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// === HTTP ===
+
+// addrKey возвращает ключ IP-адреса клиента для ограничения частоты
+func addrKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// clientKey возвращает ключ клиента для ограничения частоты: аутентифицированного пользователя
+// или, без аутентификации, IP-адрес. Параметру user_id не доверяем: клиент может менять его в каждом запросе
+func clientKey(r *http.Request) string {
+	if userID, ok := AuthenticatedUserID(r.Context()); ok {
+		return "user:" + strconv.Itoa(userID)
+	}
+	return addrKey(r)
+}
+
+// RateLimitMiddleware отвечает 429 Too Many Requests с заголовком Retry-After клиентам, превысившим
+// частоту запросов. Работает после AuthMiddleware, чтобы различать пользователей за одним адресом.
+// Если ограничитель не настроен, запросы пропускаются как есть
+func (s *Server) RateLimitMiddleware(handler http.Handler) http.Handler {
+	return s.rateLimit(handler, func() *RateLimiter { return s.Limiter }, clientKey)
+}
+
+// AddrRateLimitMiddleware ограничивает частоту запросов с одного IP-адреса. Работает до AuthMiddleware, поэтому
+// запросы с неверными учётными данными тоже тратят маркеры и перебор токенов получает 429, а не бесконечные 401.
+// Ограничение общее для всех пользователей за адресом, поэтому обычно задаётся с запасом относительно rate_limit.
+// Если ограничитель не настроен, запросы пропускаются как есть
+func (s *Server) AddrRateLimitMiddleware(handler http.Handler) http.Handler {
+	return s.rateLimit(handler, func() *RateLimiter { return s.AddrLimit }, addrKey)
+}
+
+// rateLimit пропускает запрос, если у клиента key(r) есть маркер в ограничителе limiter(), иначе отвечает 429.
+// Ограничитель берётся при каждом запросе, чтобы его можно было задать после сборки обработчика
+func (s *Server) rateLimit(handler http.Handler, limiter func() *RateLimiter, key func(r *http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rl := limiter()
+		if rl == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, retryAfter := rl.Allow(key(r))
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			s.RespondWithError(w, &HTTPError{
+				Status: http.StatusTooManyRequests,
+				Code:   "rate_limited",
+				Err:    fmt.Errorf("слишком много запросов, повторите через %d с", seconds),
+			})
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// BodyLimitMiddleware ограничивает размер тела запроса параметром max_body_bytes. Обработчик, прочитавший
+// больше, получает ошибку чтения, а сервер закрывает соединение после ответа
+func (s *Server) BodyLimitMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, s.Config.MaxBodyBytes)
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge возвращает ошибку HTTP 413, если err вызвана превышением max_body_bytes, иначе nil
+func bodyTooLarge(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return nil
+	}
+
+	return &HTTPError{
+		Status: http.StatusRequestEntityTooLarge,
+		Code:   "request_too_large",
+		Err:    fmt.Errorf("тело запроса больше %d байт", maxBytesErr.Limit),
+	}
+}
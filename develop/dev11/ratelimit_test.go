@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, time.March, 4, 10, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, 3)
+	limiter.now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		key       string
+		wantAllow bool
+		wantRetry time.Duration
+	}{
+		{"запас 1", 0, "a", true, 0},
+		{"запас 2", 0, "a", true, 0},
+		{"запас 3", 0, "a", true, 0},
+		{"запас исчерпан", 0, "a", false, 500 * time.Millisecond},
+		{"другой клиент", 0, "b", true, 0},
+		{"половина маркера", 250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+		{"маркер восполнен", 250 * time.Millisecond, "a", true, 0},
+		{"запас не превышает burst", time.Hour, "a", true, 0},
+		{"после простоя снова 3", 0, "a", true, 0},
+		{"и только 3", 0, "a", true, 0},
+		{"сверх burst", 0, "a", false, 500 * time.Millisecond},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		allowed, retry := limiter.Allow(step.key)
+		if allowed != step.wantAllow || retry != step.wantRetry {
+			t.Errorf("%s: Allow() = %t, %v, ожидалось %t, %v", step.name, allowed, retry, step.wantAllow, step.wantRetry)
+		}
+	}
+
+	if len(limiter.buckets) != 1 {
+		t.Errorf("корзин %d: заполнившаяся корзина b должна быть удалена", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2}}
+	ts.server.Limiter = NewRateLimiter(0.5, 2)
+
+	request := func(token string) testRequest {
+		return testRequest{method: "GET", path: "/events_for_day?date=2024-03-04", header: map[string]string{"Authorization": "Bearer " + token}}
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp, body := ts.do(t, request("alice")); resp.StatusCode != http.StatusOK {
+			t.Fatalf("запрос %d: код ответа %d\n%s", i+1, resp.StatusCode, body)
+		}
+	}
+
+	resp, body := ts.do(t, request("alice"))
+	if resp.StatusCode != http.StatusTooManyRequests || decodeResponse(t, body).Code != "rate_limited" {
+		t.Fatalf("код ответа %d, ожидался 429 rate_limited\n%s", resp.StatusCode, body)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Retry-After = %q, ожидалось 2", retryAfter)
+	}
+
+	// Запас считается для каждого пользователя отдельно, даже если запросы приходят с одного адреса
+	if resp, body := ts.do(t, request("bob")); resp.StatusCode != http.StatusOK {
+		t.Errorf("другой пользователь: код ответа %d\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestAddrRateLimitMiddleware(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1}}
+	ts.server.Limiter = NewRateLimiter(100, 100)
+	ts.server.AddrLimit = NewRateLimiter(0.5, 3)
+
+	request := func(token string) testRequest {
+		return testRequest{method: "GET", path: "/events_for_day?date=2024-03-04", header: map[string]string{"Authorization": "Bearer " + token}}
+	}
+
+	// Перебор токенов тратит запас адреса, хотя ни один запрос не аутентифицирован
+	for i := 0; i < 2; i++ {
+		if resp, body := ts.do(t, request(fmt.Sprintf("guess-%d", i))); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("попытка %d: код ответа %d\n%s", i+1, resp.StatusCode, body)
+		}
+	}
+	if resp, body := ts.do(t, request("alice")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("верный токен: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	resp, body := ts.do(t, request("guess-3"))
+	if resp.StatusCode != http.StatusTooManyRequests || decodeResponse(t, body).Code != "rate_limited" {
+		t.Fatalf("код ответа %d, ожидался 429 rate_limited\n%s", resp.StatusCode, body)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Retry-After = %q, ожидалось 2", retryAfter)
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	large := strings.Repeat("я", 600)
+
+	tests := []struct {
+		name string
+		req  testRequest
+	}{
+		{"JSON", testRequest{method: "POST", path: "/create_event", contentType: jsonType, body: `{"user_id":1,"title":"` + large + `","date":"2024-03-06T10:00:00Z"}`}},
+		{"форма", testRequest{method: "POST", path: "/update_event", contentType: formType, body: "user_id=1&id=1&title=" + large}},
+		{"API", testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, body: `{"user_id":1,"description":"` + large + `"}`}},
+		{"iCalendar", testRequest{method: "POST", path: "/import?user_id=1", contentType: "text/calendar", body: "BEGIN:VCALENDAR\r\nX-NOTE:" + large + "\r\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.server.Config.MaxBodyBytes = 1024
+
+			resp, body := ts.do(t, tt.req)
+			if resp.StatusCode != http.StatusRequestEntityTooLarge || decodeResponse(t, body).Code != "request_too_large" {
+				t.Errorf("код ответа %d, ожидался 413 request_too_large\n%s", resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==                 == == =
+	= ==== ПОВТОРЯЮЩИЕСЯ СОБЫТИЯ ==== =
+	  = == ==                 == == =
+*/
+
+// Частоты повторения (подмножество RRULE из RFC 5545)
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+// maxRecurrencePeriods ограничивает перебор периодов серии, чтобы бесконечная серия не зациклила запрос
+const maxRecurrencePeriods = 100000
+
+// weekdayCodes сопоставляет двухбуквенные коды дней недели RFC 5545 с time.Weekday
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Recurrence правило повторения события. Первое вхождение серии — Date самого события.
+type Recurrence struct {
+	Freq       string      `json:"freq"`                 // DAILY, WEEKLY, MONTHLY или YEARLY
+	Interval   int         `json:"interval,omitempty"`   // шаг повторения в единицах Freq, по умолчанию 1
+	ByDay      []string    `json:"by_day,omitempty"`     // дни недели: MO..SU, для MONTHLY допустим порядковый номер (1MO, -1FR)
+	Count      int         `json:"count,omitempty"`      // общее количество вхождений, включая исключённые
+	Until      *time.Time  `json:"until,omitempty"`      // последний допустимый момент начала вхождения (включительно)
+	Exceptions []time.Time `json:"exceptions,omitempty"` // даты вхождений, исключённых из серии
+}
+
+// byDayRule разобранный элемент ByDay
+type byDayRule struct {
+	ordinal int // 0 — каждый такой день периода, n > 0 — n-й с начала, n < 0 — n-й с конца месяца
+	weekday time.Weekday
+}
+
+// Validate проверяет корректность правила повторения
+func (r *Recurrence) Validate() error {
+	switch r.Freq {
+	case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+	default:
+		return fmt.Errorf("неизвестная частота повторения %q", r.Freq)
+	}
+
+	if r.Interval < 0 {
+		return fmt.Errorf("интервал повторения не может быть отрицательным")
+	}
+
+	if r.Count < 0 {
+		return fmt.Errorf("количество повторений не может быть отрицательным")
+	}
+
+	if r.Count > 0 && r.Until != nil {
+		return fmt.Errorf("count и until не могут быть указаны одновременно")
+	}
+
+	if len(r.ByDay) > 0 && r.Freq != FreqWeekly && r.Freq != FreqMonthly {
+		return fmt.Errorf("by_day поддерживается только для WEEKLY и MONTHLY")
+	}
+
+	rules, err := r.byDay()
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.ordinal != 0 && r.Freq != FreqMonthly {
+			return fmt.Errorf("порядковый номер дня недели допустим только для MONTHLY")
+		}
+	}
+
+	return nil
+}
+
+// byDay разбирает элементы ByDay
+func (r *Recurrence) byDay() ([]byDayRule, error) {
+	rules := make([]byDayRule, 0, len(r.ByDay))
+	for _, item := range r.ByDay {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		if len(item) < 2 {
+			return nil, fmt.Errorf("некорректный день недели %q", item)
+		}
+
+		weekday, ok := weekdayCodes[item[len(item)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("некорректный день недели %q", item)
+		}
+
+		rule := byDayRule{weekday: weekday}
+		if prefix := item[:len(item)-2]; prefix != "" {
+			ordinal, err := strconv.Atoi(prefix)
+			if err != nil || ordinal == 0 || ordinal < -5 || ordinal > 5 {
+				return nil, fmt.Errorf("некорректный порядковый номер дня недели %q", item)
+			}
+			rule.ordinal = ordinal
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// interval возвращает шаг повторения с учётом значения по умолчанию
+func (r *Recurrence) interval() int {
+	if r.Interval < 1 {
+		return 1
+	}
+	return r.Interval
+}
+
+// IsException сообщает, исключено ли из серии вхождение, начинающееся в момент occurrence
+func (r *Recurrence) IsException(occurrence time.Time) bool {
+	for _, exception := range r.Exceptions {
+		if sameDay(exception.In(occurrence.Location()), occurrence) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddException исключает из серии вхождение, начинающееся в момент occurrence
+func (r *Recurrence) AddException(occurrence time.Time) {
+	if !r.IsException(occurrence) {
+		r.Exceptions = append(r.Exceptions, occurrence)
+	}
+}
+
+// Occurrences возвращает моменты начала вхождений серии, начинающейся в start, попадающие в отрезок [from, to].
+// Исключённые вхождения не возвращаются, но учитываются в Count.
+func (r *Recurrence) Occurrences(start, from, to time.Time) []time.Time {
+	rules, err := r.byDay()
+	if err != nil {
+		return nil
+	}
+
+	var result []time.Time
+	emitted := 0
+	period := 0
+
+	// Без Count вхождения до from можно не перебирать: сразу переходим к периоду незадолго до from
+	if r.Count == 0 && from.After(start) {
+		period = r.periodsBetween(start, from) - 1
+		if period < 0 {
+			period = 0
+		}
+	}
+
+	for limit := period + maxRecurrencePeriods; period < limit; period++ {
+		periodStart, candidates := r.candidates(start, period, rules)
+		if periodStart.After(to) || (r.Until != nil && periodStart.After(*r.Until)) {
+			return result
+		}
+
+		for _, candidate := range candidates {
+			if candidate.Before(start) {
+				continue
+			}
+			if r.Until != nil && candidate.After(*r.Until) {
+				return result
+			}
+			if candidate.After(to) {
+				return result
+			}
+
+			emitted++
+			if !candidate.Before(from) && !r.IsException(candidate) {
+				result = append(result, candidate)
+			}
+			if r.Count > 0 && emitted >= r.Count {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// periodsBetween грубо оценивает количество периодов повторения между start и moment
+func (r *Recurrence) periodsBetween(start, moment time.Time) int {
+	days := int(moment.Sub(start).Hours() / 24)
+	switch r.Freq {
+	case FreqDaily:
+		return days / r.interval()
+	case FreqWeekly:
+		return days / (7 * r.interval())
+	case FreqMonthly:
+		months := (moment.Year()-start.Year())*12 + int(moment.Month()) - int(start.Month())
+		return months / r.interval()
+	default:
+		return (moment.Year() - start.Year()) / r.interval()
+	}
+}
+
+// candidates возвращает начало периода с номером period и упорядоченные кандидаты во вхождения внутри него
+func (r *Recurrence) candidates(start time.Time, period int, rules []byDayRule) (time.Time, []time.Time) {
+	step := period * r.interval()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	}
+
+	switch r.Freq {
+	case FreqDaily:
+		day := at(start.Year(), start.Month(), start.Day()+step)
+		return day, []time.Time{day}
+
+	case FreqWeekly:
+		// Неделя начинается с понедельника
+		offsetToMonday := (int(start.Weekday()) + 6) % 7
+		monday := at(start.Year(), start.Month(), start.Day()-offsetToMonday+7*step)
+		if len(rules) == 0 {
+			day := at(start.Year(), start.Month(), start.Day()+7*step)
+			return monday, []time.Time{day}
+		}
+
+		days := make([]time.Time, 0, len(rules))
+		for _, rule := range rules {
+			offset := (int(rule.weekday) + 6) % 7
+			days = append(days, at(monday.Year(), monday.Month(), monday.Day()+offset))
+		}
+		return monday, sortedUnique(days)
+
+	case FreqMonthly:
+		first := at(start.Year(), start.Month()+time.Month(step), 1)
+		if len(rules) == 0 {
+			day := at(first.Year(), first.Month(), start.Day())
+			if day.Month() != first.Month() {
+				// В месяце нет такого числа (например, 31-го) — вхождение пропускается
+				return first, nil
+			}
+			return first, []time.Time{day}
+		}
+
+		var days []time.Time
+		for _, rule := range rules {
+			days = append(days, weekdaysOfMonth(first, rule)...)
+		}
+		return first, sortedUnique(days)
+
+	default:
+		first := at(start.Year()+step, time.January, 1)
+		day := at(first.Year(), start.Month(), start.Day())
+		if day.Month() != start.Month() {
+			// 29 февраля в невисокосный год пропускается
+			return first, nil
+		}
+		return first, []time.Time{day}
+	}
+}
+
+// weekdaysOfMonth возвращает дни месяца, начинающегося в first, подходящие под правило rule
+func weekdaysOfMonth(first time.Time, rule byDayRule) []time.Time {
+	var days []time.Time
+	for day := first; day.Month() == first.Month(); day = time.Date(day.Year(), day.Month(), day.Day()+1, day.Hour(), day.Minute(), day.Second(), day.Nanosecond(), day.Location()) {
+		if day.Weekday() == rule.weekday {
+			days = append(days, day)
+		}
+	}
+
+	switch {
+	case rule.ordinal > 0 && rule.ordinal <= len(days):
+		return days[rule.ordinal-1 : rule.ordinal]
+	case rule.ordinal < 0 && -rule.ordinal <= len(days):
+		return days[len(days)+rule.ordinal : len(days)+rule.ordinal+1]
+	case rule.ordinal != 0:
+		return nil
+	}
+
+	return days
+}
+
+// sortedUnique упорядочивает моменты времени и убирает повторы
+func sortedUnique(days []time.Time) []time.Time {
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	result := days[:0]
+	for i, day := range days {
+		if i == 0 || !day.Equal(days[i-1]) {
+			result = append(result, day)
+		}
+	}
+	return result
+}
+
+// sameDay сообщает, приходятся ли a и b на одну календарную дату
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// expandSeries разворачивает серию master во вхождения, начинающиеся в отрезке [start, end].
+// Каждое вхождение — копия master с датой вхождения и заполненным полем Occurrence.
+// Вхождения вычисляются в часовом поясе серии, поэтому сохраняют местное время начала при переходе на летнее время.
+func expandSeries(master Event, start, end time.Time) []Event {
+	localizeEvent(&master)
+
+	var result []Event
+	for _, occurrence := range master.Recurrence.Occurrences(master.Date.In(master.Location()), start, end) {
+		instance := master
+		instance.Date = occurrence
+		occurrence := occurrence
+		instance.Occurrence = &occurrence
+		instance.End = movedEnd(master, occurrence)
+		result = append(result, instance)
+	}
+	return result
+}
+
+// overlappingOccurrences возвращает вхождения серии master, пересекающиеся с отрезком [start, end] (см. eventOverlaps),
+// в том числе начавшиеся раньше start
+func overlappingOccurrences(master Event, start, end time.Time) []Event {
+	if master.Date.After(end) {
+		return nil
+	}
+
+	var result []Event
+	for _, occurrence := range expandSeries(master, start.Add(-eventDuration(master)), end) {
+		if eventOverlaps(occurrence, start, end) {
+			result = append(result, occurrence)
+		}
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatTimes записывает моменты времени через «|» в формате «2006-01-02 15:04»
+func formatTimes(times []time.Time) string {
+	formatted := make([]string, 0, len(times))
+	for _, t := range times {
+		formatted = append(formatted, t.Format("2006-01-02 15:04"))
+	}
+	return strings.Join(formatted, "|")
+}
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	until := at(2024, time.March, 7, 9, 0)
+
+	tests := []struct {
+		name       string
+		recurrence Recurrence
+		start      time.Time
+		from, to   time.Time
+		want       string
+	}{
+		{
+			name:       "ежедневно, count",
+			recurrence: Recurrence{Freq: FreqDaily, Count: 3},
+			start:      at(2024, time.March, 4, 9, 0),
+			from:       at(2024, time.March, 1, 0, 0), to: at(2024, time.March, 31, 0, 0),
+			want: "2024-03-04 09:00|2024-03-05 09:00|2024-03-06 09:00",
+		},
+		{
+			name:       "until включительно",
+			recurrence: Recurrence{Freq: FreqDaily, Until: &until},
+			start:      at(2024, time.March, 4, 9, 0),
+			from:       at(2024, time.March, 1, 0, 0), to: at(2024, time.March, 31, 0, 0),
+			want: "2024-03-04 09:00|2024-03-05 09:00|2024-03-06 09:00|2024-03-07 09:00",
+		},
+		{
+			name:       "исключения учитываются в count",
+			recurrence: Recurrence{Freq: FreqDaily, Count: 3, Exceptions: []time.Time{at(2024, time.March, 5, 9, 0)}},
+			start:      at(2024, time.March, 4, 9, 0),
+			from:       at(2024, time.March, 1, 0, 0), to: at(2024, time.March, 31, 0, 0),
+			want: "2024-03-04 09:00|2024-03-06 09:00",
+		},
+		{
+			name:       "раз в две недели по понедельникам и пятницам",
+			recurrence: Recurrence{Freq: FreqWeekly, Interval: 2, ByDay: []string{"FR", "MO"}, Count: 4},
+			start:      at(2024, time.March, 4, 9, 0),
+			from:       at(2024, time.March, 1, 0, 0), to: at(2024, time.April, 30, 0, 0),
+			want: "2024-03-04 09:00|2024-03-08 09:00|2024-03-18 09:00|2024-03-22 09:00",
+		},
+		{
+			name:       "31-е число пропускается в коротких месяцах",
+			recurrence: Recurrence{Freq: FreqMonthly, Count: 3},
+			start:      at(2024, time.January, 31, 9, 0),
+			from:       at(2024, time.January, 1, 0, 0), to: at(2024, time.December, 31, 0, 0),
+			want: "2024-01-31 09:00|2024-03-31 09:00|2024-05-31 09:00",
+		},
+		{
+			name:       "последняя пятница месяца",
+			recurrence: Recurrence{Freq: FreqMonthly, ByDay: []string{"-1FR"}, Count: 3},
+			start:      at(2024, time.January, 1, 18, 0),
+			from:       at(2024, time.January, 1, 0, 0), to: at(2024, time.December, 31, 0, 0),
+			want: "2024-01-26 18:00|2024-02-23 18:00|2024-03-29 18:00",
+		},
+		{
+			name:       "29 февраля только в високосные годы",
+			recurrence: Recurrence{Freq: FreqYearly, Count: 2},
+			start:      at(2024, time.February, 29, 12, 0),
+			from:       at(2024, time.January, 1, 0, 0), to: at(2033, time.January, 1, 0, 0),
+			want: "2024-02-29 12:00|2028-02-29 12:00",
+		},
+		{
+			name:       "бесконечная серия с начала периода",
+			recurrence: Recurrence{Freq: FreqWeekly},
+			start:      at(2020, time.January, 6, 9, 0),
+			from:       at(2024, time.March, 1, 0, 0), to: at(2024, time.March, 15, 0, 0),
+			want: "2024-03-04 09:00|2024-03-11 09:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.recurrence.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := formatTimes(tt.recurrence.Occurrences(tt.start, tt.from, tt.to)); got != tt.want {
+				t.Errorf("вхождения %q, ожидались %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecurrenceValidate(t *testing.T) {
+	until := at(2024, time.March, 7, 9, 0)
+
+	tests := []struct {
+		name       string
+		recurrence Recurrence
+	}{
+		{"неизвестная частота", Recurrence{Freq: "HOURLY"}},
+		{"отрицательный интервал", Recurrence{Freq: FreqDaily, Interval: -1}},
+		{"отрицательное количество", Recurrence{Freq: FreqDaily, Count: -1}},
+		{"count вместе с until", Recurrence{Freq: FreqDaily, Count: 2, Until: &until}},
+		{"by_day у ежедневной серии", Recurrence{Freq: FreqDaily, ByDay: []string{"MO"}}},
+		{"некорректный день недели", Recurrence{Freq: FreqWeekly, ByDay: []string{"XX"}}},
+		{"порядковый номер у еженедельной серии", Recurrence{Freq: FreqWeekly, ByDay: []string{"1MO"}}},
+		{"порядковый номер вне месяца", Recurrence{Freq: FreqMonthly, ByDay: []string{"6MO"}}},
+	}
+
+	for _, tt := range tests {
+		if err := tt.recurrence.Validate(); err == nil {
+			t.Errorf("%s: Validate() должен завершиться ошибкой", tt.name)
+		}
+	}
+}
+
+func TestRecurringEventsByDate(t *testing.T) {
+	store := InitNewEventStore()
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 4, 7, 0), Recurrence: &Recurrence{Freq: FreqDaily, Count: 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 4, 9, 0), Recurrence: &Recurrence{Freq: FreqDaily, ByDay: []string{"MO"}}}); err == nil {
+		t.Error("AddEvent() с by_day у ежедневной серии должен завершиться ошибкой")
+	}
+
+	events, err := store.GetEventsByDate(1, at(2024, time.March, 6, 12, 0))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("события за день: %+v, %v", events, err)
+	}
+	if event := events[0]; event.Occurrence == nil || !event.Date.Equal(at(2024, time.March, 6, 7, 0)) || !event.Occurrence.Equal(event.Date) {
+		t.Errorf("вхождение серии %+v", event)
+	}
+
+	if events, err := store.GetEventsByDate(1, at(2024, time.March, 9, 12, 0)); err != nil || len(events) != 0 {
+		t.Errorf("после окончания серии: %+v, %v", events, err)
+	}
+}
+
+func TestExpandSeriesKeepsLocalTime(t *testing.T) {
+	berlin, err := LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// В ночь на 31 марта 2024 года Берлин перешёл на летнее время: вхождения остаются в 09:00 по местному времени
+	start := time.Date(2024, time.March, 29, 9, 0, 0, 0, berlin)
+	end := start.Add(30 * time.Minute)
+	master := Event{Title: "Стендап", Date: start.UTC(), End: &end, TimeZone: "Europe/Berlin", Recurrence: &Recurrence{Freq: FreqDaily, Count: 4}}
+
+	instances := expandSeries(master, start.AddDate(0, 0, -1), start.AddDate(0, 0, 7))
+	if len(instances) != 4 {
+		t.Fatalf("вхождений %d, ожидалось 4", len(instances))
+	}
+	for _, instance := range instances {
+		if instance.Date.Hour() != 9 || instance.Date.Location() != berlin {
+			t.Errorf("вхождение %v, ожидалось 09:00 по Берлину", instance.Date)
+		}
+		if instance.End == nil || instance.End.Sub(instance.Date) != 30*time.Minute {
+			t.Errorf("окончание вхождения %v: %v", instance.Date, instance.End)
+		}
+	}
+}
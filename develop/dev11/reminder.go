@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==      == == =
+	= ==== НАПОМИНАНИЯ ==== =
+	  = == ==      == == =
+*/
+
+// maxReminderMinutes наибольшее допустимое упреждение напоминания — четыре недели
+const maxReminderMinutes = 4 * 7 * 24 * 60
+
+// maxReminderLead упреждение maxReminderMinutes в виде длительности: на столько вперёд планировщик просматривает события
+const maxReminderLead = maxReminderMinutes * time.Minute
+
+// maxSchedulerSleep наибольшая пауза планировщика между проверками. Ограничивает последствия перевода системных часов
+const maxSchedulerSleep = time.Minute
+
+// reminderDebounce задержка проверки после изменения событий: изменения, пришедшие за это время, учитываются одной проверкой
+const reminderDebounce = 200 * time.Millisecond
+
+// reminderQueueSize вместимость очереди напоминаний одного способа доставки. Если способ доставки не успевает
+// и очередь заполнена, новые напоминания для него отбрасываются с записью в лог
+const reminderQueueSize = 1000
+
+// normalizeReminders проверяет список напоминаний события (минут до начала) и возвращает его упорядоченным и без повторов
+func normalizeReminders(minutes []int) ([]int, error) {
+	result := make([]int, 0, len(minutes))
+	for _, m := range minutes {
+		if m < 0 || m > maxReminderMinutes {
+			return nil, fmt.Errorf("напоминание задаётся числом минут до начала события от 0 до %d", maxReminderMinutes)
+		}
+		result = append(result, m)
+	}
+
+	sort.Ints(result)
+	unique := result[:0]
+	for i, m := range result {
+		if i == 0 || m != result[i-1] {
+			unique = append(unique, m)
+		}
+	}
+
+	return unique, nil
+}
+
+// ParseReminders разбирает список напоминаний вида "15,60" (минут до начала события)
+func ParseReminders(value string) ([]int, error) {
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		minutes, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("напоминание %q должно быть целым числом минут", part)
+		}
+		result = append(result, minutes)
+	}
+
+	return normalizeReminders(result)
+}
+
+// Reminder сработавшее напоминание о событии
+type Reminder struct {
+	EventID       int       `json:"event_id"`
+	UserID        int       `json:"user_id"`
+	Title         string    `json:"title"`
+	Start         time.Time `json:"start"`          // начало события (для серии — начало вхождения)
+	MinutesBefore int       `json:"minutes_before"` // за сколько минут до начала сработало напоминание
+	FireAt        time.Time `json:"fire_at"`
+}
+
+// Message возвращает текст напоминания для человека
+func (r Reminder) Message() string {
+	return fmt.Sprintf("Напоминание пользователю %d: «%s» начнётся %s (через %d мин.)",
+		r.UserID, r.Title, r.Start.Format("2006-01-02 15:04 MST"), r.MinutesBefore)
+}
+
+// === ReminderScheduler (планировщик напоминаний) ===
+
+// ReminderScheduler следит за EventStore и отправляет напоминания через настроенные способы доставки.
+// Напоминания не хранятся отдельно: при каждой проверке они вычисляются заново по текущему состоянию событий,
+// поэтому изменение или удаление события сразу отражается на расписании.
+// Каждое напоминание отправляется один раз — когда момент его срабатывания попадает между двумя проверками.
+// Напоминания, которые должны были сработать, пока сервер не работал, не отправляются.
+// У каждого способа доставки своя очередь и своя горутина, поэтому медленный webhook не задерживает ни проверки,
+// ни доставку другими способами.
+type ReminderScheduler struct {
+	store     *EventStore
+	notifiers []Notifier
+	now       func() time.Time
+	debounce  time.Duration
+	wake      chan struct{}
+}
+
+// NewReminderScheduler возвращает планировщик напоминаний событий store
+func NewReminderScheduler(store *EventStore, notifiers []Notifier) *ReminderScheduler {
+	return &ReminderScheduler{
+		store:     store,
+		notifiers: notifiers,
+		now:       time.Now,
+		debounce:  reminderDebounce,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Run отправляет напоминания до отмены ctx. Планировщик спит до ближайшего напоминания и просыпается раньше,
+// если события в хранилище изменились: проверка выполняется через debounce после первого изменения.
+// Run возвращается, когда доставка уже поставленных в очередь напоминаний завершена или прервана отменой ctx
+func (rs *ReminderScheduler) Run(ctx context.Context) {
+	cancel := rs.store.Watch(func(EventChange) {
+		select {
+		case rs.wake <- struct{}{}:
+		default:
+		}
+	})
+	defer cancel()
+
+	queues, wait := rs.startWorkers(ctx)
+	defer wait()
+
+	last := rs.now()
+	for {
+		now := rs.now()
+		due, next, err := rs.collect(last, now)
+		if err != nil {
+			// Полуинтервал не просмотрен, поэтому last не сдвигается: его напоминания отправит следующая проверка
+			log.Printf("Ошибка чтения событий для напоминаний, повтор через %v: %v", maxSchedulerSleep, err)
+			next = now.Add(maxSchedulerSleep)
+		} else {
+			for _, reminder := range due {
+				rs.enqueue(queues, reminder)
+			}
+			last = now
+		}
+
+		timer := time.NewTimer(next.Sub(rs.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-rs.wake:
+			if !rs.settle(ctx, timer) {
+				return
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// settle ждёт debounce после изменения событий, но не дольше срабатывания timer, и отбрасывает сигналы об изменениях,
+// пришедшие за это время: их учтёт следующая проверка. Возвращает false, если ctx отменён
+func (rs *ReminderScheduler) settle(ctx context.Context, timer *time.Timer) bool {
+	debounce := time.NewTimer(rs.debounce)
+	defer debounce.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-debounce.C:
+	case <-timer.C:
+	}
+
+	select {
+	case <-rs.wake:
+	default:
+	}
+
+	return true
+}
+
+// collect возвращает напоминания, срабатывающие в полуинтервале (from, to], и момент следующей проверки
+func (rs *ReminderScheduler) collect(from, to time.Time) ([]Reminder, time.Time, error) {
+	next := to.Add(maxSchedulerSleep)
+
+	events, err := rs.store.GetEventsForRange(0, from, to.Add(maxReminderLead))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var due []Reminder
+	for _, event := range events {
+		for _, minutes := range event.Reminders {
+			fireAt := event.Date.Add(-time.Duration(minutes) * time.Minute)
+			switch {
+			case !fireAt.After(from):
+			case !fireAt.After(to):
+				due = append(due, Reminder{
+					EventID:       event.ID,
+					UserID:        event.UserID,
+					Title:         event.Title,
+					Start:         event.Date,
+					MinutesBefore: minutes,
+					FireAt:        fireAt,
+				})
+			case fireAt.Before(next):
+				next = fireAt
+			}
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].FireAt.Equal(due[j].FireAt) {
+			return due[i].EventID < due[j].EventID
+		}
+		return due[i].FireAt.Before(due[j].FireAt)
+	})
+
+	return due, next, nil
+}
+
+// startWorkers запускает по горутине доставки на каждый способ доставки и возвращает их очереди.
+// wait закрывает очереди и дожидается завершения горутин
+func (rs *ReminderScheduler) startWorkers(ctx context.Context) (queues []chan Reminder, wait func()) {
+	var wg sync.WaitGroup
+	queues = make([]chan Reminder, len(rs.notifiers))
+	for i, notifier := range rs.notifiers {
+		queue := make(chan Reminder, reminderQueueSize)
+		queues[i] = queue
+
+		wg.Add(1)
+		go func(notifier Notifier) {
+			defer wg.Done()
+			for reminder := range queue {
+				deliver(ctx, notifier, reminder)
+			}
+		}(notifier)
+	}
+
+	return queues, func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}
+}
+
+// enqueue ставит напоминание в очереди всех способов доставки, не дожидаясь доставки
+func (rs *ReminderScheduler) enqueue(queues []chan Reminder, reminder Reminder) {
+	for _, queue := range queues {
+		select {
+		case queue <- reminder:
+		default:
+			log.Printf("Очередь доставки заполнена, напоминание о событии %d отброшено", reminder.EventID)
+		}
+	}
+}
+
+// deliver отправляет напоминание одним способом доставки. Ошибка записывается в лог и на другие способы не влияет
+func deliver(ctx context.Context, notifier Notifier, reminder Reminder) {
+	if err := notifier.SendNotification(ctx, reminder); err != nil {
+		log.Printf("Ошибка доставки напоминания о событии %d: %v", reminder.EventID, err)
+	}
+}
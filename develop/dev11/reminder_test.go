@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// reminderKey напоминание без времени срабатывания: по нему сравниваются ожидаемые и отправленные напоминания
+func reminderKey(r Reminder) string {
+	return fmt.Sprintf("%d/%d/%s", r.EventID, r.MinutesBefore, r.Start.UTC().Format(time.RFC3339))
+}
+
+// fakeClock часы планировщика, которые переводит тест
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+// newTestScheduler возвращает планировщик над событиями events с часами clock
+func newTestScheduler(t *testing.T, clock *fakeClock, events ...Event) (*ReminderScheduler, []int) {
+	t.Helper()
+
+	store := InitNewEventStore()
+	ids := make([]int, 0, len(events))
+	for _, event := range events {
+		id, err := store.AddEvent(event)
+		if err != nil {
+			t.Fatalf("не удалось создать событие %q: %v", event.Title, err)
+		}
+		ids = append(ids, id)
+	}
+
+	scheduler := NewReminderScheduler(store, nil)
+	scheduler.now = clock.Now
+	return scheduler, ids
+}
+
+func TestReminderCollect(t *testing.T) {
+	clock := &fakeClock{}
+	scheduler, _ := newTestScheduler(t, clock,
+		Event{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0), Reminders: []int{15, 60}},
+		Event{UserID: 2, Title: "Обед", Date: at(2024, time.March, 4, 13, 0), Reminders: []int{0}},
+		Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 4, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}, Reminders: []int{10}},
+	)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+		wantNext time.Time
+	}{
+		{
+			name:     "срабатывание на правой границе",
+			from:     at(2024, time.March, 4, 8, 59),
+			to:       at(2024, time.March, 4, 9, 0),
+			want:     []string{"1/60/2024-03-04T10:00:00Z"},
+			wantNext: at(2024, time.March, 4, 9, 1),
+		},
+		{
+			name:     "левая граница не включается",
+			from:     at(2024, time.March, 4, 9, 0),
+			to:       at(2024, time.March, 4, 9, 0).Add(30 * time.Second),
+			wantNext: at(2024, time.March, 4, 9, 1).Add(30 * time.Second),
+		},
+		{
+			name:     "следующая проверка — ближайшее напоминание",
+			from:     at(2024, time.March, 4, 9, 30),
+			to:       at(2024, time.March, 4, 9, 44).Add(30 * time.Second),
+			wantNext: at(2024, time.March, 4, 9, 45),
+		},
+		{
+			name: "несколько напоминаний по времени срабатывания",
+			from: at(2024, time.March, 4, 9, 0),
+			to:   at(2024, time.March, 4, 13, 0),
+			want: []string{"1/15/2024-03-04T10:00:00Z", "2/0/2024-03-04T13:00:00Z"},
+			// Напоминание о втором вхождении зарядки — в 6:50 следующего дня, позже паузы по умолчанию
+			wantNext: at(2024, time.March, 4, 13, 1),
+		},
+		{
+			name:     "вхождение серии",
+			from:     at(2024, time.March, 5, 6, 0),
+			to:       at(2024, time.March, 5, 6, 50),
+			want:     []string{"3/10/2024-03-05T07:00:00Z"},
+			wantNext: at(2024, time.March, 5, 6, 51),
+		},
+		{
+			name:     "пропущенные при остановке напоминания не отправляются",
+			from:     at(2024, time.March, 7, 0, 0),
+			to:       at(2024, time.March, 7, 0, 1),
+			wantNext: at(2024, time.March, 7, 0, 2),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next, err := scheduler.collect(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(due))
+			for _, reminder := range due {
+				got = append(got, reminderKey(reminder))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(append([]string{}, tt.want...)) {
+				t.Errorf("напоминания %v, ожидались %v", got, tt.want)
+			}
+			if !next.Equal(tt.wantNext) {
+				t.Errorf("следующая проверка %v, ожидалась %v", next, tt.wantNext)
+			}
+		})
+	}
+}
+
+// TestReminderWakeups проверяет, что при проверках в произвольные моменты, в том числе повторных без хода часов,
+// каждое напоминание отправляется ровно один раз, а изменения событий между проверками учитываются
+func TestReminderWakeups(t *testing.T) {
+	clock := &fakeClock{now: at(2024, time.March, 4, 8, 0)}
+	scheduler, ids := newTestScheduler(t, clock,
+		Event{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0), Reminders: []int{15, 60}},
+		Event{UserID: 1, Title: "Обед", Date: at(2024, time.March, 4, 13, 0), Reminders: []int{30}},
+		Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 4, 16, 0), Reminders: []int{60}},
+	)
+	store := scheduler.store
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		change func() error // изменение событий перед проверкой
+		want   []string
+	}{
+		{name: "до напоминаний", now: at(2024, time.March, 4, 8, 59)},
+		{name: "срабатывание", now: at(2024, time.March, 4, 9, 0), want: []string{"1/60/2024-03-04T10:00:00Z"}},
+		{name: "повторная проверка в тот же момент", now: at(2024, time.March, 4, 9, 0)},
+		{name: "проверка после изменения", now: at(2024, time.March, 4, 9, 0).Add(time.Second)},
+		{
+			name: "перенос события",
+			now:  at(2024, time.March, 4, 9, 40),
+			change: func() error {
+				_, err := store.UpdateEvent(1, Event{ID: ids[0], Date: at(2024, time.March, 4, 11, 0)}, UpdateFields("date"))
+				return err
+			},
+		},
+		// Прежнее время напоминания 9:45 больше не наступает, новые — 10:00 и 10:45
+		{name: "старое время напоминания", now: at(2024, time.March, 4, 9, 50)},
+		{name: "новое время напоминания", now: at(2024, time.March, 4, 10, 0), want: []string{"1/60/2024-03-04T11:00:00Z"}},
+		{
+			name:   "удаление события",
+			now:    at(2024, time.March, 4, 12, 40),
+			change: func() error { return store.DeleteEvent(1, ids[1]) },
+			want:   []string{"1/15/2024-03-04T11:00:00Z"},
+		},
+		{name: "после удалённого события", now: at(2024, time.March, 4, 15, 0), want: []string{"3/60/2024-03-04T16:00:00Z"}},
+	}
+
+	sent := map[string]int{}
+	last := clock.Now()
+	for _, tt := range tests {
+		if tt.change != nil {
+			if err := tt.change(); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+
+		clock.now = tt.now
+		due, _, err := scheduler.collect(last, scheduler.now())
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		last = tt.now
+
+		got := make([]string, 0, len(due))
+		for _, reminder := range due {
+			got = append(got, reminderKey(reminder))
+			sent[reminderKey(reminder)]++
+		}
+		if fmt.Sprint(got) != fmt.Sprint(append([]string{}, tt.want...)) {
+			t.Errorf("%s: напоминания %v, ожидались %v", tt.name, got, tt.want)
+		}
+	}
+
+	for key, count := range sent {
+		if count != 1 {
+			t.Errorf("напоминание %s отправлено %d раз", key, count)
+		}
+	}
+}
+
+// blockingNotifier способ доставки, который не возвращается до отмены контекста
+type blockingNotifier struct{}
+
+func (blockingNotifier) SendNotification(ctx context.Context, _ Reminder) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// channelNotifier способ доставки, передающий напоминания в канал
+type channelNotifier chan Reminder
+
+func (c channelNotifier) SendNotification(_ context.Context, reminder Reminder) error {
+	c <- reminder
+	return nil
+}
+
+// TestReminderSchedulerRun проверяет, что зависший способ доставки не задерживает остальные и что изменение событий
+// будит планировщик
+func TestReminderSchedulerRun(t *testing.T) {
+	store := InitNewEventStore()
+	delivered := make(channelNotifier, 10)
+	scheduler := NewReminderScheduler(store, []Notifier{blockingNotifier{}, delivered})
+	scheduler.debounce = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	// Напоминания срабатывают вскоре после запуска; планировщик узнаёт о них только из уведомлений об изменениях
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now().Add(time.Minute).Add(100 * time.Millisecond).Truncate(time.Millisecond)
+	for _, title := range []string{"Планёрка", "Созвон"} {
+		if _, err := store.AddEvent(Event{UserID: 1, Title: title, Date: start, Reminders: []int{1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case reminder := <-delivered:
+			if !reminder.Start.Equal(start) {
+				t.Errorf("напоминание %+v, ожидалось о событии в %v", reminder, start)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("напоминание %d не доставлено: доставка ждёт зависший способ доставки", i+1)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run не завершился после отмены контекста")
+	}
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	  = == ==                                  == == =
+	= ==== УЧАСТНИКИ СОБЫТИЙ И ОБЩИЕ КАЛЕНДАРИ ==== =
+	  = == ==                                  == == =
+*/
+
+// RSVPStatus ответ участника на приглашение
+type RSVPStatus string
+
+// Ответы участников
+const (
+	RSVPNeedsAction RSVPStatus = "needs_action" // участник ещё не ответил
+	RSVPAccepted    RSVPStatus = "accepted"
+	RSVPDeclined    RSVPStatus = "declined"
+	RSVPTentative   RSVPStatus = "tentative"
+)
+
+// Attendee участник события. Событие видно участнику в его выдаче наравне с собственными событиями
+type Attendee struct {
+	UserID int        `json:"user_id"`
+	Status RSVPStatus `json:"status,omitempty"`
+}
+
+// Permission уровень доступа к календарю другого пользователя
+type Permission string
+
+// Уровни доступа
+const (
+	PermissionRead  Permission = "read"  // просмотр событий календаря
+	PermissionWrite Permission = "write" // просмотр, создание, изменение и удаление событий календаря
+)
+
+// allows сообщает, включает ли доступ p доступ need
+func (p Permission) allows(need Permission) bool {
+	return p == PermissionWrite || p == need
+}
+
+// Share доступ пользователя SharedWith к календарю пользователя OwnerID
+type Share struct {
+	OwnerID    int        `json:"owner_id"`
+	SharedWith int        `json:"shared_with"`
+	Permission Permission `json:"permission"`
+}
+
+// attendee возвращает участника события с ID пользователя userID
+func (e Event) attendee(userID int) (Attendee, bool) {
+	for _, attendee := range e.Attendees {
+		if attendee.UserID == userID {
+			return attendee, true
+		}
+	}
+	return Attendee{}, false
+}
+
+// visibleTo сообщает, видно ли событие пользователю userID: владельцу или участнику
+func (e Event) visibleTo(userID int) bool {
+	_, attends := e.attendee(userID)
+	return e.UserID == userID || attends
+}
+
+// declinedBy сообщает, отказался ли пользователь userID от участия в событии. Такое событие
+// остаётся в его выдаче, но не занимает его время
+func (e Event) declinedBy(userID int) bool {
+	attendee, ok := e.attendee(userID)
+	return ok && attendee.Status == RSVPDeclined
+}
+
+// prepareAttendees проверяет список участников события и переносит в него ответы из previous.
+// Ответ меняет только сам участник через RespondToEvent, поэтому ответы из запроса владельца не учитываются,
+// а новые участники получают RSVPNeedsAction
+func prepareAttendees(event *Event, previous []Attendee) error {
+	if len(event.Attendees) == 0 {
+		event.Attendees = nil
+		return nil
+	}
+
+	responses := make(map[int]RSVPStatus, len(previous))
+	for _, attendee := range previous {
+		responses[attendee.UserID] = attendee.Status
+	}
+
+	seen := make(map[int]bool, len(event.Attendees))
+	attendees := make([]Attendee, 0, len(event.Attendees))
+	for _, attendee := range event.Attendees {
+		switch {
+		case attendee.UserID < 1:
+			return validationErrorf("некорректный user_id участника: %d", attendee.UserID)
+		case attendee.UserID == event.UserID:
+			return validationErrorf("владелец события не указывается среди участников")
+		case seen[attendee.UserID]:
+			return validationErrorf("участник %d указан несколько раз", attendee.UserID)
+		}
+		seen[attendee.UserID] = true
+
+		status, ok := responses[attendee.UserID]
+		if !ok {
+			status = RSVPNeedsAction
+		}
+		attendees = append(attendees, Attendee{UserID: attendee.UserID, Status: status})
+	}
+
+	event.Attendees = attendees
+	return nil
+}
+
+// ParseAttendees разбирает список участников из поля формы: ID пользователей через запятую
+func ParseAttendees(value string) ([]Attendee, error) {
+	var attendees []Attendee
+	for _, part := range strings.Split(value, ",") {
+		userID, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || userID < 1 {
+			return nil, fmt.Errorf("attendees: ожидаются ID пользователей через запятую, получено %q", part)
+		}
+		attendees = append(attendees, Attendee{UserID: userID})
+	}
+	return attendees, nil
+}
+
+// RespondToEvent сохраняет ответ участника userID на приглашение в событие eventID. Ответ на повторяющееся
+// событие относится ко всей серии. Из параметров opts учитываются только IfMatch и Actor. Возвращает записанное событие
+func (es *EventStore) RespondToEvent(userID, eventID int, status RSVPStatus, opts ...WriteOption) (Event, error) {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return Event{}, err
+	}
+
+	switch status {
+	case RSVPAccepted, RSVPDeclined, RSVPTentative:
+	default:
+		return Event{}, validationErrorf("status должен быть accepted, declined или tentative, получено %q", status)
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	event, exists, err := es.storage.GetEvent(eventID)
+	if err != nil {
+		return Event{}, storageError(err)
+	}
+	if !exists {
+		return Event{}, notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	if _, ok := event.attendee(userID); !ok {
+		return Event{}, forbiddenErrorf("пользователь %d не приглашён в событие с ID %d", userID, eventID)
+	}
+
+	if err := checkVersion(event, options); err != nil {
+		return Event{}, err
+	}
+
+	attendees := make([]Attendee, len(event.Attendees))
+	for i, attendee := range event.Attendees {
+		if attendee.UserID == userID {
+			attendee.Status = status
+		}
+		attendees[i] = attendee
+	}
+
+	before := event
+	event.Attendees = attendees
+	event.UpdatedAt = time.Now()
+	event.Version++
+
+	if err := es.execute(options.actorOr(userID), &updateCommand{storage: es.storage, before: before, after: event}); err != nil {
+		return Event{}, err
+	}
+
+	localizeEvent(&event)
+	return event, nil
+}
+
+// KnownUsers проверяет, что пользователь, которому выдаётся доступ к календарю, есть в справочнике users
+func KnownUsers(users UserDirectory) WriteOption {
+	return func(o *writeOptions) error { o.users = users; return nil }
+}
+
+// ShareCalendar выдаёт пользователю share.SharedWith доступ к календарю пользователя share.OwnerID
+// или меняет уровень уже выданного доступа и записывает это в журнал аудита. Из параметров opts учитываются
+// только Actor и KnownUsers
+func (es *EventStore) ShareCalendar(share Share, opts ...WriteOption) error {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case share.OwnerID < 1 || share.SharedWith < 1:
+		return validationErrorf("должны быть указаны владелец календаря и пользователь, которому выдаётся доступ")
+	case share.OwnerID == share.SharedWith:
+		return validationErrorf("у владельца календаря уже есть полный доступ к нему")
+	case share.Permission != PermissionRead && share.Permission != PermissionWrite:
+		return validationErrorf("permission должен быть read или write, получено %q", share.Permission)
+	case options.users != nil && !options.users.UserExists(share.SharedWith):
+		return validationErrorf("пользователь %d не найден", share.SharedWith)
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	command := &shareCommand{storage: es.storage, after: &share}
+	previous, exists, err := es.storage.GetShare(share.OwnerID, share.SharedWith)
+	if err != nil {
+		return storageError(err)
+	}
+	if exists {
+		command.before = &previous
+	}
+
+	return es.execute(options.actorOr(share.OwnerID), command)
+}
+
+// UnshareCalendar отменяет доступ пользователя userID к календарю пользователя ownerID и записывает это в журнал
+// аудита. Из параметров opts учитывается только Actor
+func (es *EventStore) UnshareCalendar(ownerID, userID int, opts ...WriteOption) error {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	previous, exists, err := es.storage.GetShare(ownerID, userID)
+	if err != nil {
+		return storageError(err)
+	}
+	if !exists {
+		return notFoundErrorf("у пользователя %d нет доступа к календарю пользователя %d", userID, ownerID)
+	}
+
+	return es.execute(options.actorOr(ownerID), &shareCommand{storage: es.storage, before: &previous})
+}
+
+// GetShares возвращает доступы к календарю пользователя userID и выданные ему доступы к чужим календарям
+func (es *EventStore) GetShares(userID int) ([]Share, error) {
+	es.RLock()
+	defer es.RUnlock()
+
+	shares, err := es.storage.GetShares(userID)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	if shares == nil {
+		shares = []Share{}
+	}
+	return shares, nil
+}
+
+// CalendarPermission возвращает уровень доступа пользователя userID к календарю пользователя ownerID.
+// Владельцу календаря доступно всё
+func (es *EventStore) CalendarPermission(ownerID, userID int) (Permission, bool, error) {
+	if ownerID == userID {
+		return PermissionWrite, true, nil
+	}
+
+	es.RLock()
+	defer es.RUnlock()
+
+	share, exists, err := es.storage.GetShare(ownerID, userID)
+	if err != nil {
+		return "", false, storageError(err)
+	}
+	return share.Permission, exists, nil
+}
+
+// === HTTP ===
+
+// sharesPath адрес управления доступом к календарю
+const sharesPath = "/shares"
+
+// AuthorizeCalendar проверяет, что запрос вправе работать с календарём пользователя ownerID с уровнем доступа need:
+// это сам владелец или пользователь, которому владелец выдал такой доступ. Без настроенной аутентификации доверяет
+// переданному user_id.
+func (s *Server) AuthorizeCalendar(r *http.Request, ownerID int, need Permission) error {
+	authenticated, ok := AuthenticatedUserID(r.Context())
+	if !ok {
+		return nil
+	}
+
+	permission, ok, err := s.Calendar.CalendarPermission(ownerID, authenticated)
+	if err != nil {
+		return err
+	}
+	if ok && permission.allows(need) {
+		return nil
+	}
+
+	return &HTTPError{
+		Status: http.StatusForbidden,
+		Code:   "forbidden",
+		Err:    fmt.Errorf("нет доступа к календарю пользователя %d", ownerID),
+	}
+}
+
+// userOptions возвращает параметр KnownUsers, если аутентификация знает пользователей. Без неё пользователи
+// никак не зарегистрированы, и доступ выдаётся любому ID
+func (s *Server) userOptions() []WriteOption {
+	if users, ok := s.Auth.(UserDirectory); ok {
+		return []WriteOption{KnownUsers(users)}
+	}
+	return nil
+}
+
+// RSVPRequest тело запроса POST /events/{id}/rsvp
+type RSVPRequest struct {
+	UserID int        `json:"user_id"`
+	Status RSVPStatus `json:"status"`
+}
+
+// decodeRSVP разбирает тело запроса ответа на приглашение из JSON или формы
+func (s *Server) decodeRSVP(r *http.Request) (RSVPRequest, error) {
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return RSVPRequest{}, ErrUnsupportedMediaType
+		}
+	}
+
+	var request RSVPRequest
+	switch mediaType {
+	case "application/json":
+		if err := s.DecodeJSONBody(r, &request); err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				return RSVPRequest{}, tooLarge
+			}
+			return RSVPRequest{}, badRequestf("%v", err)
+		}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				return RSVPRequest{}, tooLarge
+			}
+			return RSVPRequest{}, badRequestf("%v", err)
+		}
+		if value := r.PostForm.Get("user_id"); value != "" {
+			userID, err := s.ValidateUserID(value)
+			if err != nil {
+				return RSVPRequest{}, badRequestf("%v", err)
+			}
+			request.UserID = userID
+		}
+		request.Status = RSVPStatus(r.PostForm.Get("status"))
+	default:
+		return RSVPRequest{}, ErrUnsupportedMediaType
+	}
+
+	return request, nil
+}
+
+// rsvpHandler сохраняет ответ участника на приглашение:
+//
+//	POST /events/{id}/rsvp  {"user_id": 2, "status": "accepted|declined|tentative"}
+//
+// Отвечает участник события (user_id или аутентифицированный пользователь); в ответе — событие с обновлённым
+// списком участников и его ETag. С If-Match ответ сохраняется, только если событие не изменилось
+func (s *Server) rsvpHandler(w http.ResponseWriter, r *http.Request, eventID int) {
+	request, err := s.decodeRSVP(r)
+	if err != nil {
+		s.RespondWithAPIError(w, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err))
+		return
+	}
+
+	userID, err := s.BodyUserID(r, request.UserID)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	opts := append(s.ifMatchOptions(r), s.actorOptions(r)...)
+	event, err := s.Calendar.RespondToEvent(userID, eventID, request.Status, opts...)
+	if err != nil {
+		s.RespondWithAPIError(w, err)
+		return
+	}
+
+	s.respondWithEvent(w, event, nil)
+}
+
+// SharesHandler управляет доступом к календарю пользователя:
+//
+//	GET    /shares?user_id=1                 доступы к календарю пользователя и выданные ему доступы
+//	POST   /shares  {"user_id": 1, "shared_with": 2, "permission": "read|write"}  выдача или изменение доступа
+//	DELETE /shares?user_id=1&shared_with=2   отмена доступа
+//
+// Выдавать и отменять доступ может только владелец календаря
+func (s *Server) SharesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID, err := s.ParseQueryUserID(r)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		if err := s.AuthorizeUser(r, userID); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		shares, err := s.Calendar.GetShares(userID)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		s.RespondWithResult(w, shares)
+
+	case http.MethodPost:
+		var request struct {
+			UserID     int        `json:"user_id"`
+			SharedWith int        `json:"shared_with"`
+			Permission Permission `json:"permission"`
+		}
+		if err := s.DecodeJSONBody(r, &request); err != nil {
+			if tooLarge := bodyTooLarge(err); tooLarge != nil {
+				s.RespondWithAPIError(w, tooLarge)
+				return
+			}
+			s.RespondWithAPIError(w, badRequestf("Ошибка в ходе парсинга входных параметров: %v", err))
+			return
+		}
+
+		ownerID, err := s.BodyUserID(r, request.UserID)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		if err := s.AuthorizeUser(r, ownerID); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		share := Share{OwnerID: ownerID, SharedWith: request.SharedWith, Permission: request.Permission}
+		if err := s.Calendar.ShareCalendar(share, append(s.actorOptions(r), s.userOptions()...)...); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		s.RespondWithResult(w, share)
+
+	case http.MethodDelete:
+		ownerID, err := s.ParseQueryUserID(r)
+		if err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		if err := s.AuthorizeUser(r, ownerID); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		sharedWith, err := s.ValidateUserID(r.URL.Query().Get("shared_with"))
+		if err != nil {
+			s.RespondWithAPIError(w, badRequestf("shared_with: %v", err))
+			return
+		}
+
+		if err := s.Calendar.UnshareCalendar(ownerID, sharedWith, s.actorOptions(r)...); err != nil {
+			s.RespondWithAPIError(w, err)
+			return
+		}
+
+		s.RespondWithResult(w, "доступ отменён")
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		s.RespondWithAPIError(w, methodNotAllowed)
+	}
+}
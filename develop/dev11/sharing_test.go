@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAttendees(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2, "carol": 3}}
+	as := func(token string) map[string]string { return map[string]string{"Authorization": "Bearer " + token} }
+
+	// Алиса приглашает Боба и Кэрол; присланные ею ответы за участников не сохраняются
+	resp, body := ts.do(t, testRequest{method: "POST", path: "/api/v1/events", contentType: jsonType, header: as("alice"),
+		body: `{"title":"Ретро","date":"2024-03-05T15:00:00Z","end":"2024-03-05T16:00:00Z","attendees":[{"user_id":2,"status":"accepted"},{"user_id":3}]}`})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("создание: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+
+	var created Event
+	if err := json.Unmarshal(decodeResponse(t, body).Result, &created); err != nil {
+		t.Fatal(err)
+	}
+	for _, attendee := range created.Attendees {
+		if attendee.Status != RSVPNeedsAction {
+			t.Errorf("участник %d: статус %q, ожидался needs_action", attendee.UserID, attendee.Status)
+		}
+	}
+
+	// Событие видно участнику в его выдаче и по адресу события
+	resp, body = ts.do(t, testRequest{method: "GET", path: "/events_for_day?date=2024-03-05", header: as("bob")})
+	expectTitles("Ретро")(t, resp, body)
+
+	if resp, body := ts.do(t, testRequest{method: "GET", path: location, header: as("carol")}); resp.StatusCode != http.StatusOK {
+		t.Errorf("участник не видит событие: код ответа %d\n%s", resp.StatusCode, body)
+	}
+
+	// Изменять событие участник не может
+	if resp, _ := ts.do(t, testRequest{method: "PATCH", path: location, contentType: jsonType, header: as("bob"), body: `{"user_id":1,"title":"x"}`}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("изменение участником: код ответа %d, ожидался 403", resp.StatusCode)
+	}
+
+	rsvpPath := "/events/" + location[len(apiEventsPath)+1:] + "/rsvp"
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"участник принимает", "bob", `{"status":"accepted"}`, http.StatusOK},
+		{"участник отказывается формой", "carol", "status=declined", http.StatusOK},
+		{"неизвестный ответ", "bob", `{"status":"maybe"}`, http.StatusUnprocessableEntity},
+		{"не участник", "alice", `{"status":"accepted"}`, http.StatusForbidden},
+		{"за другого участника", "bob", `{"user_id":3,"status":"accepted"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType := jsonType
+			if tt.body[0] != '{' {
+				contentType = formType
+			}
+
+			resp, body := ts.do(t, testRequest{method: "POST", path: rsvpPath, contentType: contentType, header: as(tt.token), body: tt.body})
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("код ответа %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	event, err := ts.server.Calendar.GetEvent(1, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Attendee{{UserID: 2, Status: RSVPAccepted}, {UserID: 3, Status: RSVPDeclined}}
+	if len(event.Attendees) != 2 || event.Attendees[0] != want[0] || event.Attendees[1] != want[1] {
+		t.Errorf("участники %+v, ожидались %+v", event.Attendees, want)
+	}
+
+	// Изменение списка участников владельцем сохраняет ответы оставшихся
+	if _, err := ts.server.Calendar.UpdateEvent(1, Event{ID: created.ID, Attendees: []Attendee{{UserID: 3}, {UserID: 4}}}); err != nil {
+		t.Fatal(err)
+	}
+	event, _ = ts.server.Calendar.GetEvent(1, created.ID)
+	want = []Attendee{{UserID: 3, Status: RSVPDeclined}, {UserID: 4, Status: RSVPNeedsAction}}
+	if len(event.Attendees) != 2 || event.Attendees[0] != want[0] || event.Attendees[1] != want[1] {
+		t.Errorf("участники после изменения %+v, ожидались %+v", event.Attendees, want)
+	}
+
+	// Отказавшийся участник по-прежнему видит событие, но его время свободно
+	if busy, err := ts.server.Calendar.FreeBusy(3, at(2024, time.March, 5, 0, 0), at(2024, time.March, 6, 0, 0)); err != nil || len(busy) != 0 {
+		t.Errorf("занятость отказавшегося участника %+v, ожидалась пустая", busy)
+	}
+	if busy, err := ts.server.Calendar.FreeBusy(4, at(2024, time.March, 5, 0, 0), at(2024, time.March, 6, 0, 0)); err != nil || len(busy) != 1 {
+		t.Errorf("занятость участника %+v, ожидался один интервал", busy)
+	}
+}
+
+func TestAttendeesValidation(t *testing.T) {
+	store := InitNewEventStore()
+
+	tests := []struct {
+		name      string
+		attendees []Attendee
+	}{
+		{"владелец среди участников", []Attendee{{UserID: 1}}},
+		{"повтор участника", []Attendee{{UserID: 2}, {UserID: 2}}},
+		{"некорректный ID", []Attendee{{UserID: 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.AddEvent(Event{UserID: 1, Title: "x", Date: at(2024, time.March, 5, 10, 0), Attendees: tt.attendees})
+			if !errors.Is(err, ErrValidation) {
+				t.Errorf("AddEvent() error %v, expected validation error", err)
+			}
+		})
+	}
+}
+
+func TestSharedCalendars(t *testing.T) {
+	ts := newTestServer(t)
+	ts.server.Auth = &TokenAuthenticator{Tokens: map[string]int{"alice": 1, "bob": 2, "carol": 3}}
+	as := func(token string) map[string]string { return map[string]string{"Authorization": "Bearer " + token} }
+
+	for _, share := range []string{`{"shared_with":2,"permission":"read"}`, `{"shared_with":3,"permission":"write"}`} {
+		if resp, body := ts.do(t, testRequest{method: "POST", path: "/shares", contentType: jsonType, header: as("alice"), body: share}); resp.StatusCode != http.StatusOK {
+			t.Fatalf("выдача доступа: код ответа %d\n%s", resp.StatusCode, body)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		req        testRequest
+		wantStatus int
+	}{
+		{"чтение с доступом read", testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-04", header: as("bob")}, http.StatusOK},
+		{"чтение с доступом write", testRequest{method: "GET", path: "/api/v1/events/1?user_id=1", header: as("carol")}, http.StatusOK},
+		{"запись с доступом read", testRequest{method: "POST", path: "/create_event", contentType: jsonType, header: as("bob"), body: `{"user_id":1,"title":"x","date":"2024-03-06T10:00:00Z"}`}, http.StatusForbidden},
+		{"запись с доступом write", testRequest{method: "POST", path: "/create_event", contentType: jsonType, header: as("carol"), body: `{"user_id":1,"title":"x","date":"2024-03-06T10:00:00Z"}`}, http.StatusOK},
+		{"изменение с доступом write", testRequest{method: "PATCH", path: "/api/v1/events/1", contentType: jsonType, header: as("carol"), body: `{"user_id":1,"title":"y"}`}, http.StatusOK},
+		{"чтение без доступа", testRequest{method: "GET", path: "/events_for_day?user_id=2&date=2024-03-04", header: as("carol")}, http.StatusForbidden},
+		{"выдача доступа к чужому календарю", testRequest{method: "POST", path: "/shares", contentType: jsonType, header: as("carol"), body: `{"user_id":1,"shared_with":2,"permission":"write"}`}, http.StatusForbidden},
+		{"некорректный уровень доступа", testRequest{method: "POST", path: "/shares", contentType: jsonType, header: as("alice"), body: `{"shared_with":2,"permission":"admin"}`}, http.StatusUnprocessableEntity},
+		{"доступ неизвестному пользователю", testRequest{method: "POST", path: "/shares", contentType: jsonType, header: as("alice"), body: `{"shared_with":9,"permission":"read"}`}, http.StatusUnprocessableEntity},
+		{"отмена несуществующего доступа", testRequest{method: "DELETE", path: "/shares?shared_with=4", header: as("alice")}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp, body := ts.do(t, tt.req); resp.StatusCode != tt.wantStatus {
+				t.Errorf("код ответа %d, ожидался %d\n%s", resp.StatusCode, tt.wantStatus, body)
+			}
+		})
+	}
+
+	resp, body := ts.do(t, testRequest{method: "GET", path: "/shares", header: as("bob")})
+	var shares []Share
+	if err := json.Unmarshal(decodeResponse(t, body).Result, &shares); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("список доступов: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	if len(shares) != 1 || shares[0] != (Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}) {
+		t.Errorf("доступы Боба %+v", shares)
+	}
+
+	if resp, body := ts.do(t, testRequest{method: "DELETE", path: "/shares?shared_with=2", header: as("alice")}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("отмена доступа: код ответа %d\n%s", resp.StatusCode, body)
+	}
+	if resp, _ := ts.do(t, testRequest{method: "GET", path: "/events_for_week?user_id=1&date=2024-03-04", header: as("bob")}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("чтение после отмены доступа: код ответа %d, ожидался 403", resp.StatusCode)
+	}
+}
+
+func TestShareAudit(t *testing.T) {
+	audit := InitNewMemoryAuditLog()
+	store := InitNewEventStoreWithStorage(InitNewMemoryStorage(), audit)
+
+	if err := store.ShareCalendar(Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ShareCalendar(Share{OwnerID: 1, SharedWith: 2, Permission: PermissionWrite}, Actor(3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UnshareCalendar(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []AuditEntry{
+		{Operation: 1, ActorID: 1, Action: AuditShared, Share: &Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}},
+		{Operation: 2, ActorID: 3, Action: AuditShared, Share: &Share{OwnerID: 1, SharedWith: 2, Permission: PermissionWrite}},
+		{Operation: 3, ActorID: 1, Action: AuditUnshared, Share: &Share{OwnerID: 1, SharedWith: 2, Permission: PermissionWrite}},
+	}
+	for _, expected := range want {
+		entries, err := audit.Operation(expected.Operation)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("операция %d: записи %+v", expected.Operation, entries)
+		}
+		got := entries[0]
+		if got.ActorID != expected.ActorID || got.Action != expected.Action || got.Share == nil || *got.Share != *expected.Share {
+			t.Errorf("операция %d: запись %+v, доступ %+v, ожидались %+v, %+v", expected.Operation, got, got.Share, expected, expected.Share)
+		}
+	}
+
+	// Записи о доступах лежат в журнале под нулевым EventID, но историей события не являются
+	if _, err := store.History(1, 0); !errors.Is(err, ErrValidation) {
+		t.Errorf("History() события 0: ошибка %v, ожидалась validation", err)
+	}
+	if _, err := store.RestoreEvent(1, 0); !errors.Is(err, ErrValidation) {
+		t.Errorf("RestoreEvent() события 0: ошибка %v, ожидалась validation", err)
+	}
+
+	// Доступ, который не удалось записать в журнал аудита, не выдаётся, а отменённый — возвращается
+	if err := store.ShareCalendar(Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	store.audit = failingAuditLog{audit}
+	if err := store.ShareCalendar(Share{OwnerID: 1, SharedWith: 3, Permission: PermissionRead}); err == nil {
+		t.Error("ShareCalendar() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	if err := store.UnshareCalendar(1, 2); err == nil {
+		t.Error("UnshareCalendar() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	if shares, err := store.GetShares(1); err != nil || len(shares) != 1 || shares[0] != (Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}) {
+		t.Errorf("доступы после отменённых изменений %+v", shares)
+	}
+}
+
+func TestFileStorageShares(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+
+	storage, err := OpenFileStorage(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := InitNewEventStoreWithStorage(storage, InitNewMemoryAuditLog())
+
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 5, 15, 0), Attendees: []Attendee{{UserID: 2}}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, share := range []Share{{1, 2, PermissionRead}, {1, 3, PermissionWrite}, {2, 3, PermissionRead}} {
+		if err := store.ShareCalendar(share); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.UnshareCalendar(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStorage(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	store = InitNewEventStoreWithStorage(reopened, InitNewMemoryAuditLog())
+
+	if shares, err := store.GetShares(3); err != nil || len(shares) != 1 || shares[0] != (Share{2, 3, PermissionRead}) {
+		t.Errorf("доступы после перезапуска %+v", shares)
+	}
+	if events, err := store.GetEventsByDate(2, at(2024, time.March, 5, 0, 0)); err != nil || len(events) != 1 {
+		t.Errorf("событий участника после перезапуска %d, ожидалось 1", len(events))
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+/*
+	  = == ==           == == =
+	= ==== ХРАНЕНИЕ СОБЫТИЙ ==== =
+	  = == ==           == == =
+*/
+
+// Storage описывает хранилище событий. Хранилище не содержит бизнес-логики. Изменения сериализует EventStore,
+// а чтения он выполняет параллельно, поэтому методы чтения не должны изменять состояние хранилища.
+// Изменения выполняются в единице работы (Begin, Commit, Rollback): вместе с ними фиксируется журнал аудита
+// хранилища, а при откате не сохраняется ничего. Ошибки чтения — сбои самого хранилища
+type Storage interface {
+	// Begin начинает единицу работы. Единица работы одна на хранилище: следующую можно начать после Commit или Rollback
+	Begin() error
+	// Commit фиксирует изменения единицы работы. Если фиксация не удалась, единица работы остаётся открытой
+	// и отменяется через Rollback
+	Commit() error
+	// Rollback отменяет изменения единицы работы
+	Rollback() error
+	// AddEvent сохраняет событие, присваивая ему следующий свободный ID
+	AddEvent(event Event) (int, error)
+	// UpdateEvent заменяет сохранённое ранее событие с тем же ID
+	UpdateEvent(event Event) error
+	// DeleteEvent удаляет событие по ID
+	DeleteEvent(eventID int) error
+	// RestoreEvent возвращает удалённое событие под его прежним ID
+	RestoreEvent(event Event) error
+	// GetEvent возвращает событие по ID
+	GetEvent(eventID int) (Event, bool, error)
+	// GetEventsForRange возвращает события пользователя userID, пересекающиеся с отрезком [start, end] (см. eventOverlaps):
+	// многодневное событие попадает в каждый день, который занимает. При userID < 1 возвращаются события всех пользователей
+	GetEventsForRange(userID int, start, end time.Time) ([]Event, error)
+	// GetRecurringEvents возвращает все события пользователя userID (при userID < 1 — всех пользователей),
+	// имеющие правило повторения
+	GetRecurringEvents(userID int) ([]Event, error)
+	// GetAttendedEvents возвращает события других пользователей, в которых пользователь userID указан участником
+	GetAttendedEvents(userID int) ([]Event, error)
+	// PutShare сохраняет доступ к календарю, заменяя прежний доступ того же пользователя к тому же календарю
+	PutShare(share Share) error
+	// DeleteShare отменяет доступ пользователя userID к календарю пользователя ownerID
+	DeleteShare(ownerID, userID int) error
+	// GetShares возвращает доступы к календарю пользователя userID и доступы, выданные ему к чужим календарям
+	GetShares(userID int) ([]Share, error)
+	// GetShare возвращает доступ пользователя userID к календарю пользователя ownerID
+	GetShare(ownerID, userID int) (Share, bool, error)
+	// Close освобождает ресурсы хранилища
+	Close() error
+}
+
+// errUnitStarted и errNoUnit ошибки порядка вызовов единицы работы
+var (
+	errUnitStarted = errors.New("единица работы хранилища уже начата")
+	errNoUnit      = errors.New("единица работы хранилища не начата")
+)
+
+// === MemoryStorage (хранилище событий в памяти) ===
+
+// indexEntry элемент упорядоченного по времени индекса событий пользователя
+type indexEntry struct {
+	date time.Time
+	id   int
+}
+
+// before задаёт порядок индекса: по дате, при равенстве дат — по ID
+func (e indexEntry) before(other indexEntry) bool {
+	if e.date.Equal(other.date) {
+		return e.id < other.id
+	}
+	return e.date.Before(other.date)
+}
+
+// MemoryStorage хранит события в памяти и теряет их при перезапуске.
+// Для каждого пользователя поддерживается упорядоченный по дате срез, поэтому выборка за период
+// занимает O(log n + k), где k — количество событий, начинающихся в периоде или не раньше чем за наибольшую
+// продолжительность события пользователя до него.
+type MemoryStorage struct {
+	Events    map[int]Event
+	NextID    int
+	Shares    map[shareKey]Share
+	byUser    map[int][]indexEntry          // события пользователя, упорядоченные по (Date, ID)
+	durations map[int]map[time.Duration]int // количество событий пользователя каждой ненулевой продолжительности
+	longest   map[int]time.Duration         // наибольшая продолжительность события пользователя
+	recurring map[int]map[int]struct{}      // ID событий пользователя, имеющих правило повторения
+	attending map[int]map[int]struct{}      // ID событий, в которых пользователь указан участником
+	unit      *memoryUnit                   // открытая единица работы, nil вне её
+}
+
+// memoryUnit единица работы MemoryStorage: прежние состояния событий и доступов, изменённых в ней, по которым
+// Rollback возвращает хранилище к началу единицы работы
+type memoryUnit struct {
+	nextID int
+	events map[int]*Event      // событие до первого изменения в единице работы; nil — события не было
+	shares map[shareKey]*Share // доступ до первого изменения в единице работы; nil — доступа не было
+}
+
+// shareKey ключ доступа к календарю: владелец календаря и пользователь, которому выдан доступ
+type shareKey struct {
+	ownerID int
+	userID  int
+}
+
+// InitNewMemoryStorage возвращает указатель на новую структуру MemoryStorage с сначальным значением NextID = 1
+func InitNewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		Events:    make(map[int]Event),
+		NextID:    1,
+		Shares:    make(map[shareKey]Share),
+		byUser:    make(map[int][]indexEntry),
+		durations: make(map[int]map[time.Duration]int),
+		longest:   make(map[int]time.Duration),
+		recurring: make(map[int]map[int]struct{}),
+		attending: make(map[int]map[int]struct{}),
+	}
+}
+
+// Begin начинает единицу работы
+func (ms *MemoryStorage) Begin() error {
+	if ms.unit != nil {
+		return errUnitStarted
+	}
+
+	ms.unit = &memoryUnit{nextID: ms.NextID, events: make(map[int]*Event), shares: make(map[shareKey]*Share)}
+	return nil
+}
+
+// Commit фиксирует изменения единицы работы: они уже применены, поэтому забывается только их прежнее состояние
+func (ms *MemoryStorage) Commit() error {
+	if ms.unit == nil {
+		return errNoUnit
+	}
+
+	ms.unit = nil
+	return nil
+}
+
+// Rollback возвращает изменённые в единице работы события и доступы к прежнему состоянию
+func (ms *MemoryStorage) Rollback() error {
+	unit := ms.unit
+	if unit == nil {
+		return errNoUnit
+	}
+	ms.unit = nil
+
+	for id, event := range unit.events {
+		if event == nil {
+			ms.remove(id)
+		} else {
+			ms.put(*event)
+		}
+	}
+	for key, share := range unit.shares {
+		if share == nil {
+			delete(ms.Shares, key)
+		} else {
+			ms.Shares[key] = *share
+		}
+	}
+	ms.NextID = unit.nextID
+
+	return nil
+}
+
+// AddEvent сохраняет событие под следующим свободным ID
+func (ms *MemoryStorage) AddEvent(event Event) (int, error) {
+	event.ID = ms.NextID
+
+	ms.put(event)
+
+	return event.ID, nil
+}
+
+// UpdateEvent заменяет событие с тем же ID
+func (ms *MemoryStorage) UpdateEvent(event Event) error {
+	if _, exists := ms.Events[event.ID]; !exists {
+		return notFoundErrorf("событие с ID %d не найдено", event.ID)
+	}
+
+	ms.put(event)
+
+	return nil
+}
+
+// DeleteEvent удаляет событие по ID
+func (ms *MemoryStorage) DeleteEvent(eventID int) error {
+	if _, exists := ms.Events[eventID]; !exists {
+		return notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	ms.remove(eventID)
+
+	return nil
+}
+
+// RestoreEvent сохраняет удалённое событие под прежним ID
+func (ms *MemoryStorage) RestoreEvent(event Event) error {
+	if _, exists := ms.Events[event.ID]; exists {
+		return conflictErrorf("событие с ID %d не удалено", event.ID)
+	}
+
+	ms.put(event)
+
+	return nil
+}
+
+// GetEvent возвращает событие по ID
+func (ms *MemoryStorage) GetEvent(eventID int) (Event, bool, error) {
+	event, exists := ms.Events[eventID]
+	return event, exists, nil
+}
+
+// GetEventsForRange возвращает события пользователя, пересекающиеся с отрезком [start, end],
+// упорядоченные по дате и ID
+func (ms *MemoryStorage) GetEventsForRange(userID int, start, end time.Time) ([]Event, error) {
+	if userID > 0 {
+		return ms.rangeOf(userID, start, end), nil
+	}
+
+	var result []Event
+	for user := range ms.byUser {
+		result = append(result, ms.rangeOf(user, start, end)...)
+	}
+
+	return result, nil
+}
+
+// rangeOf выбирает из индекса пользователя события, пересекающиеся с отрезком [start, end]. Просмотр начинается
+// за наибольшую продолжительность события пользователя до start: раньше начинаются только уже закончившиеся события
+func (ms *MemoryStorage) rangeOf(userID int, start, end time.Time) []Event {
+	index := ms.byUser[userID]
+	from := start.Add(-ms.longest[userID])
+	first := sort.Search(len(index), func(i int) bool { return !index[i].date.Before(from) })
+
+	var result []Event
+	for i := first; i < len(index) && !index[i].date.After(end); i++ {
+		if event := ms.Events[index[i].id]; eventOverlaps(event, start, end) {
+			result = append(result, event)
+		}
+	}
+
+	return result
+}
+
+// GetRecurringEvents возвращает все события пользователя, имеющие правило повторения
+func (ms *MemoryStorage) GetRecurringEvents(userID int) ([]Event, error) {
+	var result []Event
+	for owner, ids := range ms.recurring {
+		if userID > 0 && owner != userID {
+			continue
+		}
+		for id := range ids {
+			result = append(result, ms.Events[id])
+		}
+	}
+
+	return result, nil
+}
+
+// GetAttendedEvents возвращает события, в которых пользователь указан участником
+func (ms *MemoryStorage) GetAttendedEvents(userID int) ([]Event, error) {
+	var result []Event
+	for id := range ms.attending[userID] {
+		result = append(result, ms.Events[id])
+	}
+
+	return result, nil
+}
+
+// PutShare сохраняет доступ к календарю
+func (ms *MemoryStorage) PutShare(share Share) error {
+	ms.touchShare(shareKey{share.OwnerID, share.SharedWith})
+	ms.Shares[shareKey{share.OwnerID, share.SharedWith}] = share
+	return nil
+}
+
+// DeleteShare отменяет доступ к календарю
+func (ms *MemoryStorage) DeleteShare(ownerID, userID int) error {
+	key := shareKey{ownerID, userID}
+	if _, exists := ms.Shares[key]; !exists {
+		return notFoundErrorf("у пользователя %d нет доступа к календарю пользователя %d", userID, ownerID)
+	}
+
+	ms.touchShare(key)
+	delete(ms.Shares, key)
+	return nil
+}
+
+// GetShares возвращает доступы к календарю пользователя и выданные ему доступы, упорядоченные по владельцу и пользователю
+func (ms *MemoryStorage) GetShares(userID int) ([]Share, error) {
+	var result []Share
+	for key, share := range ms.Shares {
+		if key.ownerID == userID || key.userID == userID {
+			result = append(result, share)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OwnerID != result[j].OwnerID {
+			return result[i].OwnerID < result[j].OwnerID
+		}
+		return result[i].SharedWith < result[j].SharedWith
+	})
+
+	return result, nil
+}
+
+// GetShare возвращает доступ пользователя к календарю
+func (ms *MemoryStorage) GetShare(ownerID, userID int) (Share, bool, error) {
+	share, exists := ms.Shares[shareKey{ownerID, userID}]
+	return share, exists, nil
+}
+
+// Close ничего не делает: хранилищу в памяти нечего освобождать
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// touchEvent запоминает в открытой единице работы состояние события до его первого изменения в ней
+func (ms *MemoryStorage) touchEvent(eventID int) {
+	if ms.unit == nil {
+		return
+	}
+	if _, touched := ms.unit.events[eventID]; touched {
+		return
+	}
+
+	if event, exists := ms.Events[eventID]; exists {
+		ms.unit.events[eventID] = &event
+	} else {
+		ms.unit.events[eventID] = nil
+	}
+}
+
+// touchShare запоминает в открытой единице работы состояние доступа до его первого изменения в ней
+func (ms *MemoryStorage) touchShare(key shareKey) {
+	if ms.unit == nil {
+		return
+	}
+	if _, touched := ms.unit.shares[key]; touched {
+		return
+	}
+
+	if share, exists := ms.Shares[key]; exists {
+		ms.unit.shares[key] = &share
+	} else {
+		ms.unit.shares[key] = nil
+	}
+}
+
+// put сохраняет событие с уже присвоенным ID, обновляя индексы и сдвигая NextID при необходимости
+func (ms *MemoryStorage) put(event Event) {
+	ms.touchEvent(event.ID)
+	if _, exists := ms.Events[event.ID]; exists {
+		ms.remove(event.ID)
+	}
+
+	ms.Events[event.ID] = event
+	if event.ID >= ms.NextID {
+		ms.NextID = event.ID + 1
+	}
+
+	entry := indexEntry{date: event.Date, id: event.ID}
+	index := ms.byUser[event.UserID]
+	position := sort.Search(len(index), func(i int) bool { return !index[i].before(entry) })
+	index = append(index, indexEntry{})
+	copy(index[position+1:], index[position:])
+	index[position] = entry
+	ms.byUser[event.UserID] = index
+
+	if duration := eventDuration(event); duration > 0 {
+		if ms.durations[event.UserID] == nil {
+			ms.durations[event.UserID] = make(map[time.Duration]int)
+		}
+		ms.durations[event.UserID][duration]++
+		if duration > ms.longest[event.UserID] {
+			ms.longest[event.UserID] = duration
+		}
+	}
+
+	if event.Recurrence != nil {
+		if ms.recurring[event.UserID] == nil {
+			ms.recurring[event.UserID] = make(map[int]struct{})
+		}
+		ms.recurring[event.UserID][event.ID] = struct{}{}
+	}
+
+	for _, attendee := range event.Attendees {
+		if ms.attending[attendee.UserID] == nil {
+			ms.attending[attendee.UserID] = make(map[int]struct{})
+		}
+		ms.attending[attendee.UserID][event.ID] = struct{}{}
+	}
+}
+
+// remove удаляет событие вместе с его записями в индексах
+func (ms *MemoryStorage) remove(eventID int) {
+	event, exists := ms.Events[eventID]
+	if !exists {
+		return
+	}
+	ms.touchEvent(eventID)
+	delete(ms.Events, eventID)
+
+	entry := indexEntry{date: event.Date, id: event.ID}
+	index := ms.byUser[event.UserID]
+	position := sort.Search(len(index), func(i int) bool { return !index[i].before(entry) })
+	if position < len(index) && index[position].id == eventID {
+		index = append(index[:position], index[position+1:]...)
+	}
+	if len(index) == 0 {
+		delete(ms.byUser, event.UserID)
+	} else {
+		ms.byUser[event.UserID] = index
+	}
+
+	if duration := eventDuration(event); duration > 0 {
+		ms.forgetDuration(event.UserID, duration)
+	}
+
+	if ids := ms.recurring[event.UserID]; ids != nil {
+		delete(ids, eventID)
+		if len(ids) == 0 {
+			delete(ms.recurring, event.UserID)
+		}
+	}
+
+	for _, attendee := range event.Attendees {
+		if ids := ms.attending[attendee.UserID]; ids != nil {
+			delete(ids, eventID)
+			if len(ids) == 0 {
+				delete(ms.attending, attendee.UserID)
+			}
+		}
+	}
+}
+
+// forgetDuration убирает из учёта продолжительность удалённого события пользователя. Если событий наибольшей
+// продолжительности не осталось, наибольшая продолжительность вычисляется заново по оставшимся
+func (ms *MemoryStorage) forgetDuration(userID int, duration time.Duration) {
+	durations := ms.durations[userID]
+	if durations[duration]--; durations[duration] > 0 {
+		return
+	}
+	delete(durations, duration)
+
+	if duration < ms.longest[userID] {
+		return
+	}
+
+	var longest time.Duration
+	for d := range durations {
+		if d > longest {
+			longest = d
+		}
+	}
+
+	if longest == 0 {
+		delete(ms.durations, userID)
+		delete(ms.longest, userID)
+		return
+	}
+	ms.longest[userID] = longest
+}
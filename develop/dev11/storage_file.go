@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// === FileStorage (файловое хранилище: журнал упреждающей записи + периодический снимок) ===
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFileName      = "wal.log"
+
+	// defaultSnapshotEvery количество записей журнала между снимками, если в конфигурации не указано иное
+	defaultSnapshotEvery = 1000
+)
+
+// Операции, записываемые в журнал
+const (
+	walOpAdd    = "add"
+	walOpUpdate = "update"
+	walOpDelete = "delete"
+
+	walOpShare   = "share"
+	walOpUnshare = "unshare"
+
+	walOpUnit = "unit"
+)
+
+// walRecord одна строка журнала упреждающей записи. Единица работы записывается одной строкой walOpUnit:
+// её изменения Records вместе с записями журнала аудита Audit, поэтому сбой не оставляет их записанными частично
+type walRecord struct {
+	Op      string       `json:"op"`
+	ID      int          `json:"id"`
+	Event   *Event       `json:"event,omitempty"`
+	Share   *Share       `json:"share,omitempty"`
+	Records []walRecord  `json:"records,omitempty"`
+	Audit   []AuditEntry `json:"audit,omitempty"`
+}
+
+// snapshot содержимое файла снимка
+type snapshot struct {
+	NextID int     `json:"next_id"`
+	Events []Event `json:"events"`
+	Shares []Share `json:"shares,omitempty"`
+}
+
+// FileStorage хранит события в памяти, а каждое изменение перед применением дописывает в журнал на диске.
+// Изменения единицы работы применяются к памяти сразу, а в журнал попадают при Commit одной строкой вместе
+// с записями журнала аудита (см. AuditLog). Каждые snapshotEvery записей состояние целиком сбрасывается в снимок,
+// а журнал обнуляется. При открытии состояние восстанавливается из снимка и журнала, включая NextID.
+type FileStorage struct {
+	dir           string
+	memory        *MemoryStorage
+	wal           *os.File
+	walSize       int64
+	records       int
+	snapshotEvery int
+	unit          *walRecord    // открытая единица работы, nil вне её
+	audit         *FileAuditLog // журнал аудита хранилища, если он открыт через AuditLog
+	replayed      []AuditEntry  // записи аудита из журнала хранилища, которые AuditLog сверит с файлом журнала аудита
+}
+
+// OpenFileStorage открывает (или создаёт) файловое хранилище в каталоге dir и восстанавливает его состояние
+func OpenFileStorage(dir string, snapshotEvery int) (*FileStorage, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("невозможно создать каталог хранилища: %v", err)
+	}
+
+	fs := &FileStorage{
+		dir:           dir,
+		memory:        InitNewMemoryStorage(),
+		snapshotEvery: snapshotEvery,
+	}
+
+	if err := fs.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := fs.replayWAL(); err != nil {
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно открыть журнал: %v", err)
+	}
+	fs.wal = wal
+
+	return fs, nil
+}
+
+// loadSnapshot загружает последний снимок, если он есть
+func (fs *FileStorage) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(fs.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка чтения снимка: %v", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("снимок повреждён: %v", err)
+	}
+
+	for _, event := range snap.Events {
+		fs.memory.put(event)
+	}
+	for _, share := range snap.Shares {
+		fs.memory.Shares[shareKey{share.OwnerID, share.SharedWith}] = share
+	}
+	if snap.NextID > fs.memory.NextID {
+		fs.memory.NextID = snap.NextID
+	}
+
+	return nil
+}
+
+// replayWAL применяет к состоянию из снимка все записи журнала.
+// Недописанная последняя запись (сбой посреди записи) отбрасывается, а журнал обрезается до последней целой записи.
+func (fs *FileStorage) replayWAL() error {
+	f, err := os.OpenFile(filepath.Join(fs.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("невозможно открыть журнал: %v", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// Последняя запись не завершена переводом строки — она не была записана до конца
+				if err := f.Truncate(offset); err != nil {
+					return fmt.Errorf("невозможно обрезать недописанную запись журнала: %v", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка чтения журнала: %v", err)
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("запись журнала по смещению %d повреждена: %v", offset, err)
+		}
+		if err := fs.apply(record); err != nil {
+			return fmt.Errorf("запись журнала по смещению %d не применима: %v", offset, err)
+		}
+		fs.replayed = append(fs.replayed, record.Audit...)
+
+		offset += int64(len(line))
+		fs.records++
+	}
+
+	fs.walSize = offset
+
+	return nil
+}
+
+// apply применяет запись журнала к состоянию в памяти.
+// Повторное применение записи безопасно: журнал может пересекаться со снимком, если сбой случился между
+// записью снимка и обнулением журнала.
+func (fs *FileStorage) apply(record walRecord) error {
+	switch record.Op {
+	case walOpAdd, walOpUpdate:
+		if record.Event == nil {
+			return fmt.Errorf("операция %q без события", record.Op)
+		}
+		fs.memory.put(*record.Event)
+	case walOpDelete:
+		fs.memory.remove(record.ID)
+	case walOpShare, walOpUnshare:
+		if record.Share == nil {
+			return fmt.Errorf("операция %q без доступа", record.Op)
+		}
+		if record.Op == walOpShare {
+			return fs.memory.PutShare(*record.Share)
+		}
+		delete(fs.memory.Shares, shareKey{record.Share.OwnerID, record.Share.SharedWith})
+	case walOpUnit:
+		for _, change := range record.Records {
+			if err := fs.apply(change); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("неизвестная операция %q", record.Op)
+	}
+
+	return nil
+}
+
+// write дописывает запись в журнал и сбрасывает её на диск. При ошибке журнал обрезается до прежнего размера.
+func (fs *FileStorage) write(record walRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации записи журнала: %v", err)
+	}
+	data = append(data, '\n')
+
+	n, err := fs.wal.Write(data)
+	if err == nil {
+		err = fs.wal.Sync()
+	}
+	if err != nil {
+		if n > 0 {
+			_ = fs.wal.Truncate(fs.walSize)
+		}
+		return fmt.Errorf("ошибка записи в журнал: %v", err)
+	}
+
+	fs.walSize += int64(n)
+	fs.records++
+
+	return nil
+}
+
+// commit записывает изменение в журнал, применяет его и при необходимости делает снимок. В единице работы
+// изменение только применяется, а в журнал его записывает Commit
+func (fs *FileStorage) commit(record walRecord) error {
+	if fs.unit != nil {
+		if err := fs.apply(record); err != nil {
+			return err
+		}
+		fs.unit.Records = append(fs.unit.Records, record)
+		return nil
+	}
+
+	if err := fs.write(record); err != nil {
+		return err
+	}
+
+	if err := fs.apply(record); err != nil {
+		return err
+	}
+
+	fs.maybeSnapshot()
+
+	return nil
+}
+
+// maybeSnapshot делает снимок, если журнал дорос до snapshotEvery записей.
+// Изменение сохранено, как только запись журнала сброшена на диск, поэтому ошибка снимка не возвращается вызывающему,
+// а только записывается в лог: журнал продолжает расти, и снимок повторяется при следующем изменении. Пока записи
+// аудита не дописаны в файл журнала аудита, журнал хранилища — их единственная копия, и снимок откладывается
+func (fs *FileStorage) maybeSnapshot() {
+	if fs.records < fs.snapshotEvery || (fs.audit != nil && len(fs.audit.unflushed) > 0) {
+		return
+	}
+
+	if err := fs.Snapshot(); err != nil {
+		log.Printf("Ошибка снимка хранилища, повтор при следующем изменении: %v", err)
+	}
+}
+
+// Begin начинает единицу работы
+func (fs *FileStorage) Begin() error {
+	if fs.unit != nil {
+		return errUnitStarted
+	}
+	if err := fs.memory.Begin(); err != nil {
+		return err
+	}
+
+	fs.unit = &walRecord{Op: walOpUnit}
+	return nil
+}
+
+// Commit дописывает изменения единицы работы и её записи аудита в журнал одной строкой. Если записать журнал
+// не удалось, единица работы остаётся открытой, а её изменения — в памяти до Rollback
+func (fs *FileStorage) Commit() error {
+	unit := fs.unit
+	if unit == nil {
+		return errNoUnit
+	}
+
+	if len(unit.Records) > 0 || len(unit.Audit) > 0 {
+		if err := fs.write(*unit); err != nil {
+			return err
+		}
+	}
+
+	fs.unit = nil
+	if err := fs.memory.Commit(); err != nil {
+		return err
+	}
+
+	if fs.audit != nil && len(unit.Audit) > 0 {
+		fs.audit.committed(unit.Audit)
+	}
+
+	fs.maybeSnapshot()
+
+	return nil
+}
+
+// Rollback отменяет изменения единицы работы в памяти. В журнал они ещё не записаны
+func (fs *FileStorage) Rollback() error {
+	if fs.unit == nil {
+		return errNoUnit
+	}
+
+	fs.unit = nil
+	return fs.memory.Rollback()
+}
+
+// Snapshot сохраняет текущее состояние в снимок и обнуляет журнал
+func (fs *FileStorage) Snapshot() error {
+	snap := snapshot{
+		NextID: fs.memory.NextID,
+		Events: make([]Event, 0, len(fs.memory.Events)),
+	}
+	for _, event := range fs.memory.Events {
+		snap.Events = append(snap.Events, event)
+	}
+	for _, share := range fs.memory.Shares {
+		snap.Shares = append(snap.Shares, share)
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации снимка: %v", err)
+	}
+
+	// Снимок пишется во временный файл и атомарно подменяет предыдущий, чтобы сбой не оставил его недописанным
+	tmpPath := filepath.Join(fs.dir, snapshotFileName+".tmp")
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("невозможно создать снимок: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка записи снимка: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка записи снимка: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ошибка записи снимка: %v", err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(fs.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("невозможно заменить снимок: %v", err)
+	}
+	if dir, err := os.Open(fs.dir); err == nil {
+		_ = dir.Sync()
+		dir.Close()
+	}
+
+	if err := fs.wal.Truncate(0); err != nil {
+		return fmt.Errorf("невозможно обнулить журнал: %v", err)
+	}
+	fs.walSize = 0
+	fs.records = 0
+
+	return nil
+}
+
+// AddEvent записывает новое событие в журнал и сохраняет его под следующим свободным ID
+func (fs *FileStorage) AddEvent(event Event) (int, error) {
+	event.ID = fs.memory.NextID
+
+	if err := fs.commit(walRecord{Op: walOpAdd, ID: event.ID, Event: &event}); err != nil {
+		return -1, err
+	}
+
+	return event.ID, nil
+}
+
+// UpdateEvent записывает изменение события в журнал и применяет его
+func (fs *FileStorage) UpdateEvent(event Event) error {
+	if _, exists := fs.memory.Events[event.ID]; !exists {
+		return notFoundErrorf("событие с ID %d не найдено", event.ID)
+	}
+
+	return fs.commit(walRecord{Op: walOpUpdate, ID: event.ID, Event: &event})
+}
+
+// DeleteEvent записывает удаление события в журнал и применяет его
+func (fs *FileStorage) DeleteEvent(eventID int) error {
+	if _, exists := fs.memory.Events[eventID]; !exists {
+		return notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	return fs.commit(walRecord{Op: walOpDelete, ID: eventID})
+}
+
+// RestoreEvent записывает возврат удалённого события в журнал и сохраняет его под прежним ID
+func (fs *FileStorage) RestoreEvent(event Event) error {
+	if _, exists := fs.memory.Events[event.ID]; exists {
+		return conflictErrorf("событие с ID %d не удалено", event.ID)
+	}
+
+	return fs.commit(walRecord{Op: walOpAdd, ID: event.ID, Event: &event})
+}
+
+// GetEvent возвращает событие по ID
+func (fs *FileStorage) GetEvent(eventID int) (Event, bool, error) {
+	return fs.memory.GetEvent(eventID)
+}
+
+// GetEventsForRange возвращает события пользователя, дата которых попадает в отрезок [start, end]
+func (fs *FileStorage) GetEventsForRange(userID int, start, end time.Time) ([]Event, error) {
+	return fs.memory.GetEventsForRange(userID, start, end)
+}
+
+// GetRecurringEvents возвращает все события пользователя, имеющие правило повторения
+func (fs *FileStorage) GetRecurringEvents(userID int) ([]Event, error) {
+	return fs.memory.GetRecurringEvents(userID)
+}
+
+// GetAttendedEvents возвращает события, в которых пользователь указан участником
+func (fs *FileStorage) GetAttendedEvents(userID int) ([]Event, error) {
+	return fs.memory.GetAttendedEvents(userID)
+}
+
+// PutShare записывает доступ к календарю в журнал и сохраняет его
+func (fs *FileStorage) PutShare(share Share) error {
+	return fs.commit(walRecord{Op: walOpShare, Share: &share})
+}
+
+// DeleteShare записывает отмену доступа к календарю в журнал и применяет её
+func (fs *FileStorage) DeleteShare(ownerID, userID int) error {
+	if _, exists := fs.memory.Shares[shareKey{ownerID, userID}]; !exists {
+		return notFoundErrorf("у пользователя %d нет доступа к календарю пользователя %d", userID, ownerID)
+	}
+
+	return fs.commit(walRecord{Op: walOpUnshare, Share: &Share{OwnerID: ownerID, SharedWith: userID}})
+}
+
+// GetShares возвращает доступы к календарю пользователя и выданные ему доступы
+func (fs *FileStorage) GetShares(userID int) ([]Share, error) {
+	return fs.memory.GetShares(userID)
+}
+
+// GetShare возвращает доступ пользователя к календарю
+func (fs *FileStorage) GetShare(ownerID, userID int) (Share, bool, error) {
+	return fs.memory.GetShare(ownerID, userID)
+}
+
+// AuditLog открывает журнал аудита в каталоге хранилища. Записи аудита единицы работы попадают в журнал
+// хранилища одной строкой с её изменениями и только после этого дописываются в файл журнала аудита; записи,
+// которые до сбоя не успели туда попасть, дописываются из журнала хранилища при открытии
+func (fs *FileStorage) AuditLog() (*FileAuditLog, error) {
+	if fs.audit != nil {
+		return fs.audit, nil
+	}
+
+	audit, err := OpenFileAuditLog(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []AuditEntry
+	for _, entry := range fs.replayed {
+		if entry.Operation > audit.lastOperation {
+			missing = append(missing, entry)
+		}
+	}
+	if err := audit.write(missing); err != nil {
+		audit.Close()
+		return nil, err
+	}
+
+	audit.storage = fs
+	fs.audit = audit
+	fs.replayed = nil
+
+	return audit, nil
+}
+
+// Close закрывает журнал. Журнал аудита закрывается отдельно
+func (fs *FileStorage) Close() error {
+	return fs.wal.Close()
+}
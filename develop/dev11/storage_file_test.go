@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestFileStorage открывает файловое хранилище в каталоге dir и закрывает его по завершении теста
+func openTestFileStorage(t *testing.T, dir string, snapshotEvery int) *FileStorage {
+	t.Helper()
+
+	storage, err := OpenFileStorage(dir, snapshotEvery)
+	if err != nil {
+		t.Fatalf("OpenFileStorage(): %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+// mustAdd сохраняет событие и возвращает его ID
+func mustAdd(t *testing.T, storage Storage, event Event) int {
+	t.Helper()
+
+	id, err := storage.AddEvent(event)
+	if err != nil {
+		t.Fatalf("AddEvent(%q): %v", event.Title, err)
+	}
+	return id
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 100)
+
+	first := mustAdd(t, storage, Event{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0)})
+	second := mustAdd(t, storage, Event{UserID: 1, Title: "Созвон", Date: at(2024, time.March, 5, 10, 0)})
+	last := mustAdd(t, storage, Event{UserID: 2, Title: "Ретро", Date: at(2024, time.March, 6, 10, 0)})
+
+	if err := storage.UpdateEvent(Event{ID: first, UserID: 1, Title: "Стендап", Date: at(2024, time.March, 4, 9, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.DeleteEvent(second); err != nil {
+		t.Fatal(err)
+	}
+	// Удаление события с наибольшим ID не должно освобождать его ID
+	if err := storage.DeleteEvent(last); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PutShare(Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Close()
+
+	// Снимка ещё нет: состояние целиком восстанавливается из журнала
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); !os.IsNotExist(err) {
+		t.Fatalf("снимок создан раньше времени: %v", err)
+	}
+	reopened := openTestFileStorage(t, dir, 100)
+
+	event, exists, err := reopened.GetEvent(first)
+	if err != nil || !exists || event.Title != "Стендап" || !event.Date.Equal(at(2024, time.March, 4, 9, 0)) {
+		t.Errorf("изменённое событие после восстановления: %+v, %v", event, exists)
+	}
+	for _, id := range []int{second, last} {
+		if _, exists, _ := reopened.GetEvent(id); exists {
+			t.Errorf("удалённое событие %d восстановлено из журнала", id)
+		}
+	}
+	if shares, err := reopened.GetShares(1); err != nil || len(shares) != 1 || shares[0].Permission != PermissionRead {
+		t.Errorf("доступы после восстановления: %+v", shares)
+	}
+
+	if id := mustAdd(t, reopened, Event{UserID: 1, Title: "Новое", Date: at(2024, time.March, 7, 10, 0)}); id != last+1 {
+		t.Errorf("ID нового события %d, ожидался %d", id, last+1)
+	}
+}
+
+func TestFileStorageTornTail(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 100)
+
+	id := mustAdd(t, storage, Event{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0)})
+	storage.Close()
+
+	walPath := filepath.Join(dir, walFileName)
+	info, err := os.Stat(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Сбой посреди записи: последняя строка журнала не дописана
+	wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.WriteString(`{"op":"add","id":2,"event":{"id":2,"user_id":1,"tit`); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	reopened := openTestFileStorage(t, dir, 100)
+	if after, err := os.Stat(walPath); err != nil || after.Size() != info.Size() {
+		t.Fatalf("журнал не обрезан до последней целой записи: %v, %v", after, err)
+	}
+	if _, exists, _ := reopened.GetEvent(id); !exists {
+		t.Error("целая запись журнала потеряна")
+	}
+	if _, exists, _ := reopened.GetEvent(id + 1); exists {
+		t.Error("недописанная запись журнала применена")
+	}
+
+	// После обрезки журнал продолжает дописываться с целой записи
+	next := mustAdd(t, reopened, Event{UserID: 1, Title: "Созвон", Date: at(2024, time.March, 5, 10, 0)})
+	reopened.Close()
+
+	again := openTestFileStorage(t, dir, 100)
+	if _, exists, _ := again.GetEvent(next); !exists {
+		t.Error("событие, записанное после обрезки журнала, потеряно")
+	}
+}
+
+func TestFileStorageSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 2)
+
+	// Снимок не удаётся записать: на месте временного файла каталог
+	tmpPath := filepath.Join(dir, snapshotFileName+".tmp")
+	if err := os.Mkdir(tmpPath, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	mustAdd(t, storage, Event{UserID: 1, Title: "Планёрка", Date: at(2024, time.March, 4, 10, 0)})
+	id, err := storage.AddEvent(Event{UserID: 1, Title: "Созвон", Date: at(2024, time.March, 5, 10, 0)})
+	if err != nil || id != 2 {
+		t.Fatalf("ошибка снимка сорвала сохранённое изменение: %d, %v", id, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); !os.IsNotExist(err) {
+		t.Fatalf("снимок создан вопреки ошибке: %v", err)
+	}
+
+	// Снимок повторяется при следующем изменении
+	if err := os.Remove(tmpPath); err != nil {
+		t.Fatal(err)
+	}
+	last := mustAdd(t, storage, Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 6, 10, 0)})
+	if err := storage.DeleteEvent(last); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("снимок не повторён: %v", err)
+	}
+	storage.Close()
+
+	// NextID восстанавливается из снимка и журнала, даже если событие с наибольшим ID удалено
+	reopened := openTestFileStorage(t, dir, 2)
+	if events, err := reopened.GetEventsForRange(1, time.Time{}, maxEventTime); err != nil || len(events) != 2 {
+		t.Errorf("после восстановления %d событий, ожидалось 2", len(events))
+	}
+	if id := mustAdd(t, reopened, Event{UserID: 1, Title: "Новое", Date: at(2024, time.March, 7, 10, 0)}); id != last+1 {
+		t.Errorf("ID нового события %d, ожидался %d", id, last+1)
+	}
+}
+
+func TestFileStorageUnit(t *testing.T) {
+	dir := t.TempDir()
+	storage := openTestFileStorage(t, dir, 100)
+	audit, err := storage.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := InitNewEventStoreWithStorage(storage, audit)
+
+	masterID, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	occurrence := at(2024, time.March, 6, 7, 0)
+	if _, err := store.UpdateEvent(1, Event{ID: masterID, Occurrence: &occurrence, Title: "Пробежка"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Удаление серии вместе с выделенным вхождением записывается одной строкой журнала вместе с записями аудита
+	if err := store.DeleteEvent(1, masterID); err != nil {
+		t.Fatal(err)
+	}
+	walPath, auditPath := filepath.Join(dir, walFileName), filepath.Join(dir, auditFileName)
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var last walRecord
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 3 || last.Op != walOpUnit || len(last.Records) != 2 || len(last.Audit) != 2 {
+		t.Fatalf("журнал из %d строк, последняя %+v, ожидались 3 и удаление двух событий с двумя записями аудита", len(lines), last)
+	}
+
+	// Сбой после записи журнала хранилища, но до записи журнала аудита: записи аудита восстанавливаются из журнала хранилища
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(auditPath, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestFileStorage(t, dir, 100)
+	reopenedAudit, err := reopened.AuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopenedAudit.Close()
+
+	history, err := reopenedAudit.History(masterID)
+	if err != nil || len(history) != 3 || history[2].Action != AuditDeleted {
+		t.Errorf("история серии после восстановления: %+v, %v", history, err)
+	}
+	if info, err := os.Stat(auditPath); err != nil || info.Size() == 0 {
+		t.Errorf("записи аудита не дописаны в файл журнала аудита: %v, %v", info, err)
+	}
+}
@@ -0,0 +1,690 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	// Драйвер SQLite на чистом Go: база в файле без внешних зависимостей, в том числе для тестов
+	_ "modernc.org/sqlite"
+)
+
+// === SQLStorage (хранилище событий в базе данных через database/sql) ===
+
+// sqliteDriver имя драйвера SQLite в database/sql
+const sqliteDriver = "sqlite"
+
+// sqlTimeLayout формат моментов времени в индексируемых столбцах: UTC с фиксированной шириной полей,
+// поэтому строки сравниваются так же, как моменты времени
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sqlTime возвращает представление момента времени для индексируемого столбца
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+// migration шаг миграции схемы базы данных. Применённые шаги записываются в schema_migrations
+// и больше не выполняются, поэтому шаги только добавляются в конец списка и не меняются.
+// Если схему недостаточно изменить запросами statements, данные переносит функция backfill в той же транзакции
+type migration struct {
+	version    int
+	name       string
+	statements []string
+	backfill   func(tx *sql.Tx) error
+}
+
+// sqlMigrations миграции схемы по порядку версий
+var sqlMigrations = []migration{
+	{1, "события и доступы", []string{
+		// Событие целиком хранится в data (JSON), а поля, по которым идёт выборка, дублируются в индексируемых столбцах
+		`CREATE TABLE events (
+			id        INTEGER PRIMARY KEY,
+			user_id   INTEGER NOT NULL,
+			start_at  TEXT    NOT NULL,
+			recurring INTEGER NOT NULL,
+			data      TEXT    NOT NULL
+		)`,
+		`CREATE INDEX events_user_start ON events (user_id, start_at, id)`,
+		`CREATE INDEX events_recurring ON events (recurring, user_id)`,
+		`CREATE TABLE event_attendees (
+			user_id  INTEGER NOT NULL,
+			event_id INTEGER NOT NULL,
+			PRIMARY KEY (user_id, event_id)
+		)`,
+		`CREATE INDEX event_attendees_event ON event_attendees (event_id)`,
+		`CREATE TABLE shares (
+			owner_id   INTEGER NOT NULL,
+			user_id    INTEGER NOT NULL,
+			permission TEXT    NOT NULL,
+			PRIMARY KEY (owner_id, user_id)
+		)`,
+		`CREATE INDEX shares_user ON shares (user_id)`,
+		// Счётчик ID событий: в отличие от MAX(id) + 1 не выдаёт повторно ID удалённых событий
+		`CREATE TABLE sequences (
+			name  TEXT    PRIMARY KEY,
+			value INTEGER NOT NULL
+		)`,
+		`INSERT INTO sequences (name, value) VALUES ('events', 0)`,
+	}, nil},
+	{2, "журнал аудита", []string{
+		`CREATE TABLE audit_log (
+			operation INTEGER NOT NULL,
+			position  INTEGER NOT NULL,
+			event_id  INTEGER NOT NULL,
+			data      TEXT    NOT NULL,
+			PRIMARY KEY (operation, position)
+		)`,
+		`CREATE INDEX audit_log_event ON audit_log (event_id, operation, position)`,
+	}, nil},
+	{3, "окончание событий", []string{
+		// end_at — окончание события по eventSpan (для события без продолжительности — начало), duration — его
+		// продолжительность в наносекундах. Наибольшая продолжительность ограничивает просмотр индекса в прошлое
+		`ALTER TABLE events ADD COLUMN end_at TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE events ADD COLUMN duration INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX events_user_duration ON events (user_id, duration)`,
+		`CREATE INDEX events_duration ON events (duration)`,
+	}, backfillEventEnds},
+}
+
+// backfillEventEnds заполняет end_at и duration событий, сохранённых до миграции 3
+func backfillEventEnds(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT data FROM events`)
+	if err != nil {
+		return err
+	}
+
+	var events []Event
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return err
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		duration := eventDuration(event)
+		_, err := tx.Exec(`UPDATE events SET end_at = ?, duration = ? WHERE id = ?`, sqlTime(event.Date.Add(duration)), int64(duration), event.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrate применяет к базе миграции, которые ещё не применены. Каждая миграция выполняется в своей транзакции
+func migrate(db *sql.DB, migrations []migration) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("невозможно создать таблицу миграций: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("невозможно прочитать версию схемы: %v", err)
+	}
+
+	if latest := migrations[len(migrations)-1].version; current > latest {
+		return fmt.Errorf("версия схемы базы %d новее поддерживаемой сервером %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		err := inTx(db, func(tx *sql.Tx) error {
+			for _, statement := range m.statements {
+				if _, err := tx.Exec(statement); err != nil {
+					return err
+				}
+			}
+			if m.backfill != nil {
+				if err := m.backfill(tx); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, m.version, m.name, sqlTime(time.Now()))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка миграции %d (%s): %v", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// inTx выполняет fn в транзакции: фиксирует её, если fn завершилась без ошибки, иначе откатывает
+func inTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqlConn общие методы *sql.DB и *sql.Tx, через которые хранилище выполняет запросы
+type sqlConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// SQLStorage хранит события в базе данных. Единица работы — транзакция, в которой выполняются и изменения,
+// и записи журнала аудита SQLAuditLog; изменение вне единицы работы выполняется в своей транзакции. Выборки
+// за период, повторяющихся событий и событий участника — индексированные запросы, без загрузки всех событий.
+// Запросы написаны на диалекте SQLite
+type SQLStorage struct {
+	db *sql.DB
+	tx *sql.Tx // транзакция открытой единицы работы, nil вне её
+}
+
+// OpenSQLStorage подключается к базе dataSource через драйвер driverName и приводит её схему к текущей версии
+func OpenSQLStorage(driverName, dataSource string) (*SQLStorage, error) {
+	db, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно открыть базу данных: %v", err)
+	}
+
+	// SQLite допускает одного писателя за раз: одно соединение исключает ошибки SQLITE_BUSY между чтениями
+	// и записью, а база :memory: остаётся одной на всё хранилище
+	if driverName == sqliteDriver {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("невозможно подключиться к базе данных: %v", err)
+	}
+
+	if err := migrate(db, sqlMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLStorage{db: db}, nil
+}
+
+// AuditLog возвращает журнал аудита в той же базе данных
+func (ss *SQLStorage) AuditLog() *SQLAuditLog {
+	return &SQLAuditLog{storage: ss}
+}
+
+// Begin начинает транзакцию единицы работы
+func (ss *SQLStorage) Begin() error {
+	if ss.tx != nil {
+		return errUnitStarted
+	}
+
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return fmt.Errorf("невозможно начать транзакцию: %v", err)
+	}
+	ss.tx = tx
+
+	return nil
+}
+
+// Commit фиксирует транзакцию единицы работы. Неудачная фиксация завершает транзакцию, поэтому последующий
+// Rollback ничего не делает
+func (ss *SQLStorage) Commit() error {
+	if ss.tx == nil {
+		return errNoUnit
+	}
+
+	tx := ss.tx
+	ss.tx = nil
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка фиксации транзакции: %v", err)
+	}
+
+	return nil
+}
+
+// Rollback откатывает транзакцию единицы работы
+func (ss *SQLStorage) Rollback() error {
+	if ss.tx == nil {
+		return nil
+	}
+
+	tx := ss.tx
+	ss.tx = nil
+	if err := tx.Rollback(); err != nil {
+		return fmt.Errorf("ошибка отката транзакции: %v", err)
+	}
+
+	return nil
+}
+
+// conn возвращает транзакцию открытой единицы работы, а вне её — базу данных. Чтения в единице работы тоже
+// идут через транзакцию: они видят её изменения, а единственное соединение SQLite занято ею
+func (ss *SQLStorage) conn() sqlConn {
+	if ss.tx != nil {
+		return ss.tx
+	}
+	return ss.db
+}
+
+// AddEvent сохраняет событие под следующим значением счётчика ID
+func (ss *SQLStorage) AddEvent(event Event) (int, error) {
+	err := ss.write(func(tx sqlConn) error {
+		if _, err := tx.Exec(`UPDATE sequences SET value = value + 1 WHERE name = 'events'`); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`SELECT value FROM sequences WHERE name = 'events'`).Scan(&event.ID); err != nil {
+			return err
+		}
+		return insertEvent(tx, event)
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return event.ID, nil
+}
+
+// UpdateEvent заменяет событие с тем же ID
+func (ss *SQLStorage) UpdateEvent(event Event) error {
+	return ss.write(func(tx sqlConn) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		duration := eventDuration(event)
+		result, err := tx.Exec(`UPDATE events SET user_id = ?, start_at = ?, end_at = ?, duration = ?, recurring = ?, data = ? WHERE id = ?`,
+			event.UserID, sqlTime(event.Date), sqlTime(event.Date.Add(duration)), int64(duration), event.Recurrence != nil, string(data), event.ID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return notFoundErrorf("событие с ID %d не найдено", event.ID)
+		}
+
+		return putAttendees(tx, event)
+	})
+}
+
+// DeleteEvent удаляет событие по ID
+func (ss *SQLStorage) DeleteEvent(eventID int) error {
+	return ss.write(func(tx sqlConn) error {
+		result, err := tx.Exec(`DELETE FROM events WHERE id = ?`, eventID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return notFoundErrorf("событие с ID %d не найдено", eventID)
+		}
+
+		_, err = tx.Exec(`DELETE FROM event_attendees WHERE event_id = ?`, eventID)
+		return err
+	})
+}
+
+// RestoreEvent сохраняет удалённое событие под прежним ID
+func (ss *SQLStorage) RestoreEvent(event Event) error {
+	return ss.write(func(tx sqlConn) error {
+		var exists int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM events WHERE id = ?`, event.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists > 0 {
+			return conflictErrorf("событие с ID %d не удалено", event.ID)
+		}
+
+		if _, err := tx.Exec(`UPDATE sequences SET value = ? WHERE name = 'events' AND value < ?`, event.ID, event.ID); err != nil {
+			return err
+		}
+		return insertEvent(tx, event)
+	})
+}
+
+// write выполняет изменение в транзакции единицы работы, а вне её — в своей транзакции. Ошибки бизнес-логики
+// возвращаются как есть, ошибки базы — с пояснением
+func (ss *SQLStorage) write(fn func(tx sqlConn) error) error {
+	var err error
+	if ss.tx != nil {
+		err = fn(ss.tx)
+	} else {
+		err = inTx(ss.db, func(tx *sql.Tx) error { return fn(tx) })
+	}
+
+	var domainErr *DomainError
+	if err != nil && !errors.As(err, &domainErr) {
+		return fmt.Errorf("ошибка записи в базу данных: %v", err)
+	}
+	return err
+}
+
+// insertEvent добавляет строку события с уже присвоенным ID и его участников
+func insertEvent(tx sqlConn, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	duration := eventDuration(event)
+	_, err = tx.Exec(`INSERT INTO events (id, user_id, start_at, end_at, duration, recurring, data) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		event.ID, event.UserID, sqlTime(event.Date), sqlTime(event.Date.Add(duration)), int64(duration), event.Recurrence != nil, string(data))
+	if err != nil {
+		return err
+	}
+
+	return putAttendees(tx, event)
+}
+
+// putAttendees заменяет записи об участниках события
+func putAttendees(tx sqlConn, event Event) error {
+	if _, err := tx.Exec(`DELETE FROM event_attendees WHERE event_id = ?`, event.ID); err != nil {
+		return err
+	}
+
+	for _, attendee := range event.Attendees {
+		_, err := tx.Exec(`INSERT INTO event_attendees (user_id, event_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, attendee.UserID, event.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetEvent возвращает событие по ID
+func (ss *SQLStorage) GetEvent(eventID int) (Event, bool, error) {
+	events, err := ss.queryEvents(NewSQLQueryBuilder().Select("data").From("events").Where("id = ?", eventID))
+	if err != nil || len(events) == 0 {
+		return Event{}, false, err
+	}
+	return events[0], true, nil
+}
+
+// GetEventsForRange возвращает события пользователя, пересекающиеся с отрезком [start, end], упорядоченные по дате и ID.
+// Выборка идёт по индексу (user_id, start_at, id) начиная за наибольшую продолжительность события пользователя
+// до start, которая берётся из индекса (user_id, duration)
+func (ss *SQLStorage) GetEventsForRange(userID int, start, end time.Time) ([]Event, error) {
+	longest := NewSQLQueryBuilder().Select("COALESCE(MAX(duration), 0)").From("events")
+	if userID > 0 {
+		longest.Where("user_id = ?", userID)
+	}
+	longestQuery, longestArgs := longest.Build()
+
+	var lookback int64
+	if err := ss.conn().QueryRow(longestQuery, longestArgs...).Scan(&lookback); err != nil {
+		return nil, fmt.Errorf("ошибка чтения событий из базы данных: %v", err)
+	}
+
+	query := NewSQLQueryBuilder().Select("data").From("events")
+	if userID > 0 {
+		query.Where("user_id = ?", userID)
+	}
+	query.Where("start_at >= ?", sqlTime(start.Add(-time.Duration(lookback)))).
+		Where("start_at <= ?", sqlTime(end)).
+		Where("(end_at > ? OR start_at >= ?)", sqlTime(start), sqlTime(start)).
+		OrderBy("start_at", true).
+		OrderBy("id", true)
+
+	return ss.queryEvents(query)
+}
+
+// GetRecurringEvents возвращает все события пользователя, имеющие правило повторения
+func (ss *SQLStorage) GetRecurringEvents(userID int) ([]Event, error) {
+	query := NewSQLQueryBuilder().Select("data").From("events").Where("recurring = ?", true)
+	if userID > 0 {
+		query.Where("user_id = ?", userID)
+	}
+
+	return ss.queryEvents(query.OrderBy("id", true))
+}
+
+// GetAttendedEvents возвращает события, в которых пользователь указан участником
+func (ss *SQLStorage) GetAttendedEvents(userID int) ([]Event, error) {
+	return ss.queryEvents(NewSQLQueryBuilder().
+		Select("events.data").
+		From("event_attendees").
+		Join("events", "events.id = event_attendees.event_id").
+		Where("event_attendees.user_id = ?", userID).
+		OrderBy("events.id", true))
+}
+
+// queryEvents выполняет запрос, выбирающий столбец data событий
+func (ss *SQLStorage) queryEvents(builder SQLQueryBuilder) ([]Event, error) {
+	query, args := builder.Build()
+
+	rows, err := ss.conn().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения событий из базы данных: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("ошибка чтения событий из базы данных: %v", err)
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return nil, fmt.Errorf("событие в базе данных повреждено: %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения событий из базы данных: %v", err)
+	}
+
+	return events, nil
+}
+
+// PutShare сохраняет доступ к календарю, заменяя прежний
+func (ss *SQLStorage) PutShare(share Share) error {
+	return ss.write(func(tx sqlConn) error {
+		_, err := tx.Exec(`INSERT INTO shares (owner_id, user_id, permission) VALUES (?, ?, ?)
+			ON CONFLICT (owner_id, user_id) DO UPDATE SET permission = excluded.permission`,
+			share.OwnerID, share.SharedWith, string(share.Permission))
+		return err
+	})
+}
+
+// DeleteShare отменяет доступ к календарю
+func (ss *SQLStorage) DeleteShare(ownerID, userID int) error {
+	return ss.write(func(tx sqlConn) error {
+		result, err := tx.Exec(`DELETE FROM shares WHERE owner_id = ? AND user_id = ?`, ownerID, userID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return notFoundErrorf("у пользователя %d нет доступа к календарю пользователя %d", userID, ownerID)
+		}
+		return nil
+	})
+}
+
+// GetShares возвращает доступы к календарю пользователя и выданные ему доступы, упорядоченные по владельцу и пользователю
+func (ss *SQLStorage) GetShares(userID int) ([]Share, error) {
+	query, args := NewSQLQueryBuilder().
+		Select("owner_id", "user_id", "permission").
+		From("shares").
+		Where("owner_id = ? OR user_id = ?", userID, userID).
+		OrderBy("owner_id", true).
+		OrderBy("user_id", true).
+		Build()
+
+	rows, err := ss.conn().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения доступов из базы данных: %v", err)
+	}
+	defer rows.Close()
+
+	var shares []Share
+	for rows.Next() {
+		var share Share
+		var permission string
+		if err := rows.Scan(&share.OwnerID, &share.SharedWith, &permission); err != nil {
+			return nil, fmt.Errorf("ошибка чтения доступов из базы данных: %v", err)
+		}
+		share.Permission = Permission(permission)
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения доступов из базы данных: %v", err)
+	}
+
+	return shares, nil
+}
+
+// GetShare возвращает доступ пользователя к календарю по первичному ключу таблицы shares
+func (ss *SQLStorage) GetShare(ownerID, userID int) (Share, bool, error) {
+	query, args := NewSQLQueryBuilder().
+		Select("permission").
+		From("shares").
+		Where("owner_id = ? AND user_id = ?", ownerID, userID).
+		Build()
+
+	var permission string
+	if err := ss.conn().QueryRow(query, args...).Scan(&permission); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Share{}, false, nil
+		}
+		return Share{}, false, fmt.Errorf("ошибка чтения доступов из базы данных: %v", err)
+	}
+
+	return Share{OwnerID: ownerID, SharedWith: userID, Permission: Permission(permission)}, true, nil
+}
+
+// Close откатывает незавершённую единицу работы и закрывает соединения с базой данных
+func (ss *SQLStorage) Close() error {
+	_ = ss.Rollback()
+	return ss.db.Close()
+}
+
+// === SQLAuditLog (журнал аудита в базе данных) ===
+
+// SQLAuditLog хранит журнал аудита в таблице audit_log базы SQLStorage. Записи дописываются в транзакции
+// единицы работы хранилища и фиксируются вместе с изменениями, а вне её — в своей транзакции
+type SQLAuditLog struct {
+	storage *SQLStorage
+}
+
+// Append дописывает записи операций под следующими номерами одной транзакцией
+func (sl *SQLAuditLog) Append(operations ...[]AuditEntry) error {
+	err := sl.storage.write(func(tx sqlConn) error {
+		var last uint64
+		if err := tx.QueryRow(`SELECT COALESCE(MAX(operation), 0) FROM audit_log`).Scan(&last); err != nil {
+			return err
+		}
+
+		for i, entries := range operations {
+			operation := last + uint64(i) + 1
+			for position, entry := range entries {
+				entry.Operation = operation
+				data, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+
+				_, err = tx.Exec(`INSERT INTO audit_log (operation, position, event_id, data) VALUES (?, ?, ?, ?)`,
+					operation, position, entry.EventID, string(data))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал аудита: %v", err)
+	}
+
+	return nil
+}
+
+// History возвращает записи о событии в порядке их добавления
+func (sl *SQLAuditLog) History(eventID int) ([]AuditEntry, error) {
+	return sl.query(NewSQLQueryBuilder().
+		Select("data").
+		From("audit_log").
+		Where("event_id = ?", eventID).
+		OrderBy("operation", true).
+		OrderBy("position", true))
+}
+
+// Operation возвращает записи операции
+func (sl *SQLAuditLog) Operation(operation uint64) ([]AuditEntry, error) {
+	return sl.query(NewSQLQueryBuilder().
+		Select("data").
+		From("audit_log").
+		Where("operation = ?", operation).
+		OrderBy("position", true))
+}
+
+// query выполняет запрос, выбирающий столбец data записей журнала
+func (sl *SQLAuditLog) query(builder SQLQueryBuilder) ([]AuditEntry, error) {
+	query, args := builder.Build()
+
+	rows, err := sl.storage.conn().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала аудита из базы данных: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("ошибка чтения журнала аудита из базы данных: %v", err)
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("запись журнала аудита в базе данных повреждена: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала аудита из базы данных: %v", err)
+	}
+
+	return entries, nil
+}
+
+// Close ничего не делает: соединения с базой закрывает SQLStorage
+func (sl *SQLAuditLog) Close() error {
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLQueryBuilder(t *testing.T) {
+	tests := []struct {
+		name     string
+		builder  SQLQueryBuilder
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			"все поля",
+			NewSQLQueryBuilder().From("events"),
+			"SELECT * FROM events",
+			nil,
+		},
+		{
+			"условия и сортировка",
+			NewSQLQueryBuilder().Select("id", "data").From("events").Where("user_id = ?", 1).Where("start_at <= ?", "2024").OrderBy("start_at", true).OrderBy("id", false).Limit(10),
+			"SELECT id, data FROM events WHERE (user_id = ?) AND (start_at <= ?) ORDER BY start_at ASC, id DESC LIMIT 10",
+			[]interface{}{1, "2024"},
+		},
+		{
+			"OR не связывает соседние условия",
+			NewSQLQueryBuilder().Select("owner_id").From("shares").Where("owner_id = ? OR user_id = ?", 2, 2).Where("permission = ?", "write"),
+			"SELECT owner_id FROM shares WHERE (owner_id = ? OR user_id = ?) AND (permission = ?)",
+			[]interface{}{2, 2, "write"},
+		},
+		{
+			"соединение",
+			NewSQLQueryBuilder().Select("events.data").From("event_attendees").Join("events", "events.id = event_attendees.event_id").Where("event_attendees.user_id = ?", 3),
+			"SELECT events.data FROM event_attendees JOIN events ON events.id = event_attendees.event_id WHERE event_attendees.user_id = ?",
+			[]interface{}{3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := tt.builder.Build()
+			if query != tt.wantSQL {
+				t.Errorf("запрос\n%s\nожидался\n%s", query, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("аргументы %v, ожидались %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+// openTestSQLStorage открывает хранилище SQLite в файле path
+func openTestSQLStorage(t *testing.T, path string) *SQLStorage {
+	t.Helper()
+
+	storage, err := OpenSQLStorage(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// comparableEvents приводит события к виду для сравнения: без отметок времени, которые EventStore ставит по часам,
+// и с датами после кодирования в JSON
+func comparableEvents(t *testing.T, events []Event) string {
+	t.Helper()
+
+	for i := range events {
+		events[i].CreatedAt, events[i].UpdatedAt = time.Time{}, time.Time{}
+	}
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestSQLStorageMatchesMemory выполняет одни и те же изменения над EventStore в памяти и в SQLite
+// и сравнивает результаты выборок
+func TestSQLStorageMatchesMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.db")
+	storage := openTestSQLStorage(t, path)
+
+	memoryStore := InitNewEventStore()
+	sqlStore := InitNewEventStoreWithStorage(storage, storage.AuditLog())
+	stores := []*EventStore{memoryStore, sqlStore}
+
+	apply := func(name string, op func(store *EventStore) error) {
+		t.Helper()
+		for _, store := range stores {
+			if err := op(store); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+	}
+
+	for _, event := range seedEvents() {
+		event.Attendees = []Attendee{{UserID: 3}}
+		apply("создание", func(store *EventStore) error { _, err := store.AddEvent(event); return err })
+	}
+
+	occurrence := at(2024, time.March, 6, 7, 0)
+	apply("выделение вхождения", func(store *EventStore) error {
+		_, err := store.UpdateEvent(1, Event{ID: 6, Occurrence: &occurrence, Title: "Пробежка"}, Actor(3))
+		return err
+	})
+	apply("перенос", func(store *EventStore) error {
+		_, err := store.UpdateEvent(1, Event{ID: 2, Date: at(2024, time.March, 8, 9, 0)})
+		return err
+	})
+	apply("удаление", func(store *EventStore) error { return store.DeleteEvent(1, 5) })
+	apply("удаление и восстановление", func(store *EventStore) error {
+		if err := store.DeleteEvent(1, 1); err != nil {
+			return err
+		}
+		_, err := store.RestoreEvent(1, 1)
+		return err
+	})
+	apply("доступ", func(store *EventStore) error {
+		return store.ShareCalendar(Share{OwnerID: 1, SharedWith: 2, Permission: PermissionRead})
+	})
+	apply("многодневное событие", func(store *EventStore) error {
+		end := at(2024, time.March, 21, 0, 0)
+		_, err := store.AddEvent(Event{UserID: 1, Title: "Конференция", Date: at(2024, time.March, 19, 0, 0), End: &end, AllDay: true})
+		return err
+	})
+
+	readEvents := func(events []Event, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return comparableEvents(t, events)
+	}
+
+	start, end := monthBounds(at(2024, time.March, 1, 0, 0))
+	checks := []struct {
+		name string
+		get  func(store *EventStore) interface{}
+	}{
+		{"события за март", func(store *EventStore) interface{} {
+			return readEvents(store.GetEventsForRange(1, start, end))
+		}},
+		{"события за день внутри многодневного события", func(store *EventStore) interface{} {
+			dayStart, dayEnd := dayBounds(at(2024, time.March, 20, 12, 0))
+			return readEvents(store.GetEventsForRange(1, dayStart, dayEnd))
+		}},
+		{"события участника", func(store *EventStore) interface{} {
+			return readEvents(store.GetEventsForRange(3, start, end))
+		}},
+		{"все события пользователя", func(store *EventStore) interface{} { return readEvents(store.GetUserEvents(1)) }},
+		{"доступы", func(store *EventStore) interface{} {
+			shares, err := store.GetShares(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return shares
+		}},
+		{"уровень доступа", func(store *EventStore) interface{} {
+			read, readable, err := store.CalendarPermission(1, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, foreign, _ := store.CalendarPermission(2, 1)
+			return []interface{}{read, readable, foreign}
+		}},
+		{"история", func(store *EventStore) interface{} {
+			history, err := store.History(1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actions []string
+			for _, entry := range history {
+				actions = append(actions, string(entry.Action))
+			}
+			return strings.Join(actions, ",")
+		}},
+	}
+
+	for _, check := range checks {
+		if memory, stored := check.get(memoryStore), check.get(sqlStore); !reflect.DeepEqual(memory, stored) {
+			t.Errorf("%s в SQLite:\n%v\nв памяти:\n%v", check.name, stored, memory)
+		}
+	}
+
+	apply("удаление вхождения", func(store *EventStore) error { return store.DeleteEvent(1, 8) })
+	if err := sqlStore.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// После перезапуска события и журнал на месте, а ID удалённого последним события не выдаётся повторно
+	reopened := openTestSQLStorage(t, path)
+	defer reopened.Close()
+	sqlStore = InitNewEventStoreWithStorage(reopened, reopened.AuditLog())
+
+	if got, want := readEvents(sqlStore.GetUserEvents(1)), readEvents(memoryStore.GetUserEvents(1)); got != want {
+		t.Errorf("события после перезапуска:\n%s\nожидались\n%s", got, want)
+	}
+	if history, err := sqlStore.History(1, 8); err != nil || len(history) != 2 || history[1].Action != AuditDeleted {
+		t.Errorf("история удалённого вхождения после перезапуска %+v, ошибка %v", history, err)
+	}
+	if id, err := sqlStore.AddEvent(Event{UserID: 1, Title: "Новое", Date: at(2024, time.March, 12, 10, 0)}); err != nil || id != 10 {
+		t.Errorf("ID нового события %d (ошибка %v), ожидался 10", id, err)
+	}
+}
+
+func TestSQLMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.db")
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// База, созданная сервером, знавшим только первую миграцию
+	if err := migrate(db, sqlMigrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO events (id, user_id, start_at, recurring, data) VALUES (1, 1, ?, 0, '{"id":1,"user_id":1,"title":"Ретро","date":"2024-03-05T15:00:00Z","end":"2024-03-07T15:00:00Z"}')`, sqlTime(at(2024, time.March, 5, 15, 0))); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := openTestSQLStorage(t, path)
+	if event, ok, err := storage.GetEvent(1); err != nil || !ok || event.Title != "Ретро" {
+		t.Errorf("событие после миграции %+v, найдено %v", event, ok)
+	}
+	// Окончание сохранённого до миграции события заполнено: оно попадает в день, когда уже идёт
+	if events, err := storage.GetEventsForRange(1, at(2024, time.March, 6, 0, 0), at(2024, time.March, 6, 23, 59)); err != nil || len(events) != 1 {
+		t.Errorf("событие, идущее в период, после миграции: %+v", events)
+	}
+	if err := storage.AuditLog().Append([]AuditEntry{{ActorID: 1, Action: AuditCreated, EventID: 1}}); err != nil {
+		t.Errorf("журнал аудита после миграции: %v", err)
+	}
+	storage.Close()
+
+	var versions int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&versions); err != nil || versions != len(sqlMigrations) {
+		t.Errorf("применено миграций %d (ошибка %v), ожидалось %d", versions, err, len(sqlMigrations))
+	}
+
+	// Повторное открытие не применяет миграции заново, а база новее сервера не открывается
+	openTestSQLStorage(t, path).Close()
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (99, 'из будущего', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSQLStorage(sqliteDriver, path); err == nil {
+		t.Error("OpenSQLStorage() открыл базу со схемой новее поддерживаемой")
+	}
+}
+
+func TestSQLRangeUsesIndex(t *testing.T) {
+	storage := openTestSQLStorage(t, ":memory:")
+	defer storage.Close()
+
+	query, args := NewSQLQueryBuilder().Select("data").From("events").
+		Where("user_id = ?", 1).
+		Where("start_at >= ?", sqlTime(at(2024, time.March, 1, 0, 0))).
+		Where("start_at <= ?", sqlTime(at(2024, time.March, 31, 0, 0))).
+		Where("(end_at > ? OR start_at >= ?)", sqlTime(at(2024, time.March, 1, 0, 0)), sqlTime(at(2024, time.March, 1, 0, 0))).
+		OrderBy("start_at", true).
+		OrderBy("id", true).
+		Build()
+
+	rows, err := storage.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, detail)
+	}
+
+	text := strings.Join(plan, "; ")
+	if !strings.Contains(text, "events_user_start") || strings.Contains(text, "TEMP B-TREE") {
+		t.Errorf("выборка за период не использует индекс events_user_start: %s", text)
+	}
+}
+
+func TestSQLUnit(t *testing.T) {
+	storage := openTestSQLStorage(t, ":memory:")
+	defer storage.Close()
+	audit := storage.AuditLog()
+	store := InitNewEventStoreWithStorage(storage, audit)
+
+	masterID, err := store.AddEvent(Event{UserID: 1, Title: "Зарядка", Date: at(2024, time.March, 5, 7, 0), Recurrence: &Recurrence{Freq: "DAILY", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Изменение, которое не удалось записать в журнал аудита, откатывается вместе с транзакцией
+	store.audit = failingAuditLog{InitNewMemoryAuditLog()}
+	if _, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 6, 15, 0)}); err == nil {
+		t.Fatal("AddEvent() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	if err := store.DeleteEvent(1, masterID); err == nil {
+		t.Fatal("DeleteEvent() без записи в журнал аудита должен завершиться ошибкой")
+	}
+	store.audit = audit
+
+	events, err := store.GetUserEvents(1)
+	if err != nil || len(events) != 1 || events[0].ID != masterID {
+		t.Fatalf("события после отката: %+v, %v", events, err)
+	}
+	if id, err := store.AddEvent(Event{UserID: 1, Title: "Ретро", Date: at(2024, time.March, 6, 15, 0)}); err != nil || id != masterID+1 {
+		t.Errorf("ID события после отката %d (ошибка %v), ожидался %d", id, err, masterID+1)
+	}
+	if history, err := store.History(1, masterID); err != nil || len(history) != 1 {
+		t.Errorf("журнал аудита серии после отката: %+v, %v", history, err)
+	}
+}
+
+func TestSQLReadError(t *testing.T) {
+	storage := openTestSQLStorage(t, ":memory:")
+	ts := newTestServerWithStore(t, InitNewEventStoreWithStorage(storage, storage.AuditLog()))
+	storage.db.Close()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/events_for_day?user_id=1&date=2024-03-05", http.StatusInternalServerError},
+		{"/api/v1/events?user_id=1", http.StatusInternalServerError},
+		{"/api/v1/events/1?user_id=1", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		resp, body := ts.do(t, testRequest{method: "GET", path: tt.path})
+		if resp.StatusCode != tt.wantStatus || !strings.Contains(string(body), `"code":"storage"`) {
+			t.Errorf("%s: код ответа %d, ожидался %d с кодом storage:\n%s", tt.path, resp.StatusCode, tt.wantStatus, body)
+		}
+	}
+}
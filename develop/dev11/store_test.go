@@ -0,0 +1,492 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// === Свойства EventStore ===
+
+// storeHistory изменения событий, полученные подписчиком EventStore
+type storeHistory struct {
+	versions map[int][]int // версии каждого события в порядке уведомлений
+	created  int
+	deleted  int
+	errors   []string
+}
+
+// record проверяет и запоминает очередное изменение. Вызывается под блокировкой EventStore, поэтому без своей
+func (h *storeHistory) record(change EventChange) {
+	event := change.Event
+	versions := h.versions[event.ID]
+
+	switch change.Kind {
+	case ChangeCreated:
+		h.created++
+		if len(versions) != 0 || event.Version != 1 {
+			h.errors = append(h.errors, "повторное создание или версия не 1 у события "+event.Title)
+		}
+	case ChangeUpdated:
+		if len(versions) == 0 || event.Version <= versions[len(versions)-1] {
+			h.errors = append(h.errors, "версия не выросла при изменении события "+event.Title)
+		}
+	case ChangeDeleted:
+		h.deleted++
+	}
+
+	h.versions[event.ID] = append(versions, event.Version)
+}
+
+// randomEvent возвращает событие пользователя userID в марте 2024 года, иногда с окончанием или повторением
+func randomEvent(rnd *rand.Rand, userID int) Event {
+	event := Event{
+		UserID: userID,
+		Title:  "Событие " + string(rune('А'+rnd.Intn(32))),
+		Date:   at(2024, time.March, 1+rnd.Intn(31), rnd.Intn(24), rnd.Intn(4)*15),
+	}
+
+	switch rnd.Intn(4) {
+	case 0:
+		end := event.Date.Add(time.Duration(1+rnd.Intn(8)) * 15 * time.Minute)
+		event.End = &end
+	case 1:
+		event.Recurrence = &Recurrence{Freq: "DAILY", Count: 1 + rnd.Intn(5)}
+	}
+
+	return event
+}
+
+// TestEventStoreConcurrentInvariants проверяет инварианты EventStore при одновременных созданиях, изменениях,
+// удалениях и чтениях событий нескольких пользователей. Запускать с -race
+func TestEventStoreConcurrentInvariants(t *testing.T) {
+	const (
+		users   = 3
+		writers = 8
+		readers = 4
+		ops     = 300
+	)
+
+	store := InitNewEventStore()
+	history := &storeHistory{versions: make(map[int][]int)}
+	defer store.Watch(history.record)()
+
+	var (
+		mu      sync.Mutex
+		created int
+		deleted int
+		lastID  int
+	)
+
+	var writersWG, readersWG sync.WaitGroup
+	done := make(chan struct{})
+	failures := make(chan string, writers+readers)
+
+	for w := 0; w < writers; w++ {
+		writersWG.Add(1)
+		go func(seed int64) {
+			defer writersWG.Done()
+			rnd := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < ops; i++ {
+				userID := 1 + rnd.Intn(users)
+
+				mu.Lock()
+				target := 1 + rnd.Intn(lastID+1)
+				mu.Unlock()
+
+				var err error
+				switch op := rnd.Intn(10); {
+				case op < 4:
+					var id int
+					id, err = store.AddEvent(randomEvent(rnd, userID))
+					if err == nil {
+						mu.Lock()
+						created++
+						if id > lastID {
+							lastID = id
+						}
+						mu.Unlock()
+					}
+				case op < 8:
+					changes := randomEvent(rnd, userID)
+					changes.ID = target
+					_, err = store.UpdateEvent(userID, changes, UpdateFields("title", "date"))
+				default:
+					err = store.DeleteEvent(userID, target)
+					if err == nil {
+						mu.Lock()
+						deleted++
+						mu.Unlock()
+					}
+				}
+
+				// Клиенты выбирают чужие и удалённые события наугад: отказы ожидаемы, иные ошибки — нет
+				if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrForbidden) {
+					failures <- "неожиданная ошибка: " + err.Error()
+					return
+				}
+			}
+		}(int64(w))
+	}
+
+	for r := 0; r < readers; r++ {
+		readersWG.Add(1)
+		go func(userID int) {
+			defer readersWG.Done()
+			from, to := monthBounds(at(2024, time.March, 1, 0, 0))
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				events, err := store.GetEventsForRange(userID, from, to)
+				if err != nil {
+					failures <- err.Error()
+					return
+				}
+				if !sort.SliceIsSorted(events, func(i, j int) bool { return eventLess(events[i], events[j]) }) {
+					failures <- "события не упорядочены по дате и ID"
+					return
+				}
+				for _, event := range events {
+					if event.UserID != userID || event.Date.Before(from) || event.Date.After(to) {
+						failures <- "в выборку попало чужое событие или событие вне периода: " + event.Title
+						return
+					}
+				}
+			}
+		}(1 + r%users)
+	}
+
+	writersWG.Wait()
+	close(done)
+	readersWG.Wait()
+	close(failures)
+
+	for failure := range failures {
+		t.Error(failure)
+	}
+	for _, failure := range history.errors {
+		t.Error(failure)
+	}
+
+	if history.created != created || history.deleted != deleted {
+		t.Errorf("уведомлений о создании %d и удалении %d, ожидалось %d и %d", history.created, history.deleted, created, deleted)
+	}
+
+	seen := make(map[int]bool)
+	for userID := 1; userID <= users; userID++ {
+		events, err := store.GetUserEvents(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if seen[event.ID] {
+				t.Errorf("ID %d выдан нескольким событиям", event.ID)
+			}
+			seen[event.ID] = true
+
+			if event.UserID != userID {
+				t.Errorf("событие %d пользователя %d выдано пользователю %d", event.ID, event.UserID, userID)
+			}
+
+			versions := history.versions[event.ID]
+			if len(versions) == 0 || versions[len(versions)-1] != event.Version {
+				t.Errorf("версия события %d = %d, последнее уведомление — %v", event.ID, event.Version, versions)
+			}
+		}
+	}
+
+	if len(seen) != created-deleted {
+		t.Errorf("в хранилище %d событий, ожидалось %d (создано %d, удалено %d)", len(seen), created-deleted, created, deleted)
+	}
+}
+
+// eventLess порядок sortEvents
+func eventLess(a, b Event) bool {
+	if !a.Date.Equal(b.Date) {
+		return a.Date.Before(b.Date)
+	}
+	return a.ID < b.ID
+}
+
+// TestEventStoreIfMatchRace проверяет, что из одновременных изменений с одной и той же версией проходит ровно одно,
+// а UpdateEvent возвращает именно записанное им событие
+func TestEventStoreIfMatchRace(t *testing.T) {
+	const clients = 16
+
+	store := InitNewEventStore()
+	id, err := store.AddEvent(Event{UserID: 1, Title: "Спорное", Date: at(2024, time.March, 4, 10, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		title   string
+		updated Event
+		err     error
+	}
+
+	results := make(chan result, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			changes := Event{ID: id, Title: "Клиент " + string(rune('A'+i))}
+			updated, err := store.UpdateEvent(1, changes, IfMatch(1))
+			results <- result{changes.Title, updated, err}
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	var winner result
+	succeeded := 0
+	for r := range results {
+		switch {
+		case r.err == nil:
+			succeeded++
+			winner = r
+		case !errors.Is(r.err, ErrPrecondition):
+			t.Errorf("UpdateEvent() error %v, expected precondition_failed", r.err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Errorf("успешных изменений %d, ожидалось 1", succeeded)
+	}
+	if winner.updated.Title != winner.title || winner.updated.Version != 2 {
+		t.Errorf("UpdateEvent() вернул %q версии %d, ожидалось %q версии 2", winner.updated.Title, winner.updated.Version, winner.title)
+	}
+
+	if event, _ := store.GetEvent(1, id); event.Version != 2 || event.Title != winner.title {
+		t.Errorf("событие %q версии %d, ожидалось %q версии 2", event.Title, event.Version, winner.title)
+	}
+}
+
+// === Свойства выдачи и границ периодов ===
+
+// quickConfig параметры testing/quick с воспроизводимым генератором
+var quickConfig = &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+
+// quickTime отображает произвольное число в момент 2000–2040 годов в одном из часовых поясов с переходами времени
+func quickTime(seconds int64, zone uint8) time.Time {
+	zones := []string{"UTC", "Europe/Moscow", "Europe/Berlin", "America/New_York", "Australia/Lord_Howe"}
+	location, err := time.LoadLocation(zones[int(zone)%len(zones)])
+	if err != nil {
+		location = time.UTC
+	}
+
+	const span = 40 * 365 * 24 * 60 * 60
+	if seconds < 0 {
+		seconds = -seconds
+	}
+	return time.Unix(946684800+seconds%span, 0).In(location)
+}
+
+func TestPeriodBoundsProperties(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds func(time.Time) (time.Time, time.Time)
+		check  func(date, start time.Time) bool // свойство начала периода
+		next   func(start time.Time) time.Time  // начало следующего периода
+	}{
+		{
+			name:   "день",
+			bounds: dayBounds,
+			check:  func(date, start time.Time) bool { return start.Day() == date.Day() },
+			next:   func(start time.Time) time.Time { return start.AddDate(0, 0, 1) },
+		},
+		{
+			name:   "неделя",
+			bounds: weekBounds,
+			check:  func(_, start time.Time) bool { return start.Weekday() == time.Monday },
+			next:   func(start time.Time) time.Time { return start.AddDate(0, 0, 7) },
+		},
+		{
+			name:   "месяц",
+			bounds: monthBounds,
+			check:  func(date, start time.Time) bool { return start.Day() == 1 && start.Month() == date.Month() },
+			next:   func(start time.Time) time.Time { return start.AddDate(0, 1, 0) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			property := func(seconds int64, zone uint8) bool {
+				date := quickTime(seconds, zone)
+				start, end := tt.bounds(date)
+
+				// Период содержит date, начинается в полночь и вплотную примыкает к следующему
+				return !date.Before(start) && !date.After(end) &&
+					start.Hour() == 0 && start.Minute() == 0 && start.Location() == date.Location() &&
+					tt.check(date, start) && end.Add(time.Nanosecond).Equal(tt.next(start))
+			}
+
+			if err := quick.Check(property, quickConfig); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// quickEvents строит упорядоченный sortEvents список событий с повторяющимися датами и названиями
+func quickEvents(seeds []uint16) []Event {
+	names := []string{"Планёрка", "ПЛАНЁРКА", "обед", "Обед", "Straße", "STRASSE", "Kelvin"}
+
+	events := make([]Event, 0, len(seeds))
+	for i, seed := range seeds {
+		events = append(events, Event{
+			ID:    i + 1,
+			Title: names[int(seed)%len(names)],
+			Date:  at(2024, time.March, 1, int(seed/16)%24, 0),
+		})
+	}
+
+	sortEvents(events)
+	return events
+}
+
+// TestSelectEventsPagination проверяет, что страницы, полученные по next_cursor, в сумме дают полную выдачу без повторов
+func TestSelectEventsPagination(t *testing.T) {
+	property := func(seeds []uint16, limit uint8, withQuery bool) bool {
+		events := quickEvents(seeds)
+		options := ListOptions{Limit: 1 + int(limit)%7}
+		if withQuery {
+			options.Query = "планёрка"
+		}
+
+		all, next := SelectEvents(events, ListOptions{Query: options.Query})
+		if next != nil {
+			return false
+		}
+
+		var pages []Event
+		for {
+			page, next := SelectEvents(events, options)
+			if len(page) > options.Limit || (next != nil && len(page) != options.Limit) {
+				return false
+			}
+
+			pages = append(pages, page...)
+			if next == nil {
+				break
+			}
+
+			// Курсор проходит через клиента в виде строки
+			cursor, err := ParseCursor(next.String())
+			if err != nil {
+				return false
+			}
+			options.After = &cursor
+		}
+
+		if len(pages) != len(all) {
+			return false
+		}
+		for i := range all {
+			if pages[i].ID != all[i].ID {
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, quickConfig); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestFoldCase проверяет, что foldCase сопоставляет строки так же, как strings.EqualFold
+func TestFoldCase(t *testing.T) {
+	property := func(a, b string) bool {
+		return (foldCase(a) == foldCase(b)) == strings.EqualFold(a, b)
+	}
+
+	if err := quick.Check(property, quickConfig); err != nil {
+		t.Error(err)
+	}
+
+	for _, pair := range [][2]string{{"Straſe", "STRASE"}, {"Kelvin", "kelvin"}, {"ЁЛКА", "ёлка"}} {
+		if foldCase(pair[0]) != foldCase(pair[1]) {
+			t.Errorf("foldCase(%q) != foldCase(%q)", pair[0], pair[1])
+		}
+	}
+}
+
+// === Выборка за период ===
+
+// TestEventsForRangeOverlap проверяет, что выборка за период возвращает события, начавшиеся раньше периода,
+// но ещё идущие в нём, а просмотр индекса в прошлое ограничен продолжительностью реальных событий
+func TestEventsForRangeOverlap(t *testing.T) {
+	storage := InitNewMemoryStorage()
+	store := InitNewEventStoreWithStorage(storage, InitNewMemoryAuditLog())
+
+	allDayEnd := at(2024, time.March, 7, 0, 0)
+	overnightEnd := at(2024, time.March, 5, 2, 0)
+	shiftEnd := at(2024, time.February, 27, 8, 0)
+	events := []Event{
+		{UserID: 1, Title: "Отпуск", Date: at(2024, time.March, 4, 0, 0), End: &allDayEnd, AllDay: true},
+		{UserID: 1, Title: "Ночной релиз", Date: at(2024, time.March, 4, 22, 0), End: &overnightEnd},
+		{UserID: 1, Title: "Дежурство", Date: at(2024, time.February, 26, 20, 0), End: &shiftEnd, Recurrence: &Recurrence{Freq: "WEEKLY"}},
+		{UserID: 1, Title: "Вчерашнее", Date: at(2024, time.March, 4, 10, 0)},
+		{UserID: 2, Title: "Выездная сессия", Date: at(2024, time.March, 3, 9, 0), End: &allDayEnd, Attendees: []Attendee{{UserID: 1}}},
+	}
+	ids := make([]int, len(events))
+	for i, event := range events {
+		id, err := store.AddEvent(event)
+		if err != nil {
+			t.Fatalf("%s: %v", event.Title, err)
+		}
+		ids[i] = id
+	}
+
+	events, err := store.GetEventsByDate(1, at(2024, time.March, 5, 12, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, event := range events {
+		titles = append(titles, event.Title)
+	}
+	want := []string{"Выездная сессия", "Отпуск", "Дежурство", "Ночной релиз"}
+	if fmt.Sprint(titles) != fmt.Sprint(want) {
+		t.Errorf("события за 5 марта %v, ожидались %v", titles, want)
+	}
+
+	busy, err := store.FreeBusy(1, at(2024, time.March, 5, 0, 0), at(2024, time.March, 5, 12, 0))
+	if err != nil || len(busy) != 1 || !busy[0].Start.Equal(at(2024, time.March, 5, 0, 0)) || !busy[0].End.Equal(at(2024, time.March, 5, 12, 0)) {
+		t.Errorf("занятость 5 марта %+v, ожидалась весь период", busy)
+	}
+
+	// Наибольшая продолжительность следует за событиями пользователя, а не за пределом maxEventDuration
+	if got := storage.longest[1]; got != 3*24*time.Hour {
+		t.Errorf("наибольшая продолжительность %v, ожидалось 72h", got)
+	}
+	if err := store.DeleteEvent(1, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.longest[1]; got != 12*time.Hour {
+		t.Errorf("после удаления самого длинного события наибольшая продолжительность %v, ожидалось 12h", got)
+	}
+	if err := store.DeleteEvent(1, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteEvent(1, ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storage.longest[1]; ok {
+		t.Errorf("у пользователя без продолжительных событий осталась наибольшая продолжительность %v", storage.longest[1])
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==                == == =
+	= ==== ЛЕНТА ИЗМЕНЕНИЙ ==== =
+	  = == ==                == == =
+*/
+
+// streamPath адрес потока изменений событий (Server-Sent Events)
+const streamPath = "/events/stream"
+
+// changeLogSize сколько последних изменений хранит лента для возобновления потока по Last-Event-ID
+const changeLogSize = 1024
+
+// subscriberBuffer сколько изменений может ждать отправки одному клиенту. Клиент, который не успевает
+// их забирать, отключается и переподключается с Last-Event-ID, не задерживая остальных
+const subscriberBuffer = 64
+
+// streamHeartbeat период комментариев-пингов в потоке: по ним обнаруживаются отключившиеся клиенты,
+// а прокси не закрывают соединение как простаивающее
+const streamHeartbeat = 15 * time.Second
+
+// streamRetry через сколько миллисекунд клиент EventSource переподключается после обрыва
+const streamRetry = 3000
+
+// streamTimeoutMargin какую часть write_timeout поток оставляет в запасе: обработчик не может снять ограничение
+// write_timeout с соединения, поэтому поток завершается сам за write_timeout/streamTimeoutMargin до него, а клиент
+// EventSource переподключается с Last-Event-ID и получает изменения, пропущенные за время переподключения
+const streamTimeoutMargin = 10
+
+// FeedEntry изменение события с порядковым номером в ленте
+type FeedEntry struct {
+	ID     uint64
+	Change EventChange
+}
+
+// feedSubscriber клиент ленты, получающий изменения событий одного пользователя: его собственных и тех, в которых он участник
+type feedSubscriber struct {
+	userID  int
+	entries chan FeedEntry
+}
+
+// ChangeFeed лента изменений событий EventStore. Последние changeLogSize изменений хранятся в кольцевом буфере:
+// изменение с номером id лежит в log[id % len(log)]. Номера начинаются с 1 и сбрасываются при перезапуске сервера
+type ChangeFeed struct {
+	sync.Mutex
+	log         []FeedEntry
+	lastID      uint64
+	subscribers map[*feedSubscriber]struct{}
+	closed      bool
+	unwatch     func()
+}
+
+// NewChangeFeed возвращает ленту изменений событий store, хранящую последние size изменений
+func NewChangeFeed(store *EventStore, size int) *ChangeFeed {
+	feed := &ChangeFeed{
+		log:         make([]FeedEntry, size),
+		subscribers: make(map[*feedSubscriber]struct{}),
+	}
+	feed.unwatch = store.Watch(feed.publish)
+	return feed
+}
+
+// publish добавляет изменение в ленту и рассылает его подписчикам. Вызывается под блокировкой EventStore,
+// поэтому не ждёт медленных клиентов
+func (f *ChangeFeed) publish(change EventChange) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.closed {
+		return
+	}
+
+	f.lastID++
+	entry := FeedEntry{ID: f.lastID, Change: change}
+	f.log[entry.ID%uint64(len(f.log))] = entry
+
+	for sub := range f.subscribers {
+		if !change.Event.visibleTo(sub.userID) {
+			continue
+		}
+
+		select {
+		case sub.entries <- entry:
+		default:
+			delete(f.subscribers, sub)
+			close(sub.entries)
+		}
+	}
+}
+
+// Subscribe подписывает клиента на изменения событий пользователя userID. Если resume, клиент уже получил
+// изменения до lastID включительно: backlog содержит пропущенные им изменения из ленты. complete == false,
+// если часть пропущенных изменений уже вытеснена из ленты (или номер относится к прошлому запуску сервера)
+// и клиенту нужно заново загрузить события. current — номер последнего изменения в ленте.
+// Для закрытой ленты sub == nil
+func (f *ChangeFeed) Subscribe(userID int, lastID uint64, resume bool) (sub *feedSubscriber, backlog []FeedEntry, current uint64, complete bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.closed {
+		return nil, nil, 0, false
+	}
+
+	sub = &feedSubscriber{userID: userID, entries: make(chan FeedEntry, subscriberBuffer)}
+	f.subscribers[sub] = struct{}{}
+
+	if !resume {
+		return sub, nil, f.lastID, true
+	}
+
+	var oldest uint64 = 1
+	if f.lastID > uint64(len(f.log)) {
+		oldest = f.lastID - uint64(len(f.log)) + 1
+	}
+
+	if lastID > f.lastID || lastID+1 < oldest {
+		return sub, nil, f.lastID, false
+	}
+
+	for id := lastID + 1; id <= f.lastID; id++ {
+		if entry := f.log[id%uint64(len(f.log))]; entry.Change.Event.visibleTo(userID) {
+			backlog = append(backlog, entry)
+		}
+	}
+
+	return sub, backlog, f.lastID, true
+}
+
+// Unsubscribe отписывает клиента от ленты
+func (f *ChangeFeed) Unsubscribe(sub *feedSubscriber) {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.subscribers[sub]; ok {
+		delete(f.subscribers, sub)
+		close(sub.entries)
+	}
+}
+
+// Close отключает всех клиентов и отписывает ленту от хранилища. Вызывается при остановке сервера:
+// открытые потоки иначе не дали бы ему дождаться завершения запросов
+func (f *ChangeFeed) Close() {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.closed {
+		return
+	}
+	f.closed = true
+
+	for sub := range f.subscribers {
+		delete(f.subscribers, sub)
+		close(sub.entries)
+	}
+	f.unwatch()
+}
+
+// === HTTP ===
+
+// writeSSE записывает событие Server-Sent Events. Многострочные данные разбиваются на несколько строк data
+func writeSSE(w io.Writer, id uint64, name string, data []byte) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %d\nevent: %s\n", id, name)
+	for _, line := range strings.Split(string(data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeFeedEntry отправляет изменение события клиенту. Имя события SSE — вид изменения (created, updated, deleted)
+func writeFeedEntry(w io.Writer, entry FeedEntry) error {
+	localizeEvent(&entry.Change.Event)
+	data, err := json.Marshal(entry.Change)
+	if err != nil {
+		return err
+	}
+
+	return writeSSE(w, entry.ID, string(entry.Change.Kind), data)
+}
+
+// lastEventID возвращает номер последнего полученного клиентом изменения из заголовка Last-Event-ID,
+// который EventSource присылает при переподключении, или из параметра last_event_id для первого подключения
+func lastEventID(r *http.Request) (uint64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, badRequestf("некорректный Last-Event-ID %q", value)
+	}
+	return id, true, nil
+}
+
+// EventStreamHandler отправляет клиенту изменения событий пользователя в формате Server-Sent Events:
+//
+//	id: 42
+//	event: updated
+//	data: {"kind":"updated","event":{...}}
+//
+// С Last-Event-ID (или last_event_id) сначала отправляются пропущенные изменения. Если их уже нет в ленте,
+// клиент получает событие reset и должен заново загрузить события пользователя. Поток завершается незадолго
+// до write_timeout (см. streamTimeoutMargin)
+func (s *Server) EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.RespondWithError(w, errMethodNotAllowed)
+		return
+	}
+
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	lastID, resume, err := lastEventID(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.RespondWithError(w, errors.New("соединение не поддерживает поток"))
+		return
+	}
+
+	sub, backlog, current, complete := s.Changes.Subscribe(userID, lastID, resume)
+	if sub == nil {
+		s.RespondWithJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "сервер останавливается", Code: "unavailable"})
+		return
+	}
+	defer s.Changes.Unsubscribe(sub)
+
+	var expired <-chan time.Time
+	if timeout := time.Duration(s.Config.WriteTimeout); timeout > 0 {
+		lifetime := time.NewTimer(timeout - timeout/streamTimeoutMargin)
+		defer lifetime.Stop()
+		expired = lifetime.C
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetry); err != nil {
+		return
+	}
+
+	if !complete {
+		if err := writeSSE(w, current, "reset", []byte("{}")); err != nil {
+			return
+		}
+	}
+
+	for _, entry := range backlog {
+		if err := writeFeedEntry(w, entry); err != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expired:
+			return
+		case entry, ok := <-sub.entries:
+			if !ok {
+				return
+			}
+			if err := writeFeedEntry(w, entry); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addEvents создаёт в календаре события пользователей userIDs по порядку
+func addEvents(t *testing.T, store *EventStore, userIDs ...int) {
+	t.Helper()
+
+	for i, userID := range userIDs {
+		if _, err := store.AddEvent(Event{UserID: userID, Title: "Событие", Date: at(2024, time.March, 4, 10+i, 0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// entryIDs возвращает номера изменений по порядку
+func entryIDs(entries []FeedEntry) []uint64 {
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+func TestChangeFeedResume(t *testing.T) {
+	store := InitNewEventStore()
+	feed := NewChangeFeed(store, 4)
+	defer feed.Close()
+
+	addEvents(t, store, 1, 2, 1)
+
+	tests := []struct {
+		name         string
+		lastID       uint64
+		resume       bool
+		wantBacklog  []uint64
+		wantComplete bool
+	}{
+		{name: "первое подключение", wantComplete: true},
+		{name: "с начала ленты", lastID: 0, resume: true, wantBacklog: []uint64{1, 3}, wantComplete: true},
+		{name: "пропущено одно изменение", lastID: 2, resume: true, wantBacklog: []uint64{3}, wantComplete: true},
+		{name: "ничего не пропущено", lastID: 3, resume: true, wantComplete: true},
+		{name: "номер из прошлого запуска", lastID: 10, resume: true},
+	}
+
+	for _, tt := range tests {
+		sub, backlog, current, complete := feed.Subscribe(1, tt.lastID, tt.resume)
+		if sub == nil || current != 3 || complete != tt.wantComplete {
+			t.Errorf("%s: подписка %v, текущий номер %d, полнота %v", tt.name, sub != nil, current, complete)
+		}
+		if got := entryIDs(backlog); fmt.Sprint(got) != fmt.Sprint(tt.wantBacklog) {
+			t.Errorf("%s: пропущенные изменения %v, ожидались %v", tt.name, got, tt.wantBacklog)
+		}
+		feed.Unsubscribe(sub)
+	}
+
+	// Изменения 1 и 2 вытеснены из ленты размером 4 — клиенту нужно загрузить события заново
+	addEvents(t, store, 1, 1, 1)
+	if _, backlog, current, complete := feed.Subscribe(1, 1, true); complete || backlog != nil || current != 6 {
+		t.Errorf("вытесненные изменения: %v, полнота %v, текущий номер %d", entryIDs(backlog), complete, current)
+	}
+	if _, backlog, _, complete := feed.Subscribe(1, 2, true); !complete || len(backlog) != 4 {
+		t.Errorf("самое старое изменение в ленте: %v, полнота %v", entryIDs(backlog), complete)
+	}
+}
+
+func TestChangeFeedSubscribers(t *testing.T) {
+	store := InitNewEventStore()
+	feed := NewChangeFeed(store, changeLogSize)
+
+	own, _, _, _ := feed.Subscribe(1, 0, false)
+	slow, _, _, _ := feed.Subscribe(2, 0, false)
+
+	addEvents(t, store, 1, 2)
+	if entry := <-own.entries; entry.ID != 1 || entry.Change.Kind != ChangeCreated || entry.Change.Event.UserID != 1 {
+		t.Errorf("изменение %+v", entry)
+	}
+	select {
+	case entry := <-own.entries:
+		t.Errorf("клиент получил изменение чужого события %+v", entry)
+	default:
+	}
+
+	// Клиент, не успевающий читать, отключается, а не задерживает запись
+	users := make([]int, subscriberBuffer+1)
+	for i := range users {
+		users[i] = 2
+	}
+	addEvents(t, store, users...)
+	received := 0
+	for range slow.entries {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("медленный клиент получил %d изменений до отключения, ожидалось %d", received, subscriberBuffer)
+	}
+
+	feed.Close()
+	if _, ok := <-own.entries; ok {
+		t.Error("после закрытия ленты канал клиента не закрыт")
+	}
+	if sub, _, _, _ := feed.Subscribe(1, 0, false); sub != nil {
+		t.Error("закрытая лента не должна принимать подписки")
+	}
+	addEvents(t, store, 1) // закрытая лента отписана от хранилища
+}
+
+func TestWriteSSE(t *testing.T) {
+	var b strings.Builder
+	if err := writeSSE(&b, 42, "updated", []byte("{\"a\":1}\n{\"b\":2}")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "id: 42\nevent: updated\ndata: {\"a\":1}\ndata: {\"b\":2}\n\n"
+	if b.String() != want {
+		t.Errorf("событие SSE %q, ожидалось %q", b.String(), want)
+	}
+}
+
+func TestLastEventID(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		query      string
+		wantID     uint64
+		wantResume bool
+		wantErr    bool
+	}{
+		{name: "без номера"},
+		{name: "заголовок", header: "42", wantID: 42, wantResume: true},
+		{name: "параметр", query: "7", wantID: 7, wantResume: true},
+		{name: "заголовок важнее параметра", header: "0", query: "7", wantID: 0, wantResume: true},
+		{name: "не число", header: "abc", wantErr: true},
+		{name: "отрицательный", query: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", streamPath+"?last_event_id="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Last-Event-ID", tt.header)
+		}
+
+		id, resume, err := lastEventID(r)
+		if (err != nil) != tt.wantErr || id != tt.wantID || resume != tt.wantResume {
+			t.Errorf("%s: lastEventID() = %d, %v, %v", tt.name, id, resume, err)
+		}
+	}
+}
@@ -1,13 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -34,15 +47,22 @@ import (
 */
 
 func main() {
-	server, err := initNewServer()
+	configPath := flag.String("config", "config.json", "путь к конфигурационному файлу")
+	flag.Parse()
+
+	config, err := ReadConfigFromFile(*configPath) // Порт нужно брать из конфигурационного файла
 	if err != nil {
-		log.Fatalf("Сбой запуска сервера: %s", err)
-		os.Exit(1)
+		log.Fatalf("Сбой запуска сервера: невозможно загрузить конфиг сервера: %s", err)
 	}
 
-	log.Printf("Сервер запущен на порте %s", server.Port)
+	server, err := initNewServer(config)
+	if err != nil {
+		log.Fatalf("Сбой запуска сервера: %s", err)
+	}
 
-	log.Fatal(http.ListenAndServe("localhost:"+server.Port, server.LoggingMiddleware(http.DefaultServeMux)))
+	if err := server.Run(); err != nil {
+		log.Fatalf("Сбой работы сервера: %s", err)
+	}
 }
 
 /*
@@ -54,258 +74,1244 @@ func main() {
 // === Структуры ===
 
 type Event struct {
-	ID        int       `json:"id"`
-	UserID    int       `json:"user_id"`
-	Title     string    `json:"title"`
-	Date      time.Time `json:"date"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          int         `json:"id"`
+	UserID      int         `json:"user_id"`
+	Title       string      `json:"title"`
+	UID         string      `json:"uid,omitempty"` // идентификатор события во внешних календарях (iCalendar UID)
+	Date        time.Time   `json:"date"`
+	End         *time.Time  `json:"end,omitempty"`      // окончание события; nil — событие без продолжительности
+	TimeZone    string      `json:"tz,omitempty"`       // часовой пояс IANA, в котором повторяется событие (например, Europe/Moscow)
+	AllDay      bool        `json:"all_day,omitempty"`  // событие на весь день (или несколько дней до End)
+	Place       string      `json:"location,omitempty"` // место проведения; поле не называется Location из-за метода Event.Location
+	Description string      `json:"description,omitempty"`
+	Recurrence  *Recurrence `json:"recurrence,omitempty"` // правило повторения; nil — разовое событие
+	SeriesID    int         `json:"series_id,omitempty"`  // ID серии, из которой выделено отредактированное вхождение
+	Occurrence  *time.Time  `json:"occurrence,omitempty"` // исходная дата вхождения серии
+	Reminders   []int       `json:"reminders,omitempty"`  // напоминания: за сколько минут до начала уведомить пользователя
+	Attendees   []Attendee  `json:"attendees,omitempty"`  // участники события кроме владельца и их ответы на приглашение
+	Version     int         `json:"version"`              // номер версии: увеличивается при каждом изменении события, по нему строится ETag
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// cloneRecurrence возвращает копию правила повторения, не разделяющую с оригиналом список исключений
+func cloneRecurrence(r *Recurrence) *Recurrence {
+	if r == nil {
+		return nil
+	}
+
+	clone := *r
+	clone.Exceptions = append([]time.Time(nil), r.Exceptions...)
+	if r.Until != nil {
+		until := *r.Until
+		clone.Until = &until
+	}
+	clone.ByDay = append([]string(nil), r.ByDay...)
+
+	return &clone
 }
 
-// === EventStore (хранилище событий в памяти) ===
+// maxEventTime верхняя граница дат событий, используемая для выборки всех событий хранилища
+var maxEventTime = time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+// === EventStore (бизнес-логика работы с событиями) ===
+
+// EventStore проверяет события на соответствие правилам предметной области и делегирует их хранение реализации Storage.
+// Изменения выполняются под эксклюзивной блокировкой, чтения не блокируют друг друга.
 type EventStore struct {
-	sync.Mutex
-	Events map[int]Event
-	NextID int
+	sync.RWMutex
+	storage       Storage
+	audit         AuditLog
+	watchers      map[int]func(EventChange)
+	nextWatcherID int
+	pending       *[]pendingOperation // не nil, пока ApplyBatch выполняет пакет операций
+}
+
+// ChangeKind вид изменения события
+type ChangeKind string
+
+// Виды изменений событий
+const (
+	ChangeCreated ChangeKind = "created"
+	ChangeUpdated ChangeKind = "updated"
+	ChangeDeleted ChangeKind = "deleted"
+)
+
+// EventChange изменение события в хранилище
+type EventChange struct {
+	Kind  ChangeKind `json:"kind"`
+	Event Event      `json:"event"`
+}
+
+// WriteOption параметр записи события в EventStore
+type WriteOption func(*writeOptions) error
+
+// writeOptions параметры записи события. По умолчанию пересечения не проверяются, версия не сверяется,
+// а при изменении переносятся заполненные поля
+type writeOptions struct {
+	rejectConflicts bool
+	conflicts       *[]Event
+	ifMatch         []int           // допустимые текущие версии события; nil — без проверки
+	fields          map[string]bool // изменяемые поля; nil — заполненные поля
+	actor           int             // автор изменения для журнала аудита; 0 — владелец календаря
+	users           UserDirectory   // известные пользователи для проверки доступа к календарю; nil — без проверки
 }
 
-// InitNewEventStore возвращает указатель на новую структуру EventStore с сначальным значением NextID = 1
+// newWriteOptions собирает параметры записи
+func newWriteOptions(opts []WriteOption) (writeOptions, error) {
+	var options writeOptions
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return writeOptions{}, err
+		}
+	}
+	return options, nil
+}
+
+// InitNewEventStore возвращает указатель на новую структуру EventStore, хранящую события и журнал аудита в памяти
 func InitNewEventStore() *EventStore {
+	return InitNewEventStoreWithStorage(InitNewMemoryStorage(), InitNewMemoryAuditLog())
+}
+
+// InitNewEventStoreWithStorage возвращает указатель на новую структуру EventStore поверх переданных хранилища
+// и журнала аудита
+func InitNewEventStoreWithStorage(storage Storage, audit AuditLog) *EventStore {
 	return &EventStore{
-		Events: make(map[int]Event),
-		NextID: 1,
+		storage:  storage,
+		audit:    audit,
+		watchers: make(map[int]func(EventChange)),
 	}
 }
 
-// AddEvent добавляет событие в хранилище
-func (es *EventStore) AddEvent(event Event) (int, error) {
+// Watch подписывает fn на изменения событий и возвращает функцию отмены подписки.
+// fn вызывается под блокировкой EventStore, поэтому не должна блокироваться и обращаться к EventStore.
+func (es *EventStore) Watch(fn func(EventChange)) (cancel func()) {
+	es.Lock()
+	defer es.Unlock()
 
-	if event.UserID < 1 {
-		return -1, fmt.Errorf("должен быть указан UserID превосходящий 0")
+	id := es.nextWatcherID
+	es.nextWatcherID++
+	es.watchers[id] = fn
+
+	return func() {
+		es.Lock()
+		defer es.Unlock()
+		delete(es.watchers, id)
 	}
+}
 
+// notify сообщает подписчикам об изменении события. Вызывается под блокировкой
+func (es *EventStore) notify(kind ChangeKind, event Event) {
+	for _, fn := range es.watchers {
+		fn(EventChange{Kind: kind, Event: event})
+	}
+}
+
+// validateEvent проверяет заполненные клиентом поля события и приводит список напоминаний к каноническому виду
+func validateEvent(event *Event) error {
 	if event.Title == "" {
-		return -1, fmt.Errorf("обязателен к заполнению Title события (!= \"\") ")
+		return validationErrorf("обязателен к заполнению Title события (!= \"\") ")
+	}
+
+	if err := validateEventTimes(event); err != nil {
+		return err
+	}
+
+	if event.Recurrence != nil {
+		if err := event.Recurrence.Validate(); err != nil {
+			return validationErrorf("некорректное правило повторения: %v", err)
+		}
+	}
+
+	if event.Reminders != nil {
+		reminders, err := normalizeReminders(event.Reminders)
+		if err != nil {
+			return validationErrorf("%v", err)
+		}
+		event.Reminders = reminders
 	}
 
-	event.CreatedAt = time.Now()
-	event.UpdatedAt = event.CreatedAt
+	return nil
+}
+
+// AddEvent добавляет событие в хранилище. Параметры opts включают проверку пересечений с другими событиями пользователя
+func (es *EventStore) AddEvent(event Event, opts ...WriteOption) (int, error) {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return -1, err
+	}
 
 	es.Lock()
 	defer es.Unlock()
 
-	event.ID = es.NextID
-	es.NextID++
+	created, err := es.addEvent(event, options)
+	if err != nil {
+		return -1, err
+	}
+
+	return created.ID, nil
+}
+
+// addEvent создаёт событие и возвращает его вместе с присвоенным ID. Вызывается под блокировкой
+func (es *EventStore) addEvent(event Event, options writeOptions) (Event, error) {
+	if event.UserID < 1 {
+		return Event{}, validationErrorf("должен быть указан UserID превосходящий 0")
+	}
+
+	if err := validateEvent(&event); err != nil {
+		return Event{}, err
+	}
+
+	if err := prepareAttendees(&event, nil); err != nil {
+		return Event{}, err
+	}
 
-	es.Events[event.ID] = event
+	// Выделенные из серии вхождения создаются только через UpdateEvent с указанием Occurrence
+	event.SeriesID = 0
+	event.Occurrence = nil
+
+	// Заданные заранее отметки времени сохраняются: так импорт переносит их из внешнего календаря
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if event.UpdatedAt.IsZero() {
+		event.UpdatedAt = event.CreatedAt
+	}
+	event.Version = 1
+
+	if err := es.checkConflicts(event, options); err != nil {
+		return Event{}, err
+	}
 
-	return event.ID, nil
+	create := &createCommand{storage: es.storage, event: event}
+	if err := es.execute(options.actorOr(event.UserID), create); err != nil {
+		return Event{}, err
+	}
+
+	return create.event, nil
 }
 
-// UpdateEvent обновляет событие в хранилище
-func (es *EventStore) UpdateEvent(event Event) error {
+// getOwnedEvent возвращает событие eventID, если оно принадлежит пользователю userID. Вызывается под блокировкой
+func (es *EventStore) getOwnedEvent(userID, eventID int) (Event, error) {
+	event, exists, err := es.storage.GetEvent(eventID)
+	if err != nil {
+		return Event{}, storageError(err)
+	}
+	if !exists {
+		return Event{}, notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	if event.UserID != userID {
+		return Event{}, forbiddenErrorf("событие с ID %d принадлежит другому пользователю", eventID)
+	}
+
+	return event, nil
+}
+
+// GetEvent возвращает хранимое событие eventID, если пользователь userID его владелец или участник
+func (es *EventStore) GetEvent(userID, eventID int) (Event, error) {
+	es.RLock()
+	defer es.RUnlock()
+
+	event, exists, err := es.storage.GetEvent(eventID)
+	if err != nil {
+		return Event{}, storageError(err)
+	}
+	if !exists {
+		return Event{}, notFoundErrorf("событие с ID %d не найдено", eventID)
+	}
+
+	if !event.visibleTo(userID) {
+		return Event{}, forbiddenErrorf("событие с ID %d принадлежит другому пользователю", eventID)
+	}
+
+	localizeEvent(&event)
+	return event, nil
+}
+
+// ReplaceEvent заменяет все изменяемые поля события пользователя userID значениями из event.
+// В отличие от UpdateEvent незаполненные поля не сохраняют прежние значения. Если событие перестаёт быть
+// повторяющимся, выделенные из серии вхождения удаляются вместе с ней. Возвращает записанное событие.
+func (es *EventStore) ReplaceEvent(userID int, event Event, opts ...WriteOption) (Event, error) {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return Event{}, err
+	}
+
 	es.Lock()
 	defer es.Unlock()
-	if e, exists := es.Events[event.ID]; !exists {
-		return fmt.Errorf("событие с ID %d не найдено", event.ID)
-	} else {
-		event.CreatedAt = e.CreatedAt
-		event.UpdatedAt = time.Now()
 
-		if event.UserID < 1 {
-			event.UserID = e.UserID
+	replaced, err := es.replaceEvent(userID, event, options)
+	if err != nil {
+		return Event{}, err
+	}
+
+	localizeEvent(&replaced)
+	return replaced, nil
+}
+
+// replaceEvent заменяет событие и возвращает его новое состояние. Вызывается под блокировкой
+func (es *EventStore) replaceEvent(userID int, event Event, options writeOptions) (Event, error) {
+	if err := validateEvent(&event); err != nil {
+		return Event{}, err
+	}
+
+	if event.Date.IsZero() {
+		return Event{}, validationErrorf("обязательна к заполнению дата события")
+	}
+
+	stored, err := es.getOwnedEvent(userID, event.ID)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if event.UserID > 0 && event.UserID != stored.UserID {
+		return Event{}, validationErrorf("событие с ID %d нельзя передать другому пользователю", event.ID)
+	}
+
+	if err := checkVersion(stored, options); err != nil {
+		return Event{}, err
+	}
+
+	event.UserID = stored.UserID
+	if err := prepareAttendees(&event, stored.Attendees); err != nil {
+		return Event{}, err
+	}
+
+	event.SeriesID = stored.SeriesID
+	event.Occurrence = stored.Occurrence
+	event.CreatedAt = stored.CreatedAt
+	event.UpdatedAt = time.Now()
+	event.Version = stored.Version + 1
+
+	if err := es.checkConflicts(event, options); err != nil {
+		return Event{}, err
+	}
+
+	var commands []Command
+	if stored.Recurrence != nil && event.Recurrence == nil {
+		if commands, err = es.deleteDetached(stored); err != nil {
+			return Event{}, err
+		}
+	}
+	commands = append(commands, &updateCommand{storage: es.storage, before: stored, after: event})
+
+	if err := es.execute(options.actorOr(userID), commands...); err != nil {
+		return Event{}, err
+	}
+
+	return event, nil
+}
+
+// deleteDetached возвращает команды удаления вхождений, выделенных из серии master. Вызывается под блокировкой
+func (es *EventStore) deleteDetached(master Event) ([]Command, error) {
+	events, err := es.storage.GetEventsForRange(master.UserID, time.Time{}, maxEventTime)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	var commands []Command
+	for _, detached := range events {
+		if detached.SeriesID == master.ID {
+			commands = append(commands, &deleteCommand{storage: es.storage, event: detached})
+		}
+	}
+
+	return commands, nil
+}
+
+// UpdateEvent обновляет событие пользователя userID в хранилище. Передать событие другому пользователю нельзя.
+// Если у повторяющегося события указано Occurrence, изменяется только это вхождение: оно исключается из серии
+// и сохраняется отдельным событием со ссылкой на серию. Иначе изменяется вся серия целиком.
+// Без параметра UpdateFields изменяются только заполненные поля event; с ним — ровно перечисленные поля,
+// и пустое значение очищает поле. Возвращает записанное событие, а при изменении вхождения — выделенное вхождение.
+func (es *EventStore) UpdateEvent(userID int, event Event, opts ...WriteOption) (Event, error) {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return Event{}, err
+	}
+
+	es.Lock()
+	defer es.Unlock()
+
+	updated, err := es.updateEvent(userID, event, options)
+	if err != nil {
+		return Event{}, err
+	}
+
+	localizeEvent(&updated)
+	return updated, nil
+}
+
+// updateEvent изменяет событие и возвращает его новое состояние, а при изменении вхождения серии — выделенное
+// вхождение. Вызывается под блокировкой
+func (es *EventStore) updateEvent(userID int, event Event, options writeOptions) (Event, error) {
+	e, err := es.getOwnedEvent(userID, event.ID)
+	if err != nil {
+		return Event{}, err
+	}
+
+	if event.UserID > 0 && event.UserID != e.UserID {
+		return Event{}, validationErrorf("событие с ID %d нельзя передать другому пользователю", event.ID)
+	}
+
+	if err := checkVersion(e, options); err != nil {
+		return Event{}, err
+	}
+
+	if event.Occurrence != nil && e.Recurrence != nil {
+		return es.detachOccurrence(e, event, options)
+	}
+
+	updated := e
+	applyChanges(&updated, event, options.fields)
+	if err := prepareAttendees(&updated, e.Attendees); err != nil {
+		return Event{}, err
+	}
+	updated.UpdatedAt = time.Now()
+	updated.Version = e.Version + 1
+
+	if err := validateStoredEvent(&updated); err != nil {
+		return Event{}, err
+	}
+
+	if err := es.checkConflicts(updated, options); err != nil {
+		return Event{}, err
+	}
+
+	if err := es.execute(options.actorOr(userID), &updateCommand{storage: es.storage, before: e, after: updated}); err != nil {
+		return Event{}, err
+	}
+
+	return updated, nil
+}
+
+// validateStoredEvent проверяет событие после применения изменений: в отличие от нового события у него
+// нельзя очистить дату
+func validateStoredEvent(event *Event) error {
+	if event.Date.IsZero() {
+		return validationErrorf("обязательна к заполнению дата события")
+	}
+
+	return validateEvent(event)
+}
+
+// applyChanges переносит в event изменения из changes. Если fields == nil, переносятся только заполненные поля
+// changes, иначе — ровно поля из fields, в том числе пустые. Перенос начала без нового окончания
+// сохраняет продолжительность события
+func applyChanges(event *Event, changes Event, fields map[string]bool) {
+	changed := func(field string, filled bool) bool {
+		if fields == nil {
+			return filled
+		}
+		return fields[field]
+	}
+
+	if changed("date", !changes.Date.IsZero()) {
+		event.End = movedEnd(*event, changes.Date)
+		event.Date = changes.Date
+	}
+
+	if changed("end", changes.End != nil) {
+		event.End = changes.End
+	}
+
+	if changed("title", changes.Title != "") {
+		event.Title = changes.Title
+	}
+
+	if changed("uid", changes.UID != "") {
+		event.UID = changes.UID
+	}
+
+	if changed("tz", changes.TimeZone != "") {
+		event.TimeZone = changes.TimeZone
+	}
+
+	if changed("all_day", changes.AllDay) {
+		event.AllDay = changes.AllDay
+	}
+
+	if changed("location", changes.Place != "") {
+		event.Place = changes.Place
+	}
+
+	if changed("description", changes.Description != "") {
+		event.Description = changes.Description
+	}
+
+	if changed("recurrence", changes.Recurrence != nil) {
+		event.Recurrence = changes.Recurrence
+	}
+
+	if changed("reminders", changes.Reminders != nil) {
+		event.Reminders = changes.Reminders
+	}
+
+	if changed("attendees", changes.Attendees != nil) {
+		event.Attendees = changes.Attendees
+	}
+}
+
+// detachOccurrence выделяет вхождение серии master в отдельное событие с изменениями из changes и возвращает его
+func (es *EventStore) detachOccurrence(master Event, changes Event, options writeOptions) (Event, error) {
+	occurrence, err := findOccurrence(master, *changes.Occurrence)
+	if err != nil {
+		return Event{}, err
+	}
+
+	instance := master
+	instance.ID = 0
+	instance.SeriesID = master.ID
+	instance.Occurrence = &occurrence
+	instance.Date = occurrence
+	instance.End = movedEnd(master, occurrence)
+	instance.CreatedAt = time.Now()
+	instance.UpdatedAt = instance.CreatedAt
+	instance.Version = 1
+
+	applyChanges(&instance, changes, options.fields)
+	instance.Recurrence = nil
+	if err := prepareAttendees(&instance, master.Attendees); err != nil {
+		return Event{}, err
+	}
+
+	if err := validateStoredEvent(&instance); err != nil {
+		return Event{}, err
+	}
+
+	if err := es.checkConflicts(instance, options); err != nil {
+		return Event{}, err
+	}
+
+	before := master
+	master.Recurrence = cloneRecurrence(master.Recurrence)
+	master.Recurrence.AddException(occurrence)
+	master.UpdatedAt = instance.CreatedAt
+	master.Version++
+
+	create := &createCommand{storage: es.storage, event: instance}
+	err = es.execute(options.actorOr(master.UserID),
+		&updateCommand{storage: es.storage, before: before, after: master},
+		create,
+	)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return create.event, nil
+}
+
+// findOccurrence находит вхождение серии master, приходящееся на дату date
+func findOccurrence(master Event, date time.Time) (time.Time, error) {
+	location := master.Location()
+	dayStart, dayEnd := dayBounds(date.In(location))
+
+	occurrences := master.Recurrence.Occurrences(master.Date.In(location), dayStart, dayEnd)
+	if len(occurrences) == 0 {
+		return time.Time{}, notFoundErrorf("у события с ID %d нет вхождения %s", master.ID, date.Format("2006-01-02"))
+	}
+
+	return occurrences[0], nil
+}
+
+// DeleteEvent удаляет событие пользователя userID из хранилища. Удаление серии удаляет и все выделенные из неё вхождения.
+// Удалённое событие остаётся в журнале аудита и возвращается через RestoreEvent. Из параметров opts учитываются только
+// IfMatch и Actor
+func (s *EventStore) DeleteEvent(userID, eventID int, opts ...WriteOption) error {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.deleteEvent(userID, eventID, options)
+}
+
+// deleteEvent удаляет событие вместе с выделенными из серии вхождениями. Вызывается под блокировкой
+func (s *EventStore) deleteEvent(userID, eventID int, options writeOptions) error {
+	event, err := s.getOwnedEvent(userID, eventID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(event, options); err != nil {
+		return err
+	}
+
+	var commands []Command
+	if event.Recurrence != nil {
+		if commands, err = s.deleteDetached(event); err != nil {
+			return err
+		}
+	}
+	commands = append(commands, &deleteCommand{storage: s.storage, event: event})
+
+	return s.execute(options.actorOr(userID), commands...)
+}
+
+// DeleteOccurrence удаляет одно вхождение повторяющегося события пользователя userID, исключая его из серии.
+// Из параметров opts учитываются только IfMatch (версия сверяется с версией серии) и Actor
+func (s *EventStore) DeleteOccurrence(userID, eventID int, occurrence time.Time, opts ...WriteOption) error {
+	options, err := newWriteOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.deleteOccurrence(userID, eventID, occurrence, options)
+}
+
+// deleteOccurrence исключает вхождение occurrence из серии. Вызывается под блокировкой
+func (s *EventStore) deleteOccurrence(userID, eventID int, occurrence time.Time, options writeOptions) error {
+	master, err := s.getOwnedEvent(userID, eventID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkVersion(master, options); err != nil {
+		return err
+	}
+
+	if master.Recurrence == nil {
+		return validationErrorf("событие с ID %d не является повторяющимся", eventID)
+	}
+
+	found, err := findOccurrence(master, occurrence)
+	if err != nil {
+		return err
+	}
+
+	before := master
+	master.Recurrence = cloneRecurrence(master.Recurrence)
+	master.Recurrence.AddException(found)
+	master.UpdatedAt = time.Now()
+	master.Version++
+
+	return s.execute(options.actorOr(userID), &updateCommand{storage: s.storage, before: before, after: master})
+}
+
+// GetEventsByDate возвращает все события пользователя за определенную дату
+func (s *EventStore) GetEventsByDate(userID int, date time.Time) ([]Event, error) {
+	start, end := dayBounds(date)
+
+	return s.GetEventsForRange(userID, start, end)
+}
+
+// GetEventsForRange возвращает все события пользователя за указанный диапазон дат, упорядоченные по дате, затем по ID.
+// Повторяющиеся события разворачиваются в отдельные вхождения.
+func (s *EventStore) GetEventsForRange(userID int, start, end time.Time) ([]Event, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.eventsForRange(userID, start, end)
+}
+
+// eventsForRange возвращает события пользователя, пересекающиеся с диапазоном дат (см. eventOverlaps), разворачивая
+// серии, в порядке sortEvents. Кроме собственных событий пользователя возвращает события, в которых он участник.
+// Вызывается под блокировкой
+func (s *EventStore) eventsForRange(userID int, start, end time.Time) ([]Event, error) {
+	events, err := s.storage.GetEventsForRange(userID, start, end)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	recurring, err := s.storage.GetRecurringEvents(userID)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	attended, err := s.storage.GetAttendedEvents(userID)
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	var result []Event
+	for _, event := range events {
+		if event.Recurrence == nil {
+			localizeEvent(&event)
+			result = append(result, event)
+		}
+	}
+
+	for _, master := range recurring {
+		result = append(result, overlappingOccurrences(master, start, end)...)
+	}
+
+	for _, event := range attended {
+		switch {
+		case event.Recurrence != nil:
+			result = append(result, overlappingOccurrences(event, start, end)...)
+		case eventOverlaps(event, start, end):
+			localizeEvent(&event)
+			result = append(result, event)
+		}
+	}
+
+	sortEvents(result)
+	return result, nil
+}
+
+// GetUserEvents возвращает все хранимые события пользователя без разворачивания серий, упорядоченные по дате, затем по ID
+func (s *EventStore) GetUserEvents(userID int) ([]Event, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	result, err := s.storage.GetEventsForRange(userID, time.Time{}, maxEventTime)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	for i := range result {
+		localizeEvent(&result[i])
+	}
+
+	sortEvents(result)
+
+	return result, nil
+}
+
+// Close закрывает хранилище событий и журнал аудита
+func (s *EventStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.storage.Close()
+	if auditErr := s.audit.Close(); auditErr != nil && err == nil {
+		err = auditErr
+	}
+
+	return err
+}
+
+/*
+	  = == ==       == == =
+	= ==== HTTP СЕРВЕР ==== =
+	  = == ==       == == =
+*/
+
+// === Структуры ===
+
+type Server struct {
+	Port      string             `json:"port"`
+	Calendar  *EventStore        `json:"calendar"`
+	Auth      Authenticator      `json:"-"` // nil — аутентификация отключена
+	Reminders *ReminderScheduler `json:"-"`
+	Config    Config             `json:"-"`
+	Metrics   *Metrics           `json:"-"`
+	Changes   *ChangeFeed        `json:"-"`
+	Limiter   *RateLimiter       `json:"-"` // nil — частота запросов пользователя не ограничивается
+	AddrLimit *RateLimiter       `json:"-"` // nil — частота запросов с адреса не ограничивается
+	mux       *http.ServeMux
+	routes    []string // адреса, зарегистрированные в mux
+}
+
+type RequestObjects struct {
+	User_ID int       `json:"user_id"`
+	Date    time.Time `json:"date"`
+}
+
+// NewServer возвращает сервер поверх календаря calendar с собственной системой маршрутизации,
+// поэтому в одном процессе может работать несколько серверов
+func NewServer(calendar *EventStore) *Server {
+	server := &Server{
+		Calendar: calendar,
+		Metrics:  NewMetrics(),
+		Changes:  NewChangeFeed(calendar, changeLogSize),
+		mux:      http.NewServeMux(),
+	}
+
+	server.SetupRoutes()
+
+	return server
+}
+
+// SetupRoutes задаёт систему маршрутищации
+func (s *Server) SetupRoutes() {
+	// Методы из условия задания. Оставлены для совместимости с существующими клиентами
+	s.handle("/create_event", s.CreateEventHandler)
+	s.handle("/update_event", s.UpdateEventHandler)
+	s.handle("/delete_event", s.DeleteEventHandler)
+
+	s.handle("/events_for_day", s.EventsForDayHandler)
+	s.handle("/events_for_week", s.EventsForWeekHandler)
+	s.handle("/events_for_month", s.EventsForMonthHandler)
+
+	s.handle("/export.ics", s.ExportICalHandler)
+	s.handle("/import", s.ImportICalHandler)
+
+	// REST API
+	s.handle(apiEventsPath, s.APIEventsHandler)
+	s.handle(apiEventsPath+"/", s.APIEventHandler)
+
+	s.handle(freeBusyPath, s.FreeBusyHandler)
+	s.handle(streamPath, s.EventStreamHandler)
+	s.handle(batchPath, s.BatchHandler)
+	s.handle(eventsPathPrefix, s.EventActionHandler)
+	s.handle(sharesPath, s.SharesHandler)
+
+	// CalDAV для стандартных календарных клиентов; /.well-known/caldav ведёт к корню (RFC 6764)
+	s.handle(caldavPath, s.CalDAVHandler)
+	s.handle("/.well-known/caldav", http.RedirectHandler(caldavPath, http.StatusMovedPermanently).ServeHTTP)
+
+	s.handle(metricsPath, s.MetricsHandler)
+	s.handle(openAPIPath, s.OpenAPIHandler)
+}
+
+// handle регистрирует обработчик адреса pattern и запоминает адрес, чтобы его можно было сверить с документом OpenAPI
+func (s *Server) handle(pattern string, handler http.HandlerFunc) {
+	s.mux.Handle(pattern, handler)
+	s.routes = append(s.routes, pattern)
+}
+
+func initNewServer(config Config) (*Server, error) {
+	storage, err := openStorage(config)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно открыть хранилище событий: %s", err)
+	}
+
+	audit, err := openAuditLog(storage)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно открыть журнал аудита: %s", err)
+	}
+
+	server := NewServer(InitNewEventStoreWithStorage(storage, audit))
+	server.Port = config.Port
+	server.Config = config
+
+	if config.TokensFile != "" {
+		auth, err := LoadTokenFile(config.TokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("невозможно загрузить токены: %s", err)
+		}
+		server.Auth = auth
+	}
+
+	notifiers, err := openNotifiers(config)
+	if err != nil {
+		return nil, fmt.Errorf("невозможно настроить доставку напоминаний: %s", err)
+	}
+	server.Reminders = NewReminderScheduler(server.Calendar, notifiers)
+
+	if config.RateLimit > 0 {
+		server.Limiter = NewRateLimiter(config.RateLimit, config.RateBurst)
+	}
+	if config.AddrRateLimit > 0 {
+		server.AddrLimit = NewRateLimiter(config.AddrRateLimit, config.AddrRateBurst)
+	}
+
+	return server, nil
+}
+
+// Handler возвращает обработчик всех запросов сервера вместе с middleware. Частота запросов с адреса ограничивается
+// до аутентификации, чтобы перебор учётных данных тоже упирался в ограничение. Запрос проверяется по документу OpenAPI
+// последним, чтобы неаутентифицированный клиент получал 401, а не подробности ошибок в параметрах
+func (s *Server) Handler() http.Handler {
+	return s.LoggingMiddleware(s.BodyLimitMiddleware(s.AddrRateLimitMiddleware(s.AuthMiddleware(s.RateLimitMiddleware(s.ValidationMiddleware(s.mux))))))
+}
+
+// Run запускает HTTP-сервер и планировщик напоминаний и работает до сигнала SIGINT или SIGTERM.
+// После сигнала сервер перестаёт принимать соединения и ждёт завершения начатых запросов не дольше shutdown_timeout,
+// затем останавливает планировщик и закрывает хранилище, чтобы журнал не остался недописанным.
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.Config.Host, s.Config.Port))
+	if err != nil {
+		return err
+	}
+
+	return s.serve(ctx, listener)
+}
+
+// serve обслуживает соединения listener до отмены ctx и останавливает сервер так, как описано в Run
+func (s *Server) serve(ctx context.Context, listener net.Listener) error {
+	httpServer := &http.Server{
+		Addr:         listener.Addr().String(),
+		Handler:      s.Handler(),
+		ReadTimeout:  time.Duration(s.Config.ReadTimeout),
+		WriteTimeout: time.Duration(s.Config.WriteTimeout),
+		IdleTimeout:  time.Duration(s.Config.IdleTimeout),
+	}
+	httpServer.RegisterOnShutdown(s.Changes.Close)
+
+	remindersCtx, stopReminders := context.WithCancel(context.Background())
+	remindersDone := make(chan struct{})
+	go func() {
+		defer close(remindersDone)
+		if s.Reminders != nil {
+			s.Reminders.Run(remindersCtx)
+		}
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.Config.TLSEnabled() {
+			serveErr <- httpServer.ServeTLS(listener, s.Config.TLSCertFile, s.Config.TLSKeyFile)
+		} else {
+			serveErr <- httpServer.Serve(listener)
 		}
+	}()
 
-		if event.Title == "" {
-			event.Title = e.Title
+	scheme := "http"
+	if s.Config.TLSEnabled() {
+		scheme = "https"
+	}
+	log.Printf("Сервер запущен на %s://%s", scheme, httpServer.Addr)
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		log.Printf("Получен сигнал остановки, завершаем начатые запросы")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.ShutdownTimeout))
+		defer cancel()
+		if err = httpServer.Shutdown(shutdownCtx); err != nil {
+			err = fmt.Errorf("не все запросы завершены до остановки: %v", err)
 		}
+	}
 
-		if tmpTime := time.Date(1, 1, 1, 0, 0, 0, 0, e.Date.Location()); tmpTime == event.Date {
-			event.Date = e.Date
-		}
+	stopReminders()
+	<-remindersDone
 
-		es.Events[event.ID] = event
-		return nil
+	if closeErr := s.Calendar.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка закрытия хранилища: %v", closeErr)
 	}
+
+	if err == nil {
+		log.Printf("Сервер остановлен")
+	}
+	return err
 }
 
-// DeleteEvent удаляет событие из хранилища
-func (s *EventStore) DeleteEvent(eventID int) error {
-	s.Lock()
-	defer s.Unlock()
-	if _, exists := s.Events[eventID]; !exists {
-		return fmt.Errorf("событие с ID %d не найдено", eventID)
+// openStorage выбирает реализацию хранилища согласно конфигурации
+func openStorage(config Config) (Storage, error) {
+	if config.StorageDSN != "" {
+		return OpenSQLStorage(config.StorageDriver, config.StorageDSN)
 	}
 
-	delete(s.Events, eventID)
+	if config.StoragePath == "" {
+		return InitNewMemoryStorage(), nil
+	}
 
-	return nil
+	return OpenFileStorage(config.StoragePath, config.SnapshotEvery)
 }
 
-// GetEventsByDate возвращает все события за определенную дату
-func (s *EventStore) GetEventsByDate(date time.Time) []Event {
-	s.Lock()
-	defer s.Unlock()
-
-	var result []Event
-	for _, event := range s.Events {
-		if event.Date.Year() == date.Year() && event.Date.YearDay() == date.YearDay() {
-			result = append(result, event)
-		}
+// openAuditLog выбирает реализацию журнала аудита по хранилищу: журнал хранится рядом с событиями
+func openAuditLog(storage Storage) (AuditLog, error) {
+	if sqlStorage, ok := storage.(*SQLStorage); ok {
+		return sqlStorage.AuditLog(), nil
+	}
+	if fileStorage, ok := storage.(*FileStorage); ok {
+		return fileStorage.AuditLog()
 	}
 
-	return result
+	return InitNewMemoryAuditLog(), nil
 }
 
-// GetEventsForRange возвращает все события за указанный диапазон дат
-func (s *EventStore) GetEventsForRange(start, end time.Time) []Event {
-	s.Lock()
-	defer s.Unlock()
+// openNotifiers создаёт способы доставки напоминаний согласно конфигурации
+func openNotifiers(config Config) ([]Notifier, error) {
+	if len(config.Notifiers) == 0 {
+		return []Notifier{LogNotifier{}}, nil
+	}
 
-	var result []Event
-	for _, event := range s.Events {
-		if !event.Date.Before(start) && !event.Date.After(end) {
-			result = append(result, event)
+	notifiers := make([]Notifier, 0, len(config.Notifiers))
+	for _, notifierConfig := range config.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			return nil, err
 		}
+		notifiers = append(notifiers, notifier)
 	}
 
-	return result
+	return notifiers, nil
 }
 
-/*
-	  = == ==       == == =
-	= ==== HTTP СЕРВЕР ==== =
-	  = == ==       == == =
-*/
+// === Вспомогательные функции для сериализации объектов доменной области в JSON ===
 
-// === Структуры ===
+// RespondWithJSON сериализует payload в JSON и отправляет его клиенту.
+// Ошибка записи в соединение касается только этого клиента, поэтому она лишь логируется.
+func (s *Server) RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	response, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Ошибка сериализации ответа: %v", err)
+		code = http.StatusInternalServerError
+		response = []byte(`{"error":"ошибка сериализации ответа","code":"internal"}`)
+	}
 
-type Server struct {
-	Port     string      `json:"port"`
-	Calendar *EventStore `json:"calendar"`
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(response); err != nil {
+		log.Printf("Ошибка при попытке записи ответа в соединение: %v", err)
+	}
 }
 
-type Config struct {
-	Port string `json:"port"`
+// RespondWithResult отправляет клиенту успешный результат в виде {"result": ...}
+func (s *Server) RespondWithResult(w http.ResponseWriter, result interface{}) {
+	s.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"result": result})
 }
 
-type RequestObjects struct {
-	User_ID int       `json:"user_id"`
-	Date    time.Time `json:"date"`
+// === Вспомогательные функции для парсинга и валидации параметров методов /create_event и /update_event ===
+
+// DecodeJSONBody парсит тело запроса JSON в структуру.
+func (s *Server) DecodeJSONBody(r *http.Request, dst interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(dst)
 }
 
-// === Конфигурации ===
+// maxMultipartMemory объём multipart-тела, который разбирается в памяти (остальное — во временных файлах)
+const maxMultipartMemory = 1 << 20
 
-func LoadConfig() (Config, error) {
-	config, err := ReadConfigFromFile("config.json")
+// ErrUnsupportedMediaType возвращается DecodeEventBody для тела неизвестного типа
+var ErrUnsupportedMediaType = &HTTPError{
+	Status: http.StatusUnsupportedMediaType,
+	Code:   "unsupported_media_type",
+	Err:    errors.New("неподдерживаемый Content-Type: ожидается application/x-www-form-urlencoded, multipart/form-data или application/json"),
+}
 
-	if err != nil {
-		return Config{}, err
+// DecodeEventBody парсит тело POST-запроса в событие согласно Content-Type.
+// Запрос без Content-Type считается JSON, как и до поддержки форм. Любая ошибка разбора — ошибка входных данных.
+func (s *Server) DecodeEventBody(r *http.Request) (Event, error) {
+	event, _, err := s.decodeEventBody(r)
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		return Event{}, tooLarge
 	}
-	return config, nil
+	if err != nil && !errors.Is(err, ErrUnsupportedMediaType) {
+		return Event{}, badRequestf("%v", err)
+	}
+	return event, err
 }
 
-// LoadConfig читает конфиг из конфигурационного файла
-func ReadConfigFromFile(filename string) (Config, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return Config{}, fmt.Errorf("ошибка чтения конфигурационного файла: %v", err)
+// decodeEventBody выбирает способ разбора тела по Content-Type. Кроме события возвращает ключи,
+// присутствующие в теле: по ним частичное изменение отличает очищаемые поля от неизменяемых
+func (s *Server) decodeEventBody(r *http.Request) (Event, []string, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType := "application/json"
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return Event{}, nil, ErrUnsupportedMediaType
+		}
 	}
 
-	var config Config
-	err = json.Unmarshal(data, &config)
-	if err != nil {
-		return Config{}, fmt.Errorf("ошибка парсинга содежимого конфигурационного файла: %v", err)
-	}
+	var event Event
+	switch mediaType {
+	case "application/json":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return Event{}, nil, err
+		}
 
-	if config.Port == "" {
-		return Config{}, fmt.Errorf("в конфигурационном файле не указан порт: %v", err)
-	}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err := s.DecodeJSONBody(r, &event); err != nil {
+			return Event{}, nil, err
+		}
 
-	return config, nil
-}
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(body, &present); err != nil {
+			return Event{}, nil, err
+		}
 
-// SetupRoutes задаёт систему маршрутищации
-func (s *Server) SetupRoutes() {
-	http.HandleFunc("/create_event", s.CreateEventHandler)
-	http.HandleFunc("/update_event", s.UpdateEventHandler)
-	http.HandleFunc("/delete_event", s.DeleteEventHandler)
+		keys := make([]string, 0, len(present))
+		for key := range present {
+			keys = append(keys, key)
+		}
+		return event, keys, nil
 
-	http.HandleFunc("/events_for_day", s.EventsForDayHandler)
-	http.HandleFunc("/events_for_week", s.EventsForWeekHandler)
-	http.HandleFunc("/events_for_month", s.EventsForMonthHandler)
-}
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return Event{}, nil, err
+		}
+		event, err := s.ParseEventForm(r.PostForm)
+		return event, formKeys(r.PostForm), err
 
-func initNewServer() (*Server, error) {
-	config, err := LoadConfig() // Порт нужно брать из конфигурационного файла
-	if err != nil {
-		return nil, fmt.Errorf("невозможно загрузить конфиг сервера: %s", err)
-	}
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+			return Event{}, nil, err
+		}
+		event, err := s.ParseEventForm(r.MultipartForm.Value)
+		return event, formKeys(r.MultipartForm.Value), err
 
-	server := &Server{
-		Port:     config.Port,
-		Calendar: InitNewEventStore(),
+	default:
+		return Event{}, nil, ErrUnsupportedMediaType
 	}
+}
 
-	server.SetupRoutes()
-
-	return server, nil
+// formKeys возвращает имена непустых полей формы: пустое поле, как и в ParseEventForm, равносильно отсутствующему
+func formKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for key, list := range values {
+		if len(list) == 1 && strings.TrimSpace(list[0]) == "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
 }
 
-// === Вспомогательные функции для сериализации объектов доменной области в JSON ===
+// ParseEventForm собирает событие из полей формы, применяя те же проверки, что и к параметрам GET-запросов.
+// Даты передаются в формате гггг-мм-дд, гггг-мм-ддTчч:мм или RFC 3339; время без смещения относится к часовому поясу
+// из поля tz (по умолчанию UTC). Правило повторения передаётся строкой RRULE (например, FREQ=WEEKLY;BYDAY=MO),
+// напоминания — списком минут до начала через запятую (например, 15,60), all_day — значением true или false.
+// Пустое поле равносильно отсутствующему.
+func (s *Server) ParseEventForm(values url.Values) (Event, error) {
+	var event Event
 
-// RespondWithJSON сериализует payload в JSON и отправляет его клиенту
-func (s *Server) RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	response, _ := json.Marshal(payload)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	_, err := w.Write(response)
+	event.TimeZone = strings.TrimSpace(values.Get("tz"))
+	location, err := LoadLocation(event.TimeZone)
 	if err != nil {
-		log.Fatalf("Ошибка при попытке записи ответа в соединение")
+		return Event{}, err
+	}
+
+	for key, list := range values {
+		if len(list) != 1 {
+			return Event{}, fmt.Errorf("поле %q должно быть указано один раз", key)
+		}
+
+		value := strings.TrimSpace(list[0])
+		if value == "" {
+			continue
+		}
+
+		switch key {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil || id < 1 {
+				return Event{}, fmt.Errorf("некорректный id")
+			}
+			event.ID = id
+		case "user_id":
+			userID, err := s.ValidateUserID(value)
+			if err != nil {
+				return Event{}, err
+			}
+			event.UserID = userID
+		case "title":
+			event.Title = value
+		case "uid":
+			event.UID = value
+		case "tz":
+		case "date":
+			date, err := ParseDateTime(value, location)
+			if err != nil {
+				return Event{}, err
+			}
+			event.Date = date
+		case "end":
+			end, err := ParseDateTime(value, location)
+			if err != nil {
+				return Event{}, fmt.Errorf("end: %v", err)
+			}
+			event.End = &end
+		case "all_day":
+			allDay, err := strconv.ParseBool(value)
+			if err != nil {
+				return Event{}, fmt.Errorf("all_day должно быть true или false")
+			}
+			event.AllDay = allDay
+		case "location":
+			event.Place = value
+		case "description":
+			event.Description = value
+		case "occurrence":
+			occurrence, err := ParseDateTime(value, location)
+			if err != nil {
+				return Event{}, fmt.Errorf("occurrence: %v", err)
+			}
+			event.Occurrence = &occurrence
+		case "rrule":
+			recurrence, err := ParseRRule(value)
+			if err != nil {
+				return Event{}, fmt.Errorf("некорректное правило повторения: %v", err)
+			}
+			event.Recurrence = recurrence
+		case "attendees":
+			attendees, err := ParseAttendees(value)
+			if err != nil {
+				return Event{}, err
+			}
+			event.Attendees = attendees
+		case "reminders":
+			reminders, err := ParseReminders(value)
+			if err != nil {
+				return Event{}, err
+			}
+			event.Reminders = reminders
+		default:
+			return Event{}, fmt.Errorf("неизвестное поле %q", key)
+		}
 	}
+
+	return event, nil
 }
 
-// === Вспомогательные функции для парсинга и валидации параметров методов /create_event и /update_event ===
+// ValidateDate проверяет дату по схеме dateSchema документа OpenAPI. Дата означает начало дня в часовом поясе location
+func (s *Server) ValidateDate(dateStr string, location *time.Location) (time.Time, error) {
+	if err := dateSchema.validateString(dateStr); err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation(dateLayout, dateStr, location)
+}
 
-// DecodeJSONBody парсит тело запроса JSON в структуру.
-func (s *Server) DecodeJSONBody(r *http.Request, dst interface{}) error {
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
-	return decoder.Decode(dst)
+// ValidateUserID проверяет user_id по схеме userIDSchema документа OpenAPI
+func (s *Server) ValidateUserID(userIDStr string) (int, error) {
+	if err := userIDSchema.validateString(userIDStr); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(userIDStr)
 }
 
-// ValidateDate проверяет корректность формата даты
-func (s *Server) ValidateDate(dateStr string) (time.Time, error) {
-	date, err := time.Parse("2006-01-02", dateStr)
+// ParseQueryUserID извлекает user_id из queryString. Аутентифицированный пользователь может его не указывать.
+func (s *Server) ParseQueryUserID(r *http.Request) (int, error) {
+	userIDStr := r.URL.Query().Get("user_id")
+	if authenticated, ok := AuthenticatedUserID(r.Context()); ok && userIDStr == "" {
+		return authenticated, nil
+	}
+
+	userID, err := s.ValidateUserID(userIDStr)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("формат даты должен соответствовать шаблону гггг-мм-дд")
+		return 0, badRequestf("валидация пользовательского идентификатора не пройдена: %v", err)
 	}
-	return date, nil
+
+	return userID, nil
 }
 
-// ValidateUserID проверяет корректность user_id
-func (s *Server) ValidateUserID(userIDStr string) (int, error) {
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil || userID <= 0 {
-		return 0, fmt.Errorf("некорректный user_id")
+// BodyUserID возвращает пользователя, от имени которого выполняется POST-запрос: указанного в теле
+// или, если он не указан, аутентифицированного
+func (s *Server) BodyUserID(r *http.Request, userID int) (int, error) {
+	if authenticated, ok := AuthenticatedUserID(r.Context()); ok && userID == 0 {
+		return authenticated, nil
 	}
+
+	if userID < 1 {
+		return 0, badRequestf("некорректный user_id")
+	}
+
 	return userID, nil
 }
 
 // ParseRequestToRequestObjects Проверяет корректность id и даты. В случае успеха возвращает структуру с извлечёнными объектами.
+// Дата относится к часовому поясу из параметра tz, поэтому границы дня, недели и месяца вычисляются в нём.
 func (s *Server) ParseRequestToRequestObjects(r *http.Request) (RequestObjects, error) {
-	userIDStr := r.URL.Query().Get("user_id")
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		return RequestObjects{}, err
+	}
 
-	userID, err := s.ValidateUserID(userIDStr)
+	location, err := s.RequestLocation(r)
 	if err != nil {
-		return RequestObjects{}, fmt.Errorf("валидация пользовательского идентификатора не пройдена: %v", err)
+		return RequestObjects{}, err
 	}
 
 	dateStr := r.URL.Query().Get("date")
 
-	date, err := s.ValidateDate(dateStr)
+	date, err := s.ValidateDate(dateStr, location)
 	if err != nil {
-		return RequestObjects{}, fmt.Errorf("валидация даты не пройдена: %v", err)
+		return RequestObjects{}, badRequestf("валидация даты не пройдена: %v", err)
 	}
 
 	return RequestObjects{
@@ -319,156 +1325,452 @@ func (s *Server) ParseRequestToRequestObjects(r *http.Request) (RequestObjects,
 // CreateEventHandler обрабатывает запрос на создание события
 func (s *Server) CreateEventHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
-	var event Event
-	err := s.DecodeJSONBody(r, &event)
+	event, err := s.DecodeEventBody(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Ошибка в процессе парсинга нового события: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе парсинга нового события: %w", err))
 		return
 	}
 
-	eventID, err := s.Calendar.AddEvent(event)
+	// Отметки времени назначает сервер; сохраняются они только при импорте
+	event.CreatedAt = time.Time{}
+	event.UpdatedAt = time.Time{}
+
+	event.UserID, err = s.BodyUserID(r, event.UserID)
+	if err != nil {
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе парсинга нового события: %w", err))
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, event.UserID, PermissionWrite); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	opts, conflicts, err := s.conflictOptions(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка в процессе добавления нового события: %v", err)})
+		s.RespondWithError(w, err)
 		return
 	}
 
-	s.RespondWithJSON(w, http.StatusOK, map[string]string{"result": fmt.Sprintf("Событие [ID:%d] успешно создано", eventID)})
+	eventID, err := s.Calendar.AddEvent(event, append(opts, s.actorOptions(r)...)...)
+	if err != nil {
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе добавления нового события: %w", err))
+		return
+	}
+
+	s.respondWithConflicts(w, http.StatusOK, fmt.Sprintf("Событие [ID:%d] успешно создано", eventID), conflicts)
 }
 
-// UpdateEventHandler обрабатывает запрос на обновление события
+// UpdateEventHandler обрабатывает запрос на обновление события. Изменяются только поля, присутствующие в теле:
+// null в JSON очищает значение, пустое поле формы равносильно отсутствующему. Заголовок If-Match с ETag события
+// защищает от перезаписи чужих изменений
 func (s *Server) UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
-	var event Event
-	err := s.DecodeJSONBody(r, &event)
+	event, fields, err := s.DecodeEventPatch(r)
+	if err != nil {
+		s.RespondWithError(w, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err))
+		return
+	}
+
+	userID, err := s.BodyUserID(r, event.UserID)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Ошибка в ходе парсинга входных параметров: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err))
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithError(w, err)
 		return
 	}
 
 	event.UpdatedAt = time.Now()
 
-	err = s.Calendar.UpdateEvent(event)
+	opts, conflicts, err := s.conflictOptions(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+	opts = append(opts, fields)
+	opts = append(opts, s.ifMatchOptions(r)...)
+	opts = append(opts, s.actorOptions(r)...)
+
+	_, err = s.Calendar.UpdateEvent(userID, event, opts...)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"error": fmt.Sprintf("Ошибка в процессе обновления события [ID:%d]: %v", event.ID, err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе обновления события [ID:%d]: %w", event.ID, err))
 		return
 	}
 
-	s.RespondWithJSON(w, http.StatusOK, map[string]string{"result": "обновление события успешно"})
+	s.respondWithConflicts(w, http.StatusOK, "обновление события успешно", conflicts)
 }
 
 // DeleteEventHandler обрабатывает запрос на удаление события
 func (s *Server) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
-	var event Event
-	err := s.DecodeJSONBody(r, &event)
+	event, err := s.DecodeEventBody(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("Ошибка в ходе парсинга входных параметров: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err))
 		return
 	}
 
 	if event.ID < 1 {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "некорректный ID события"})
+		s.RespondWithError(w, badRequestf("некорректный ID события"))
+		return
+	}
+
+	userID, err := s.BodyUserID(r, event.UserID)
+	if err != nil {
+		s.RespondWithError(w, fmt.Errorf("Ошибка в ходе парсинга входных параметров: %w", err))
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithError(w, err)
 		return
 	}
 
-	err = s.Calendar.DeleteEvent(event.ID)
+	opts := append(s.ifMatchOptions(r), s.actorOptions(r)...)
+	if event.Occurrence != nil {
+		err = s.Calendar.DeleteOccurrence(userID, event.ID, *event.Occurrence, opts...)
+	} else {
+		err = s.Calendar.DeleteEvent(userID, event.ID, opts...)
+	}
 
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusServiceUnavailable, map[string]string{"error": fmt.Sprintf("Ошибка в процессе удаления события [ID:%d]: %v", event.ID, err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе удаления события [ID:%d]: %w", event.ID, err))
 		return
 	}
 
-	s.RespondWithJSON(w, http.StatusOK, map[string]string{"result": "удаление события успешно"})
+	s.RespondWithResult(w, "удаление события успешно")
 }
 
-// EventsForDayHandler возвращает все события за конкретный день
+// EventsForDayHandler возвращает все события за конкретный день. Как и остальные списки событий,
+// упорядочен по дате, затем по ID и принимает параметры q, limit и cursor (см. ParseListOptions)
 func (s *Server) EventsForDayHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
 	requestObjects, err := s.ParseRequestToRequestObjects(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка в процессе парсинга объектов домена: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе парсинга объектов домена: %w", err))
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, requestObjects.User_ID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	options, err := s.ParseListOptions(r)
+	if err != nil {
+		s.RespondWithError(w, err)
 		return
 	}
 
-	events := s.Calendar.GetEventsByDate(requestObjects.Date)
+	events, err := s.Calendar.GetEventsByDate(requestObjects.User_ID, requestObjects.Date)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
 
-	s.RespondWithJSON(w, http.StatusOK, events)
+	s.RespondWithEvents(w, events, options)
 }
 
 // EventsForWeekHandler возвращает события за конкретную неделю
 func (s *Server) EventsForWeekHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
 	requestObjects, err := s.ParseRequestToRequestObjects(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка в процессе парсинга объектов домена: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе парсинга объектов домена: %w", err))
 		return
 	}
 
-	weekday := requestObjects.Date.Weekday()
-
-	// Определяем смещение от текущей даты до понедельника соответствующей недели
-	offsetToMonday := int(time.Monday - weekday)
-	if offsetToMonday > 0 {
-		offsetToMonday -= 7
+	if err := s.AuthorizeCalendar(r, requestObjects.User_ID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
 	}
 
-	start := requestObjects.Date.AddDate(0, 0, offsetToMonday)
+	options, err := s.ParseListOptions(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
 
-	end := start.AddDate(0, 0, 6)
+	// Неделя с понедельника по воскресенье включительно
+	start, end := weekBounds(requestObjects.Date)
 
-	events := s.Calendar.GetEventsForRange(start, end)
+	events, err := s.Calendar.GetEventsForRange(requestObjects.User_ID, start, end)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
 
-	s.RespondWithJSON(w, http.StatusOK, events)
+	s.RespondWithEvents(w, events, options)
 }
 
 // EventsForMonthHandler возвращает события за конкретный месяц
 func (s *Server) EventsForMonthHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": "Неверный http метод"})
+		s.RespondWithError(w, errMethodNotAllowed)
 		return
 	}
 
 	requestObjects, err := s.ParseRequestToRequestObjects(r)
 	if err != nil {
-		s.RespondWithJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка в процессе парсинга объектов домена: %v", err)})
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе парсинга объектов домена: %w", err))
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, requestObjects.User_ID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	options, err := s.ParseListOptions(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	start, end := monthBounds(requestObjects.Date)
+
+	events, err := s.Calendar.GetEventsForRange(requestObjects.User_ID, start, end)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	s.RespondWithEvents(w, events, options)
+}
+
+// ExportICalHandler выгружает все события пользователя в формате iCalendar
+func (s *Server) ExportICalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.RespondWithError(w, errMethodNotAllowed)
+		return
+	}
+
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	if err := s.AuthorizeCalendar(r, userID, PermissionRead); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	events, err := s.Calendar.GetUserEvents(userID)
+	if err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="calendar.ics"`)
+	if err := WriteICalendar(w, events); err != nil {
+		log.Printf("Ошибка при записи календаря в соединение: %v", err)
+	}
+}
+
+// ImportICalHandler создаёт события пользователя из переданного в теле запроса документа iCalendar
+func (s *Server) ImportICalHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.RespondWithError(w, errMethodNotAllowed)
+		return
+	}
+
+	userID, err := s.ParseQueryUserID(r)
+	if err != nil {
+		s.RespondWithError(w, err)
 		return
 	}
 
-	start := time.Date(requestObjects.Date.Year(), requestObjects.Date.Month(), 1, 0, 0, 0, 0, requestObjects.Date.Location())
-	end := start.AddDate(0, 1, 0).Add(-time.Second)
+	if err := s.AuthorizeCalendar(r, userID, PermissionWrite); err != nil {
+		s.RespondWithError(w, err)
+		return
+	}
+
+	items, err := ParseICalendar(r.Body)
+	if tooLarge := bodyTooLarge(err); tooLarge != nil {
+		s.RespondWithError(w, tooLarge)
+		return
+	}
+	if err != nil {
+		s.RespondWithError(w, badRequestf("Ошибка в процессе разбора iCalendar: %v", err))
+		return
+	}
 
-	events := s.Calendar.GetEventsForRange(start, end)
+	ids, err := ImportICalEvents(s.Calendar, userID, items, s.actorOptions(r)...)
+	if err != nil {
+		s.RespondWithError(w, fmt.Errorf("Ошибка в процессе импорта, события не созданы: %w", err))
+		return
+	}
 
-	s.RespondWithJSON(w, http.StatusOK, events)
+	s.RespondWithResult(w, fmt.Sprintf("Импортировано событий: %d", len(ids)))
 }
 
 // === Middleware для логирования запросов ===
 
-// LoggingMiddleware логирует каждый запрос
+// requestIDHeader заголовок, в котором передаётся идентификатор запроса
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength наибольшая длина идентификатора запроса, принимаемого от клиента
+const maxRequestIDLength = 128
+
+// requestIDContextKey ключ контекста запроса, под которым хранится его идентификатор
+type requestIDContextKey struct{}
+
+// RequestID возвращает идентификатор запроса, назначенный LoggingMiddleware
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// requestIDFor возвращает идентификатор запроса из X-Request-ID, если клиент передал допустимый, или новый случайный
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= maxRequestIDLength && isPrintableASCII(id) {
+		return id
+	}
+
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// isPrintableASCII сообщает, состоит ли строка только из видимых ASCII-символов
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '!' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder запоминает код ответа и количество отправленных байт тела
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader запоминает код ответа
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write считает отправленные байты. Ответ без явного WriteHeader получает код 200
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(data)
+	rr.bytes += int64(n)
+	return n, err
+}
+
+// Flush отправляет клиенту буферизованные данные, если это умеет исходный ResponseWriter
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		if rr.status == 0 {
+			rr.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap возвращает исходный ResponseWriter
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// accessLogEntry запись журнала доступа
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Route      string  `json:"route"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RemoteAddr string  `json:"remote_addr"`
+}
+
+// accessLog журнал доступа: по одному JSON-объекту на строку, без префикса стандартного логгера
+var accessLog = log.New(os.Stderr, "", 0)
+
+// LoggingMiddleware логирует каждый запрос в формате JSON и учитывает его в метриках сервера.
+// Идентификатор запроса берётся из X-Request-ID или создаётся, возвращается клиенту в том же заголовке
+// и доступен обработчикам через RequestID.
 func (s *Server) LoggingMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		handler.ServeHTTP(w, r)
-		log.Printf("[%s] %s %s %s", r.Method, r.RequestURI, r.RemoteAddr, time.Since(start))
+
+		requestID := requestIDFor(r)
+		w.Header().Set(requestIDHeader, requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, requestID))
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		duration := time.Since(start)
+		route := s.route(r)
+		if s.Metrics != nil {
+			s.Metrics.ObserveRequest(route, r.Method, recorder.status, duration)
+		}
+
+		entry, err := json.Marshal(accessLogEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			RequestID:  requestID,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Route:      route,
+			Status:     recorder.status,
+			Bytes:      recorder.bytes,
+			DurationMS: float64(duration.Microseconds()) / 1000,
+			RemoteAddr: r.RemoteAddr,
+		})
+		if err != nil {
+			log.Printf("Ошибка сериализации записи журнала доступа: %v", err)
+			return
+		}
+		accessLog.Print(string(entry))
 	})
 }
+
+// route возвращает шаблон маршрута, которым обработан запрос, или "other" для неизвестных адресов.
+// В метриках используется шаблон, а не путь, чтобы число временных рядов не зависело от запросов
+func (s *Server) route(r *http.Request) string {
+	if _, pattern := s.mux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return "other"
+}
@@ -1 +1,478 @@
 package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// === Бенчмарки выборки событий за период ===
+
+const (
+	benchEvents = 200000 // общее количество событий в хранилище
+	benchUsers  = 100    // количество пользователей, между которыми распределены события
+)
+
+// benchStorage заполняет хранилище событиями, равномерно распределёнными по пользователям и по 2024 году
+func benchStorage(b *testing.B) *MemoryStorage {
+	b.Helper()
+
+	storage := InitNewMemoryStorage()
+	yearStart := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < benchEvents; i++ {
+		_, err := storage.AddEvent(Event{
+			UserID: i%benchUsers + 1,
+			Title:  fmt.Sprintf("событие %d", i),
+			Date:   yearStart.Add(time.Duration(i) * (366 * 24 * time.Hour / benchEvents)),
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return storage
+}
+
+// scanRange полный перебор событий — способ выборки, использовавшийся до появления индекса
+func scanRange(storage *MemoryStorage, userID int, start, end time.Time) []Event {
+	var result []Event
+	for _, event := range storage.Events {
+		if event.UserID == userID && !event.Date.Before(start) && !event.Date.After(end) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// benchmarkRange измеряет выборку событий одного пользователя за период длиной period
+func benchmarkRange(b *testing.B, period time.Duration, indexed bool) {
+	storage := benchStorage(b)
+	start := time.Date(2024, time.June, 3, 0, 0, 0, 0, time.UTC)
+	end := start.Add(period)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if indexed {
+			storage.GetEventsForRange(42, start, end)
+		} else {
+			scanRange(storage, 42, start, end)
+		}
+	}
+}
+
+func BenchmarkRangeDayIndexed(b *testing.B)   { benchmarkRange(b, 24*time.Hour, true) }
+func BenchmarkRangeDayScan(b *testing.B)      { benchmarkRange(b, 24*time.Hour, false) }
+func BenchmarkRangeWeekIndexed(b *testing.B)  { benchmarkRange(b, 7*24*time.Hour, true) }
+func BenchmarkRangeWeekScan(b *testing.B)     { benchmarkRange(b, 7*24*time.Hour, false) }
+func BenchmarkRangeMonthIndexed(b *testing.B) { benchmarkRange(b, 30*24*time.Hour, true) }
+func BenchmarkRangeMonthScan(b *testing.B)    { benchmarkRange(b, 30*24*time.Hour, false) }
+
+// === Конфигурация ===
+
+// writeConfig записывает конфигурационный файл в каталог теста и возвращает путь к нему
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigDefaults(t *testing.T) {
+	config, err := ReadConfigFromFile(writeConfig(t, `{"port": "8080"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Host:            defaultHost,
+		Port:            "8080",
+		ReadTimeout:     Duration(defaultReadTimeout),
+		WriteTimeout:    Duration(defaultWriteTimeout),
+		IdleTimeout:     Duration(defaultIdleTimeout),
+		ShutdownTimeout: Duration(defaultShutdownTimeout),
+		MaxBodyBytes:    defaultMaxBodyBytes,
+	}
+	if fmt.Sprintf("%+v", config) != fmt.Sprintf("%+v", want) {
+		t.Errorf("конфигурация %+v, ожидалась %+v", config, want)
+	}
+
+	config, err = ReadConfigFromFile(writeConfig(t, `{"port": "8080", "rate_limit": 2.5, "storage_dsn": "file:calendar.db"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RateBurst != 3 || config.StorageDriver != sqliteDriver {
+		t.Errorf("rate_burst %d, storage_driver %q: ожидались 3 и %q", config.RateBurst, config.StorageDriver, sqliteDriver)
+	}
+}
+
+func TestConfigEnvOverrides(t *testing.T) {
+	path := writeConfig(t, `{"host": "0.0.0.0", "port": "8080", "read_timeout": "5s", "rate_limit": 10, "storage_path": "data"}`)
+
+	t.Setenv("CALENDAR_PORT", "9090")
+	t.Setenv("CALENDAR_READ_TIMEOUT", "1m")
+	t.Setenv("CALENDAR_RATE_LIMIT", "0.5")
+	t.Setenv("CALENDAR_ADDR_RATE_LIMIT", "50")
+	t.Setenv("CALENDAR_MAX_BODY_BYTES", "2048")
+	// Заданная пустая переменная сбрасывает параметр файла к значению по умолчанию
+	t.Setenv("CALENDAR_HOST", "")
+	t.Setenv("CALENDAR_STORAGE_PATH", "")
+
+	config, err := ReadConfigFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != "9090" || config.Host != defaultHost || config.StoragePath != "" {
+		t.Errorf("адрес и хранилище: %q %q %q", config.Host, config.Port, config.StoragePath)
+	}
+	if config.ReadTimeout != Duration(time.Minute) || config.RateLimit != 0.5 || config.RateBurst != 1 || config.MaxBodyBytes != 2048 {
+		t.Errorf("переопределённые параметры: %+v", config)
+	}
+	if config.AddrRateLimit != 50 || config.AddrRateBurst != 50 {
+		t.Errorf("addr_rate_limit %v, addr_rate_burst %d: ожидались 50 и 50", config.AddrRateLimit, config.AddrRateBurst)
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "нечисловой snapshot_every", env: map[string]string{"SNAPSHOT_EVERY": "часто"}, wantErr: "CALENDAR_SNAPSHOT_EVERY"},
+		{name: "некорректная длительность", env: map[string]string{"SHUTDOWN_TIMEOUT": "15"}, wantErr: "CALENDAR_SHUTDOWN_TIMEOUT"},
+		{name: "отрицательная длительность", env: map[string]string{"IDLE_TIMEOUT": "-1s"}, wantErr: "idle_timeout"},
+		{name: "порт вне диапазона", env: map[string]string{"PORT": "70000"}, wantErr: "порт"},
+		{name: "база вместе с каталогом", env: map[string]string{"STORAGE_PATH": "data", "STORAGE_DSN": "file:calendar.db"}, wantErr: "storage_dsn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Port: "8080"}
+			lookup := func(name string) (string, bool) {
+				value, ok := tt.env[strings.TrimPrefix(name, configEnvPrefix)]
+				return value, ok
+			}
+
+			err := applyConfigEnv(&config, lookup)
+			if err == nil {
+				err = validateConfig(&config)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ошибка %v, ожидалась содержащая %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "не JSON", content: `port: 8080`, wantErr: "парсинга"},
+		{name: "без порта", content: `{"host": "localhost"}`, wantErr: "не указан порт"},
+		{name: "порт не число", content: `{"port": "http"}`, wantErr: "порт"},
+		{name: "длительность числом", content: `{"port": "8080", "write_timeout": 10}`, wantErr: "длительность"},
+		{name: "некорректная длительность", content: `{"port": "8080", "idle_timeout": "минута"}`, wantErr: "invalid duration"},
+		{name: "отрицательный таймаут", content: `{"port": "8080", "shutdown_timeout": "-5s"}`, wantErr: "shutdown_timeout"},
+		{name: "отрицательный snapshot_every", content: `{"port": "8080", "snapshot_every": -1}`, wantErr: "snapshot_every"},
+		{name: "драйвер без базы", content: `{"port": "8080", "storage_driver": "sqlite"}`, wantErr: "storage_driver"},
+		{name: "неизвестный драйвер", content: `{"port": "8080", "storage_dsn": "calendar", "storage_driver": "oracle"}`, wantErr: "oracle"},
+		{name: "отрицательный rate_limit", content: `{"port": "8080", "rate_limit": -1}`, wantErr: "rate_limit"},
+		{name: "отрицательный max_body_bytes", content: `{"port": "8080", "max_body_bytes": -1}`, wantErr: "max_body_bytes"},
+		{name: "внешний webhook", content: `{"port": "8080", "notifiers": [{"type": "webhook", "url": "http://example.com"}]}`, wantErr: "notifiers[0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadConfigFromFile(writeConfig(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ошибка %v, ожидалась содержащая %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := ReadConfigFromFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("ReadConfigFromFile() без файла должен завершиться ошибкой")
+	}
+}
+
+// writeTestCertificate создаёт самоподписанный сертификат для localhost и возвращает пути к сертификату и ключу
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestConfigTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	otherCert, _ := writeTestCertificate(t)
+
+	tests := []struct {
+		name      string
+		cert, key string
+		wantErr   string // пусто — конфигурация корректна
+	}{
+		{name: "без TLS"},
+		{name: "сертификат с ключом", cert: certFile, key: keyFile},
+		{name: "сертификат без ключа", cert: certFile, wantErr: "только вместе"},
+		{name: "ключ без сертификата", key: keyFile, wantErr: "только вместе"},
+		{name: "ключ от другого сертификата", cert: otherCert, key: keyFile, wantErr: "невозможно загрузить сертификат"},
+		{name: "файла нет", cert: certFile + ".missing", key: keyFile, wantErr: "невозможно загрузить сертификат"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Port: "8443", TLSCertFile: tt.cert, TLSKeyFile: tt.key}
+			err := validateConfig(&config)
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ошибка %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("ошибка %v, ожидалась содержащая %q", err, tt.wantErr)
+			case tt.wantErr == "" && config.TLSEnabled() != (tt.cert != ""):
+				t.Errorf("TLSEnabled() = %v", config.TLSEnabled())
+			}
+		})
+	}
+}
+
+// === Остановка сервера ===
+
+// TestGracefulShutdown проверяет, что после остановки сервер не принимает новые соединения,
+// но дожидается ответа на начатый запрос и закрывает хранилище
+func TestGracefulShutdown(t *testing.T) {
+	config := Config{Port: "8080"}
+	if err := validateConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := OpenFileStorage(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(InitNewEventStoreWithStorage(storage, InitNewMemoryAuditLog()))
+	server.Config = config
+
+	started, release := make(chan struct{}), make(chan struct{})
+	server.mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		fmt.Fprint(w, "готово")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.serve(ctx, listener) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		inFlight <- result{string(body), err}
+	}()
+
+	<-started
+	stop()
+
+	// Сервер перестаёт принимать соединения, но не завершается, пока запрос не обработан
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("сервер принимает соединения после остановки")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("сервер завершился, не дождавшись начатого запроса: %v", err)
+	default:
+	}
+
+	close(release)
+	if got := <-inFlight; got.err != nil || got.body != "готово" {
+		t.Errorf("начатый запрос: %q, %v", got.body, got.err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() = %v", err)
+	}
+
+	if _, err := storage.AddEvent(Event{UserID: 1, Title: "x", Date: time.Now()}); err == nil {
+		t.Error("хранилище не закрыто после остановки")
+	}
+}
+
+// === Разбор тела запроса ===
+
+func TestParseEventForm(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	values := url.Values{
+		"user_id":   {"3"},
+		"title":     {" Ретро "},
+		"date":      {"2024-03-05T15:00"},
+		"end":       {"2024-03-05T16:00"},
+		"tz":        {"Europe/Moscow"},
+		"location":  {"Переговорная"},
+		"rrule":     {"FREQ=WEEKLY;BYDAY=TU"},
+		"reminders": {"15,60"},
+		"all_day":   {""},
+	}
+	event, err := server.ParseEventForm(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event.UserID != 3 || event.Title != "Ретро" || event.Place != "Переговорная" || event.TimeZone != "Europe/Moscow" {
+		t.Errorf("поля события %+v", event)
+	}
+	// Время без смещения относится к часовому поясу tz: 15:00 по Москве — 12:00 UTC
+	if !event.Date.Equal(at(2024, time.March, 5, 12, 0)) || event.End == nil || !event.End.Equal(at(2024, time.March, 5, 13, 0)) {
+		t.Errorf("время события %v–%v", event.Date, event.End)
+	}
+	if event.Recurrence == nil || event.Recurrence.Freq != FreqWeekly || len(event.Reminders) != 2 || event.AllDay {
+		t.Errorf("повторение %+v, напоминания %v, весь день %v", event.Recurrence, event.Reminders, event.AllDay)
+	}
+
+	tests := []struct {
+		name   string
+		values url.Values
+	}{
+		{"некорректный user_id", url.Values{"user_id": {"-1"}}},
+		{"некорректная дата", url.Values{"date": {"05.03.2024"}}},
+		{"неизвестный часовой пояс", url.Values{"tz": {"Mars/Olympus"}, "date": {"2024-03-05"}}},
+		{"поле указано дважды", url.Values{"title": {"a", "b"}}},
+		{"неизвестное поле", url.Values{"colour": {"red"}}},
+		{"некорректное правило повторения", url.Values{"rrule": {"FREQ=HOURLY"}}},
+		{"некорректный all_day", url.Values{"all_day": {"да"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := server.ParseEventForm(tt.values); err == nil {
+			t.Errorf("%s: ParseEventForm() должен завершиться ошибкой", tt.name)
+		}
+	}
+}
+
+func TestDecodeEventBody(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	writer.WriteField("user_id", "3")
+	writer.WriteField("title", "Ретро")
+	writer.WriteField("date", "2024-03-05")
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{"user_id": 3, "title": "Ретро", "date": "2024-03-05T00:00:00Z"}`},
+		{name: "json без Content-Type", body: `{"user_id": 3, "title": "Ретро", "date": "2024-03-05T00:00:00Z"}`},
+		{name: "форма", contentType: "application/x-www-form-urlencoded", body: "user_id=3&title=%D0%A0%D0%B5%D1%82%D1%80%D0%BE&date=2024-03-05"},
+		{name: "multipart", contentType: writer.FormDataContentType(), body: multipartBody.String()},
+		{name: "неизвестный тип", contentType: "text/plain", body: "user_id=3", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "некорректный Content-Type", contentType: "application/", body: "user_id=3", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "неизвестное поле json", contentType: "application/json", body: `{"user_id": 3, "colour": "red"}`, wantStatus: http.StatusBadRequest},
+		{name: "некорректная форма", contentType: "application/x-www-form-urlencoded", body: "user_id=abc", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/create_event", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			event, err := server.DecodeEventBody(r)
+			if tt.wantStatus != 0 {
+				var httpErr *HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Status != tt.wantStatus {
+					t.Errorf("DecodeEventBody() = %v, ожидался код %d", err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.UserID != 3 || event.Title != "Ретро" || !event.Date.Equal(at(2024, time.March, 5, 0, 0)) {
+				t.Errorf("событие %+v", event)
+			}
+		})
+	}
+}
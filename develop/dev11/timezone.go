@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+	  = == ==            == == =
+	= ==== ЧАСОВЫЕ ПОЯСА ==== =
+	  = == ==            == == =
+*/
+
+// locationCache загруженные часовые пояса: time.LoadLocation каждый раз читает базу часовых поясов заново
+var locationCache sync.Map // имя часового пояса -> *time.Location
+
+// LoadLocation возвращает часовой пояс IANA по имени (например, Europe/Moscow). Пустое имя означает UTC
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	if cached, ok := locationCache.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("неизвестный часовой пояс %q", name)
+	}
+
+	locationCache.Store(name, location)
+	return location, nil
+}
+
+// Location возвращает часовой пояс события. Событие без TimeZone живёт в часовом поясе своей даты.
+// Часовой пояс берётся по имени, а не из Date: после сериализации в JSON у даты остаётся только смещение,
+// по которому нельзя правильно перенести вхождения серии через переход на летнее время
+func (e Event) Location() *time.Location {
+	if e.TimeZone != "" {
+		if location, err := LoadLocation(e.TimeZone); err == nil {
+			return location
+		}
+	}
+	return e.Date.Location()
+}
+
+// localizeEvent переводит моменты времени события в его часовой пояс
+func localizeEvent(event *Event) {
+	if event.TimeZone == "" {
+		return
+	}
+
+	location := event.Location()
+	event.Date = event.Date.In(location)
+	if event.End != nil {
+		end := event.End.In(location)
+		event.End = &end
+	}
+	if event.Occurrence != nil {
+		occurrence := event.Occurrence.In(location)
+		event.Occurrence = &occurrence
+	}
+}
+
+// maxEventDuration наибольшая продолжительность события
+const maxEventDuration = 366 * 24 * time.Hour
+
+// validateEventTimes проверяет часовой пояс и время окончания события и переводит его время в часовой пояс события.
+// Событие на весь день начинается в полночь своей даты, а его окончание — полночь дня, следующего за последним
+func validateEventTimes(event *Event) error {
+	if _, err := LoadLocation(event.TimeZone); err != nil {
+		return validationErrorf("%v", err)
+	}
+
+	localizeEvent(event)
+
+	if event.AllDay {
+		event.Date = truncateToDay(event.Date)
+		if event.End != nil {
+			end := truncateToDay(event.End.In(event.Date.Location()))
+			if !end.After(event.Date) {
+				return validationErrorf("окончание события на весь день должно приходиться на один из следующих дней")
+			}
+			event.End = &end
+		}
+	}
+
+	if event.End != nil && event.End.Before(event.Date) {
+		return validationErrorf("окончание события не может быть раньше его начала")
+	}
+
+	if event.End != nil && event.End.Sub(event.Date) > maxEventDuration {
+		return validationErrorf("продолжительность события не может превышать %d дней", int(maxEventDuration.Hours()/24))
+	}
+
+	return nil
+}
+
+// truncateToDay возвращает полночь календарного дня t в его часовом поясе
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// movedEnd возвращает окончание события, перенесённого на начало start, с сохранением продолжительности.
+// Событие на весь день сохраняет количество дней, а не часов: иначе переход на летнее время сдвинул бы его на час
+func movedEnd(event Event, start time.Time) *time.Time {
+	if event.End == nil {
+		return nil
+	}
+
+	var end time.Time
+	if event.AllDay {
+		days := int(math.Round(event.End.Sub(event.Date).Hours() / 24))
+		end = truncateToDay(start).AddDate(0, 0, days)
+	} else {
+		end = start.Add(event.End.Sub(event.Date))
+	}
+
+	return &end
+}
+
+// eventSpan возвращает интервал [start, end), который событие занимает в календаре.
+// Событие без окончания — момент времени: оно не занимает времени и не пересекается с другими
+func eventSpan(event Event) (time.Time, time.Time, bool) {
+	switch {
+	case event.End != nil && event.End.After(event.Date):
+		return event.Date, *event.End, true
+	case event.AllDay:
+		start := truncateToDay(event.Date.In(event.Location()))
+		return start, start.AddDate(0, 0, 1), true
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+}
+
+// eventDuration возвращает продолжительность события по eventSpan, у события без продолжительности — ноль.
+// Хранилища учитывают наибольшую продолжительность событий пользователя, чтобы знать, насколько раньше начала
+// периода искать пересекающиеся с ним события
+func eventDuration(event Event) time.Duration {
+	_, end, ok := eventSpan(event)
+	if !ok {
+		return 0
+	}
+	return end.Sub(event.Date)
+}
+
+// eventOverlaps сообщает, пересекается ли событие с отрезком [start, end]: начинается не позже end и заканчивается
+// позже start. Событие без продолжительности пересекается с отрезком, если начинается в нём
+func eventOverlaps(event Event, start, end time.Time) bool {
+	if event.Date.After(end) {
+		return false
+	}
+	return !event.Date.Before(start) || event.Date.Add(eventDuration(event)).After(start)
+}
+
+// dateTimeLayouts форматы даты и времени, принимаемые от клиентов. Время без смещения относится к часовому поясу запроса
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseDateTime разбирает время в формате RFC 3339 или локальные дату и время (гггг-мм-дд, гггг-мм-ддTчч:мм[:сс])
+// в часовом поясе location
+func ParseDateTime(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("ожидается дата гггг-мм-дд, время гггг-мм-ддTчч:мм или время в формате RFC 3339, получено %q", value)
+}
+
+// RequestLocation возвращает часовой пояс запроса из параметра tz. Без параметра — UTC
+func (s *Server) RequestLocation(r *http.Request) (*time.Location, error) {
+	location, err := LoadLocation(r.URL.Query().Get("tz"))
+	if err != nil {
+		return nil, badRequestf("%v", err)
+	}
+	return location, nil
+}
+
+// dayBounds возвращает начало и последний момент календарного дня date в часовом поясе date.
+// День перехода на летнее или зимнее время длится 23 или 25 часов
+func dayBounds(date time.Time) (time.Time, time.Time) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	return start, start.AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+// weekBounds возвращает начало понедельника и последний момент воскресенья недели, содержащей date
+func weekBounds(date time.Time) (time.Time, time.Time) {
+	offsetToMonday := (int(date.Weekday()) + 6) % 7
+	start := time.Date(date.Year(), date.Month(), date.Day()-offsetToMonday, 0, 0, 0, 0, date.Location())
+	return start, start.AddDate(0, 0, 7).Add(-time.Nanosecond)
+}
+
+// monthBounds возвращает начало и последний момент календарного месяца, содержащего date
+func monthBounds(date time.Time) (time.Time, time.Time) {
+	start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	return start, start.AddDate(0, 1, 0).Add(-time.Nanosecond)
+}
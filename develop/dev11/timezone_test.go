@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// loadTestLocation загружает часовой пояс или пропускает тест, если базы часовых поясов нет
+func loadTestLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := LoadLocation(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	return location
+}
+
+func TestLoadLocation(t *testing.T) {
+	if location, err := LoadLocation(""); err != nil || location != time.UTC {
+		t.Errorf("пустое имя: %v, %v; ожидался UTC", location, err)
+	}
+
+	first := loadTestLocation(t, "Europe/Berlin")
+	if second, err := LoadLocation("Europe/Berlin"); err != nil || second != first {
+		t.Errorf("повторная загрузка вернула %p, ожидался закэшированный %p", second, first)
+	}
+
+	if _, err := LoadLocation("Mars/Olympus"); err == nil {
+		t.Error("LoadLocation() с неизвестным поясом должен завершиться ошибкой")
+	}
+}
+
+func TestParseDateTime(t *testing.T) {
+	moscow := loadTestLocation(t, "Europe/Moscow")
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"2024-03-05", at(2024, time.March, 4, 21, 0)},
+		{"2024-03-05T10:30", at(2024, time.March, 5, 7, 30)},
+		{"2024-03-05 10:30", at(2024, time.March, 5, 7, 30)},
+		{"2024-03-05T10:30:15", at(2024, time.March, 5, 7, 30).Add(15 * time.Second)},
+		// Смещение из значения важнее часового пояса запроса
+		{"2024-03-05T10:30:00+01:00", at(2024, time.March, 5, 9, 30)},
+	}
+
+	for _, tt := range tests {
+		if got, err := ParseDateTime(tt.value, moscow); err != nil || !got.Equal(tt.want) {
+			t.Errorf("ParseDateTime(%q) = %v, %v; ожидалось %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "05.03.2024", "2024-03-05T25:00", "2024-02-30"} {
+		if _, err := ParseDateTime(value, moscow); err == nil {
+			t.Errorf("ParseDateTime(%q) должен завершиться ошибкой", value)
+		}
+	}
+}
+
+func TestBoundsAcrossDST(t *testing.T) {
+	berlin := loadTestLocation(t, "Europe/Berlin")
+
+	// 31 марта 2024 года в Берлине длится 23 часа, 27 октября — 25 часов
+	start, end := dayBounds(time.Date(2024, time.March, 31, 12, 0, 0, 0, berlin))
+	if got := end.Add(time.Nanosecond).Sub(start); got != 23*time.Hour || start.Hour() != 0 {
+		t.Errorf("день перехода на летнее время: %v–%v (%v)", start, end, got)
+	}
+	start, end = dayBounds(time.Date(2024, time.October, 27, 12, 0, 0, 0, berlin))
+	if got := end.Add(time.Nanosecond).Sub(start); got != 25*time.Hour {
+		t.Errorf("день перехода на зимнее время: %v–%v (%v)", start, end, got)
+	}
+
+	// Неделя 25–31 марта: воскресенье короче на час
+	start, end = weekBounds(time.Date(2024, time.March, 31, 23, 0, 0, 0, berlin))
+	if !start.Equal(time.Date(2024, time.March, 25, 0, 0, 0, 0, berlin)) || end.Add(time.Nanosecond).Sub(start) != 7*24*time.Hour-time.Hour {
+		t.Errorf("неделя: %v–%v", start, end)
+	}
+
+	start, end = monthBounds(time.Date(2024, time.March, 15, 0, 0, 0, 0, berlin))
+	if !start.Equal(time.Date(2024, time.March, 1, 0, 0, 0, 0, berlin)) || !end.Add(time.Nanosecond).Equal(time.Date(2024, time.April, 1, 0, 0, 0, 0, berlin)) {
+		t.Errorf("месяц: %v–%v", start, end)
+	}
+}
+
+func TestValidateEventTimes(t *testing.T) {
+	moscow := loadTestLocation(t, "Europe/Moscow")
+
+	end := at(2024, time.March, 6, 5, 0)
+	event := Event{Title: "Отпуск", Date: at(2024, time.March, 4, 22, 0), End: &end, TimeZone: "Europe/Moscow", AllDay: true}
+	if err := validateEventTimes(&event); err != nil {
+		t.Fatal(err)
+	}
+	// 22:00 UTC 4 марта — уже 5 марта по Москве: событие на весь день начинается в полночь своей даты
+	if !event.Date.Equal(time.Date(2024, time.March, 5, 0, 0, 0, 0, moscow)) || event.Date.Location() != moscow {
+		t.Errorf("начало события на весь день %v", event.Date)
+	}
+	if !event.End.Equal(time.Date(2024, time.March, 6, 0, 0, 0, 0, moscow)) {
+		t.Errorf("окончание события на весь день %v", event.End)
+	}
+
+	before := at(2024, time.March, 4, 9, 0)
+	tooLong := at(2025, time.March, 6, 10, 0)
+	sameDay := at(2024, time.March, 4, 12, 0)
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{"неизвестный часовой пояс", Event{Date: at(2024, time.March, 4, 10, 0), TimeZone: "Mars/Olympus"}},
+		{"окончание раньше начала", Event{Date: at(2024, time.March, 4, 10, 0), End: &before}},
+		{"больше года", Event{Date: at(2024, time.March, 4, 10, 0), End: &tooLong}},
+		{"весь день без следующего дня", Event{Date: at(2024, time.March, 4, 10, 0), End: &sameDay, AllDay: true}},
+	}
+
+	for _, tt := range tests {
+		event := tt.event
+		if err := validateEventTimes(&event); err == nil {
+			t.Errorf("%s: validateEventTimes() должен завершиться ошибкой", tt.name)
+		}
+	}
+}
+
+func TestMovedEnd(t *testing.T) {
+	berlin := loadTestLocation(t, "Europe/Berlin")
+
+	// Двухдневное событие на весь день, перенесённое через переход на летнее время, остаётся двухдневным
+	start := time.Date(2024, time.March, 23, 0, 0, 0, 0, berlin)
+	end := start.AddDate(0, 0, 2)
+	allDay := Event{Date: start, End: &end, TimeZone: "Europe/Berlin", AllDay: true}
+
+	moved := movedEnd(allDay, time.Date(2024, time.March, 30, 0, 0, 0, 0, berlin))
+	if moved == nil || !moved.Equal(time.Date(2024, time.April, 1, 0, 0, 0, 0, berlin)) {
+		t.Errorf("окончание перенесённого события на весь день %v", moved)
+	}
+
+	// Обычное событие сохраняет продолжительность в часах
+	meetingEnd := at(2024, time.March, 4, 11, 30)
+	meeting := Event{Date: at(2024, time.March, 4, 10, 0), End: &meetingEnd}
+	if moved := movedEnd(meeting, at(2024, time.March, 5, 9, 0)); moved == nil || !moved.Equal(at(2024, time.March, 5, 10, 30)) {
+		t.Errorf("окончание перенесённой встречи %v", moved)
+	}
+
+	if movedEnd(Event{Date: meeting.Date}, at(2024, time.March, 5, 9, 0)) != nil {
+		t.Error("у события без окончания перенесённое окончание должно отсутствовать")
+	}
+}
+
+func TestEventOverlaps(t *testing.T) {
+	end := at(2024, time.March, 4, 11, 0)
+	meeting := Event{Date: at(2024, time.March, 4, 10, 0), End: &end}
+	moment := Event{Date: at(2024, time.March, 4, 10, 0)}
+	allDay := Event{Date: at(2024, time.March, 4, 0, 0), AllDay: true}
+
+	tests := []struct {
+		name       string
+		event      Event
+		start, end time.Time
+		want       bool
+	}{
+		{"встреча внутри периода", meeting, at(2024, time.March, 4, 0, 0), at(2024, time.March, 4, 23, 59), true},
+		{"встреча началась раньше периода", meeting, at(2024, time.March, 4, 10, 30), at(2024, time.March, 4, 12, 0), true},
+		{"встреча закончилась к началу периода", meeting, at(2024, time.March, 4, 11, 0), at(2024, time.March, 4, 12, 0), false},
+		{"момент на границе периода", moment, at(2024, time.March, 4, 9, 0), at(2024, time.March, 4, 10, 0), true},
+		{"момент до периода", moment, at(2024, time.March, 4, 10, 1), at(2024, time.March, 4, 12, 0), false},
+		{"весь день вечером", allDay, at(2024, time.March, 4, 20, 0), at(2024, time.March, 4, 21, 0), true},
+		{"весь день на следующий день", allDay, at(2024, time.March, 5, 0, 0), at(2024, time.March, 5, 1, 0), false},
+	}
+
+	for _, tt := range tests {
+		if got := eventOverlaps(tt.event, tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: eventOverlaps() = %v, ожидалось %v", tt.name, got, tt.want)
+		}
+	}
+}
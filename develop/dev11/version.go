@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+	  = == ==                    == == =
+	= ==== ВЕРСИИ И ЧАСТИЧНЫЕ ИЗМЕНЕНИЯ ==== =
+	  = == ==                    == == =
+*/
+
+// updatableFields поля события (по именам в JSON), которые можно изменить через UpdateFields
+var updatableFields = map[string]bool{
+	"title": true, "uid": true, "date": true, "end": true, "tz": true, "all_day": true,
+	"location": true, "description": true, "recurrence": true, "reminders": true, "attendees": true,
+}
+
+// IfMatch разрешает изменение или удаление события, только если его текущая версия — одна из versions.
+// Иначе запись возвращает ошибку вида KindPrecondition: событие успел изменить кто-то другой
+func IfMatch(versions ...int) WriteOption {
+	return func(o *writeOptions) error {
+		o.ifMatch = append([]int{}, versions...)
+		return nil
+	}
+}
+
+// UpdateFields задаёт поля (по именам в JSON), которые изменяет UpdateEvent. Перечисленные поля принимают значения
+// из переданного события, даже пустые, — так поле очищается. Остальные поля сохраняют прежние значения
+func UpdateFields(fields ...string) WriteOption {
+	return func(o *writeOptions) error {
+		o.fields = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			if !updatableFields[field] {
+				return validationErrorf("поле %q нельзя изменить", field)
+			}
+			o.fields[field] = true
+		}
+		return nil
+	}
+}
+
+// checkVersion сверяет версию хранимого события с условием IfMatch
+func checkVersion(stored Event, options writeOptions) error {
+	if options.ifMatch == nil {
+		return nil
+	}
+
+	for _, version := range options.ifMatch {
+		if version == stored.Version {
+			return nil
+		}
+	}
+
+	return preconditionErrorf("событие с ID %d изменено другим клиентом: текущая версия %d", stored.ID, stored.Version)
+}
+
+// === HTTP ===
+
+// eventETag возвращает ETag события — номер его версии
+func eventETag(event Event) string {
+	return strconv.Quote(strconv.Itoa(event.Version))
+}
+
+// ifMatchOptions возвращает условие IfMatch по заголовку If-Match. Заголовок "*" и его отсутствие условий не задают.
+// Слабые и чужие ETag не совпадают ни с одной версией, поэтому запрос с ними получает 412
+func (s *Server) ifMatchOptions(r *http.Request) []WriteOption {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil
+	}
+
+	versions := []int{}
+	for _, tag := range strings.Split(header, ",") {
+		unquoted, err := strconv.Unquote(strings.TrimSpace(tag))
+		if err != nil {
+			continue
+		}
+		if version, err := strconv.Atoi(unquoted); err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	return []WriteOption{IfMatch(versions...)}
+}
+
+// fieldNames возвращает изменяемые поля события, присутствующие среди ключей тела запроса.
+// Ключи JSON сравниваются без учёта регистра, как их сопоставляет encoding/json; поле формы rrule — это recurrence
+func fieldNames(keys []string) []string {
+	var fields []string
+	for _, key := range keys {
+		field := strings.ToLower(key)
+		if field == "rrule" {
+			field = "recurrence"
+		}
+		if updatableFields[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// DecodeEventPatch парсит тело запроса на изменение события так же, как DecodeEventBody, и возвращает параметр
+// UpdateFields с полями, присутствующими в теле: null в JSON очищает значение, а пустое поле формы равносильно
+// отсутствующему и значение не меняет. Очистить поле можно только в JSON
+func (s *Server) DecodeEventPatch(r *http.Request) (Event, WriteOption, error) {
+	event, keys, err := s.decodeEventBody(r)
+	if err != nil {
+		if tooLarge := bodyTooLarge(err); tooLarge != nil {
+			return Event{}, nil, tooLarge
+		}
+		if errors.Is(err, ErrUnsupportedMediaType) {
+			return Event{}, nil, err
+		}
+		return Event{}, nil, badRequestf("%v", err)
+	}
+
+	return event, UpdateFields(fieldNames(keys)...), nil
+}
+
+// setETag выставляет заголовок ETag ответа
+func setETag(w http.ResponseWriter, event Event) {
+	w.Header().Set("ETag", eventETag(event))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIfMatchOptions(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	tests := []struct {
+		header string
+		want   []int // nil — условие не задано
+	}{
+		{header: ""},
+		{header: "*"},
+		{header: `"3"`, want: []int{3}},
+		{header: ` "3" , "5"`, want: []int{3, 5}},
+		// Слабые и чужие ETag не совпадают ни с одной версией
+		{header: `W/"3"`, want: []int{}},
+		{header: `"abc", 4`, want: []int{}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/api/v1/events/1", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+
+		options, err := newWriteOptions(server.ifMatchOptions(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(options.ifMatch) != fmt.Sprint(tt.want) || (options.ifMatch == nil) != (tt.want == nil) {
+			t.Errorf("If-Match: %s — версии %v, ожидались %v", tt.header, options.ifMatch, tt.want)
+		}
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	stored := Event{ID: 7, Version: 3}
+
+	if err := checkVersion(stored, writeOptions{}); err != nil {
+		t.Errorf("без условия: %v", err)
+	}
+
+	for _, versions := range [][]int{{3}, {1, 3}} {
+		options, _ := newWriteOptions([]WriteOption{IfMatch(versions...)})
+		if err := checkVersion(stored, options); err != nil {
+			t.Errorf("версии %v: %v", versions, err)
+		}
+	}
+
+	for _, versions := range [][]int{{}, {2}, {4, 5}} {
+		options, _ := newWriteOptions([]WriteOption{IfMatch(versions...)})
+		if err := checkVersion(stored, options); !errors.Is(err, ErrPrecondition) {
+			t.Errorf("версии %v: %v, ожидалась ErrPrecondition", versions, err)
+		}
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	got := fieldNames([]string{"Title", "rrule", "user_id", "id", "END", "colour"})
+	sort.Strings(got)
+	if strings.Join(got, ",") != "end,recurrence,title" {
+		t.Errorf("поля %v, ожидались [end recurrence title]", got)
+	}
+
+	if _, err := newWriteOptions([]WriteOption{UpdateFields("title", "version")}); !errors.Is(err, ErrValidation) {
+		t.Errorf("UpdateFields с полем version: %v, ожидалась ErrValidation", err)
+	}
+}
+
+func TestUpdateEventVersions(t *testing.T) {
+	store := InitNewEventStore()
+	id, err := store.AddEvent(Event{UserID: 1, Title: "Планёрка", Place: "Переговорная", Description: "Еженедельная", Date: at(2024, time.March, 4, 10, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Частичное изменение: place очищается, description не упомянуто и сохраняется
+	updated, err := store.UpdateEvent(1, Event{ID: id, Title: "Ретро"}, IfMatch(1), UpdateFields("title", "location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 || updated.Title != "Ретро" || updated.Place != "" || updated.Description != "Еженедельная" ||
+		!updated.Date.Equal(at(2024, time.March, 4, 10, 0)) {
+		t.Errorf("изменённое событие %+v", updated)
+	}
+
+	// Клиент с устаревшей версией не перезаписывает чужое изменение
+	if _, err := store.UpdateEvent(1, Event{ID: id, Title: "Старое"}, IfMatch(1), UpdateFields("title")); !errors.Is(err, ErrPrecondition) {
+		t.Errorf("изменение по версии 1: %v, ожидалась ErrPrecondition", err)
+	}
+	if err := store.DeleteEvent(1, id, IfMatch(1)); !errors.Is(err, ErrPrecondition) {
+		t.Errorf("удаление по версии 1: %v, ожидалась ErrPrecondition", err)
+	}
+
+	if event, err := store.GetEvent(1, id); err != nil || event.Version != 2 || event.Title != "Ретро" {
+		t.Errorf("событие после отклонённых записей: %+v, %v", event, err)
+	}
+	if err := store.DeleteEvent(1, id, IfMatch(2)); err != nil {
+		t.Errorf("удаление по текущей версии: %v", err)
+	}
+}
+
+func TestDecodeEventPatch(t *testing.T) {
+	server := NewServer(InitNewEventStore())
+	defer server.Changes.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantFields  string
+	}{
+		{name: "null в JSON очищает поле", contentType: "application/json", body: `{"title": "Ретро", "location": null}`, wantFields: "location,title"},
+		{name: "пустое поле формы не меняется", contentType: "application/x-www-form-urlencoded", body: "title=Ретро&location=", wantFields: "title"},
+		{name: "rrule формы — это recurrence", contentType: "application/x-www-form-urlencoded", body: "rrule=FREQ%3DDAILY", wantFields: "recurrence"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/api/v1/events/1", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			_, option, err := server.DecodeEventPatch(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options, err := newWriteOptions([]WriteOption{option})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fields []string
+			for field := range options.fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if got := strings.Join(fields, ","); got != tt.wantFields {
+				t.Errorf("изменяемые поля %q, ожидались %q", got, tt.wantFields)
+			}
+		})
+	}
+}